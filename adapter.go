@@ -0,0 +1,181 @@
+package eio
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+
+	"github.com/jjeffcaii/engine.io/parser"
+)
+
+// Adapter connects the engines of nodes in a cluster, so broadcasts and rooms reach sockets of any node,
+// see EngineBuilder.SetAdapter. Implementations must be safe for concurrent use.
+type Adapter interface {
+	// Start begins delivering messages of other nodes to node, it's called once by EngineBuilder.Build.
+	// Adapters connecting to a network should connect in background and retry, since Build can't fail.
+	Start(node Node)
+	// Publish sends message to the other nodes, the local node isn't delivered.
+	Publish(message *ClusterMessage) error
+	// Join records the socket of sid in rooms of the cluster.
+	Join(sid string, rooms []string) error
+	// Leave removes the socket of sid from rooms, or from all of its rooms if rooms is nil.
+	Leave(sid string, rooms []string) error
+	// Members returns the sids of sockets in room of all nodes.
+	Members(room string) ([]string, error)
+	// SendTo forwards message to the node which owns the socket of sid, it returns false if no other node owns it.
+	SendTo(sid string, message *ClusterMessage) (bool, error)
+	// Close stops the adapter, it's called by Engine.Close.
+	Close() error
+}
+
+// Node is the local engine of an adapter.
+type Node interface {
+	// ID returns the unique ID of node, messages of a node are tagged with it.
+	ID() string
+	// Deliver sends message to the sockets of node, it returns the count of sockets sent.
+	Deliver(message *ClusterMessage) int
+	// Nodes returns the IDs of alive nodes of the cluster, see Engine.Nodes.
+	Nodes() []string
+	// Owner returns the node owning sid by the session router, it's empty if there's no router.
+	// The socket may be owned by another node if it's opened before the nodes of router change.
+	Owner(sid string) string
+}
+
+// ClusterMessage is a MESSAGE broadcasted through an adapter.
+type ClusterMessage struct {
+	// Node is the ID of node which publishes the message.
+	Node string `json:"node"`
+	// Rooms are the rooms of sockets the message is sent to, it's sent to all sockets if they are empty.
+	Rooms []string `json:"rooms,omitempty"`
+	// Sid is the socket the message is sent to by Engine.SendTo, Rooms are ignored if it's not empty.
+	Sid    string `json:"sid,omitempty"`
+	Data   []byte `json:"data"`
+	Binary bool   `json:"binary,omitempty"`
+}
+
+// Delivery is where a message of Engine.SendTo goes.
+type Delivery int8
+
+const (
+	// DeliveryUnknown means no node owns the socket.
+	DeliveryUnknown Delivery = iota
+	// DeliveryLocal means the socket is owned by the local node, which sends the message.
+	DeliveryLocal
+	// DeliveryForwarded means the message is forwarded to the node which owns the socket.
+	DeliveryForwarded
+)
+
+func (d Delivery) String() string {
+	switch d {
+	case DeliveryLocal:
+		return "local"
+	case DeliveryForwarded:
+		return "forwarded"
+	default:
+		return "unknown"
+	}
+}
+
+// clusterNode is the node of an engine.
+type clusterNode struct {
+	eng *engineImpl
+	id  string
+}
+
+// newClusterNode returns the node of id, a random one if it's empty.
+func newClusterNode(eng *engineImpl, id string) *clusterNode {
+	if len(id) < 1 {
+		b := make([]byte, 8)
+		rand.Read(b)
+		id = hex.EncodeToString(b)
+	}
+	return &clusterNode{eng: eng, id: id}
+}
+
+func (p *clusterNode) ID() string {
+	return p.id
+}
+
+func (p *clusterNode) Nodes() []string {
+	return p.eng.Nodes()
+}
+
+func (p *clusterNode) Deliver(message *ClusterMessage) int {
+	if message.Node == p.id {
+		return 0
+	}
+	if len(message.Sid) > 0 {
+		socket, ok := p.eng.sockets.Get(message.Sid)
+		if ok && p.eng.sendTo(socket, message.Data, message.Binary) == nil || p.eng.bufferTo(message.Sid, message.Data, message.Binary) {
+			return 1
+		}
+		return 0
+	}
+	p.eng.buffer(message.Rooms, message.Data, message.Binary)
+	sockets := p.eng.sockets.List(nil)
+	if len(message.Rooms) > 0 {
+		sockets = p.eng.rooms.sockets(message.Rooms)
+	}
+	return p.eng.broadcast(sockets, message.Data, message.Binary, nil)
+}
+
+func (p *engineImpl) SendTo(sid string, data []byte, binary bool) (Delivery, error) {
+	if socket, ok := p.sockets.Get(sid); ok {
+		err := p.sendTo(socket, data, binary)
+		// the socket may be suspended but not removed yet.
+		if err != nil && p.bufferTo(sid, data, binary) {
+			err = nil
+		}
+		return DeliveryLocal, err
+	}
+	if p.bufferTo(sid, data, binary) {
+		return DeliveryLocal, nil
+	}
+	if p.adapter == nil {
+		return DeliveryUnknown, nil
+	}
+	ok, err := p.adapter.SendTo(sid, &ClusterMessage{Node: p.node.id, Sid: sid, Data: data, Binary: binary})
+	if err != nil || !ok {
+		return DeliveryUnknown, err
+	}
+	return DeliveryForwarded, nil
+}
+
+func (p *engineImpl) sendTo(socket *socketImpl, data []byte, binary bool) error {
+	var option parser.PacketOption
+	if binary {
+		option = parser.BINARY
+	}
+	return socket.Send(parser.NewPacketCustom(parser.MESSAGE, data, option))
+}
+
+// publish sends data to rooms of other nodes if there's an adapter.
+func (p *engineImpl) publish(rooms []string, data []byte, binary bool) {
+	if p.adapter == nil {
+		return
+	}
+	message := &ClusterMessage{Node: p.node.id, Rooms: rooms, Data: data, Binary: binary}
+	if err := p.adapter.Publish(message); err != nil {
+		p.log(LogCluster, slog.LevelError, "publish_failed", "publish message failed", LogKeyError, err)
+	}
+}
+
+// clusterJoin records the socket of sid in rooms by the adapter.
+func (p *engineImpl) clusterJoin(sid string, rooms []string) {
+	if p.adapter == nil {
+		return
+	}
+	if err := p.adapter.Join(sid, rooms); err != nil {
+		p.log(LogCluster, slog.LevelError, "join_failed", "join rooms failed", LogKeySid, sid, "rooms", rooms, LogKeyError, err)
+	}
+}
+
+// clusterLeave removes the socket of sid from rooms by the adapter, or from all of its rooms if rooms is nil.
+func (p *engineImpl) clusterLeave(sid string, rooms []string) {
+	if p.adapter == nil {
+		return
+	}
+	if err := p.adapter.Leave(sid, rooms); err != nil {
+		p.log(LogCluster, slog.LevelError, "leave_failed", "leave rooms failed", LogKeySid, sid, "rooms", rooms, LogKeyError, err)
+	}
+}
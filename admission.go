@@ -0,0 +1,72 @@
+package eio
+
+import (
+	"errors"
+	"net/http"
+	"time"
+)
+
+// codeOverloaded is the error code of handshakes rejected by admission control.
+const codeOverloaded = 6
+
+// ErrEngineOverloaded rejects the handshakes of new sessions once the max connections are open, see EngineBuilder.SetMaxConnections.
+var ErrEngineOverloaded = errors.New("engine: too many connections")
+
+// OverloadPolicy decides what happens to a handshake once the max connections are open.
+type OverloadPolicy int8
+
+const (
+	// OverloadReject rejects the handshake with 503 and the error code 6 at once.
+	OverloadReject OverloadPolicy = iota
+	// OverloadQueue holds the handshake until a socket is closed. It's rejected as OverloadReject does
+	// if no socket is closed in the queue timeout, or the client goes away.
+	OverloadQueue
+)
+
+// admissionError is the error of a handshake rejected by admission control, it's answered with 503.
+type admissionError struct {
+	err error
+}
+
+func (e *admissionError) Error() string {
+	return e.err.Error()
+}
+
+func (e *admissionError) Unwrap() error {
+	return e.err
+}
+
+// admit decides whether a session can be opened for the handshake request, which is nil for sessions out of http.
+// release must be called once the session is closed if it's admitted.
+func (p *engineImpl) admit(request *http.Request) (release func(), err error) {
+	if p.admission != nil {
+		if err := p.admission(request, p.sockets.Count()); err != nil {
+			return nil, &admissionError{err}
+		}
+	}
+	if p.slots == nil {
+		return func() {}, nil
+	}
+	release = func() { <-p.slots }
+	select {
+	case p.slots <- struct{}{}:
+		return release, nil
+	default:
+	}
+	if p.options.overloadPolicy != OverloadQueue {
+		return nil, &admissionError{ErrEngineOverloaded}
+	}
+	var gone <-chan struct{}
+	if request != nil {
+		gone = request.Context().Done()
+	}
+	timer := time.NewTimer(p.options.overloadQueueTimeout)
+	defer timer.Stop()
+	select {
+	case p.slots <- struct{}{}:
+		return release, nil
+	case <-timer.C:
+	case <-gone:
+	}
+	return nil, &admissionError{ErrEngineOverloaded}
+}
@@ -0,0 +1,74 @@
+package eio
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMaxConnections(t *testing.T) {
+	srv := NewServer(WithMaxConnections(1, OverloadReject))
+	defer srv.Close()
+	hs := httptest.NewServer(srv)
+	defer hs.Close()
+	url := hs.URL + "/engine.io/?EIO=3&transport=polling"
+
+	if res, _ := poll(t, http.MethodGet, url, "", ""); res.StatusCode != http.StatusOK {
+		t.Fatalf("should be admitted: %d", res.StatusCode)
+	}
+	res, body := poll(t, http.MethodGet, url, "", "")
+	if res.StatusCode != http.StatusServiceUnavailable || !strings.Contains(body, `"code":6`) {
+		t.Errorf("should be rejected: %d %s", res.StatusCode, body)
+	}
+	if _, err := srv.Loopback(); !errors.Is(err, ErrEngineOverloaded) {
+		t.Error("loopback should be rejected:", err)
+	}
+	for _, it := range srv.GetClients() {
+		it.Close()
+	}
+	if res, _ := poll(t, http.MethodGet, url, "", ""); res.StatusCode != http.StatusOK {
+		t.Errorf("should be admitted once a socket is closed: %d", res.StatusCode)
+	}
+}
+
+func TestOverloadQueue(t *testing.T) {
+	eng := NewEngineBuilder().SetMaxConnections(1).SetOverloadPolicy(OverloadQueue).SetOverloadQueueTimeout(100 * time.Millisecond).Build()
+	defer eng.Close()
+	first, err := eng.Loopback()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := eng.Loopback(); !errors.Is(err, ErrEngineOverloaded) {
+		t.Error("should time out in queue:", err)
+	}
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		first.Close()
+	}()
+	if _, err := eng.Loopback(); err != nil {
+		t.Error("queued handshake should be admitted:", err)
+	}
+}
+
+func TestAdmission(t *testing.T) {
+	srv := NewServer(WithAdmission(func(request *http.Request, connections int) error {
+		if request.URL.Query().Get("vip") == "" && connections > 0 {
+			return errors.New("busy")
+		}
+		return nil
+	}))
+	defer srv.Close()
+	hs := httptest.NewServer(srv)
+	defer hs.Close()
+	url := hs.URL + "/engine.io/?EIO=3&transport=polling"
+	poll(t, http.MethodGet, url, "", "")
+	if res, body := poll(t, http.MethodGet, url, "", ""); res.StatusCode != http.StatusServiceUnavailable || !strings.Contains(body, "busy") {
+		t.Errorf("should be rejected: %d %s", res.StatusCode, body)
+	}
+	if res, _ := poll(t, http.MethodGet, url+"&vip=1", "", ""); res.StatusCode != http.StatusOK {
+		t.Errorf("should be admitted: %d", res.StatusCode)
+	}
+}
@@ -1,6 +1,9 @@
 package eio
 
 import (
+	"context"
+	"fmt"
+	"net"
 	"net/http"
 
 	"github.com/jjeffcaii/engine.io/parser"
@@ -14,27 +17,78 @@ const (
 	POLLING TransportType = iota
 	// WEBSOCKET use Websocket as Transport.
 	WEBSOCKET TransportType = iota
+	// STREAM use a stream connection such as TCP or Unix domain socket as Transport, see Engine.Serve.
+	STREAM TransportType = iota
+	// LOOPBACK connects a session to a client in the same process through channels, see Engine.Loopback.
+	LOOPBACK TransportType = iota
 )
 
 // DefaultPath for engine.io http router.
 var DefaultPath = "/engine.io/"
 
+// Middleware wraps the handler of engine.io requests, see EngineBuilder.Use.
+type Middleware func(next http.Handler) http.Handler
+
 // Engine is the main server/manager.
 type Engine interface {
 	// Router returns a std golang http handler.
 	Router() func(http.ResponseWriter, *http.Request)
 	// Listen engine server.
 	Listen(addr string) error
-	// GetProtocol returns engine protocol version.
+	// Serve sessions on stream connections accepted by listener, packets are framed as parser.StreamConn does.
+	// Sessions don't upgrade and requests aren't checked by the allow request function. It returns the error of Accept.
+	Serve(listener net.Listener) error
+	// Loopback opens a session connected to the returned client through channels, without network or http.
+	// The client receives the OPEN packet first and should PING as other clients do.
+	Loopback() (LoopbackClient, error)
+	// GetProtocol returns the default protocol version, which sessions speak if their handshake has no EIO query.
+	// Clients of both v3 and v4 are accepted, see Socket.Protocol.
 	GetProtocol() uint8
 	// GetClients returns current socket map. (SocketID -> Socket)
 	GetClients() map[string]Socket
 	// CountClients returns current socket count.
 	CountClients() int
+	// Stats returns a snapshot of the counters of engine, such as handshakes, upgrades and packets of transports.
+	Stats() Stats
+	// LiveStats returns a snapshot of the open sessions, such as the sockets of transports and their send queues.
+	LiveStats() LiveStats
+	// Events returns the bus of the lifecycle events of sockets.
+	Events() *EventBus
+	// Broadcast sends data as a MESSAGE to the sockets which filter returns true for, or to all if filter is nil.
+	// The packet is encoded once per wire format instead of once per socket. It returns the count of sockets sent.
+	// An unfiltered broadcast reaches the sockets of other nodes too if there's an adapter, see EngineBuilder.SetAdapter.
+	Broadcast(data []byte, binary bool, filter func(socket Socket) bool) int
+	// To returns the sockets in any of rooms, see Socket.Join.
+	To(rooms ...string) Room
+	// Rooms returns the sorted rooms which have sockets.
+	Rooms() []string
+	// SendTo sends data as a MESSAGE to the socket of sid, which may be owned by another node if there's an adapter.
+	// The Delivery tells where it goes, the error is the failure of sending it locally or of the adapter.
+	SendTo(sid string, data []byte, binary bool) (Delivery, error)
+	// Nodes returns the sorted IDs of alive nodes of the cluster including the local one, which are found by the
+	// membership (see EngineBuilder.SetMembership). It's the local node only without membership, or nil if the
+	// engine isn't in a cluster.
+	Nodes() []string
+	// Migrate hands the polling session of sid over to node by the session store (see EngineBuilder.SetSessionStore),
+	// with its metadata, rooms and the packets the client hasn't received. The transport is paused meanwhile, then the
+	// pending poll ends with a NOOP and the requests of client are relayed to node, so no packet is lost. The socket
+	// is closed with CloseMigrated on this node, and the OnConnect handlers of node run for it. Metadata are passed as
+	// JSON, so node gets their decoded values. Websocket sessions can't be migrated as their connections are bound to
+	// this node: ErrMigrateWebsocket is returned and the session goes on untouched, close it to have its client
+	// handshake again with the node it's routed to.
+	Migrate(ctx context.Context, sid, node string) error
+	// Presence returns the users online, it's nil unless presence is enabled by EngineBuilder.SetPresence.
+	Presence() Presence
 	// OnConnect bind handler when sockets created.
 	OnConnect(func(socket Socket)) Engine
-	// Close current engine server.
+	// OnDisconnect bind handler when sockets closed, it runs after the close handlers of socket are started.
+	OnDisconnect(func(socket Socket, reason CloseReason)) Engine
+	// Close current engine server, open sockets are closed with CloseServerShutdown.
 	Close()
+	// Shutdown closes the engine gracefully: handshakes are rejected with ErrEngineShutdown, open sockets are sent
+	// the shutdown message if any and closed with CloseServerShutdown. It returns once in-flight requests are done,
+	// or the error of ctx if it's done first.
+	Shutdown(ctx context.Context) error
 }
 
 // Transport is used to control socket.
@@ -45,8 +99,14 @@ type Transport interface {
 	GetEngine() Engine
 	// GetSocket returns current socket.
 	GetSocket() Socket
-	// GetRequest returns native http request.
+	// GetRequest returns native http request, it's nil for STREAM and LOOPBACK.
 	GetRequest() *http.Request
+	// Pause the transport, it waits for the packets being sent and holds the packets written since until Resume.
+	Pause()
+	// Resume sends the packets held in the order they were written, then packets are sent as written again.
+	Resume() error
+	// Paused returns true if the transport is paused.
+	Paused() bool
 
 	// inner functions.
 	setSocket(socket Socket)
@@ -61,15 +121,80 @@ type Transport interface {
 	close() error
 }
 
+// WebsocketTransport is the transport of WEBSOCKET.
+type WebsocketTransport interface {
+	Transport
+	// Subprotocol returns the Sec-WebSocket-Protocol negotiated, it's empty if none is.
+	Subprotocol() string
+	// Extensions returns the names of websocket extensions negotiated, such as permessage-deflate.
+	Extensions() []string
+}
+
+// CustomTransport is a transport implemented out of this package, see RegisterTransport.
+// A session runs on one instance, which gets every request of the session using the transport.
+type CustomTransport interface {
+	// Name returns the name of transport, which is the value of transport query.
+	Name() string
+	// Upgradeable returns true if sessions can be upgraded to the transport, it's offered in upgrades of handshake then.
+	Upgradeable() bool
+	// HandleRequest serves a request of the session, it may return after the request or block as long as a connection lives.
+	HandleRequest(writer http.ResponseWriter, request *http.Request) error
+	// Send a packet to the client, packets sent before the first request are buffered until it.
+	Send(packet *parser.Packet) error
+	// Receive blocks until a packet comes from the client, an error ends the session.
+	Receive() (*parser.Packet, error)
+	// Close the transport, Receive should return io.EOF then.
+	Close() error
+}
+
+// LoopbackClient is the client side of a session opened by Engine.Loopback.
+// Packets are passed as is, so they shouldn't be modified once sent.
+type LoopbackClient interface {
+	// Send a packet to the server.
+	Send(packet *parser.Packet) error
+	// Receive blocks until a packet comes from the server, it returns io.EOF once the session is closed.
+	Receive() (*parser.Packet, error)
+	// Close the session.
+	Close() error
+}
+
 // Socket is a representation of a client.
 type Socket interface {
 	// ID returns SessionID of socket.
 	ID() string
 	// Server returns engine of current socket.
 	Server() Engine
-	// Transport returns the active transport of socket.
+	// Context returns the context of socket, which keeps the values of the handshake request context unless it's
+	// detached (see EngineBuilder.SetDetachedContext) or given by the function of EngineBuilder.SetAllowHandshake.
+	// It's canceled once the socket is closed, so goroutines of the connection can end with it.
+	Context() context.Context
+	// RemoteAddr returns the address of client when the socket is opened, such as the RemoteAddr of handshake request.
+	// It's resolved by the proxy header if the request comes from a trusted proxy, see EngineBuilder.SetTrustedProxies.
+	RemoteAddr() string
+	// Protocol returns the protocol version negotiated by the EIO query of handshake.
+	Protocol() uint8
+	// Set attaches the value of key to socket, such as the user or tenant of the connection. It's safe for concurrent use.
+	// Setting the key of presence brings socket online as the user of value, see EngineBuilder.SetPresence.
+	Set(key string, value interface{})
+	// Get returns the value of key attached by Set, ok is false if there's no such key.
+	Get(key string) (value interface{}, ok bool)
+	// Delete removes the value of key.
+	Delete(key string)
+	// Join adds socket to rooms, it leaves all of them once it's closed. A closed socket joins nothing.
+	// Rooms are recorded by the adapter too if there's one, see EngineBuilder.SetAdapter.
+	Join(rooms ...string)
+	// Leave removes socket from rooms.
+	Leave(rooms ...string)
+	// Rooms returns the sorted rooms of socket.
+	Rooms() []string
+	// State returns the lifecycle state of socket.
+	State() SocketState
+	// Recovered returns true if socket resumes a session closed uncleanly, see EngineBuilder.SetRecovery.
+	Recovered() bool
+	// Transport returns the active transport of socket, it changes once the socket is upgraded.
 	Transport() Transport
-	// OnClose bind handler when socket closed.
+	// OnClose bind handler when socket closed, reason is the message of CloseReason unless an error or
+	// the reason of CloseWithReason causes the close.
 	OnClose(func(reason string)) Socket
 	// OnMessage bind handler when message income.
 	OnMessage(func(data []byte)) Socket
@@ -77,8 +202,73 @@ type Socket interface {
 	OnError(func(err error)) Socket
 	// OnUpgrade bind handler when socket upgraded.
 	OnUpgrade(func()) Socket
-	// Send a message.
+	// Send a message, a *parser.Packet is sent as is so its options and streamed body (see parser.NewPacketFromReader) are kept.
 	Send(message interface{}) error
+	// SendContext sends data as a MESSAGE, which is binary if binary is true. It returns once ctx is done.
+	SendContext(ctx context.Context, data []byte, binary bool) error
 	// Close current socket.
 	Close()
+	// CloseWithReason closes current socket, reason is passed to the close handlers.
+	CloseWithReason(reason string)
+}
+
+// CloseReason is why a socket is closed, see Engine.OnDisconnect.
+type CloseReason int8
+
+const (
+	// CloseForced means the socket is closed by the application, see Socket.Close.
+	CloseForced CloseReason = iota
+	// ClosePingTimeout means the client doesn't ping in ping timeout.
+	ClosePingTimeout
+	// CloseTransportError means the transport fails, e.g. it can't decode a packet or a write stalls.
+	CloseTransportError
+	// CloseClientClose means the client sends a CLOSE packet or closes its transport.
+	CloseClientClose
+	// CloseServerShutdown means the engine is closed.
+	CloseServerShutdown
+	// CloseMigrated means the session is migrated to another node, see Engine.Migrate.
+	CloseMigrated
+)
+
+// String returns the reason as the JS implementation names it.
+func (r CloseReason) String() string {
+	switch r {
+	case CloseForced:
+		return "forced close"
+	case ClosePingTimeout:
+		return "ping timeout"
+	case CloseTransportError:
+		return "transport error"
+	case CloseClientClose:
+		return "transport close"
+	case CloseServerShutdown:
+		return "server shutdown"
+	case CloseMigrated:
+		return "migrated"
+	}
+	return fmt.Sprintf("CloseReason(%d)", r)
+}
+
+// SocketState is the lifecycle state of a socket.
+type SocketState int8
+
+const (
+	// SocketOpen means the socket is open on one transport.
+	SocketOpen SocketState = iota
+	// SocketUpgrading means the socket is being upgraded to another transport.
+	SocketUpgrading
+	// SocketClosed means the socket is closed.
+	SocketClosed
+)
+
+func (s SocketState) String() string {
+	switch s {
+	case SocketOpen:
+		return "open"
+	case SocketUpgrading:
+		return "upgrading"
+	case SocketClosed:
+		return "closed"
+	}
+	return fmt.Sprintf("SocketState(%d)", s)
 }
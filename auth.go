@@ -0,0 +1,213 @@
+package eio
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Authenticator authenticates the handshake request of a new session before the OPEN packet is sent, see
+// EngineBuilder.SetAuthenticator. The metadata it returns is set to the socket (see Socket.Set) before the connect
+// handlers run. An error rejects the handshake with 403 and the error code 4 unless it's a *RequestError.
+type Authenticator func(request *http.Request) (metadata map[string]interface{}, err error)
+
+// authMetadataKey is the context key of the metadata of an authenticated handshake.
+type authMetadataKey struct{}
+
+// JWTOptions are the options of NewJWTAuthenticator.
+type JWTOptions struct {
+	// Keys verify the signatures of tokens by the "kid" of their headers, the key of "" verifies tokens without kid.
+	// A key is a []byte of HS256/384/512, an *rsa.PublicKey of RS256/384/512 or an *ecdsa.PublicKey of ES256/384/512.
+	Keys map[string]interface{}
+	// Audience must be one of the "aud" of tokens if it's not empty.
+	Audience string
+	// Leeway tolerates the clock skew in the checks of "exp" and "nbf".
+	Leeway time.Duration
+	// Claims maps the claims of tokens to the metadata keys of sockets, e.g. {"sub": "user"}. Claims missing are skipped.
+	Claims map[string]string
+	// Token extracts the token of a handshake request. By default it's the bearer of the Authorization header,
+	// or the "token" query as browsers can't set headers of websockets.
+	Token func(request *http.Request) string
+}
+
+var jwtAlgorithms = map[string]crypto.Hash{
+	"HS256": crypto.SHA256, "HS384": crypto.SHA384, "HS512": crypto.SHA512,
+	"RS256": crypto.SHA256, "RS384": crypto.SHA384, "RS512": crypto.SHA512,
+	"ES256": crypto.SHA256, "ES384": crypto.SHA384, "ES512": crypto.SHA512,
+}
+
+// NewJWTAuthenticator returns an authenticator of the JSON web tokens of handshakes. A token is accepted if it's
+// signed by a key of options, it isn't expired (tokens without "exp" are rejected) nor used before "nbf", and it's
+// issued to the audience of options.
+func NewJWTAuthenticator(options JWTOptions) Authenticator {
+	if len(options.Keys) < 1 {
+		panic(errors.New("invalid jwt keys: empty"))
+	}
+	token := options.Token
+	if token == nil {
+		token = bearerToken
+	}
+	return func(request *http.Request) (map[string]interface{}, error) {
+		raw := token(request)
+		if len(raw) < 1 {
+			return nil, errors.New("jwt: token is missing")
+		}
+		claims, err := verifyJWT(raw, options.Keys)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkJWTClaims(claims, options.Audience, options.Leeway, time.Now()); err != nil {
+			return nil, err
+		}
+		var metadata map[string]interface{}
+		for claim, key := range options.Claims {
+			if value, ok := claims[claim]; ok {
+				if metadata == nil {
+					metadata = make(map[string]interface{}, len(options.Claims))
+				}
+				metadata[key] = value
+			}
+		}
+		return metadata, nil
+	}
+}
+
+// bearerToken returns the bearer of the Authorization header of request, or its "token" query.
+func bearerToken(request *http.Request) string {
+	if auth := request.Header.Get("Authorization"); len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	return request.URL.Query().Get("token")
+}
+
+// verifyJWT checks the signature of token by keys and returns its claims.
+func verifyJWT(token string, keys map[string]interface{}) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("jwt: malformed token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, err
+	}
+	hash, ok := jwtAlgorithms[header.Alg]
+	if !ok {
+		return nil, fmt.Errorf("jwt: unsupported algorithm '%s'", header.Alg)
+	}
+	key, ok := keys[header.Kid]
+	if !ok {
+		return nil, fmt.Errorf("jwt: unknown key '%s'", header.Kid)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("jwt: malformed signature")
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+	if err := verifyJWTSignature(header.Alg[:2], hash, key, signed, signature); err != nil {
+		return nil, err
+	}
+	var claims map[string]interface{}
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	bs, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return errors.New("jwt: malformed token")
+	}
+	if err := json.Unmarshal(bs, v); err != nil {
+		return errors.New("jwt: malformed token")
+	}
+	return nil
+}
+
+// verifyJWTSignature verifies signature of signed by key of the algorithm family, the type of key must match
+// the family, so a public key is never taken as an HMAC secret.
+func verifyJWTSignature(family string, hash crypto.Hash, key interface{}, signed, signature []byte) error {
+	invalid := errors.New("jwt: invalid signature")
+	h := hash.New()
+	switch family {
+	case "HS":
+		secret, ok := key.([]byte)
+		if !ok {
+			return invalid
+		}
+		mac := hmac.New(hash.New, secret)
+		mac.Write(signed)
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return invalid
+		}
+		return nil
+	case "RS":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return invalid
+		}
+		h.Write(signed)
+		if rsa.VerifyPKCS1v15(pub, hash, h.Sum(nil), signature) != nil {
+			return invalid
+		}
+		return nil
+	case "ES":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return invalid
+		}
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return invalid
+		}
+		h.Write(signed)
+		r, s := new(big.Int).SetBytes(signature[:size]), new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(pub, h.Sum(nil), r, s) {
+			return invalid
+		}
+		return nil
+	}
+	return invalid
+}
+
+// checkJWTClaims checks the expiry, the not before and the audience of claims at now.
+func checkJWTClaims(claims map[string]interface{}, audience string, leeway time.Duration, now time.Time) error {
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return errors.New("jwt: expiry is missing")
+	}
+	if now.Add(-leeway).After(time.Unix(int64(exp), 0)) {
+		return errors.New("jwt: token is expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(leeway).Before(time.Unix(int64(nbf), 0)) {
+		return errors.New("jwt: token isn't valid yet")
+	}
+	if len(audience) < 1 {
+		return nil
+	}
+	switch aud := claims["aud"].(type) {
+	case string:
+		if aud == audience {
+			return nil
+		}
+	case []interface{}:
+		for _, it := range aud {
+			if it == audience {
+				return nil
+			}
+		}
+	}
+	return errors.New("jwt: audience is invalid")
+}
@@ -0,0 +1,115 @@
+package eio
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// signJWT returns a token of claims signed by key with alg.
+func signJWT(t *testing.T, alg, kid string, key interface{}, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": alg, "typ": "JWT", "kid": kid})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	var signature []byte
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		var err error
+		if signature, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:]); err != nil {
+			t.Fatal(err)
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestJWTAuthenticator(t *testing.T) {
+	secret := []byte("secret")
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	authenticate := NewJWTAuthenticator(JWTOptions{
+		Keys:     map[string]interface{}{"": secret, "rsa": &rsaKey.PublicKey, "ec": &ecKey.PublicKey},
+		Audience: "chat",
+		Claims:   map[string]string{"sub": "user"},
+	})
+	exp := time.Now().Add(time.Hour).Unix()
+	good := map[string]interface{}{"sub": "alice", "aud": []string{"chat", "admin"}, "exp": exp}
+	for _, it := range []struct {
+		name, token string
+		ok          bool
+	}{
+		{"hmac", signJWT(t, "HS256", "", secret, good), true},
+		{"rsa", signJWT(t, "RS256", "rsa", rsaKey, good), true},
+		{"ecdsa", signJWT(t, "ES256", "ec", ecKey, good), true},
+		{"missing", "", false},
+		{"malformed", "a.b", false},
+		{"bad signature", signJWT(t, "HS256", "", []byte("guess"), good), false},
+		{"unknown key", signJWT(t, "HS256", "nope", secret, good), false},
+		{"key of another algorithm", signJWT(t, "HS256", "rsa", secret, good), false},
+		{"expired", signJWT(t, "HS256", "", secret, map[string]interface{}{"aud": "chat", "exp": time.Now().Add(-time.Minute).Unix()}), false},
+		{"no expiry", signJWT(t, "HS256", "", secret, map[string]interface{}{"aud": "chat"}), false},
+		{"not before", signJWT(t, "HS256", "", secret, map[string]interface{}{"aud": "chat", "exp": exp, "nbf": exp}), false},
+		{"audience", signJWT(t, "HS256", "", secret, map[string]interface{}{"aud": "other", "exp": exp}), false},
+	} {
+		request := httptest.NewRequest(http.MethodGet, "/engine.io/?EIO=4&transport=polling", nil)
+		if len(it.token) > 0 {
+			request.Header.Set("Authorization", "Bearer "+it.token)
+		}
+		metadata, err := authenticate(request)
+		if it.ok && (err != nil || metadata["user"] != "alice") {
+			t.Errorf("%s: should be accepted: %v %v", it.name, metadata, err)
+		} else if !it.ok && err == nil {
+			t.Errorf("%s: should be rejected", it.name)
+		}
+	}
+}
+
+func TestAuthenticator(t *testing.T) {
+	secret := []byte("secret")
+	eng := NewEngineBuilder().SetAuthenticator(NewJWTAuthenticator(JWTOptions{
+		Keys:   map[string]interface{}{"": secret},
+		Claims: map[string]string{"sub": "user"},
+	})).Build()
+	defer eng.Close()
+	users := make(chan interface{}, 1)
+	eng.OnConnect(func(socket Socket) {
+		user, _ := socket.Get("user")
+		users <- user
+	})
+	srv := httptest.NewServer(http.HandlerFunc(eng.Router()))
+	defer srv.Close()
+	url := srv.URL + "/engine.io/?EIO=4&transport=polling"
+
+	res, body := poll(t, http.MethodGet, url, "", "")
+	if res.StatusCode != http.StatusForbidden || !strings.Contains(body, `"code":4`) {
+		t.Errorf("should be forbidden: %d %s", res.StatusCode, body)
+	}
+	token := signJWT(t, "HS256", "", secret, map[string]interface{}{"sub": "alice", "exp": time.Now().Add(time.Hour).Unix()})
+	if res, body := poll(t, http.MethodGet, url+"&token="+token, "", ""); res.StatusCode != http.StatusOK || !strings.HasPrefix(body, "0{") {
+		t.Fatalf("should be opened: %d %s", res.StatusCode, body)
+	}
+	if user := <-users; user != "alice" {
+		t.Errorf("claims should be set to socket: %v", user)
+	}
+}
@@ -0,0 +1,62 @@
+package eio
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"github.com/jjeffcaii/engine.io/parser"
+)
+
+// Broadcast sends data as a MESSAGE to the sockets which filter returns true for, or to all if filter is nil.
+// The packet is encoded once per wire format and shared by the sockets, sends are fanned out by the broadcast workers
+// (see EngineBuilder.SetBroadcastWorkers), so a slow socket only holds its worker. It returns once the packet is
+// queued to every socket, with the count of them. It's published to other nodes too if there's an adapter and
+// filter is nil (see EngineBuilder.SetAdapter), the count is of local sockets.
+func (p *engineImpl) Broadcast(data []byte, binary bool, filter func(socket Socket) bool) int {
+	if filter == nil {
+		p.publish(nil, data, binary)
+		p.buffer(nil, data, binary)
+	}
+	return p.broadcast(p.sockets.List(nil), data, binary, filter)
+}
+
+// broadcast sends data to sockets as Broadcast does, sockets isn't modified.
+func (p *engineImpl) broadcast(sockets []*socketImpl, data []byte, binary bool, filter func(socket Socket) bool) int {
+	var option parser.PacketOption
+	if binary {
+		option = parser.BINARY
+	}
+	packet := parser.NewPacketCustom(parser.MESSAGE, data, option)
+	parser.NewPreEncodedPacket(packet)
+	workers := p.options.broadcastWorkers
+	if workers < 1 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if workers > len(sockets) {
+		workers = len(sockets)
+	}
+	var next, sent int64
+	wg := new(sync.WaitGroup)
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for {
+				n := atomic.AddInt64(&next, 1)
+				if n > int64(len(sockets)) {
+					return
+				}
+				socket := sockets[n-1]
+				if filter != nil && !filter(socket) {
+					continue
+				}
+				if err := socket.Send(packet); err == nil {
+					atomic.AddInt64(&sent, 1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return int(sent)
+}
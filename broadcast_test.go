@@ -0,0 +1,84 @@
+package eio
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestBroadcast(t *testing.T) {
+	srv := NewServer(WithBroadcastWorkers(2))
+	defer srv.Close()
+	sockets := make(chan Socket, 4)
+	srv.OnConnect(func(socket Socket) { sockets <- socket })
+	hs := httptest.NewServer(srv)
+	defer hs.Close()
+
+	v3 := dialWebsocket(t, hs, "")
+	readFrame(t, v3)
+	(<-sockets).Set("room", "a")
+	url := "ws" + strings.TrimPrefix(hs.URL, "http") + "/engine.io/?EIO=4&transport=websocket"
+	v4, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer v4.Close()
+	readFrame(t, v4)
+	(<-sockets).Set("room", "a")
+	loopback, err := srv.Loopback()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer loopback.Close()
+	loopback.Receive()
+	(<-sockets).Set("room", "b")
+
+	inRoom := func(socket Socket) bool {
+		room, _ := socket.Get("room")
+		return room == "a"
+	}
+	if n := srv.Broadcast([]byte{0x01, 0x02}, true, inRoom); n != 2 {
+		t.Errorf("should be sent to 2 sockets: %d", n)
+	}
+	if msgType, msg := readFrame(t, v3); msgType != websocket.BinaryMessage || msg != "\x04\x01\x02" {
+		t.Errorf("bad v3 frame: %d %q", msgType, msg)
+	}
+	if msgType, msg := readFrame(t, v4); msgType != websocket.BinaryMessage || msg != "\x01\x02" {
+		t.Errorf("bad v4 frame: %d %q", msgType, msg)
+	}
+	if n := srv.Broadcast([]byte("hi"), false, nil); n != 3 {
+		t.Errorf("should be sent to all: %d", n)
+	}
+	if _, msg := readFrame(t, v3); msg != "4hi" {
+		t.Errorf("bad v3 frame: %q", msg)
+	}
+	if pack, err := loopback.Receive(); err != nil || string(pack.Data) != "hi" {
+		t.Errorf("bad loopback packet: %v %v", pack, err)
+	}
+}
+
+func TestSendTo(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+	sockets := make(chan Socket, 1)
+	srv.OnConnect(func(socket Socket) { sockets <- socket })
+	loopback, err := srv.Loopback()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer loopback.Close()
+	loopback.Receive()
+	socket := <-sockets
+
+	if d, err := srv.SendTo(socket.ID(), []byte("hi"), false); err != nil || d != DeliveryLocal {
+		t.Errorf("should be sent locally: %s %v", d, err)
+	}
+	if pack, err := loopback.Receive(); err != nil || string(pack.Data) != "hi" {
+		t.Errorf("bad packet: %v %v", pack, err)
+	}
+	if d, err := srv.SendTo("nope", []byte("hi"), false); err != nil || d != DeliveryUnknown {
+		t.Errorf("sid should be unknown: %s %v", d, err)
+	}
+}
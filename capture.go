@@ -0,0 +1,201 @@
+package eio
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/jjeffcaii/engine.io/parser"
+)
+
+// CaptureRecord is a packet received or sent by a session, it's a line of JSON in a capture,
+// e.g. {"time":"...","sid":"...","transport":"polling","dir":"in","packet":{"type":"message","data":"hello"}}.
+type CaptureRecord struct {
+	Time      time.Time
+	Sid       string
+	Transport TransportType
+	Direction PacketDirection
+	Packet    *parser.Packet
+}
+
+type captureRecordJSON struct {
+	Time      time.Time      `json:"time"`
+	Sid       string         `json:"sid"`
+	Transport string         `json:"transport"`
+	Direction string         `json:"dir"`
+	Packet    *parser.Packet `json:"packet"`
+}
+
+func (p *CaptureRecord) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&captureRecordJSON{p.Time, p.Sid, p.Transport.String(), p.Direction.String(), p.Packet})
+}
+
+func (p *CaptureRecord) UnmarshalJSON(data []byte) error {
+	var in captureRecordJSON
+	if err := json.Unmarshal(data, &in); err != nil {
+		return err
+	}
+	t, ok := lookupTransport(in.Transport)
+	if !ok {
+		return fmt.Errorf("invalid transport '%s'", in.Transport)
+	}
+	switch in.Direction {
+	default:
+		return fmt.Errorf("invalid direction '%s'", in.Direction)
+	case PacketIn.String():
+		p.Direction = PacketIn
+	case PacketOut.String():
+		p.Direction = PacketOut
+	}
+	if in.Packet == nil {
+		return errors.New("packet of record is missing")
+	}
+	p.Time, p.Sid, p.Transport, p.Packet = in.Time, in.Sid, t, in.Packet
+	return nil
+}
+
+// Capture records the packets received and sent by the sessions of an engine as JSON Lines, see
+// EngineBuilder.SetCapture. The capture of a production incident can be replayed offline by Replay.
+// Streamed bodies are never read, so packets of them are recorded without data. It's safe for concurrent use.
+type Capture struct {
+	lock   sync.Mutex
+	writer io.Writer
+	// filter selects the sessions recorded, all are recorded if it's nil.
+	filter func(sid string) bool
+	err    error
+}
+
+// NewCapture returns a capture writing to writer, only the sessions which filter returns true for are recorded
+// if filter isn't nil.
+func NewCapture(writer io.Writer, filter func(sid string) bool) *Capture {
+	return &Capture{writer: writer, filter: filter}
+}
+
+// Record writes record as a line, it fails since a write has failed.
+func (p *Capture) Record(record *CaptureRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.err != nil {
+		return p.err
+	}
+	if _, err := p.writer.Write(append(line, '\n')); err != nil {
+		p.err = err
+	}
+	return p.err
+}
+
+// Err returns the error of the first write failed, nothing is recorded since.
+func (p *Capture) Err() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.err
+}
+
+// capturePacket records packet of transport t if its session is selected.
+func (p *engineImpl) capturePacket(direction PacketDirection, t Transport, packet *parser.Packet) {
+	socket, _ := t.GetSocket().(*socketImpl)
+	if socket == nil || p.capture.filter != nil && !p.capture.filter(socket.id) {
+		return
+	}
+	if err := p.capture.Record(&CaptureRecord{time.Now(), socket.id, t.GetType(), direction, packet}); err != nil {
+		p.logTransport(t, slog.LevelDebug, "capture_failed", "capture packet failed", LogKeyError, err)
+	}
+}
+
+// ReadCapture reads the records of a capture.
+func ReadCapture(reader io.Reader) ([]*CaptureRecord, error) {
+	decoder := json.NewDecoder(reader)
+	var records []*CaptureRecord
+	for {
+		record := new(CaptureRecord)
+		if err := decoder.Decode(record); err == io.EOF {
+			return records, nil
+		} else if err != nil {
+			return records, err
+		}
+		records = append(records, record)
+	}
+}
+
+// ReplayOptions are the options of Replay.
+type ReplayOptions struct {
+	// Speed scales the recorded intervals between packets, e.g. 2 replays twice as fast.
+	// Packets are fed without delay if it's 0.
+	Speed float64
+	// Linger is how long the session stays open after the last packet, so the replies to it are received.
+	Linger time.Duration
+}
+
+// Replay feeds the packets received by the recorded session sid back to eng through a loopback session, see
+// Engine.Loopback. The replayed session is opened by a handshake of its own, so recorded packets of the upgrade of
+// transports aren't replayed. It returns the packets sent by eng to the replayed session, once the session is closed
+// by a replayed CLOSE or the linger ends. It's canceled by ctx.
+func Replay(ctx context.Context, eng Engine, records []*CaptureRecord, sid string, options ReplayOptions) ([]*parser.Packet, error) {
+	client, err := eng.Loopback()
+	if err != nil {
+		return nil, err
+	}
+	var sent []*parser.Packet
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			packet, err := client.Receive()
+			if err != nil {
+				return
+			}
+			sent = append(sent, packet)
+		}
+	}()
+	err = replay(ctx, client, records, sid, options)
+	if err == nil && options.Linger > 0 {
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+		case <-done:
+		case <-time.After(options.Linger):
+		}
+	}
+	client.Close()
+	<-done
+	return sent, err
+}
+
+func replay(ctx context.Context, client LoopbackClient, records []*CaptureRecord, sid string, options ReplayOptions) error {
+	var last time.Time
+	for _, it := range records {
+		if it.Sid != sid || it.Direction != PacketIn || it.Packet.Type == parser.UPGRADE ||
+			it.Packet.Type == parser.PING && string(it.Packet.Data) == "probe" {
+			continue
+		}
+		if options.Speed > 0 && !last.IsZero() {
+			if delay := time.Duration(float64(it.Time.Sub(last)) / options.Speed); delay > 0 {
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-time.After(delay):
+				}
+			}
+		}
+		last = it.Time
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := client.Send(it.Packet.Clone()); err != nil {
+			return err
+		}
+		if it.Packet.Type == parser.CLOSE {
+			return nil
+		}
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package eio
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jjeffcaii/engine.io/parser"
+)
+
+// echoEngine returns an engine which echoes messages, sockets are sent to sockets if it's not nil.
+func echoEngine(builder *EngineBuilder, sockets chan Socket) Engine {
+	eng := builder.Build()
+	eng.OnConnect(func(socket Socket) {
+		socket.OnMessage(func(data []byte) { socket.Send(data) })
+		if sockets != nil {
+			sockets <- socket
+		}
+	})
+	return eng
+}
+
+func TestCaptureReplay(t *testing.T) {
+	var b bytes.Buffer
+	capture := NewCapture(&b, nil)
+	sockets := make(chan Socket, 1)
+	eng := echoEngine(NewEngineBuilder().SetCapture(capture), sockets)
+	defer eng.Close()
+	client, err := eng.Loopback()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Receive()
+	sid := (<-sockets).ID()
+	client.Send(parser.NewPacketCustom(parser.MESSAGE, []byte("hello"), 0))
+	client.Receive()
+	client.Send(parser.NewPacketCustom(parser.MESSAGE, []byte{1, 2}, parser.BINARY))
+	client.Receive()
+	client.Send(parser.NewPacketCustom(parser.CLOSE, nil, 0))
+	client.Receive()
+	if err := capture.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := ReadCapture(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var in, out int
+	for _, it := range records {
+		if it.Sid != sid || it.Transport != LOOPBACK || it.Time.IsZero() {
+			t.Errorf("bad record: %+v", it)
+		}
+		if it.Direction == PacketIn {
+			in++
+		} else {
+			out++
+		}
+	}
+	if in != 3 || out != 3 || records[0].Packet.Type != parser.OPEN {
+		t.Fatalf("bad records: %d in, %d out", in, out)
+	}
+
+	replayed := echoEngine(NewEngineBuilder(), nil)
+	defer replayed.Close()
+	sent, err := Replay(context.Background(), replayed, records, sid, ReplayOptions{Speed: 100, Linger: 5 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sent) != 3 || sent[0].Type != parser.OPEN || string(sent[1].Data) != "hello" || !bytes.Equal(sent[2].Data, []byte{1, 2}) {
+		t.Errorf("bad packets replayed: %v", sent)
+	}
+}
@@ -0,0 +1,94 @@
+package eio
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	encodingGzip    = "gzip"
+	encodingDeflate = "deflate"
+)
+
+// compressors are pooled, because each of them holds hundreds of KB for the deflate state.
+var (
+	gzipWriters = sync.Pool{
+		New: func() interface{} { return gzip.NewWriter(nil) },
+	}
+	zlibWriters = sync.Pool{
+		New: func() interface{} { return zlib.NewWriter(nil) },
+	}
+)
+
+// acceptEncoding returns the content encoding of polling responses accepted by request, gzip is preferred to deflate.
+// It returns an empty string if neither is accepted.
+func acceptEncoding(request *http.Request) string {
+	var gzipOK, deflateOK bool
+	for _, it := range strings.Split(request.Header.Get("Accept-Encoding"), ",") {
+		name, params := it, ""
+		if i := strings.IndexByte(it, ';'); i >= 0 {
+			name, params = it[:i], it[i+1:]
+		}
+		if rejected(params) {
+			continue
+		}
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case encodingGzip, "*":
+			gzipOK = true
+		case encodingDeflate:
+			deflateOK = true
+		}
+	}
+	if gzipOK {
+		return encodingGzip
+	}
+	if deflateOK {
+		return encodingDeflate
+	}
+	return ""
+}
+
+// rejected returns true if params of an Accept-Encoding entry has q=0.
+func rejected(params string) bool {
+	for _, it := range strings.Split(params, ";") {
+		it = strings.TrimSpace(it)
+		if !strings.HasPrefix(it, "q=") {
+			continue
+		}
+		q, err := strconv.ParseFloat(it[2:], 64)
+		return err == nil && q <= 0
+	}
+	return false
+}
+
+// writeCompressed compresses body with encoding and writes it to writer, the Content-Encoding header is set.
+func writeCompressed(writer http.ResponseWriter, encoding string, body []byte) error {
+	var w io.WriteCloser
+	switch encoding {
+	case encodingGzip:
+		gz := gzipWriters.Get().(*gzip.Writer)
+		defer gzipWriters.Put(gz)
+		gz.Reset(writer)
+		w = gz
+	case encodingDeflate:
+		zw := zlibWriters.Get().(*zlib.Writer)
+		defer zlibWriters.Put(zw)
+		zw.Reset(writer)
+		w = zw
+	default:
+		_, err := writer.Write(body)
+		return err
+	}
+	writer.Header().Set("Content-Encoding", encoding)
+	writer.Header().Add("Vary", "Accept-Encoding")
+	if _, err := w.Write(body); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
@@ -0,0 +1,80 @@
+package eio
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAcceptEncoding(t *testing.T) {
+	cases := map[string]string{
+		"":                       "",
+		"gzip, deflate, br":      encodingGzip,
+		"deflate":                encodingDeflate,
+		"br;q=1.0, deflate;q=.5": encodingDeflate,
+		"gzip;q=0, deflate":      encodingDeflate,
+		"*":                      encodingGzip,
+		"identity, br":           "",
+	}
+	for header, expect := range cases {
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.Header.Set("Accept-Encoding", header)
+		if got := acceptEncoding(request); got != expect {
+			t.Errorf("%q: should be %q, got %q", header, expect, got)
+		}
+	}
+}
+
+func TestPollingCompression(t *testing.T) {
+	eng := NewEngineBuilder().SetHTTPCompressionThreshold(64).Build()
+	defer eng.Close()
+	sockets := make(chan Socket, 1)
+	eng.OnConnect(func(socket Socket) { sockets <- socket })
+	srv := httptest.NewServer(http.HandlerFunc(eng.Router()))
+	defer srv.Close()
+	url, socket := openPolling(t, srv, sockets)
+	defer socket.Close()
+
+	large := strings.Repeat("hello", 100)
+	cases := []struct {
+		accept, message, encoding string
+	}{
+		{"gzip, deflate", large, encodingGzip},
+		{"deflate", large, encodingDeflate},
+		{"gzip", "tiny", ""},
+		{"", large, ""},
+	}
+	for _, it := range cases {
+		socket.Send(it.message)
+		request, _ := http.NewRequest(http.MethodGet, url, nil)
+		// an explicit Accept-Encoding keeps the client from decompressing the response.
+		request.Header.Set("Accept-Encoding", it.accept)
+		res, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := res.Header.Get("Content-Encoding"); got != it.encoding {
+			t.Errorf("%q: should be encoded by %q, got %q", it.accept, it.encoding, got)
+		}
+		var reader io.Reader = res.Body
+		switch it.encoding {
+		case encodingGzip:
+			reader, err = gzip.NewReader(res.Body)
+		case encodingDeflate:
+			reader, err = zlib.NewReader(res.Body)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(reader)
+		res.Body.Close()
+		if expect := "4" + it.message; !strings.HasSuffix(string(body), expect) {
+			t.Errorf("%q: bad payload %q", it.accept, body)
+		}
+	}
+}
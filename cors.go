@@ -0,0 +1,101 @@
+package eio
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSOptions configures the CORS headers of polling responses and preflight requests, see EngineBuilder.SetCORS.
+type CORSOptions struct {
+	// Origins are the origins allowed, "*" allows any origin.
+	Origins []string
+	// AllowOrigin decides whether an origin is allowed if Origins is empty, any origin is allowed if it's nil too.
+	AllowOrigin func(origin string) bool
+	// Credentials allows cookies of cross origin requests, the allowed origin is echoed instead of "*" then.
+	Credentials bool
+	// AllowedHeaders are the request headers allowed by preflight responses. (default is Content-Type)
+	AllowedHeaders []string
+	// MaxAge is how long preflight responses can be cached, zero leaves it to the browser.
+	MaxAge time.Duration
+	// checkOrigin is the origin check of engine, it overrides Origins and AllowOrigin so polling and websocket
+	// allow the same origins, see EngineBuilder.SetAllowedOrigins.
+	checkOrigin func(*http.Request) bool
+}
+
+// defaultCORS allows any origin with credentials.
+var defaultCORS = &CORSOptions{Credentials: true}
+
+func (p *CORSOptions) allowed(origin string) bool {
+	if len(p.Origins) < 1 {
+		return p.AllowOrigin == nil || p.AllowOrigin(origin)
+	}
+	for _, it := range p.Origins {
+		if it == "*" || it == origin {
+			return true
+		}
+	}
+	return false
+}
+
+// setHeaders sets the CORS headers of response for request, nothing is set if the origin of request isn't allowed.
+func (p *CORSOptions) setHeaders(writer http.ResponseWriter, request *http.Request) {
+	origin := request.Header.Get("Origin")
+	header := writer.Header()
+	if len(origin) < 1 {
+		header.Set("Access-Control-Allow-Origin", "*")
+		return
+	}
+	if p.checkOrigin != nil && !p.checkOrigin(request) || p.checkOrigin == nil && !p.allowed(origin) {
+		return
+	}
+	header.Add("Vary", "Origin")
+	if p.Credentials || len(p.Origins) > 0 || p.AllowOrigin != nil || p.checkOrigin != nil {
+		header.Set("Access-Control-Allow-Origin", origin)
+	} else {
+		header.Set("Access-Control-Allow-Origin", "*")
+	}
+	if p.Credentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// errOriginForbidden rejects a request whose origin isn't allowed, see EngineBuilder.SetAllowedOrigins.
+var errOriginForbidden = errors.New("origin forbidden")
+
+// allowedOrigins returns the origin check of origins, "*" allows any origin. Requests without an Origin header
+// aren't sent by browsers, so they're allowed. Origins are compared case-insensitively.
+func allowedOrigins(origins []string) func(*http.Request) bool {
+	return func(request *http.Request) bool {
+		origin := request.Header.Get("Origin")
+		if len(origin) < 1 {
+			return true
+		}
+		for _, it := range origins {
+			if it == "*" || strings.EqualFold(it, origin) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// preflight answers a preflight request.
+func (p *CORSOptions) preflight(writer http.ResponseWriter, request *http.Request) {
+	p.setHeaders(writer, request)
+	header := writer.Header()
+	if len(header.Get("Access-Control-Allow-Origin")) > 0 {
+		header.Set("Access-Control-Allow-Methods", "GET, POST")
+		allowed := "Content-Type"
+		if len(p.AllowedHeaders) > 0 {
+			allowed = strings.Join(p.AllowedHeaders, ", ")
+		}
+		header.Set("Access-Control-Allow-Headers", allowed)
+		if p.MaxAge > 0 {
+			header.Set("Access-Control-Max-Age", strconv.Itoa(int(p.MaxAge.Seconds())))
+		}
+	}
+	writer.WriteHeader(http.StatusOK)
+}
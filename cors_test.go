@@ -0,0 +1,141 @@
+package eio
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestCORS(t *testing.T) {
+	cases := []struct {
+		options        *CORSOptions
+		origin, expect string
+		credentials    bool
+	}{
+		{defaultCORS, "https://a.io", "https://a.io", true},
+		{defaultCORS, "", "*", false},
+		{&CORSOptions{Origins: []string{"https://a.io"}}, "https://a.io", "https://a.io", false},
+		{&CORSOptions{Origins: []string{"https://a.io"}}, "https://b.io", "", false},
+		{&CORSOptions{Origins: []string{"*"}}, "https://b.io", "https://b.io", false},
+		{&CORSOptions{}, "https://b.io", "*", false},
+		{&CORSOptions{AllowOrigin: func(origin string) bool { return strings.HasSuffix(origin, ".a.io") }}, "https://x.a.io", "https://x.a.io", false},
+		{&CORSOptions{AllowOrigin: func(origin string) bool { return false }}, "https://x.a.io", "", false},
+	}
+	for i, it := range cases {
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		if len(it.origin) > 0 {
+			request.Header.Set("Origin", it.origin)
+		}
+		recorder := httptest.NewRecorder()
+		it.options.setHeaders(recorder, request)
+		if got := recorder.Header().Get("Access-Control-Allow-Origin"); got != it.expect {
+			t.Errorf("case %d: should allow %q, got %q", i, it.expect, got)
+		}
+		if got := recorder.Header().Get("Access-Control-Allow-Credentials") == "true"; got != it.credentials {
+			t.Errorf("case %d: credentials should be %v", i, it.credentials)
+		}
+	}
+}
+
+func TestCORSPreflight(t *testing.T) {
+	srv := NewServer(WithCORS(CORSOptions{
+		Origins:        []string{"https://a.io"},
+		AllowedHeaders: []string{"Content-Type", "Authorization"},
+		MaxAge:         time.Hour,
+	}))
+	defer srv.Close()
+	request := httptest.NewRequest(http.MethodOptions, "/engine.io/?EIO=3&transport=polling", nil)
+	request.Header.Set("Origin", "https://a.io")
+	recorder := httptest.NewRecorder()
+	srv.ServeHTTP(recorder, request)
+	header := recorder.Header()
+	if recorder.Code != http.StatusOK || header.Get("Access-Control-Allow-Origin") != "https://a.io" {
+		t.Errorf("bad preflight: %d %v", recorder.Code, header)
+	}
+	if header.Get("Access-Control-Allow-Headers") != "Content-Type, Authorization" || header.Get("Access-Control-Max-Age") != "3600" {
+		t.Errorf("bad preflight: %v", header)
+	}
+
+	request.Header.Set("Origin", "https://b.io")
+	recorder = httptest.NewRecorder()
+	srv.ServeHTTP(recorder, request)
+	if len(recorder.Header().Get("Access-Control-Allow-Methods")) > 0 {
+		t.Errorf("origin shouldn't be allowed: %v", recorder.Header())
+	}
+}
+
+func TestAllowedOrigins(t *testing.T) {
+	srv := NewServer(WithAllowedOrigins("https://a.io"))
+	defer srv.Close()
+	hs := httptest.NewServer(srv)
+	defer hs.Close()
+	for _, it := range []string{http.MethodGet, http.MethodOptions} {
+		if res, body := pollOrigin(t, it, hs.URL, "https://b.io"); res.StatusCode != http.StatusForbidden || !strings.Contains(body, `"code":4`) {
+			t.Errorf("%s of another origin should be forbidden: %d %s", it, res.StatusCode, body)
+		}
+		if res, _ := pollOrigin(t, it, hs.URL, "https://b.io"); len(res.Header.Get("Access-Control-Allow-Origin")) > 0 {
+			t.Errorf("%s of another origin shouldn't be allowed by CORS: %v", it, res.Header)
+		}
+	}
+	// the allow-list governs the CORS headers, though the default CORS allows any origin with credentials.
+	request := httptest.NewRequest(http.MethodGet, "/engine.io/?EIO=4&transport=polling", nil)
+	request.Header.Set("Origin", "https://b.io")
+	recorder := httptest.NewRecorder()
+	srv.Engine.(*engineImpl).cors.setHeaders(recorder, request)
+	if header := recorder.Header(); len(header.Get("Access-Control-Allow-Origin")) > 0 || len(header.Get("Access-Control-Allow-Credentials")) > 0 {
+		t.Errorf("origin shouldn't be allowed by CORS: %v", header)
+	}
+	if res, _ := pollOrigin(t, http.MethodGet, hs.URL, "HTTPS://A.IO"); res.StatusCode != http.StatusOK || res.Header.Get("Access-Control-Allow-Origin") != "HTTPS://A.IO" {
+		t.Errorf("origin should be allowed: %d %v", res.StatusCode, res.Header)
+	}
+	if res, _ := pollOrigin(t, http.MethodGet, hs.URL, ""); res.StatusCode != http.StatusOK {
+		t.Errorf("request without origin should be allowed: %d", res.StatusCode)
+	}
+
+	url := "ws" + strings.TrimPrefix(hs.URL, "http") + "/engine.io/?EIO=4&transport=websocket"
+	if _, res, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": {"https://b.io"}}); err == nil || res == nil || res.StatusCode != http.StatusForbidden {
+		t.Errorf("websocket of another origin should be forbidden: %v", err)
+	}
+	conn, _, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": {"https://a.io"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+}
+
+func TestCheckOrigin(t *testing.T) {
+	srv := NewServer(WithAllowedOrigins("https://a.io"), WithCheckOrigin(func(request *http.Request) bool {
+		return strings.HasSuffix(request.Header.Get("Origin"), ".b.io")
+	}))
+	defer srv.Close()
+	hs := httptest.NewServer(srv)
+	defer hs.Close()
+	for origin, code := range map[string]int{"https://x.b.io": http.StatusOK, "https://a.io": http.StatusForbidden, "": http.StatusForbidden} {
+		if res, _ := pollOrigin(t, http.MethodGet, hs.URL, origin); res.StatusCode != code {
+			t.Errorf("origin %q should be answered with %d: %d", origin, code, res.StatusCode)
+		}
+	}
+}
+
+// pollOrigin sends a handshake of polling to the server at url from origin.
+func pollOrigin(t *testing.T, method, url, origin string) (*http.Response, string) {
+	request, err := http.NewRequest(method, url+"/engine.io/?EIO=4&transport=polling", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(origin) > 0 {
+		request.Header.Set("Origin", origin)
+	}
+	res, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, _ := io.ReadAll(res.Body)
+	return res, string(body)
+}
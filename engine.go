@@ -1,123 +1,476 @@
 package eio
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"log/slog"
+	"net"
 	"net/http"
 	"sync"
 	"sync/atomic"
 	"time"
-)
 
-var (
-	protocolVersion = struct {
-		n uint8
-		s string
-	}{3, "3",}
+	"github.com/gorilla/websocket"
+	"github.com/jjeffcaii/engine.io/parser"
 )
 
+// ErrEngineShutdown rejects the handshakes of new sessions once the engine is shutting down, see Engine.Shutdown.
+var ErrEngineShutdown = errors.New("engine: shutting down")
+
+// shutdownPollInterval is how often Shutdown checks whether in-flight requests are done.
+const shutdownPollInterval = 10 * time.Millisecond
+
+// protocolVersion is the protocol revision of sessions whose handshake has no valid EIO query, such as loopback ones.
+// Sessions of v3 and v4 clients are served side by side, each speaks the revision of its handshake.
+const protocolVersion = parser.V3
+
 type engineOptions struct {
 	allowUpgrades             bool
 	cookie                    bool
+	cookieName                string
 	cookiePath                string
+	cookieDomain              string
+	cookieSameSite            http.SameSite
+	cookieSecure              bool
 	cookieHTTPOnly            bool
 	pingInterval, pingTimeout time.Duration
+	upgradeTimeout            time.Duration
+	writeTimeout              time.Duration
+	slowHandler, slowWrite    time.Duration
+	maxPayload                int64
+	compression               bool
+	compressionLevel          int
+	compressionThreshold      int
+	httpCompression           bool
+	httpCompressionThreshold  int
+	wsReadLimit               int64
+	wsReadBufferSize          int
+	wsWriteBufferSize         int
+	wsSubprotocols            []string
+	wsCodecs                  []string
+	wsKeepalive               time.Duration
+	shutdownMessage           interface{}
+	detachedContext           bool
+	maxConnections            int
+	overloadPolicy            OverloadPolicy
+	overloadQueueTimeout      time.Duration
+	sendQueuePackets          int
+	sendQueueBytes            int64
+	overflowPolicy            OverflowPolicy
+	broadcastWorkers          int
 }
 
 type engineImpl struct {
-	logInfo, logWarn, logErr func(format string, v ...interface{})
-	allowTransports          []TransportType
-	sidGen                   func(seq uint32) string
-	sequence                 uint32
-	path                     string
-	options                  *engineOptions
-	onSockets                []func(Socket)
-	sockets                  *socketMap
-	junkKiller               chan struct{}
-	junkTicker               *time.Ticker
-	allowRequest             func(*http.Request) error
-	checkProtocol            bool
+	logger           Logger
+	logLevels        [logComponents]slog.Level
+	allowTransports  []TransportType
+	idGen            SessionIDGenerator
+	path             string
+	options          *engineOptions
+	onSockets        []func(Socket)
+	onDisconnects    []func(Socket, CloseReason)
+	sockets          *socketMap
+	rooms            *roomMap
+	adapter          Adapter
+	store            SessionStore
+	presence         *presenceImpl
+	membership       *membership
+	recovery         *recovery
+	stats            engineStats
+	tracer           Tracer
+	packetTracer     PacketTracer
+	packetTraceLimit int
+	statsPath        string
+	statsAuth        func(*http.Request) error
+	// started is when the engine is built.
+	started          time.Time
+	events           *EventBus
+	capture          *Capture
+	profileLabels    bool
+	profileTenantKey string
+	router           *SessionRouter
+	routerRedirect   bool
+	node             *clusterNode
+	junkKiller       chan struct{}
+	junkTicker       *time.Ticker
+	allowRequest     func(*http.Request) error
+	allowHandshake   func(*http.Request) (context.Context, error)
+	authenticator    Authenticator
+	handshakeFields  func(Socket) map[string]interface{}
+	admission        func(*http.Request, int) error
+	cors             *CORSOptions
+	checkOrigin      func(*http.Request) bool
+	checkProtocol    bool
+	sessionKey       func(*http.Request) ([]byte, error)
+	wsNegotiation    func(*http.Request, string, []string) error
+	proxies          trustedProxies
+	proxyHeader      string
+	upgrader         *websocket.Upgrader
+	handler          http.Handler
+	closeOnce        sync.Once
+	clusterOnce      sync.Once
+	// shutdown is set to 1 once Shutdown is called, requests counts the requests being served.
+	shutdown int32
+	requests int64
+	// slots holds a token of every open socket if the max connections are limited.
+	slots chan struct{}
 }
 
 func (p *engineImpl) Router() func(http.ResponseWriter, *http.Request) {
 	p.ensureCleaner()
-	return func(writer http.ResponseWriter, request *http.Request) {
-		if request.Method == http.MethodOptions {
-			writer.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-			writer.WriteHeader(http.StatusOK)
+	return p.route
+}
+
+// route serves a request of Router.
+func (p *engineImpl) route(writer http.ResponseWriter, request *http.Request) {
+	atomic.AddInt64(&(p.requests), 1)
+	defer atomic.AddInt64(&(p.requests), -1)
+	if p.statsAuth != nil && request.URL.Path == p.statsPath {
+		p.serveStats(writer, request)
+		return
+	}
+	if request.Method == http.MethodOptions {
+		if p.checkOrigin != nil && !p.checkOrigin(request) {
+			sendError(writer, errOriginForbidden, http.StatusForbidden, 4)
 			return
 		}
-		if !(request.Method == http.MethodGet || request.Method == http.MethodPost) {
-			writer.WriteHeader(http.StatusMethodNotAllowed)
+		p.cors.preflight(writer, request)
+		return
+	}
+	p.handler.ServeHTTP(writer, request)
+}
+
+// serve serves a request of engine.io, it's the innermost handler of middlewares.
+func (p *engineImpl) serve(writer http.ResponseWriter, request *http.Request) {
+	if !(request.Method == http.MethodGet || request.Method == http.MethodPost) {
+		writer.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	query := request.URL.Query()
+	var err error
+	// check protocol version
+	if p.checkProtocol {
+		if err = p.checkVersion(query.Get("EIO")); err != nil {
+			sendError(writer, err, http.StatusBadRequest)
 			return
 		}
-		query := request.URL.Query()
-		var err error
-		// check protocol version
-		if p.checkProtocol {
-			if err = p.checkVersion(query.Get("EIO")); err != nil {
-				sendError(writer, err, http.StatusBadRequest)
-				return
-			}
+	}
+	// check transport
+	var ttype TransportType
+	if ttype, err = p.checkTransport(query.Get("transport")); err != nil {
+		sendError(writer, errors.New("transprot error"), http.StatusBadRequest, 0)
+		return
+	}
+
+	// check origin, so polling and websocket are guarded alike.
+	if p.checkOrigin != nil && !p.checkOrigin(request) {
+		sendError(writer, errOriginForbidden, http.StatusForbidden, 4)
+		return
+	}
+	// check allow request
+	if p.allowRequest != nil {
+		if err := p.allowRequest(request); err != nil {
+			sendError(writer, err, http.StatusNotAcceptable, 0)
+			return
 		}
-		// check transport
-		var ttype TransportType
-		if ttype, err = p.checkTransport(query.Get("transport")); err != nil {
-			sendError(writer, errors.New("transprot error"), http.StatusBadRequest, 0)
+	}
+	if ttype == WEBSOCKET {
+		if _, err := p.wsCodec(request); err != nil {
+			sendError(writer, err, http.StatusBadRequest, 3)
 			return
 		}
-
-		// check allow request
-		if p.allowRequest != nil {
-			if err := p.allowRequest(request); err != nil {
-				sendError(writer, err, http.StatusNotAcceptable, 0)
-				return
-			}
+	}
+	if ttype == WEBSOCKET && p.wsNegotiation != nil {
+		subprotocol := negotiatedSubprotocol(p.options.wsSubprotocols, request)
+		if err := p.wsNegotiation(request, subprotocol, negotiatedExtensions(p.options.compression, request)); err != nil {
+			sendError(writer, err, http.StatusForbidden)
+			return
 		}
+	}
 
-		var sid = query.Get("sid")
-		var isNew = len(sid) < 1
+	var sid = query.Get("sid")
+	var isNew = len(sid) < 1
 
-		var socket *socketImpl
-		var tp Transport
+	var socket *socketImpl
+	var tp Transport
 
-		if isNew {
-			sid = p.generateID()
-			tp, socket = newTransport(p, ttype), newSocket(sid, p)
-			socket.setTransport(tp)
-			tp.setSocket(socket)
-			if err = tp.ready(writer, request); err != nil {
-				sendError(writer, err)
+	if isNew {
+		if atomic.LoadInt32(&(p.shutdown)) == 1 {
+			sendError(writer, ErrEngineShutdown, http.StatusServiceUnavailable)
+			return
+		}
+		// the handshake request of polling ends at once, so only the values of its context are kept.
+		ctx := context.WithoutCancel(request.Context())
+		if p.options.detachedContext {
+			ctx = context.Background()
+		}
+		if p.allowHandshake != nil {
+			c, err := p.allowHandshake(request)
+			if err != nil {
+				sendError(writer, err, http.StatusForbidden, 4)
 				return
 			}
-			socket.OnClose(func(reason string) {
-				p.sockets.Remove(socket)
-			})
-			p.sockets.Put(socket)
-			p.socketCreated(socket)
-		} else if socket0, ok := p.sockets.Get(sid); !ok {
+			if c != nil {
+				ctx = c
+			}
+		}
+		if p.authenticator != nil {
+			metadata, err := p.authenticator(request)
+			if err != nil {
+				sendError(writer, err, http.StatusForbidden, 4)
+				return
+			}
+			ctx = context.WithValue(ctx, authMetadataKey{}, metadata)
+		}
+		remoteAddr := p.remoteAddr(request)
+		ctx, span := p.startSpan(p.extract(ctx, request), SpanHandshake,
+			Attribute{AttrTransport, ttype.String()}, Attribute{AttrRemoteAddr, remoteAddr})
+		tp = newTransport(p, ttype)
+		socket, err = p.openSocket(ctx, tp, remoteAddr, writer, request)
+		if span != nil && socket != nil {
+			span.SetAttributes(Attribute{AttrSid, socket.id})
+		}
+		endSpan(span, err)
+		if err != nil {
+			var rejected *admissionError
+			if errors.As(err, &rejected) {
+				sendError(writer, err, http.StatusServiceUnavailable, codeOverloaded)
+			} else {
+				sendError(writer, err)
+			}
+			return
+		}
+	} else if socket0, ok := p.sockets.Get(sid); !ok {
+		if !p.redirect(writer, request, sid, ttype) && !p.relay(writer, request, sid, ttype) {
 			sendError(writer, fmt.Errorf("%s:socket#%s doesn't exist", request.Method, sid))
+		}
+		return
+	} else {
+		socket = socket0
+		// a session speaks the revision of its handshake till the end.
+		if protocol, err := parser.ParseProtocol(query.Get("EIO")); err == nil && protocol != socket0.protocol {
+			sendError(writer, fmt.Errorf("%s:socket#%s speaks EIO=%s", request.Method, sid, socket0.protocol), http.StatusBadRequest)
 			return
-		} else {
-			socket = socket0
-			tp0 := socket0.getTransport()
-			ttype0 := tp0.GetType()
-			if ttype > ttype0 {
-				tp = newTransport(p, ttype)
-				tp.setSocket(socket)
-				socket.setTransport(tp)
-			} else if ttype < ttype0 {
-				tp = socket0.getTransportOld()
-			} else {
-				tp = tp0
+		}
+		tp0 := socket0.getTransport()
+		ttype0 := tp0.GetType()
+		if ttype > ttype0 {
+			tp = newTransport(p, ttype)
+			tp.setSocket(socket)
+			if err = socket.setTransport(tp); err != nil {
+				sendError(writer, err, http.StatusBadRequest)
+				return
+			}
+			_, span := p.startSpan(p.extract(socket.ctx, request), SpanUpgrade,
+				Attribute{AttrSid, sid}, Attribute{AttrTransport, ttype.String()})
+			socket.traceUpgrade(tp, span)
+		} else if ttype < ttype0 {
+			// requests of the old transport are served until the upgrade is done.
+			if tp = socket0.getTransportOld(); tp == nil {
+				sendError(writer, fmt.Errorf("%s:socket#%s is upgraded to %s", request.Method, sid, ttype0), http.StatusBadRequest)
+				return
 			}
+		} else {
+			tp = tp0
+		}
+		if tp.GetType() == POLLING && p.tracer != nil {
+			ctx, span := p.startSpan(p.extract(request.Context(), request), SpanPoll,
+				Attribute{AttrSid, sid}, Attribute{AttrMethod, request.Method})
+			defer endSpan(span, nil)
+			request = request.WithContext(ctx)
 		}
-		tp.doReq(writer, request)
+	}
+	socket.profile(tp.GetType(), func() { tp.doReq(writer, request) })
+}
+
+// remoteAddr returns the address of client of request, which is resolved by the proxy header if the peer is trusted.
+func (p *engineImpl) remoteAddr(request *http.Request) string {
+	if p.proxies == nil {
+		return request.RemoteAddr
+	}
+	return p.proxies.remoteAddr(request, p.proxyHeader)
+}
+
+// sessionCookie returns the cookie of handshake responses, it's nil if cookie is disabled.
+func (p *engineImpl) sessionCookie(id string) *http.Cookie {
+	if !p.options.cookie {
+		return nil
+	}
+	return &http.Cookie{
+		Name:     p.options.cookieName,
+		Value:    id,
+		Path:     p.options.cookiePath,
+		Domain:   p.options.cookieDomain,
+		SameSite: p.options.cookieSameSite,
+		Secure:   p.options.cookieSecure,
+		HttpOnly: p.options.cookieHTTPOnly,
 	}
 }
 
+// openSocket creates a socket of client at remoteAddr on transport tp and sends the handshake by tp,
+// the context of socket is derived from ctx.
+func (p *engineImpl) openSocket(ctx context.Context, tp Transport, remoteAddr string, writer http.ResponseWriter, request *http.Request) (*socketImpl, error) {
+	if atomic.LoadInt32(&(p.shutdown)) == 1 {
+		return nil, ErrEngineShutdown
+	}
+	release, err := p.admit(request)
+	if err != nil {
+		return nil, err
+	}
+	socket, recovered := p.reserveRecovered(ctx, remoteAddr, request)
+	if socket == nil {
+		if socket, err = p.reserveSocket(ctx, remoteAddr, request); err != nil {
+			release()
+			return nil, err
+		}
+	}
+	if p.recovery != nil && request != nil {
+		socket.recoveryToken = newRecoveryToken()
+	}
+	if request != nil {
+		if protocol, err := parser.ParseProtocol(request.URL.Query().Get("EIO")); err == nil {
+			socket.protocol = protocol
+		}
+	}
+	socket.setTransport(tp)
+	tp.setSocket(socket)
+	if cookie := p.sessionCookie(socket.id); cookie != nil && writer != nil {
+		http.SetCookie(writer, cookie)
+	}
+	if err := tp.ready(writer, request); err != nil {
+		p.sockets.Remove(socket)
+		socket.cancel()
+		release()
+		return nil, err
+	}
+	p.watchSocket(socket, release, request != nil)
+	if metadata, _ := ctx.Value(authMetadataKey{}).(map[string]interface{}); metadata != nil {
+		for k, v := range metadata {
+			socket.Set(k, v)
+		}
+	}
+	if request != nil {
+		p.saveSession(socket)
+	}
+	if recovered != nil {
+		p.resume(socket, recovered)
+	}
+	if p.presence != nil && len(p.presence.key) < 1 {
+		p.presence.track(socket, socket.id)
+	}
+	if socket.protocol == parser.V4 {
+		socket.startPing()
+	}
+	atomic.AddUint64(&(p.stats.handshakes), 1)
+	p.socketCreated(socket)
+	return socket, nil
+}
+
+// watchSocket cleans up socket once it's closed, release gives back its admission and the session is deleted
+// from the store if it's shared. A migrated socket stays in the rooms and the store of cluster, its new owner has them.
+// A socket suspended for recovery stays in the rooms of cluster until the recovery window ends.
+func (p *engineImpl) watchSocket(socket *socketImpl, release func(), shared bool) {
+	socket.OnClose(func(reason string) {
+		p.sockets.Remove(socket)
+		p.rooms.leave(socket, nil)
+		p.stats.closed(socket.closeReason)
+		migrated := socket.closeReason == CloseMigrated
+		if !migrated && !socket.suspended {
+			p.clusterLeave(socket.id, nil)
+		}
+		if p.presence != nil {
+			p.presence.untrack(socket)
+		}
+		if shared && !migrated {
+			p.deleteSession(socket)
+		}
+		release()
+		for _, fn := range p.onDisconnects {
+			fn(socket, socket.closeReason)
+		}
+		p.events.publish(EventDisconnect, socket, func(event *Event) { event.Reason = socket.closeReason })
+	})
+}
+
+func (p *engineImpl) Serve(listener net.Listener) error {
+	p.ensureCleaner()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			tp := newStreamTransport(p, conn)
+			socket, err := p.openSocket(context.Background(), tp, conn.RemoteAddr().String(), nil, nil)
+			if err != nil {
+				p.log(LogEngine, slog.LevelError, "handshake_failed", "open stream socket failed",
+					LogKeyTransport, tp.GetType().String(), LogKeyRemoteAddr, conn.RemoteAddr().String(), LogKeyError, err)
+				conn.Close()
+				return
+			}
+			socket.profile(STREAM, tp.serve)
+		}()
+	}
+}
+
+func (p *engineImpl) Loopback() (LoopbackClient, error) {
+	p.ensureCleaner()
+	tp := newLoopbackTransport(p)
+	socket, err := p.openSocket(context.Background(), tp, loopbackAddr, nil, nil)
+	if err != nil {
+		tp.close()
+		return nil, err
+	}
+	go socket.profile(LOOPBACK, tp.serve)
+	return &loopbackClient{tp}, nil
+}
+
 func (p *engineImpl) Close() {
-	close(p.junkKiller)
+	p.closeOnce.Do(func() { close(p.junkKiller) })
+	for _, it := range p.sockets.List(nil) {
+		it.closeWith(CloseServerShutdown, nil)
+	}
+	if p.recovery != nil {
+		p.recovery.close()
+	}
+	p.clusterOnce.Do(func() {
+		if p.adapter != nil {
+			if err := p.adapter.Close(); err != nil {
+				p.log(LogCluster, slog.LevelError, "close_failed", "close adapter failed", LogKeyError, err)
+			}
+		}
+		if p.store != nil {
+			if err := p.store.Close(); err != nil {
+				p.log(LogCluster, slog.LevelError, "close_failed", "close session store failed", LogKeyError, err)
+			}
+		}
+		if p.membership != nil {
+			p.membership.close()
+		}
+	})
+}
+
+func (p *engineImpl) Shutdown(ctx context.Context) error {
+	atomic.StoreInt32(&(p.shutdown), 1)
+	defer p.Close()
+	for _, it := range p.sockets.List(nil) {
+		if p.options.shutdownMessage != nil {
+			it.Send(p.options.shutdownMessage)
+		}
+		it.closeWith(CloseServerShutdown, nil)
+	}
+	// closed polling transports answer their pending polls with CLOSE, websocket connections are closed.
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for atomic.LoadInt64(&(p.requests)) > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
 }
 
 func (p *engineImpl) Listen(addr string) error {
@@ -126,7 +479,7 @@ func (p *engineImpl) Listen(addr string) error {
 }
 
 func (p *engineImpl) GetProtocol() uint8 {
-	return protocolVersion.n
+	return uint8(protocolVersion)
 }
 
 func (p *engineImpl) GetClients() map[string]Socket {
@@ -146,22 +499,22 @@ func (p *engineImpl) OnConnect(onConn func(socket Socket)) Engine {
 	return p
 }
 
+func (p *engineImpl) OnDisconnect(onDisconn func(socket Socket, reason CloseReason)) Engine {
+	p.onDisconnects = append(p.onDisconnects, onDisconn)
+	return p
+}
+
 func (p *engineImpl) checkVersion(v string) error {
-	if v != protocolVersion.s {
+	if _, err := parser.ParseProtocol(v); err != nil {
 		return fmt.Errorf("illegal protocol version: EIO=%s", v)
 	}
 	return nil
 }
 
 func (p *engineImpl) checkTransport(qTransport string) (TransportType, error) {
-	var t TransportType
-	switch qTransport {
-	default:
+	t, ok := lookupTransport(qTransport)
+	if entry, _ := transportEntryOf(t); !ok || entry.direct {
 		return -1, fmt.Errorf("invalid transport '%s'", qTransport)
-	case "polling":
-		t = POLLING
-	case "websocket":
-		t = WEBSOCKET
 	}
 	for _, it := range p.allowTransports {
 		if t == it {
@@ -171,19 +524,42 @@ func (p *engineImpl) checkTransport(qTransport string) (TransportType, error) {
 	return -1, fmt.Errorf("transport '%s' is forbiden", qTransport)
 }
 
-func (p *engineImpl) generateID() string {
-	if atomic.CompareAndSwapUint32(&(p.sequence), 0xFFFF, 0) {
-		return p.sidGen(0)
+// reserveSocket creates a socket whose session ID is put in sockets, so it's unique before the handshake is sent.
+// IDs colliding with open sessions are generated again, so are the ones owned by other nodes of the session router
+// until maxRoutedIDAttempts.
+func (p *engineImpl) reserveSocket(ctx context.Context, remoteAddr string, request *http.Request) (*socketImpl, error) {
+	misrouted := 0
+	for i := 0; i < maxSessionIDAttempts; i++ {
+		id, err := p.idGen.Generate(request)
+		if err != nil {
+			return nil, err
+		}
+		if misrouted < maxRoutedIDAttempts && !p.owns(id) {
+			misrouted++
+			i--
+			continue
+		}
+		socket := newSocket(ctx, id, p, remoteAddr)
+		if p.sockets.Put(socket) {
+			return socket, nil
+		}
+		socket.cancel()
+		p.log(LogEngine, slog.LevelWarn, "sid_collision", "session ID collides", LogKeySid, id)
 	}
-	return p.sidGen(atomic.AddUint32(&(p.sequence), 1))
+	return nil, fmt.Errorf("session ID collides %d times", maxSessionIDAttempts)
 }
 
 func (p *engineImpl) ensureCleaner() {
 	if p.junkTicker != nil {
 		return
 	}
-	p.junkTicker = time.NewTicker(time.Millisecond * time.Duration(p.options.pingTimeout))
-	// cron: check and kill lost socket.
+	// a lost socket is closed in a tick after it's lost.
+	tick := p.options.pingTimeout
+	if p.options.pingInterval < tick {
+		tick = p.options.pingInterval
+	}
+	p.junkTicker = time.NewTicker(tick)
+	// cron: check and kill lost socket, whose heartbeat is missed.
 	go func() {
 		var end bool
 		for {
@@ -197,11 +573,9 @@ func (p *engineImpl) ensureCleaner() {
 				})
 				if len(losts) > 0 {
 					for _, it := range losts {
-						it.Close()
-					}
-					if p.logInfo != nil {
-						p.logInfo("***** kill %d DEAD sockets *****\n", len(losts))
+						it.closeWith(ClosePingTimeout, nil)
 					}
+					p.log(LogEngine, slog.LevelInfo, "ping_timeout", "kill dead sockets", "count", len(losts))
 				}
 				break
 			case <-p.junkKiller:
@@ -220,47 +594,5 @@ func (p *engineImpl) socketCreated(socket *socketImpl) {
 	for _, fn := range p.onSockets {
 		fn(socket)
 	}
-}
-
-type socketMap struct {
-	store *sync.Map
-}
-
-func (p *socketMap) Get(id string) (*socketImpl, bool) {
-	val, ok := p.store.Load(id)
-	if ok {
-		return val.(*socketImpl), ok
-	}
-	return nil, ok
-}
-
-func (p *socketMap) Put(socket *socketImpl) {
-	if _, ok := p.store.LoadOrStore(socket.ID(), socket); ok {
-		panic(fmt.Errorf("socket#%s exists already", socket.ID()))
-	}
-}
-
-func (p *socketMap) Remove(socket *socketImpl) {
-	p.store.Delete(socket.ID())
-}
-
-func (p *socketMap) Count() int {
-	var c int
-	p.store.Range(func(_, _ interface{}) bool {
-		c++
-		return true
-	})
-	return c
-}
-
-func (p *socketMap) List(filter func(impl *socketImpl) bool) []*socketImpl {
-	ret := make([]*socketImpl, 0)
-	p.store.Range(func(key, value interface{}) bool {
-		it := value.(*socketImpl)
-		if filter == nil || filter(it) {
-			ret = append(ret, it)
-		}
-		return true
-	})
-	return ret
+	p.events.publish(EventConnect, socket, nil)
 }
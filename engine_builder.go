@@ -1,35 +1,81 @@
 package eio
 
 import (
+	"compress/flate"
+	"context"
 	"errors"
-	"math/rand"
+	"fmt"
+	"log/slog"
+	"net"
 	"net/http"
 	"strings"
-	"sync"
 	"time"
+
+	"github.com/jjeffcaii/engine.io/parser"
 )
 
 const (
 	defaultPingTimeout  = 60 * time.Second
 	defaultPingInterval = 25 * time.Second
-	defaultCookiePath   = "/"
+	// defaultUpgradeTimeout and defaultCompressionThreshold are the same as the JS implementation.
+	defaultUpgradeTimeout       = 10 * time.Second
+	defaultCompressionThreshold = 1024
+	defaultWebsocketBufferSize  = 1024
+	// defaultMaxPayload is the maxHttpBufferSize of the JS implementation.
+	defaultMaxPayload           = 1e6
+	defaultCookieName           = "io"
+	defaultCookiePath           = "/"
+	defaultOverloadQueueTimeout = 5 * time.Second
 )
 
-func init() {
-	rand.Seed(time.Now().UnixNano())
-}
-
 var defaultTransports = []TransportType{POLLING, WEBSOCKET}
 
 // EngineBuilder is a builder for Engine.
 type EngineBuilder struct {
 	l1, l2, l3      func(format string, v ...interface{})
+	logger          Logger
+	logLevels       [logComponents]slog.Level
 	allowTransports []TransportType
 	options         *engineOptions
 	path            string
-	gen             func(uint32) string
+	idGen           SessionIDGenerator
 	allowRequest    func(*http.Request) error
+	allowHandshake  func(*http.Request) (context.Context, error)
+	authenticator   Authenticator
+	handshakeFields func(Socket) map[string]interface{}
+	admission       func(*http.Request, int) error
+	cors            *CORSOptions
+	allowedOrigins  []string
+	checkOrigin     func(*http.Request) bool
 	checkProtocol   bool
+	sessionKey      func(*http.Request) ([]byte, error)
+	wsNegotiation   func(*http.Request, string, []string) error
+	proxies         trustedProxies
+	proxyHeader     string
+	middlewares     []Middleware
+	adapter         Adapter
+	store           SessionStore
+	// presence enables the presence of users keyed by presenceKey.
+	presence          bool
+	presenceKey       string
+	presenceRetention time.Duration
+	// membership gossips on membershipConn.
+	membershipConn      net.PacketConn
+	membershipDiscovery Discovery
+	membershipInterval  time.Duration
+	membershipKey       []byte
+	nodeID              string
+	router              *SessionRouter
+	routerRedirect      bool
+	recoveryWindow      time.Duration
+	capture             *Capture
+	profileLabels       bool
+	profileTenantKey    string
+	tracer              Tracer
+	packetTracer        PacketTracer
+	packetTraceLimit    int
+	statsPath           string
+	statsAuth           func(*http.Request) error
 }
 
 // ForceCheckProtocol force check eio protocol version in query EIO.
@@ -44,19 +90,357 @@ func (p *EngineBuilder) SetAllowRequest(validator func(*http.Request) error) *En
 	return p
 }
 
+// Use appends middlewares which run on every request of engine.io, such as handshakes, polls and websocket upgrades,
+// but not CORS preflight requests. They run in order, the first one is the outermost, so it can serve as auth, logging,
+// rate limiting or tenant resolution. A handshake request has no sid query. A middleware wrapping the response writer
+// should keep http.Flusher and http.Hijacker working, websocket upgrades and streaming polls need them.
+func (p *EngineBuilder) Use(middlewares ...Middleware) *EngineBuilder {
+	for _, it := range middlewares {
+		if it == nil {
+			panic(errors.New("invalid middleware: middleware is nil"))
+		}
+	}
+	p.middlewares = append(p.middlewares, middlewares...)
+	return p
+}
+
+// SetAdapter define the adapter connecting the engine to other nodes of a cluster, so unfiltered broadcasts and
+// sends of rooms reach sockets of any node. It's started by Build and closed by Engine.Close.
+func (p *EngineBuilder) SetAdapter(adapter Adapter) *EngineBuilder {
+	p.adapter = adapter
+	return p
+}
+
+// SetSessionStore define the store sharing sessions with other nodes of a cluster, so polling requests landing on
+// a node which doesn't own their session are relayed to the owner instead of failing. It's started by Build and
+// closed by Engine.Close.
+func (p *EngineBuilder) SetSessionStore(store SessionStore) *EngineBuilder {
+	p.store = store
+	return p
+}
+
+// SetMembership define the membership of cluster nodes, which gossip the alive nodes on conn once an interval.
+// Peers are found by discovery, a node is dead if it isn't heard in 5 intervals, then its state is expired by the
+// adapter and the session store which are Expirers. (default interval is 1 second) conn is closed by Engine.Close.
+func (p *EngineBuilder) SetMembership(conn net.PacketConn, discovery Discovery, interval time.Duration) *EngineBuilder {
+	if conn == nil {
+		panic(errors.New("invalid membership connection: nil"))
+	}
+	if interval < 0 {
+		panic(fmt.Errorf("invalid membership interval: %s", interval))
+	}
+	p.membershipConn, p.membershipDiscovery, p.membershipInterval = conn, discovery, interval
+	return p
+}
+
+// SetMembershipKey define the key shared by the nodes of cluster, gossip is signed by HMAC-SHA256 of it and gossip
+// unsigned or signed by another key is dropped. Gossip is unauthenticated UDP without it, so any host reaching the
+// membership address could mark nodes dead, whose state is expired then.
+func (p *EngineBuilder) SetMembershipKey(key []byte) *EngineBuilder {
+	if len(key) < 1 {
+		panic(errors.New("invalid membership key: empty"))
+	}
+	p.membershipKey = append([]byte(nil), key...)
+	return p
+}
+
+// SetNodeID define the ID of the engine in a cluster, which is random by default. It's the node of a SessionRouter.
+func (p *EngineBuilder) SetNodeID(id string) *EngineBuilder {
+	if len(id) < 1 {
+		panic(errors.New("invalid node ID: empty"))
+	}
+	p.nodeID = id
+	return p
+}
+
+// SetSessionRouter define the router of sids, handshakes generate the sids owned by the engine if it's a node of
+// router, so the adapter sends to sockets by their owners. Polling requests of sessions owned by other nodes are
+// redirected to the URLs of owners by 307 if redirect is true, instead of being relayed by the session store.
+func (p *EngineBuilder) SetSessionRouter(router *SessionRouter, redirect bool) *EngineBuilder {
+	if router == nil {
+		panic(errors.New("invalid session router: nil"))
+	}
+	p.router, p.routerRedirect = router, redirect
+	return p
+}
+
+// SetRecovery enable the recovery of sessions closed by a ping timeout or a transport error. Their state and the
+// messages sent to them by broadcasts, rooms and Engine.SendTo are kept for window, a client handshaking with the
+// recoveryToken of its handshake in the recover query resumes the session of the same sid, then the missed messages
+// are sent in order (see Socket.Recovered). A session missing more than 1024 messages can't be recovered.
+func (p *EngineBuilder) SetRecovery(window time.Duration) *EngineBuilder {
+	if window <= 0 {
+		panic(fmt.Errorf("invalid recovery window: %s", window))
+	}
+	p.recoveryWindow = window
+	return p
+}
+
+// SetTracer define the tracer of handshakes, upgrades, polling requests and messages, see Tracer.
+// The trace context of handshake requests is extracted into the contexts of sockets.
+func (p *EngineBuilder) SetTracer(tracer Tracer) *EngineBuilder {
+	if tracer == nil {
+		panic(errors.New("invalid tracer: nil"))
+	}
+	p.tracer = tracer
+	return p
+}
+
+// SetPacketTracer define the tracer of every packet received or sent, see PacketTracer. The encoded packets are traced
+// up to limit bytes, or without data if limit is 0.
+func (p *EngineBuilder) SetPacketTracer(tracer PacketTracer, limit int) *EngineBuilder {
+	if tracer == nil {
+		panic(errors.New("invalid packet tracer: nil"))
+	}
+	if limit < 0 {
+		panic(fmt.Errorf("invalid packet trace limit: %d", limit))
+	}
+	p.packetTracer, p.packetTraceLimit = tracer, limit
+	return p
+}
+
+// SetStatsEndpoint enable the endpoint serving the live stats and the counters of engine as JSON at path,
+// see Engine.LiveStats and Engine.Stats. The default path is "stats" under the path of engine, e.g. /engine.io/stats.
+// auth checks every request of the endpoint, an error rejects the request with 403.
+func (p *EngineBuilder) SetStatsEndpoint(path string, auth func(*http.Request) error) *EngineBuilder {
+	if auth == nil {
+		panic(errors.New("invalid stats auth: nil"))
+	}
+	p.statsPath, p.statsAuth = path, auth
+	return p
+}
+
+// SetProfileLabels enable the pprof labels of the goroutines serving sessions, such as the requests of transports,
+// the dispatch of messages and the close handlers. So CPU and goroutine profiles can be told apart by the labels
+// ProfileLabelSid and ProfileLabelTransport, and ProfileLabelTenant which is the metadata tenantKey of socket
+// if tenantKey isn't empty, see Socket.Set. Labels are taken when a goroutine starts serving.
+func (p *EngineBuilder) SetProfileLabels(tenantKey string) *EngineBuilder {
+	p.profileLabels, p.profileTenantKey = true, tenantKey
+	return p
+}
+
+// SetCapture define the capture recording the packets of sessions, see Capture and Replay.
+func (p *EngineBuilder) SetCapture(capture *Capture) *EngineBuilder {
+	if capture == nil {
+		panic(errors.New("invalid capture: nil"))
+	}
+	p.capture = capture
+	return p
+}
+
+// SetPresence enable the presence of users, see Engine.Presence. A user is the value of metadata key of its sockets
+// (see Socket.Set), it's formatted by fmt.Sprint unless it's a string. Every socket is a user of its sid if key is empty.
+// Offline users are forgotten after retention, they are kept forever if it's 0.
+func (p *EngineBuilder) SetPresence(key string, retention time.Duration) *EngineBuilder {
+	if retention < 0 {
+		panic(fmt.Errorf("invalid presence retention: %s", retention))
+	}
+	p.presence, p.presenceKey, p.presenceRetention = true, key, retention
+	return p
+}
+
+// SetAllowHandshake set a function that receives the handshake request of a new session before it's created.
+// The context it returns replaces the context of socket (see Socket.Context), so it shouldn't be canceled
+// along with the request. An error rejects the handshake with 403 and the error code 4 unless it's a *RequestError.
+func (p *EngineBuilder) SetAllowHandshake(fn func(*http.Request) (context.Context, error)) *EngineBuilder {
+	p.allowHandshake = fn
+	return p
+}
+
+// SetAuthenticator define the authenticator of the handshakes of new sessions, it runs after the function of
+// SetAllowHandshake, see Authenticator and NewJWTAuthenticator.
+func (p *EngineBuilder) SetAuthenticator(authenticator Authenticator) *EngineBuilder {
+	if authenticator == nil {
+		panic(errors.New("invalid authenticator: nil"))
+	}
+	p.authenticator = authenticator
+	return p
+}
+
+// SetMaxConnections define how many sockets can be open at most, handshakes over it are handled by the
+// overload policy, see SetOverloadPolicy. It applies to sessions of any transport. (default is 0, which means no limit)
+func (p *EngineBuilder) SetMaxConnections(max int) *EngineBuilder {
+	p.options.maxConnections = max
+	return p
+}
+
+// SetOverloadPolicy define what happens to handshakes once the max connections are open. (default is OverloadReject)
+func (p *EngineBuilder) SetOverloadPolicy(policy OverloadPolicy) *EngineBuilder {
+	p.options.overloadPolicy = policy
+	return p
+}
+
+// SetOverloadQueueTimeout define how long a handshake is held by OverloadQueue. (default is 5 seconds)
+func (p *EngineBuilder) SetOverloadQueueTimeout(timeout time.Duration) *EngineBuilder {
+	p.options.overloadQueueTimeout = timeout
+	return p
+}
+
+// SetAdmission set a function that decides whether a new session is admitted before it's created, it receives the
+// handshake request (nil for sessions out of http) and the count of open sockets. An error rejects the handshake
+// with 503 and the error code 6 unless it's a *RequestError.
+func (p *EngineBuilder) SetAdmission(fn func(request *http.Request, connections int) error) *EngineBuilder {
+	p.admission = fn
+	return p
+}
+
+// SetDetachedContext define whether contexts of sockets are detached from their handshake requests. By default
+// the context of socket keeps the values of the request context, but it's canceled only once the socket is closed.
+// A detached context derives from context.Background.
+func (p *EngineBuilder) SetDetachedContext(detached bool) *EngineBuilder {
+	p.options.detachedContext = detached
+	return p
+}
+
+// SetHandshakeFields set a function that returns extra fields of the OPEN packet body of a new socket,
+// such as the server region or feature flags. Fields of the protocol (sid, upgrades, pingInterval...) can't be replaced.
+func (p *EngineBuilder) SetHandshakeFields(fn func(socket Socket) map[string]interface{}) *EngineBuilder {
+	p.handshakeFields = fn
+	return p
+}
+
+// SetTrustedProxies define the proxies (CIDRs or single IPs) whose handshake requests are resolved to the address
+// of client by the proxy header, see SetProxyHeader. So Socket.RemoteAddr reports the real client behind load balancers,
+// and clients can't forge their addresses by sending the header. No proxies disables the resolution. (default)
+// It panics if a CIDR is invalid.
+func (p *EngineBuilder) SetTrustedProxies(cidrs ...string) *EngineBuilder {
+	if len(cidrs) < 1 {
+		p.proxies = nil
+		return p
+	}
+	proxies, err := parseTrustedProxies(cidrs)
+	if err != nil {
+		panic(err)
+	}
+	p.proxies = proxies
+	return p
+}
+
+// SetProxyHeader define the header set by trusted proxies, which is HeaderXForwardedFor, HeaderForwarded or HeaderXRealIP.
+// Only one header is taken, since a client could forge the others which aren't overwritten by proxies.
+// (default is X-Forwarded-For)
+func (p *EngineBuilder) SetProxyHeader(header string) *EngineBuilder {
+	for _, it := range []string{HeaderXForwardedFor, HeaderForwarded, HeaderXRealIP} {
+		if strings.EqualFold(header, it) {
+			p.proxyHeader = it
+			return p
+		}
+	}
+	panic(fmt.Errorf("invalid proxy header: %s", header))
+}
+
+// SetCORS define the CORS headers of polling responses and preflight requests, as the cors option of the JS implementation.
+// By default any origin is allowed with credentials.
+func (p *EngineBuilder) SetCORS(options CORSOptions) *EngineBuilder {
+	options.Origins = append([]string(nil), options.Origins...)
+	options.AllowedHeaders = append([]string(nil), options.AllowedHeaders...)
+	p.cors = &options
+	return p
+}
+
+// SetAllowedOrigins define the origins allowed to connect, "*" allows any origin. Requests of other origins are
+// rejected with 403 and the error code 4, both polling requests with their preflights and websocket upgrades.
+// It overrides the origins of SetCORS, so CORS headers are sent to the allowed origins only.
+// Requests without an Origin header are allowed, as they aren't sent by browsers. (default is any origin)
+func (p *EngineBuilder) SetAllowedOrigins(origins ...string) *EngineBuilder {
+	p.allowedOrigins = append([]string(nil), origins...)
+	return p
+}
+
+// SetCheckOrigin define a function that decides whether the origin of a request is allowed, it's applied as
+// SetAllowedOrigins is and overrides it.
+func (p *EngineBuilder) SetCheckOrigin(fn func(*http.Request) bool) *EngineBuilder {
+	if fn == nil {
+		panic(errors.New("invalid origin check: nil"))
+	}
+	p.checkOrigin = fn
+	return p
+}
+
+// SetSessionKey set a function that returns the AES key (16, 24 or 32 bytes) of a session from its websocket request,
+// the key is exchanged out of band. MESSAGE packets of the session are encrypted with AES-GCM and sent in binary frames,
+// see parser.NewAESGCMCodec. Only the websocket transport is encrypted, polling requests of the session stay in plain
+// text until it's upgraded. A nil key leaves the session unencrypted, an error rejects the connection.
+func (p *EngineBuilder) SetSessionKey(fn func(*http.Request) ([]byte, error)) *EngineBuilder {
+	p.sessionKey = fn
+	return p
+}
+
+// SetWebsocketKeepalive define the interval of websocket ping frames, which keep intermediaries such as load balancers
+// from closing connections looking idle between engine.io heartbeats. They are answered by clients on the protocol level,
+// not as PING packets. (default is 0, which means no ping frame)
+func (p *EngineBuilder) SetWebsocketKeepalive(interval time.Duration) *EngineBuilder {
+	p.options.wsKeepalive = interval
+	return p
+}
+
+// SetWebsocketSubprotocols define the Sec-WebSocket-Protocol values accepted, in the order of preference.
+// The first one requested by a client is chosen, see WebsocketTransport.Subprotocol.
+func (p *EngineBuilder) SetWebsocketSubprotocols(protocols ...string) *EngineBuilder {
+	p.options.wsSubprotocols = make([]string, len(protocols))
+	copy(p.options.wsSubprotocols, protocols)
+	return p
+}
+
+// SetWebsocketCodecs define the codecs which clients can select by the 'codec' query of websocket handshakes,
+// see parser.RegisterCodec. A codec not in the list is rejected with 400. (default is none, so frames are encoded by
+// the protocol of session)
+func (p *EngineBuilder) SetWebsocketCodecs(names ...string) *EngineBuilder {
+	for _, it := range names {
+		if _, ok := parser.LookupCodec(it); !ok {
+			panic(fmt.Errorf("invalid websocket codec: '%s' isn't registered", it))
+		}
+	}
+	p.options.wsCodecs = make([]string, len(names))
+	copy(p.options.wsCodecs, names)
+	return p
+}
+
+// SetWebsocketNegotiation set a function that inspects the subprotocol and extensions negotiated for a websocket request
+// before it's upgraded, the subprotocol is empty if none is chosen. An error rejects the request with 403.
+func (p *EngineBuilder) SetWebsocketNegotiation(fn func(request *http.Request, subprotocol string, extensions []string) error) *EngineBuilder {
+	p.wsNegotiation = fn
+	return p
+}
+
+// SetLogger define the logger of engine, logs of every component are at least of slog.LevelInfo by default,
+// see SetLogLevel. The default logger is slog.Default, or the loggers of SetLoggerInfo and friends if any is set.
+func (p *EngineBuilder) SetLogger(logger Logger) *EngineBuilder {
+	if logger == nil {
+		panic(errors.New("invalid logger: nil"))
+	}
+	p.logger = logger
+	return p
+}
+
+// SetLogLevel define the minimum level of the logs of component.
+func (p *EngineBuilder) SetLogLevel(component LogComponent, level slog.Level) *EngineBuilder {
+	if component >= logComponents {
+		panic(fmt.Errorf("invalid log component: %s", component))
+	}
+	p.logLevels[component] = level
+	return p
+}
+
 // SetLoggerInfo set logger for INFO
+//
+// Deprecated: use SetLogger, the attributes of logs are appended to messages as key=value.
 func (p *EngineBuilder) SetLoggerInfo(logger func(format string, v ...interface{})) *EngineBuilder {
 	p.l1 = logger
 	return p
 }
 
 // SetLoggerWarn set logger for WARN
+//
+// Deprecated: use SetLogger.
 func (p *EngineBuilder) SetLoggerWarn(logger func(format string, v ...interface{})) *EngineBuilder {
 	p.l2 = logger
 	return p
 }
 
 // SetLoggerError set logger for ERROR
+//
+// Deprecated: use SetLogger.
 func (p *EngineBuilder) SetLoggerError(logger func(format string, v ...interface{})) *EngineBuilder {
 	p.l3 = logger
 	return p
@@ -68,9 +452,18 @@ func (p *EngineBuilder) SetTransports(transports ...TransportType) *EngineBuilde
 	return p
 }
 
-// SetGenerateID define the method of creating SocketID.
+// SetGenerateID define the method of creating SocketID by a sequence, see SetSessionIDGenerator.
 func (p *EngineBuilder) SetGenerateID(gen func(uint32) string) *EngineBuilder {
-	p.gen = gen
+	p.idGen = &sequenceIDGenerator{gen: gen, sequence: randomSequence()}
+	return p
+}
+
+// SetSessionIDGenerator define the generator of session IDs. (default is NewBase64IDGenerator)
+func (p *EngineBuilder) SetSessionIDGenerator(gen SessionIDGenerator) *EngineBuilder {
+	if gen == nil {
+		panic(errors.New("invalid session ID generator: generator is nil"))
+	}
+	p.idGen = gen
 	return p
 }
 
@@ -91,13 +484,41 @@ func (p *EngineBuilder) SetCookiePath(path string) *EngineBuilder {
 	if len(path) < 1 {
 		panic(errors.New("invalid cookie path: path is blank"))
 	}
-	if strings.HasPrefix(path, "/") {
+	if !strings.HasPrefix(path, "/") {
 		panic(errors.New("cookie path must starts with '/'"))
 	}
 	p.options.cookiePath = path
 	return p
 }
 
+// SetCookieName define the name of cookie. (default is io)
+func (p *EngineBuilder) SetCookieName(name string) *EngineBuilder {
+	if len(name) < 1 {
+		panic(errors.New("invalid cookie name: name is blank"))
+	}
+	p.options.cookieName = name
+	return p
+}
+
+// SetCookieDomain define the domain of cookie, it's a host-only cookie if domain is blank.
+func (p *EngineBuilder) SetCookieDomain(domain string) *EngineBuilder {
+	p.options.cookieDomain = domain
+	return p
+}
+
+// SetCookieSameSite define the SameSite attribute of cookie, it's omitted by default.
+// Browsers require Secure cookie for http.SameSiteNoneMode.
+func (p *EngineBuilder) SetCookieSameSite(sameSite http.SameSite) *EngineBuilder {
+	p.options.cookieSameSite = sameSite
+	return p
+}
+
+// SetCookieSecure if set true io cookie is only sent over https. (false)
+func (p *EngineBuilder) SetCookieSecure(secure bool) *EngineBuilder {
+	p.options.cookieSecure = secure
+	return p
+}
+
 // SetCookieHTTPOnly if set true HttpOnly io cookie cannot be accessed by client-side APIs,
 // such as JavaScript. (true) This option has no effect
 // if cookie or cookiePath is set to false.
@@ -124,33 +545,270 @@ func (p *EngineBuilder) SetPingTimeout(timeout time.Duration) *EngineBuilder {
 	return p
 }
 
-// Build returns a new Engine.
+// SetUpgradeTimeout define how long an upgrade can take, the transport being upgraded to is closed
+// if the client doesn't finish it in time and the socket stays on its transport. It must be less than the ping interval
+// plus the ping timeout. (default is 10 seconds)
+func (p *EngineBuilder) SetUpgradeTimeout(timeout time.Duration) *EngineBuilder {
+	p.options.upgradeTimeout = timeout
+	return p
+}
+
+// SetMaxPayload define the max length in bytes of polling payloads sent by clients, a larger POST is rejected with 413.
+// It's announced as maxPayload in handshake. (default is 1e6, 0 means unlimited)
+func (p *EngineBuilder) SetMaxPayload(max int64) *EngineBuilder {
+	p.options.maxPayload = max
+	return p
+}
+
+// SetWriteTimeout define how long a write to a client can take, a socket whose client stops reading is closed
+// with the reason ErrWriteStalled then. It applies to the built-in transports. (default is 0, which means no timeout)
+func (p *EngineBuilder) SetWriteTimeout(timeout time.Duration) *EngineBuilder {
+	p.options.writeTimeout = timeout
+	return p
+}
+
+// SetSlowThresholds define how long the message handlers of a socket and a write to a client can run before they're
+// reported as slow, by a warning with the sid and a stack sample of the goroutine stalled and the counters of
+// Stats. It finds the handlers stalling the read loop of transports. (default is 0, which means no detection)
+func (p *EngineBuilder) SetSlowThresholds(handler, write time.Duration) *EngineBuilder {
+	p.options.slowHandler, p.options.slowWrite = handler, write
+	return p
+}
+
+// SetShutdownMessage define a message sent to every open socket before it's closed by Shutdown,
+// e.g. telling clients to reconnect to another server. (default is none)
+func (p *EngineBuilder) SetShutdownMessage(message interface{}) *EngineBuilder {
+	p.options.shutdownMessage = message
+	return p
+}
+
+// SetSendQueue define the bounds of the send queue of every socket: packets queued until the client receives them
+// are at most maxPackets, and their data are at most maxBytes unless it's 0. The policy decides what happens to
+// packets sent while the queue is full. (default is 128 packets and OverflowBlock)
+func (p *EngineBuilder) SetSendQueue(maxPackets int, maxBytes int64, policy OverflowPolicy) *EngineBuilder {
+	if maxPackets < 1 || maxBytes < 0 {
+		panic(fmt.Errorf("invalid send queue bounds: %d, %d", maxPackets, maxBytes))
+	}
+	p.options.sendQueuePackets = maxPackets
+	p.options.sendQueueBytes = maxBytes
+	p.options.overflowPolicy = policy
+	return p
+}
+
+// SetBroadcastWorkers define how many goroutines send a packet of Engine.Broadcast to sockets.
+// (default is 0, which means GOMAXPROCS)
+func (p *EngineBuilder) SetBroadcastWorkers(workers int) *EngineBuilder {
+	if workers < 0 {
+		panic(fmt.Errorf("invalid broadcast workers: %d", workers))
+	}
+	p.options.broadcastWorkers = workers
+	return p
+}
+
+// SetCompression define whether to negotiate permessage-deflate with websocket clients. (default enabled)
+// Only the no context takeover mode is supported, so every message is compressed on its own.
+func (p *EngineBuilder) SetCompression(enable bool) *EngineBuilder {
+	p.options.compression = enable
+	return p
+}
+
+// SetCompressionLevel define the compress/flate level of websocket messages. (default is flate.BestSpeed)
+func (p *EngineBuilder) SetCompressionLevel(level int) *EngineBuilder {
+	if level < flate.HuffmanOnly || level > flate.BestCompression {
+		panic(fmt.Errorf("invalid compression level: %d", level))
+	}
+	p.options.compressionLevel = level
+	return p
+}
+
+// SetCompressionThreshold define the min length in bytes of websocket messages to be compressed,
+// smaller ones are sent as is unless their packet option Compress is set. (default is 1024)
+func (p *EngineBuilder) SetCompressionThreshold(threshold int) *EngineBuilder {
+	p.options.compressionThreshold = threshold
+	return p
+}
+
+// SetHTTPCompression define whether to compress polling responses with gzip or deflate
+// as the Accept-Encoding of clients allows. (default enabled)
+func (p *EngineBuilder) SetHTTPCompression(enable bool) *EngineBuilder {
+	p.options.httpCompression = enable
+	return p
+}
+
+// SetHTTPCompressionThreshold define the min length in bytes of polling responses to be compressed. (default is 1024)
+func (p *EngineBuilder) SetHTTPCompressionThreshold(threshold int) *EngineBuilder {
+	p.options.httpCompressionThreshold = threshold
+	return p
+}
+
+// SetWebsocketReadLimit define the max length in bytes of websocket messages read, a client sending a larger one
+// is closed with code 1009 (message too big). It must not be less than the max payload. (default is 0, which means
+// unlimited)
+func (p *EngineBuilder) SetWebsocketReadLimit(limit int64) *EngineBuilder {
+	p.options.wsReadLimit = limit
+	return p
+}
+
+// SetWebsocketBufferSize define the I/O buffer sizes in bytes of websocket connections, frames sent are at most
+// write bytes and larger messages are fragmented. (default is 1024 for both)
+func (p *EngineBuilder) SetWebsocketBufferSize(read, write int) *EngineBuilder {
+	if read < 1 || write < 1 {
+		panic(fmt.Errorf("invalid websocket buffer size: %d, %d", read, write))
+	}
+	p.options.wsReadBufferSize, p.options.wsWriteBufferSize = read, write
+	return p
+}
+
+// Validate returns an error if settings of the builder are inconsistent, so they would fail at runtime. Besides each
+// setting, it checks the upgrade timeout against the heartbeat and the websocket read limit against the max payload.
+func (p *EngineBuilder) Validate() error {
+	options := p.options
+	switch {
+	case options.pingInterval <= 0:
+		return fmt.Errorf("invalid ping interval: %s", options.pingInterval)
+	case options.pingTimeout <= 0:
+		return fmt.Errorf("invalid ping timeout: %s", options.pingTimeout)
+	case options.upgradeTimeout <= 0:
+		return fmt.Errorf("invalid upgrade timeout: %s", options.upgradeTimeout)
+	case options.overflowPolicy < OverflowBlock || options.overflowPolicy > OverflowDisconnect:
+		return fmt.Errorf("invalid overflow policy: %d", options.overflowPolicy)
+	case options.maxConnections < 0:
+		return fmt.Errorf("invalid max connections: %d", options.maxConnections)
+	case options.overloadPolicy != OverloadReject && options.overloadPolicy != OverloadQueue:
+		return fmt.Errorf("invalid overload policy: %d", options.overloadPolicy)
+	case options.overloadPolicy == OverloadQueue && options.overloadQueueTimeout <= 0:
+		return fmt.Errorf("invalid overload queue timeout: %s", options.overloadQueueTimeout)
+	case options.writeTimeout < 0:
+		return fmt.Errorf("invalid write timeout: %s", options.writeTimeout)
+	case options.wsKeepalive < 0:
+		return fmt.Errorf("invalid websocket keepalive: %s", options.wsKeepalive)
+	case options.maxPayload < 0:
+		return fmt.Errorf("invalid max payload: %d", options.maxPayload)
+	case options.wsReadLimit < 0:
+		return fmt.Errorf("invalid websocket read limit: %d", options.wsReadLimit)
+	case options.compressionThreshold < 0 || options.httpCompressionThreshold < 0:
+		return fmt.Errorf("invalid compression threshold: %d, %d", options.compressionThreshold, options.httpCompressionThreshold)
+	case options.upgradeTimeout >= options.pingInterval+options.pingTimeout:
+		// the heartbeat would close a session whose polling is paused by the upgrade before the upgrade times out.
+		return fmt.Errorf("upgrade timeout %s must be less than ping interval + ping timeout %s",
+			options.upgradeTimeout, options.pingInterval+options.pingTimeout)
+	case options.maxPayload > 0 && options.wsReadLimit > 0 && options.wsReadLimit < options.maxPayload:
+		// a packet within the max payload announced to clients would close their websocket.
+		return fmt.Errorf("websocket read limit %d must not be less than max payload %d", options.wsReadLimit, options.maxPayload)
+	case options.cookie && options.cookieSameSite == http.SameSiteNoneMode && !options.cookieSecure:
+		// browsers drop such cookies.
+		return errors.New("cookie of SameSite=None must be secure")
+	}
+	for _, it := range p.allowTransports {
+		entry, ok := transportEntryOf(it)
+		if !ok {
+			return fmt.Errorf("invalid transport: %s", it)
+		}
+		if entry.direct {
+			return fmt.Errorf("transport %s can't be requested by http", it)
+		}
+	}
+	return nil
+}
+
+// Build creates an Engine, it panics if the settings are invalid, see Validate.
 func (p *EngineBuilder) Build() Engine {
+	if err := p.Validate(); err != nil {
+		panic(err)
+	}
 	clone := func(origin engineOptions) engineOptions {
 		return origin
 	}(*p.options)
-	sockets := socketMap{
-		store: new(sync.Map),
-	}
 	eng := &engineImpl{
-		logInfo:       p.l1,
-		logWarn:       p.l2,
-		logErr:        p.l3,
-		sequence:      rand.Uint32(),
-		onSockets:     make([]func(Socket), 0),
-		options:       &clone,
-		sockets:       &sockets,
-		path:          p.path,
-		sidGen:        p.gen,
-		junkKiller:    make(chan struct{}),
-		junkTicker:    nil,
-		allowRequest:  p.allowRequest,
-		checkProtocol: p.checkProtocol,
+		logger:           p.logger,
+		logLevels:        p.logLevels,
+		onSockets:        make([]func(Socket), 0),
+		options:          &clone,
+		sockets:          newSocketMap(),
+		rooms:            newRoomMap(),
+		adapter:          p.adapter,
+		store:            p.store,
+		router:           p.router,
+		routerRedirect:   p.routerRedirect,
+		tracer:           p.tracer,
+		packetTracer:     p.packetTracer,
+		packetTraceLimit: p.packetTraceLimit,
+		statsPath:        p.statsPath,
+		statsAuth:        p.statsAuth,
+		started:          time.Now(),
+		path:             p.path,
+		idGen:            p.idGen,
+		junkKiller:       make(chan struct{}),
+		junkTicker:       nil,
+		allowRequest:     p.allowRequest,
+		allowHandshake:   p.allowHandshake,
+		authenticator:    p.authenticator,
+		handshakeFields:  p.handshakeFields,
+		admission:        p.admission,
+		cors:             p.cors,
+		checkProtocol:    p.checkProtocol,
+		sessionKey:       p.sessionKey,
+		wsNegotiation:    p.wsNegotiation,
+		proxies:          p.proxies,
+		proxyHeader:      p.proxyHeader,
+		upgrader:         newWebsocketUpgrader(&clone),
+	}
+	eng.events = newEventBus(eng)
+	eng.capture = p.capture
+	eng.profileLabels, eng.profileTenantKey = p.profileLabels, p.profileTenantKey
+	if eng.cors == nil {
+		eng.cors = defaultCORS
+	}
+	if eng.checkOrigin = p.checkOrigin; eng.checkOrigin == nil && p.allowedOrigins != nil {
+		eng.checkOrigin = allowedOrigins(p.allowedOrigins)
+	}
+	if eng.checkOrigin != nil {
+		cors := *eng.cors
+		cors.checkOrigin = eng.checkOrigin
+		eng.cors, eng.upgrader.CheckOrigin = &cors, eng.checkOrigin
+	}
+	if eng.statsAuth != nil && len(eng.statsPath) < 1 {
+		eng.statsPath = strings.TrimSuffix(p.path, "/") + "/stats"
+	}
+	if eng.logger == nil {
+		eng.logger = slog.Default()
+		if p.l1 != nil || p.l2 != nil || p.l3 != nil {
+			eng.logger = funcLogger{p.l1, p.l2, p.l3}
+		}
+	}
+	if p.recoveryWindow > 0 {
+		eng.recovery = newRecovery(eng, p.recoveryWindow)
+	}
+	if p.presence {
+		eng.presence = newPresence(eng, p.presenceKey, p.presenceRetention)
+	}
+	if eng.adapter != nil || eng.store != nil || p.membershipConn != nil || eng.router != nil {
+		eng.node = newClusterNode(eng, p.nodeID)
+	}
+	if p.membershipConn != nil {
+		eng.membership = newMembership(eng, p.membershipConn, p.membershipDiscovery, p.membershipInterval, p.membershipKey)
+		if p.membershipKey == nil {
+			eng.log(LogCluster, slog.LevelWarn, "gossip_unsigned", "gossip isn't signed, see EngineBuilder.SetMembershipKey")
+		}
+		eng.membership.start()
+	}
+	if eng.adapter != nil {
+		eng.adapter.Start(eng.node)
+	}
+	if eng.store != nil {
+		eng.store.Start(eng.node)
+	}
+	eng.handler = http.HandlerFunc(eng.serve)
+	for i := len(p.middlewares) - 1; i >= 0; i-- {
+		eng.handler = p.middlewares[i](eng.handler)
+	}
+	if clone.maxConnections > 0 {
+		eng.slots = make(chan struct{}, clone.maxConnections)
 	}
 	if len(p.allowTransports) < 1 {
 		eng.allowTransports = defaultTransports
 	} else {
-		allows := make([]TransportType, 0)
+		allows := make([]TransportType, len(p.allowTransports))
 		copy(allows, p.allowTransports)
 		eng.allowTransports = allows
 	}
@@ -160,17 +818,30 @@ func (p *EngineBuilder) Build() Engine {
 // NewEngineBuilder create a builder for Engine.
 func NewEngineBuilder() *EngineBuilder {
 	options := engineOptions{
-		cookie:         false,
-		cookiePath:     defaultCookiePath,
-		cookieHTTPOnly: true,
-		pingInterval:   defaultPingInterval,
-		pingTimeout:    defaultPingTimeout,
-		allowUpgrades:  true,
+		cookie:                   false,
+		cookieName:               defaultCookieName,
+		cookiePath:               defaultCookiePath,
+		cookieHTTPOnly:           true,
+		pingInterval:             defaultPingInterval,
+		pingTimeout:              defaultPingTimeout,
+		upgradeTimeout:           defaultUpgradeTimeout,
+		overloadQueueTimeout:     defaultOverloadQueueTimeout,
+		sendQueuePackets:         outboxThreshold,
+		compression:              true,
+		compressionLevel:         flate.BestSpeed,
+		compressionThreshold:     defaultCompressionThreshold,
+		httpCompression:          true,
+		httpCompressionThreshold: defaultCompressionThreshold,
+		wsReadBufferSize:         defaultWebsocketBufferSize,
+		maxPayload:               defaultMaxPayload,
+		wsWriteBufferSize:        defaultWebsocketBufferSize,
+		allowUpgrades:            true,
 	}
 	builder := EngineBuilder{
-		path:    DefaultPath,
-		options: &options,
-		gen:     randomSessionID,
+		path:        DefaultPath,
+		options:     &options,
+		idGen:       NewBase64IDGenerator(),
+		proxyHeader: HeaderXForwardedFor,
 	}
 	return &builder
 }
@@ -0,0 +1,45 @@
+package eio
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuilderValidate(t *testing.T) {
+	if err := NewEngineBuilder().Validate(); err != nil {
+		t.Fatal("defaults should be valid:", err)
+	}
+	for name, builder := range map[string]*EngineBuilder{
+		"ping interval":   NewEngineBuilder().SetPingInterval(0),
+		"ping timeout":    NewEngineBuilder().SetPingTimeout(-time.Second),
+		"upgrade timeout": NewEngineBuilder().SetUpgradeTimeout(0),
+		"write timeout":   NewEngineBuilder().SetWriteTimeout(-time.Second),
+		"max payload":     NewEngineBuilder().SetMaxPayload(-1),
+		"heartbeat":       NewEngineBuilder().SetPingInterval(time.Second).SetPingTimeout(time.Second).SetUpgradeTimeout(2 * time.Second),
+		"read limit":      NewEngineBuilder().SetMaxPayload(1024).SetWebsocketReadLimit(512),
+		"cookie":          NewEngineBuilder().SetCookie(true).SetCookieSameSite(http.SameSiteNoneMode),
+		"transport":       NewEngineBuilder().SetTransports(POLLING, STREAM),
+		"unknown":         NewEngineBuilder().SetTransports(TransportType(100)),
+	} {
+		if err := builder.Validate(); err == nil {
+			t.Errorf("%s should be invalid", name)
+		}
+	}
+	defer func() {
+		if e := recover(); e == nil || !strings.Contains(e.(error).Error(), "ping interval") {
+			t.Error("build should panic:", e)
+		}
+	}()
+	NewEngineBuilder().SetPingInterval(0).Build()
+}
+
+func TestValidateOptions(t *testing.T) {
+	if err := ValidateOptions(WithPingInterval(time.Second), WithCookie("lb"), WithCompression(false)); err != nil {
+		t.Error("should be valid:", err)
+	}
+	if err := ValidateOptions(WithTransports(LOOPBACK)); err == nil {
+		t.Error("loopback shouldn't be requested by http")
+	}
+}
@@ -2,8 +2,14 @@ package eio
 
 import (
 	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/jjeffcaii/engine.io/parser"
 )
 
 var server Engine
@@ -18,3 +24,225 @@ func TestFunction(t *testing.T) {
 	t.Log("PASS")
 
 }
+
+func TestOnDisconnect(t *testing.T) {
+	eng := NewEngineBuilder().Build()
+	sockets := make(chan Socket, 1)
+	reasons := make(chan CloseReason, 1)
+	eng.OnConnect(func(socket Socket) { sockets <- socket })
+	eng.OnDisconnect(func(socket Socket, reason CloseReason) { reasons <- reason })
+	expect := func(reason CloseReason) {
+		select {
+		case got := <-reasons:
+			if got != reason {
+				t.Errorf("should be %s, got %s", reason, got)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("should be closed by %s", reason)
+		}
+	}
+	open := func() (LoopbackClient, Socket) {
+		client, err := eng.Loopback()
+		if err != nil {
+			t.Fatal(err)
+		}
+		client.Receive()
+		return client, <-sockets
+	}
+
+	client, _ := open()
+	client.Close()
+	expect(CloseClientClose)
+
+	client, _ = open()
+	client.Send(parser.NewPacketCustom(parser.CLOSE, nil, 0))
+	expect(CloseClientClose)
+
+	_, socket := open()
+	socket.Close()
+	expect(CloseForced)
+
+	client, _ = open()
+	// clients never send OPEN.
+	client.Send(parser.NewPacketCustom(parser.OPEN, nil, 0))
+	expect(CloseTransportError)
+
+	open()
+	eng.Close()
+	expect(CloseServerShutdown)
+}
+
+func TestHeartbeat(t *testing.T) {
+	eng := NewEngineBuilder().SetPingInterval(50 * time.Millisecond).SetPingTimeout(50 * time.Millisecond).
+		SetUpgradeTimeout(50 * time.Millisecond).Build()
+	defer eng.Close()
+	reasons := make(chan CloseReason, 2)
+	eng.OnDisconnect(func(socket Socket, reason CloseReason) { reasons <- reason })
+	expectTimeout := func() {
+		select {
+		case reason := <-reasons:
+			if reason != ClosePingTimeout {
+				t.Errorf("should be ping timeout, got %s", reason)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("should be closed")
+		}
+	}
+
+	// V3 clients ping, a silent one is lost.
+	client, err := eng.Loopback()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	for i := 0; i < 4; i++ {
+		time.Sleep(25 * time.Millisecond)
+		client.Send(parser.NewPacketCustom(parser.PING, nil, 0))
+	}
+	select {
+	case reason := <-reasons:
+		t.Fatalf("shouldn't be closed while pinging: %s", reason)
+	default:
+	}
+	expectTimeout()
+
+	// V4 servers ping, clients pong.
+	srv := httptest.NewServer(http.HandlerFunc(eng.Router()))
+	defer srv.Close()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/engine.io/?EIO=4&transport=websocket", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	readFrame(t, conn)
+	for i := 0; i < 3; i++ {
+		if _, msg := readFrame(t, conn); msg != "2" {
+			t.Fatalf("should be PING: %q", msg)
+		}
+		conn.WriteMessage(websocket.TextMessage, []byte("3"))
+	}
+	expectTimeout()
+}
+
+func TestHandshakeCookie(t *testing.T) {
+	eng := NewEngineBuilder().
+		SetCookie(true).
+		SetCookieName("lb").
+		SetCookiePath("/app").
+		SetCookieDomain("example.com").
+		SetCookieSameSite(http.SameSiteNoneMode).
+		SetCookieSecure(true).
+		Build()
+	defer eng.Close()
+	sockets := make(chan Socket, 2)
+	eng.OnConnect(func(socket Socket) { sockets <- socket })
+	srv := httptest.NewServer(http.HandlerFunc(eng.Router()))
+	defer srv.Close()
+
+	check := func(res *http.Response, id string) {
+		t.Helper()
+		cookies := res.Cookies()
+		if len(cookies) != 1 {
+			t.Fatalf("should set one cookie: %v", res.Header)
+		}
+		c := cookies[0]
+		if c.Name != "lb" || c.Value != id || c.Path != "/app" || c.Domain != "example.com" ||
+			c.SameSite != http.SameSiteNoneMode || !c.Secure || !c.HttpOnly {
+			t.Errorf("bad cookie: %v", c)
+		}
+	}
+	url := srv.URL + "/engine.io/?EIO=3&transport=polling"
+	res, _ := poll(t, http.MethodGet, url, "", "")
+	socket := <-sockets
+	check(res, socket.ID())
+	socket.Send("hello")
+	if res, _ := poll(t, http.MethodGet, url+"&sid="+socket.ID(), "", ""); len(res.Cookies()) > 0 {
+		t.Error("cookie should be set by handshake only")
+	}
+
+	url = "ws" + strings.TrimPrefix(srv.URL, "http") + "/engine.io/?EIO=3&transport=websocket"
+	conn, res, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	check(res, (<-sockets).ID())
+}
+
+func TestHandshakeCookieDisabled(t *testing.T) {
+	eng := NewEngineBuilder().Build()
+	defer eng.Close()
+	srv := httptest.NewServer(http.HandlerFunc(eng.Router()))
+	defer srv.Close()
+	if res, _ := poll(t, http.MethodGet, srv.URL+"/engine.io/?EIO=3&transport=polling", "", ""); len(res.Cookies()) > 0 {
+		t.Errorf("cookie should be disabled: %v", res.Cookies())
+	}
+}
+
+func TestProtocolSideBySide(t *testing.T) {
+	eng := NewEngineBuilder().Build()
+	defer eng.Close()
+	sockets := make(chan Socket, 1)
+	messages := make(chan string, 4)
+	eng.OnConnect(func(socket Socket) {
+		socket.OnMessage(func(data []byte) { messages <- string(data) })
+		sockets <- socket
+	})
+	srv := httptest.NewServer(http.HandlerFunc(eng.Router()))
+	defer srv.Close()
+
+	// v4 polling: a payload has no length prefix and packets are separated by a record separator.
+	url := srv.URL + "/engine.io/?EIO=4&transport=polling"
+	_, body := poll(t, http.MethodGet, url, "", "")
+	if !strings.HasPrefix(body, "0{") {
+		t.Fatalf("bad v4 handshake: %q", body)
+	}
+	v4 := <-sockets
+	if v4.Protocol() != 4 {
+		t.Errorf("should speak v4: %d", v4.Protocol())
+	}
+	url += "&sid=" + v4.ID()
+	if _, body = poll(t, http.MethodPost, url, parser.ContentTypeText, "4hello\x1e4world"); body != "ok" {
+		t.Errorf("bad post response: %q", body)
+	}
+	if a, b := <-messages, <-messages; a != "hello" || b != "world" {
+		t.Errorf("bad messages: %q %q", a, b)
+	}
+	v4.Send([]byte{1, 2, 3})
+	if _, body = poll(t, http.MethodGet, url, "", ""); body != "bAQID" {
+		t.Errorf("bad v4 binary payload: %q", body)
+	}
+	if res, _ := poll(t, http.MethodGet, srv.URL+"/engine.io/?EIO=3&transport=polling&sid="+v4.ID(), "", ""); res.StatusCode != http.StatusBadRequest {
+		t.Errorf("a session shouldn't change its protocol: %d", res.StatusCode)
+	}
+
+	// v3 polling aside.
+	_, body = poll(t, http.MethodGet, srv.URL+"/engine.io/?EIO=3&transport=polling&b64=1", "", "")
+	if packets, err := parser.DecodePayloadString(body); err != nil || len(packets) != 1 || packets[0].Type != parser.OPEN {
+		t.Fatalf("bad v3 handshake: %q %v", body, err)
+	}
+	if v3 := <-sockets; v3.Protocol() != 3 {
+		t.Errorf("should speak v3: %d", v3.Protocol())
+	}
+
+	// v4 websocket: binary frames carry the data of MESSAGE only.
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/engine.io/?EIO=4&transport=websocket", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, msg := readFrame(t, conn); !strings.HasPrefix(msg, "0{") {
+		t.Fatalf("bad v4 handshake: %q", msg)
+	}
+	ws := <-sockets
+	conn.WriteMessage(websocket.BinaryMessage, []byte("raw"))
+	if got := <-messages; got != "raw" {
+		t.Errorf("bad binary message: %q", got)
+	}
+	ws.Send([]byte{1, 2, 3})
+	if msgType, msg := readFrame(t, conn); msgType != websocket.BinaryMessage || msg != "\x01\x02\x03" {
+		t.Errorf("bad binary frame: %d %q", msgType, msg)
+	}
+}
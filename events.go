@@ -0,0 +1,163 @@
+package eio
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultEventBuffer is the buffer of the subscribers of Subscribe.
+const defaultEventBuffer = 1024
+
+// EventType is the type of a lifecycle event of sockets, see EventBus.
+type EventType uint8
+
+const (
+	// EventConnect is published once a socket is opened and the handlers of Engine.OnConnect return.
+	EventConnect EventType = iota
+	// EventUpgrade is published once the transport of a socket is upgraded.
+	EventUpgrade
+	// EventDisconnect is published once a socket is closed, after the handlers of Engine.OnDisconnect.
+	EventDisconnect
+	// EventError is published when a handler of Socket.OnMessage fails, or a transport fails and closes its socket.
+	EventError
+	eventTypes
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventConnect:
+		return "connect"
+	case EventUpgrade:
+		return "upgrade"
+	case EventDisconnect:
+		return "disconnect"
+	case EventError:
+		return "error"
+	}
+	return fmt.Sprintf("event(%d)", uint8(t))
+}
+
+// Event is a lifecycle event of a socket.
+type Event struct {
+	Type   EventType
+	Time   time.Time
+	Socket Socket
+	// Transport is the transport of socket, it's the transport upgraded to for EventUpgrade.
+	Transport TransportType
+	// Reason is the close reason of EventDisconnect.
+	Reason CloseReason
+	// Err is the failure of EventError.
+	Err error
+}
+
+// EventBus fans the lifecycle events of the sockets of an engine out to subscribers, so components such as presence,
+// audit or billing observe them without being wired into the handlers of application, see Engine.Events.
+// Every subscriber has its own buffer, a subscriber whose buffer is full misses events rather than stalling sockets.
+// Events reach a subscriber in the order they are published. It's safe for concurrent use.
+type EventBus struct {
+	eng         *engineImpl
+	lock        sync.RWMutex
+	subscribers map[*eventSubscriber]struct{}
+	// count is the count of subscribers, events aren't made if there's none.
+	count int32
+	// dropped counts the events missed by subscribers.
+	dropped uint64
+}
+
+type eventSubscriber struct {
+	ch    chan Event
+	types [eventTypes]bool
+}
+
+func newEventBus(eng *engineImpl) *EventBus {
+	return &EventBus{eng: eng, subscribers: make(map[*eventSubscriber]struct{})}
+}
+
+// Subscribe calls fn with the events of types, or all events if types are empty, until the returned cancel is called.
+// fn is called by a goroutine of the subscription one event after another, a panic of fn is logged.
+func (p *EventBus) Subscribe(fn func(Event), types ...EventType) (cancel func()) {
+	ch, cancel := p.Channel(defaultEventBuffer, types...)
+	go func() {
+		for it := range ch {
+			p.call(fn, it)
+		}
+	}()
+	return cancel
+}
+
+func (p *EventBus) call(fn func(Event), event Event) {
+	defer func() {
+		if e := recover(); e != nil {
+			p.eng.log(LogEngine, slog.LevelError, "handler_panic", "handle lifecycle event failed",
+				"type", event.Type.String(), LogKeyError, e)
+		}
+	}()
+	fn(event)
+}
+
+// Channel returns a channel of the events of types, or all events if types are empty, which buffers size events.
+// The channel is closed by the returned cancel.
+func (p *EventBus) Channel(size int, types ...EventType) (<-chan Event, func()) {
+	if size < 1 {
+		panic(fmt.Errorf("invalid event buffer: %d", size))
+	}
+	sub := &eventSubscriber{ch: make(chan Event, size)}
+	for i := range sub.types {
+		sub.types[i] = len(types) < 1
+	}
+	for _, it := range types {
+		if it >= eventTypes {
+			panic(fmt.Errorf("invalid event type: %s", it))
+		}
+		sub.types[it] = true
+	}
+	p.lock.Lock()
+	p.subscribers[sub] = struct{}{}
+	atomic.AddInt32(&(p.count), 1)
+	p.lock.Unlock()
+	var once sync.Once
+	return sub.ch, func() {
+		once.Do(func() {
+			p.lock.Lock()
+			delete(p.subscribers, sub)
+			atomic.AddInt32(&(p.count), -1)
+			close(sub.ch)
+			p.lock.Unlock()
+		})
+	}
+}
+
+// Dropped returns the count of events missed by subscribers whose buffers are full.
+func (p *EventBus) Dropped() uint64 {
+	return atomic.LoadUint64(&(p.dropped))
+}
+
+// publish sends the event of type t of socket to subscribers, fill sets the other fields.
+func (p *EventBus) publish(t EventType, socket *socketImpl, fill func(*Event)) {
+	if atomic.LoadInt32(&(p.count)) < 1 {
+		return
+	}
+	event := Event{Type: t, Time: time.Now(), Socket: socket, Transport: socket.transportType()}
+	if fill != nil {
+		fill(&event)
+	}
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	for it := range p.subscribers {
+		if !it.types[t] {
+			continue
+		}
+		select {
+		case it.ch <- event:
+		default:
+			atomic.AddUint64(&(p.dropped), 1)
+		}
+	}
+}
+
+func (p *engineImpl) Events() *EventBus {
+	return p.events
+}
@@ -0,0 +1,73 @@
+package eio
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestEventBus(t *testing.T) {
+	eng := NewEngineBuilder().Build()
+	defer eng.Close()
+	events, cancel := eng.Events().Channel(16)
+	defer cancel()
+	disconnects := make(chan Event, 1)
+	defer eng.Events().Subscribe(func(event Event) { disconnects <- event }, EventDisconnect)()
+	sockets := make(chan Socket, 1)
+	boom := errors.New("boom")
+	eng.OnConnect(func(socket Socket) {
+		socket.OnMessage(func(data []byte) { panic(boom) })
+		sockets <- socket
+	})
+	srv := httptest.NewServer(http.HandlerFunc(eng.Router()))
+	defer srv.Close()
+	next := func(want EventType) Event {
+		select {
+		case it := <-events:
+			if it.Type != want {
+				t.Fatalf("event should be %s: %+v", want, it)
+			}
+			return it
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no %s event", want)
+			return Event{}
+		}
+	}
+
+	poll(t, http.MethodGet, srv.URL+"/engine.io/?EIO=3&transport=polling", "", "")
+	socket := <-sockets
+	if it := next(EventConnect); it.Socket != socket || it.Transport != POLLING || it.Time.IsZero() {
+		t.Errorf("bad connect event: %+v", it)
+	}
+	conn := dialWebsocket(t, srv, "&sid="+socket.ID())
+	defer conn.Close()
+	conn.WriteMessage(websocket.TextMessage, []byte("2probe"))
+	readFrame(t, conn)
+	conn.WriteMessage(websocket.TextMessage, []byte("5"))
+	if it := next(EventUpgrade); it.Socket != socket || it.Transport != WEBSOCKET {
+		t.Errorf("bad upgrade event: %+v", it)
+	}
+	conn.WriteMessage(websocket.TextMessage, []byte("4hello"))
+	if it := next(EventError); it.Err != boom {
+		t.Errorf("bad error event: %+v", it)
+	}
+	socket.Close()
+	if it := next(EventDisconnect); it.Reason != CloseForced {
+		t.Errorf("bad disconnect event: %+v", it)
+	}
+	select {
+	case it := <-disconnects:
+		if it.Socket != socket {
+			t.Errorf("bad disconnect event of subscription: %+v", it)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("subscription should be called")
+	}
+	if eng.Events().Dropped() != 0 {
+		t.Error("no event should be dropped")
+	}
+}
@@ -1,7 +1,9 @@
+//go:build example
+
+// Package example serves a demo page of the engine at :3000, run it by "go test -tags example ./example".
 package example
 
 import (
-	"flag"
 	"fmt"
 	"log"
 	"net/http"
@@ -14,7 +16,6 @@ import (
 var server eio.Engine
 
 func init() {
-	flag.Parse()
 	server = eio.NewEngineBuilder().Build()
 	http.HandleFunc("/conns", func(writer http.ResponseWriter, request *http.Request) {
 		writer.WriteHeader(http.StatusOK)
@@ -0,0 +1,5 @@
+module github.com/jjeffcaii/engine.io
+
+go 1.21
+
+require github.com/gorilla/websocket v1.2.0
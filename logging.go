@@ -0,0 +1,114 @@
+package eio
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// LogComponent is a part of engine whose logs have their own level, see EngineBuilder.SetLogLevel.
+type LogComponent uint8
+
+const (
+	// LogEngine logs the handshakes, sessions and shutdown of engine.
+	LogEngine LogComponent = iota
+	// LogTransport logs the requests, reads and writes of transports.
+	LogTransport
+	// LogSocket logs the handlers and upgrades of sockets.
+	LogSocket
+	// LogCluster logs adapters, session stores, membership, presence, migrations and recovery.
+	LogCluster
+	logComponents
+)
+
+func (c LogComponent) String() string {
+	switch c {
+	case LogEngine:
+		return "engine"
+	case LogTransport:
+		return "transport"
+	case LogSocket:
+		return "socket"
+	case LogCluster:
+		return "cluster"
+	}
+	return fmt.Sprintf("component(%d)", uint8(c))
+}
+
+// keys of the attributes of logs.
+const (
+	LogKeyComponent  = "component"
+	LogKeyEvent      = "event"
+	LogKeySid        = "sid"
+	LogKeyTransport  = "transport"
+	LogKeyRemoteAddr = "remote_addr"
+	LogKeyError      = "error"
+	LogKeyNode       = "node"
+	LogKeyElapsed    = "elapsed"
+	LogKeyStack      = "stack"
+)
+
+// Logger logs the events of an engine, *slog.Logger is one. args are key value pairs as slog.Logger.Log takes them,
+// a log has the LogKeyComponent and LogKeyEvent attributes at least. Implementations must be safe for concurrent use.
+type Logger interface {
+	Log(ctx context.Context, level slog.Level, msg string, args ...interface{})
+}
+
+// funcLogger logs by the printf-like loggers of EngineBuilder.SetLoggerInfo and friends, a nil one is off.
+type funcLogger struct {
+	info, warn, err func(format string, v ...interface{})
+}
+
+func (p funcLogger) Log(ctx context.Context, level slog.Level, msg string, args ...interface{}) {
+	fn := p.info
+	if level >= slog.LevelError {
+		fn = p.err
+	} else if level >= slog.LevelWarn {
+		fn = p.warn
+	}
+	if fn == nil {
+		return
+	}
+	var b strings.Builder
+	b.WriteString(msg)
+	for i := 0; i+1 < len(args); i += 2 {
+		fmt.Fprintf(&b, " %v=%v", args[i], args[i+1])
+	}
+	fn("%s\n", b.String())
+}
+
+// logEnabled returns whether logs of component c at level are logged, nothing is logged without an engine.
+func (p *engineImpl) logEnabled(c LogComponent, level slog.Level) bool {
+	return p != nil && p.logger != nil && level >= p.logLevels[c]
+}
+
+// log logs event of component c, args are key value pairs.
+func (p *engineImpl) log(c LogComponent, level slog.Level, event, msg string, args ...interface{}) {
+	if !p.logEnabled(c, level) {
+		return
+	}
+	p.logger.Log(context.Background(), level, msg, append([]interface{}{LogKeyComponent, c.String(), LogKeyEvent, event}, args...)...)
+}
+
+// logTransport logs event of transport t, with the sid and address of its socket or the address of its request.
+func (p *engineImpl) logTransport(t Transport, level slog.Level, event, msg string, args ...interface{}) {
+	if !p.logEnabled(LogTransport, level) {
+		return
+	}
+	attrs := []interface{}{LogKeyTransport, t.GetType().String()}
+	if socket, _ := t.GetSocket().(*socketImpl); socket != nil {
+		attrs = append(attrs, LogKeySid, socket.id, LogKeyRemoteAddr, socket.remoteAddr)
+	} else if request := t.GetRequest(); request != nil {
+		attrs = append(attrs, LogKeyRemoteAddr, p.remoteAddr(request))
+	}
+	p.log(LogTransport, level, event, msg, append(attrs, args...)...)
+}
+
+// logSocket logs event of socket, with its sid and address.
+func (p *engineImpl) logSocket(socket *socketImpl, level slog.Level, event, msg string, args ...interface{}) {
+	if !p.logEnabled(LogSocket, level) {
+		return
+	}
+	p.log(LogSocket, level, event, msg, append([]interface{}{LogKeySid, socket.id, LogKeyRemoteAddr, socket.remoteAddr}, args...)...)
+}
@@ -0,0 +1,83 @@
+package eio
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type logRecord struct {
+	level slog.Level
+	msg   string
+	attrs map[string]interface{}
+}
+
+// recordingLogger records logs, it's a Logger as *slog.Logger is.
+type recordingLogger struct {
+	records chan *logRecord
+}
+
+func (p *recordingLogger) Log(ctx context.Context, level slog.Level, msg string, args ...interface{}) {
+	record := &logRecord{level: level, msg: msg, attrs: make(map[string]interface{})}
+	for i := 0; i+1 < len(args); i += 2 {
+		record.attrs[args[i].(string)] = args[i+1]
+	}
+	select {
+	case p.records <- record:
+	default:
+	}
+}
+
+func TestLogger(t *testing.T) {
+	logger := &recordingLogger{records: make(chan *logRecord, 16)}
+	eng := NewEngineBuilder().SetLogger(logger).SetLogLevel(LogSocket, slog.LevelError+1).Build()
+	defer eng.Close()
+	if impl := eng.(*engineImpl); impl.logEnabled(LogSocket, slog.LevelError) || !impl.logEnabled(LogTransport, slog.LevelWarn) {
+		t.Error("logs should be filtered by the levels of components")
+	}
+	sockets := make(chan Socket, 1)
+	eng.OnConnect(func(socket Socket) { sockets <- socket })
+	srv := httptest.NewServer(http.HandlerFunc(eng.Router()))
+	defer srv.Close()
+	url := srv.URL + "/engine.io/?EIO=3&transport=polling"
+	poll(t, http.MethodGet, url, "", "")
+	socket := <-sockets
+
+	poll(t, http.MethodPost, url+"&sid="+socket.ID(), "", "bad payload")
+	for {
+		select {
+		case it := <-logger.records:
+			if it.attrs[LogKeyEvent] != "decode_failed" {
+				continue
+			}
+			if it.level != slog.LevelWarn || it.attrs[LogKeyComponent] != "transport" || it.attrs[LogKeySid] != socket.ID() ||
+				it.attrs[LogKeyTransport] != "polling" || it.attrs[LogKeyRemoteAddr] != socket.RemoteAddr() || it.attrs[LogKeyError] == nil {
+				t.Errorf("bad log: %v %s %v", it.level, it.msg, it.attrs)
+			}
+			return
+		case <-time.After(5 * time.Second):
+			t.Fatal("decode failure should be logged")
+		}
+	}
+}
+
+func TestFuncLogger(t *testing.T) {
+	var logs []string
+	record := func(format string, v ...interface{}) { logs = append(logs, fmt.Sprintf(format, v...)) }
+	logger := funcLogger{warn: record, err: record}
+	logger.Log(context.Background(), slog.LevelInfo, "off")
+	logger.Log(context.Background(), slog.LevelWarn, "node is dead", LogKeyNode, "a")
+	logger.Log(context.Background(), slog.LevelError+4, "failed", LogKeyError, errPollingEOF)
+	if len(logs) != 2 || logs[0] != "node is dead node=a\n" || logs[1] != "failed error="+errPollingEOF.Error()+"\n" {
+		t.Errorf("bad logs: %q", logs)
+	}
+	if eng := NewEngineBuilder().SetLoggerWarn(record).Build(); eng.(*engineImpl).logger == nil {
+		t.Error("legacy loggers should be adapted")
+	} else {
+		eng.Close()
+	}
+}
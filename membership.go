@@ -0,0 +1,360 @@
+package eio
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"log/slog"
+	"math/rand"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMembershipInterval = time.Second
+	// deadIntervals is how many intervals a node isn't heard in before it's dead.
+	deadIntervals = 5
+	// forgetIntervals is how many intervals a dead node is remembered, so stale gossip can't bring it back.
+	forgetIntervals = 60
+	// gossipFanout is how many peers a node gossips to in an interval.
+	gossipFanout  = 3
+	maxGossipSize = 64 << 10
+	dnsTimeout    = 5 * time.Second
+)
+
+// Discovery finds the membership addresses of nodes which may be in the cluster, see EngineBuilder.SetMembership.
+// Nodes gossip the nodes they know, so a discovery only needs to find some alive ones.
+type Discovery interface {
+	// Peers returns the UDP addresses of peers, the local address may be one of them.
+	Peers() ([]string, error)
+}
+
+// DiscoveryFunc is a function as Discovery.
+type DiscoveryFunc func() ([]string, error)
+
+func (f DiscoveryFunc) Peers() ([]string, error) {
+	return f()
+}
+
+// StaticDiscovery finds the fixed addresses, a node with no address is found by the gossip of other nodes only.
+func StaticDiscovery(addrs ...string) Discovery {
+	addrs = append([]string(nil), addrs...)
+	return DiscoveryFunc(func() ([]string, error) { return addrs, nil })
+}
+
+// DNSDiscovery finds the addresses name resolves to on port, such as the headless service of nodes.
+func DNSDiscovery(name string, port int) Discovery {
+	return DiscoveryFunc(func() ([]string, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), dnsTimeout)
+		defer cancel()
+		hosts, err := net.DefaultResolver.LookupHost(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		ret := make([]string, 0, len(hosts))
+		for _, it := range hosts {
+			ret = append(ret, net.JoinHostPort(it, strconv.Itoa(port)))
+		}
+		return ret, nil
+	})
+}
+
+// Expirer is an Adapter or SessionStore which keeps the state of nodes, such as room memberships or sessions.
+// The state of a node is expired once the membership finds it dead, so it isn't leaked after the node crashes.
+type Expirer interface {
+	// Expire removes the state of node, it's called by every alive node so it should be idempotent.
+	Expire(node string) error
+}
+
+// memberState is a node in gossip, a node increases its heartbeat once an interval. Incarnation is when the node
+// starts, so the state of a restarted node supersedes the one before though its heartbeat starts over.
+type memberState struct {
+	ID          string `json:"id"`
+	Addr        string `json:"addr"`
+	Incarnation uint64 `json:"incarnation"`
+	Heartbeat   uint64 `json:"heartbeat"`
+}
+
+// newer returns true if p is a later state of the node than the one of incarnation and heartbeat.
+func (p *memberState) newer(incarnation, heartbeat uint64) bool {
+	return p.Incarnation > incarnation || p.Incarnation == incarnation && p.Heartbeat > heartbeat
+}
+
+// gossip is a message of membership, the first member is the sender.
+type gossip struct {
+	Members []memberState `json:"members"`
+}
+
+type member struct {
+	state memberState
+	// updated is when the heartbeat of member increases last.
+	updated time.Time
+}
+
+type deadMember struct {
+	incarnation, heartbeat uint64
+	at                     time.Time
+}
+
+// membership keeps the alive nodes of cluster by gossip over UDP.
+type membership struct {
+	eng       *engineImpl
+	conn      net.PacketConn
+	discovery Discovery
+	interval  time.Duration
+	// key signs gossip by HMAC-SHA256, gossip isn't signed if it's nil.
+	key         []byte
+	lock        sync.Mutex
+	incarnation uint64
+	heartbeat   uint64
+	members     map[string]*member
+	dead        map[string]deadMember
+	// peers are the addresses found by discovery last.
+	peers     []string
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newMembership(eng *engineImpl, conn net.PacketConn, discovery Discovery, interval time.Duration, key []byte) *membership {
+	if interval <= 0 {
+		interval = defaultMembershipInterval
+	}
+	return &membership{
+		eng:         eng,
+		conn:        conn,
+		discovery:   discovery,
+		interval:    interval,
+		key:         key,
+		incarnation: uint64(time.Now().UnixNano()),
+		members:     make(map[string]*member),
+		dead:        make(map[string]deadMember),
+		done:        make(chan struct{}),
+	}
+}
+
+func (p *membership) start() {
+	go p.receive()
+	go p.run()
+}
+
+// run gossips once an interval and finds the dead nodes until membership is closed.
+func (p *membership) run() {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for tick := 0; ; tick++ {
+		// the discovery is refreshed once a node may be dead, DNS isn't resolved every interval.
+		if tick%deadIntervals == 0 {
+			p.discover()
+		}
+		p.gossip()
+		for _, it := range p.sweep() {
+			p.eng.expire(it)
+		}
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *membership) discover() {
+	if p.discovery == nil {
+		return
+	}
+	peers, err := p.discovery.Peers()
+	if err != nil {
+		p.eng.log(LogCluster, slog.LevelError, "discover_failed", "discover nodes failed", LogKeyError, err)
+		return
+	}
+	p.lock.Lock()
+	p.peers = peers
+	p.lock.Unlock()
+}
+
+// gossip sends the members to some of the alive members and the peers of discovery.
+func (p *membership) gossip() {
+	p.lock.Lock()
+	p.heartbeat++
+	message := gossip{Members: []memberState{{ID: p.eng.node.id, Incarnation: p.incarnation, Heartbeat: p.heartbeat}}}
+	targets := make(map[string]struct{}, len(p.members)+len(p.peers))
+	for _, it := range p.members {
+		message.Members = append(message.Members, it.state)
+		targets[it.state.Addr] = struct{}{}
+	}
+	for _, it := range p.peers {
+		targets[it] = struct{}{}
+	}
+	p.lock.Unlock()
+	delete(targets, p.conn.LocalAddr().String())
+	data, err := json.Marshal(&message)
+	if err != nil {
+		return
+	}
+	data = p.sign(data)
+	addrs := make([]string, 0, len(targets))
+	for it := range targets {
+		addrs = append(addrs, it)
+	}
+	rand.Shuffle(len(addrs), func(i, j int) { addrs[i], addrs[j] = addrs[j], addrs[i] })
+	if len(addrs) > gossipFanout {
+		addrs = addrs[:gossipFanout]
+	}
+	for _, it := range addrs {
+		addr, err := net.ResolveUDPAddr("udp", it)
+		if err != nil {
+			continue
+		}
+		if _, err := p.conn.WriteTo(data, addr); err != nil {
+			p.eng.log(LogCluster, slog.LevelWarn, "gossip_failed", "gossip failed", "addr", it, LogKeyError, err)
+		}
+	}
+}
+
+// receive merges the gossip of other nodes until the connection is closed.
+func (p *membership) receive() {
+	buf := make([]byte, maxGossipSize)
+	for {
+		n, addr, err := p.conn.ReadFrom(buf)
+		if err != nil {
+			select {
+			case <-p.done:
+				return
+			default:
+			}
+			p.eng.log(LogCluster, slog.LevelWarn, "gossip_failed", "receive gossip failed", LogKeyError, err)
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
+			}
+			return
+		}
+		data, ok := p.verify(buf[:n])
+		if !ok {
+			p.eng.log(LogCluster, slog.LevelWarn, "gossip_rejected", "gossip isn't signed by the key", "addr", addr.String())
+			continue
+		}
+		var message gossip
+		if err := json.Unmarshal(data, &message); err != nil || len(message.Members) < 1 {
+			continue
+		}
+		// the sender is reached at the address it sends from, which may differ from the one it listens.
+		message.Members[0].Addr = addr.String()
+		p.merge(message.Members)
+	}
+}
+
+// sign prefixes data with its HMAC by key, data is sent as is without a key.
+func (p *membership) sign(data []byte) []byte {
+	if p.key == nil {
+		return data
+	}
+	mac := hmac.New(sha256.New, p.key)
+	mac.Write(data)
+	return append(mac.Sum(make([]byte, 0, sha256.Size+len(data))), data...)
+}
+
+// verify returns the gossip of datagram signed by key, it's false if datagram is unsigned or signed by another key.
+func (p *membership) verify(datagram []byte) ([]byte, bool) {
+	if p.key == nil {
+		return datagram, true
+	}
+	if len(datagram) < sha256.Size {
+		return nil, false
+	}
+	mac := hmac.New(sha256.New, p.key)
+	mac.Write(datagram[sha256.Size:])
+	if !hmac.Equal(mac.Sum(nil), datagram[:sha256.Size]) {
+		return nil, false
+	}
+	return datagram[sha256.Size:], true
+}
+
+// merge keeps the latest heartbeats of members.
+func (p *membership) merge(members []memberState) {
+	now := time.Now()
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for _, it := range members {
+		if it.ID == p.eng.node.id || len(it.Addr) < 1 {
+			continue
+		}
+		if dead, ok := p.dead[it.ID]; ok {
+			if !it.newer(dead.incarnation, dead.heartbeat) {
+				continue
+			}
+			delete(p.dead, it.ID)
+		}
+		if m, ok := p.members[it.ID]; !ok {
+			p.members[it.ID] = &member{state: it, updated: now}
+		} else if it.newer(m.state.Incarnation, m.state.Heartbeat) {
+			m.state, m.updated = it, now
+		}
+	}
+}
+
+// sweep removes the members not heard in deadIntervals and returns them.
+func (p *membership) sweep() []string {
+	now := time.Now()
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	var ret []string
+	for id, it := range p.members {
+		if now.Sub(it.updated) > deadIntervals*p.interval {
+			delete(p.members, id)
+			p.dead[id] = deadMember{incarnation: it.state.Incarnation, heartbeat: it.state.Heartbeat, at: now}
+			ret = append(ret, id)
+		}
+	}
+	for id, it := range p.dead {
+		if now.Sub(it.at) > forgetIntervals*p.interval {
+			delete(p.dead, id)
+		}
+	}
+	return ret
+}
+
+// nodes returns the sorted IDs of alive nodes including the local one.
+func (p *membership) nodes() []string {
+	p.lock.Lock()
+	ret := make([]string, 0, len(p.members)+1)
+	ret = append(ret, p.eng.node.id)
+	for id := range p.members {
+		ret = append(ret, id)
+	}
+	p.lock.Unlock()
+	sort.Strings(ret)
+	return ret
+}
+
+func (p *membership) close() {
+	p.closeOnce.Do(func() {
+		close(p.done)
+		p.conn.Close()
+	})
+}
+
+func (p *engineImpl) Nodes() []string {
+	if p.membership != nil {
+		return p.membership.nodes()
+	}
+	if p.node != nil {
+		return []string{p.node.id}
+	}
+	return nil
+}
+
+// expire removes the state of a dead node by the adapter and the session store which are Expirers.
+func (p *engineImpl) expire(node string) {
+	p.log(LogCluster, slog.LevelWarn, "node_dead", "node is dead", LogKeyNode, node)
+	for _, it := range []interface{}{p.adapter, p.store} {
+		if expirer, ok := it.(Expirer); ok {
+			if err := expirer.Expire(node); err != nil {
+				p.log(LogCluster, slog.LevelError, "expire_failed", "expire node failed", LogKeyNode, node, LogKeyError, err)
+			}
+		}
+	}
+}
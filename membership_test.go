@@ -0,0 +1,123 @@
+package eio
+
+import (
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// expiringAdapter is an Adapter of a single node which records the expired nodes.
+type expiringAdapter struct {
+	lock    sync.Mutex
+	expired []string
+}
+
+func (p *expiringAdapter) Start(node Node)                        {}
+func (p *expiringAdapter) Publish(message *ClusterMessage) error  { return nil }
+func (p *expiringAdapter) Join(sid string, rooms []string) error  { return nil }
+func (p *expiringAdapter) Leave(sid string, rooms []string) error { return nil }
+func (p *expiringAdapter) Members(room string) ([]string, error)  { return nil, nil }
+func (p *expiringAdapter) SendTo(sid string, message *ClusterMessage) (bool, error) {
+	return false, nil
+}
+func (p *expiringAdapter) Close() error { return nil }
+
+func (p *expiringAdapter) Expire(node string) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.expired = append(p.expired, node)
+	return nil
+}
+
+func TestMembership(t *testing.T) {
+	var engines []Engine
+	var seed string
+	adapter := new(expiringAdapter)
+	for i := 0; i < 3; i++ {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			seed = conn.LocalAddr().String()
+		}
+		builder := NewEngineBuilder().SetMembership(conn, StaticDiscovery(seed), 20*time.Millisecond).SetMembershipKey([]byte("key"))
+		if i == 0 {
+			builder.SetAdapter(adapter)
+		}
+		eng := builder.Build()
+		defer eng.Close()
+		engines = append(engines, eng)
+	}
+	waitNodes := func(n int, engines ...Engine) {
+		for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+			done := true
+			for _, it := range engines {
+				done = done && len(it.Nodes()) == n
+			}
+			if done {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("nodes should be %d: %v", n, engines[0].Nodes())
+			}
+		}
+	}
+	// nodes which know the seed only find each other by gossip.
+	waitNodes(3, engines...)
+	if !reflect.DeepEqual(engines[1].Nodes(), engines[2].Nodes()) {
+		t.Errorf("nodes should be the same: %v %v", engines[1].Nodes(), engines[2].Nodes())
+	}
+
+	gone := engines[2].Nodes()
+	engines[2].Close()
+	waitNodes(2, engines[:2]...)
+	adapter.lock.Lock()
+	defer adapter.lock.Unlock()
+	if len(adapter.expired) != 1 {
+		t.Fatalf("dead node should be expired once: %v", adapter.expired)
+	}
+	found := false
+	for _, it := range gone {
+		found = found || it == adapter.expired[0]
+	}
+	if !found {
+		t.Errorf("bad expired node: %v", adapter.expired)
+	}
+	if NewEngineBuilder().Build().Nodes() != nil {
+		t.Error("engine out of cluster has no node")
+	}
+}
+
+func TestMembershipGossip(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	eng := NewEngineBuilder().SetMembership(conn, nil, time.Hour).SetMembershipKey([]byte("key")).Build()
+	defer eng.Close()
+	m := eng.(*engineImpl).membership
+	data := []byte(`{"members":[]}`)
+	if got, ok := m.verify(m.sign(data)); !ok || string(got) != string(data) {
+		t.Errorf("signed gossip should be verified: %q", got)
+	}
+	forged := &membership{key: []byte("other")}
+	for _, it := range [][]byte{data, forged.sign(data), nil} {
+		if _, ok := m.verify(it); ok {
+			t.Errorf("gossip %q shouldn't be verified", it)
+		}
+	}
+
+	m.merge([]memberState{{ID: "b", Addr: "127.0.0.1:1", Incarnation: 2, Heartbeat: 100}})
+	m.merge([]memberState{{ID: "b", Addr: "127.0.0.1:1", Incarnation: 1, Heartbeat: 1000}})
+	if state := m.members["b"].state; state.Incarnation != 2 || state.Heartbeat != 100 {
+		t.Errorf("state of former incarnation should be ignored: %+v", state)
+	}
+	// a restarted node starts its heartbeat over.
+	m.merge([]memberState{{ID: "b", Addr: "127.0.0.1:1", Incarnation: 3, Heartbeat: 1}})
+	if state := m.members["b"].state; state.Incarnation != 3 || state.Heartbeat != 1 {
+		t.Errorf("restarted node should supersede its former state: %+v", state)
+	}
+}
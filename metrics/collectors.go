@@ -0,0 +1,137 @@
+package metrics
+
+import (
+	"sort"
+
+	eio "github.com/jjeffcaii/engine.io"
+	"github.com/jjeffcaii/engine.io/parser"
+)
+
+// EngineCollector collects the counters of an engine, see eio.Engine.Stats.
+type EngineCollector struct {
+	eng       eio.Engine
+	namespace string
+	labels    []Label
+}
+
+// NewEngineCollector returns the collector of eng, its families are named "<namespace>_..." (default namespace is
+// "eio"). labels are added to every sample, so the engines of a process can be told apart.
+func NewEngineCollector(eng eio.Engine, namespace string, labels ...Label) *EngineCollector {
+	if len(namespace) < 1 {
+		namespace = "eio"
+	}
+	return &EngineCollector{eng: eng, namespace: namespace, labels: labels}
+}
+
+// sample returns a sample of value labeled by labels of collector and extra.
+func (p *EngineCollector) sample(value float64, extra ...Label) Sample {
+	labels := make([]Label, 0, len(p.labels)+len(extra))
+	return Sample{Labels: append(append(labels, p.labels...), extra...), Value: value}
+}
+
+// histogram returns the samples of h labeled by labels of collector and extra.
+func (p *EngineCollector) histogram(h eio.Histogram, extra ...Label) []Sample {
+	labels := make([]Label, 0, len(p.labels)+len(extra))
+	return HistogramSamples(h, append(append(labels, p.labels...), extra...)...)
+}
+
+func (p *EngineCollector) family(name, help string, t Type, samples ...Sample) Family {
+	return Family{Name: p.namespace + "_" + name, Help: help, Type: t, Samples: samples}
+}
+
+func (p *EngineCollector) Collect() []Family {
+	stats := p.eng.Stats()
+	transports := make([]eio.TransportType, 0, len(stats.Transports))
+	for it := range stats.Transports {
+		transports = append(transports, it)
+	}
+	sort.Slice(transports, func(i, j int) bool { return transports[i] < transports[j] })
+	var packetsIn, packetsOut, bytesIn, bytesOut, sizes, rtt []Sample
+	for _, it := range transports {
+		label, counters := Label{"transport", it.String()}, stats.Transports[it]
+		packetsIn = append(packetsIn, p.sample(float64(counters.PacketsIn), label))
+		packetsOut = append(packetsOut, p.sample(float64(counters.PacketsOut), label))
+		bytesIn = append(bytesIn, p.sample(float64(counters.BytesIn), label))
+		bytesOut = append(bytesOut, p.sample(float64(counters.BytesOut), label))
+		sizes = append(sizes, p.histogram(counters.SizesIn, label, Label{"direction", "in"})...)
+		sizes = append(sizes, p.histogram(counters.SizesOut, label, Label{"direction", "out"})...)
+		rtt = append(rtt, p.histogram(counters.HeartbeatRTT, label)...)
+	}
+	reasons := make([]eio.CloseReason, 0, len(stats.Closes))
+	for it := range stats.Closes {
+		reasons = append(reasons, it)
+	}
+	sort.Slice(reasons, func(i, j int) bool { return reasons[i] < reasons[j] })
+	var closes []Sample
+	for _, it := range reasons {
+		closes = append(closes, p.sample(float64(stats.Closes[it]), Label{"reason", it.String()}))
+	}
+	return []Family{
+		p.family("connections", "Open sockets.", Gauge, p.sample(float64(stats.Connections))),
+		p.family("handshakes_total", "Sockets opened.", Counter, p.sample(float64(stats.Handshakes))),
+		p.family("upgrades_total", "Upgrades of transports by result.", Counter,
+			p.sample(float64(stats.Upgrades), Label{"result", "success"}),
+			p.sample(float64(stats.UpgradeFailures), Label{"result", "failure"})),
+		p.family("packets_received_total", "Packets received by transport.", Counter, packetsIn...),
+		p.family("packets_sent_total", "Packets sent by transport.", Counter, packetsOut...),
+		p.family("received_bytes_total", "Bytes of packet data received by transport.", Counter, bytesIn...),
+		p.family("sent_bytes_total", "Bytes of packet data sent by transport.", Counter, bytesOut...),
+		p.family("message_size_bytes", "Data length of messages by transport and direction.", Histogram, sizes...),
+		p.family("heartbeat_rtt_seconds", "Round trips from the PING of server to the PONG of client by transport.",
+			Histogram, rtt...),
+		p.family("heartbeat_timeouts_total", "Sockets closed by the ping timeout.", Counter,
+			p.sample(float64(stats.HeartbeatTimeouts))),
+		p.family("closes_total", "Sockets closed by reason.", Counter, closes...),
+		p.family("slow_operations_total", "Message handlers and writes beyond the slow thresholds.", Counter,
+			p.sample(float64(stats.SlowHandlers), Label{"operation", "handler"}),
+			p.sample(float64(stats.SlowWrites), Label{"operation", "write"})),
+	}
+}
+
+// CodecCollector collects the counters of the codecs registered in parser, see parser.RegisterMetrics.
+type CodecCollector struct {
+	namespace string
+}
+
+// NewCodecCollector returns the collector of codecs, its families are named "<namespace>_codec_..."
+// (default namespace is "eio") and labeled by the names of codecs.
+func NewCodecCollector(namespace string) *CodecCollector {
+	if len(namespace) < 1 {
+		namespace = "eio"
+	}
+	return &CodecCollector{namespace: namespace}
+}
+
+func (p *CodecCollector) Collect() []Family {
+	all := parser.AllStats()
+	prefix := p.namespace + "_codec_"
+	families := []Family{
+		{Name: prefix + "packets_encoded_total", Help: "Packets encoded by codec.", Type: Counter},
+		{Name: prefix + "packets_decoded_total", Help: "Packets decoded by codec.", Type: Counter},
+		{Name: prefix + "encoded_bytes_total", Help: "Bytes of output encoded by codec.", Type: Counter},
+		{Name: prefix + "decoded_bytes_total", Help: "Bytes of input decoded by codec.", Type: Counter},
+		{Name: prefix + "errors_total", Help: "Encode and decode errors by codec and cause.", Type: Counter},
+	}
+	for _, name := range parser.MetricsNames() {
+		stats, ok := all[name]
+		if !ok {
+			continue
+		}
+		label := Label{"codec", name}
+		for i, v := range []uint64{stats.PacketsEncoded, stats.PacketsDecoded, stats.EncodedBytes, stats.DecodedBytes} {
+			families[i].Samples = append(families[i].Samples, Sample{Labels: []Label{label}, Value: float64(v)})
+		}
+		causes := make([]string, 0, len(stats.Errors))
+		for it := range stats.Errors {
+			causes = append(causes, it)
+		}
+		sort.Strings(causes)
+		for _, it := range causes {
+			families[4].Samples = append(families[4].Samples, Sample{
+				Labels: []Label{label, {"cause", it}},
+				Value:  float64(stats.Errors[it]),
+			})
+		}
+	}
+	return families
+}
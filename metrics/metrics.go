@@ -0,0 +1,204 @@
+// Package metrics exposes the counters of engines and codecs as Prometheus metrics, see eio.Engine.Stats and
+// parser.AllStats. Collectors are gathered by a Registry which serves the Prometheus text format, so the package
+// has no dependency. A collector may be registered on any number of registries, or bridged to another client
+// by calling its Collect on scrapes.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	eio "github.com/jjeffcaii/engine.io"
+)
+
+// Type is the type of a metric family.
+type Type string
+
+const (
+	// Counter is a value which only goes up, until the process restarts.
+	Counter Type = "counter"
+	// Gauge is a value which goes up and down.
+	Gauge Type = "gauge"
+	// Histogram is a distribution of values in cumulative buckets, see HistogramSamples.
+	Histogram Type = "histogram"
+)
+
+// contentType is the Prometheus text format.
+const contentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Family is a metric of samples which differ by labels.
+type Family struct {
+	Name    string
+	Help    string
+	Type    Type
+	Samples []Sample
+}
+
+// Sample is a value of a family.
+type Sample struct {
+	// Suffix is appended to the name of family, e.g. "_bucket" of histograms.
+	Suffix string
+	Labels []Label
+	Value  float64
+}
+
+// HistogramSamples returns the samples of h labeled by labels: a "_bucket" of every bound labeled by "le" counting the
+// observations up to it, "_sum" and "_count".
+func HistogramSamples(h eio.Histogram, labels ...Label) []Sample {
+	samples := make([]Sample, 0, len(h.Counts)+2)
+	var cumulative uint64
+	for i, n := range h.Counts {
+		le := "+Inf"
+		if i < len(h.Bounds) {
+			le = strconv.FormatFloat(h.Bounds[i], 'g', -1, 64)
+		}
+		cumulative += n
+		samples = append(samples, Sample{
+			Suffix: "_bucket",
+			Labels: append(append([]Label(nil), labels...), Label{"le", le}),
+			Value:  float64(cumulative),
+		})
+	}
+	return append(samples,
+		Sample{Suffix: "_sum", Labels: labels, Value: h.Sum},
+		Sample{Suffix: "_count", Labels: labels, Value: float64(h.Count)})
+}
+
+// Label is a dimension of samples.
+type Label struct {
+	Name, Value string
+}
+
+// Collector collects families on every scrape, it must be safe for concurrent use and comparable,
+// as registries tell collectors apart by ==.
+type Collector interface {
+	Collect() []Family
+}
+
+// Registry gathers the families of collectors, it's safe for concurrent use.
+// Families of the same name are merged, so collectors of engines told apart by labels can share names.
+type Registry struct {
+	lock       sync.RWMutex
+	collectors []Collector
+	// types are the types of family names, a name can't have two types.
+	types map[string]Type
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{types: make(map[string]Type)}
+}
+
+// Register adds c, it fails if c is registered already or a family of c has a name of another type.
+func (p *Registry) Register(c Collector) error {
+	families := c.Collect()
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for _, it := range p.collectors {
+		if it == c {
+			return fmt.Errorf("metrics: collector %v is registered already", c)
+		}
+	}
+	for _, it := range families {
+		if t, ok := p.types[it.Name]; ok && t != it.Type {
+			return fmt.Errorf("metrics: %s is a %s already", it.Name, t)
+		}
+	}
+	for _, it := range families {
+		p.types[it.Name] = it.Type
+	}
+	p.collectors = append(p.collectors, c)
+	return nil
+}
+
+// MustRegister registers collectors, it panics if any of them fails.
+func (p *Registry) MustRegister(collectors ...Collector) {
+	for _, it := range collectors {
+		if err := p.Register(it); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// Unregister removes c, it returns false if c isn't registered.
+func (p *Registry) Unregister(c Collector) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for i, it := range p.collectors {
+		if it == c {
+			p.collectors = append(p.collectors[:i], p.collectors[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Gather collects the families of all collectors sorted by name.
+func (p *Registry) Gather() []Family {
+	p.lock.RLock()
+	collectors := append([]Collector(nil), p.collectors...)
+	p.lock.RUnlock()
+	merged := make(map[string]*Family)
+	for _, c := range collectors {
+		for _, it := range c.Collect() {
+			if family, ok := merged[it.Name]; ok {
+				family.Samples = append(family.Samples, it.Samples...)
+			} else {
+				it := it
+				merged[it.Name] = &it
+			}
+		}
+	}
+	ret := make([]Family, 0, len(merged))
+	for _, it := range merged {
+		ret = append(ret, *it)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Name < ret[j].Name })
+	return ret
+}
+
+// ServeHTTP serves the families in the Prometheus text format.
+func (p *Registry) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	writer.Header().Set("Content-Type", contentType)
+	WriteText(writer, p.Gather())
+}
+
+// WriteText writes families in the Prometheus text format.
+func WriteText(writer io.Writer, families []Family) error {
+	w := bufio.NewWriter(writer)
+	for _, family := range families {
+		if len(family.Help) > 0 {
+			fmt.Fprintf(w, "# HELP %s %s\n", family.Name, helpEscaper.Replace(family.Help))
+		}
+		fmt.Fprintf(w, "# TYPE %s %s\n", family.Name, family.Type)
+		for _, sample := range family.Samples {
+			w.WriteString(family.Name)
+			w.WriteString(sample.Suffix)
+			if len(sample.Labels) > 0 {
+				w.WriteByte('{')
+				for i, it := range sample.Labels {
+					if i > 0 {
+						w.WriteByte(',')
+					}
+					fmt.Fprintf(w, "%s=\"%s\"", it.Name, labelEscaper.Replace(it.Value))
+				}
+				w.WriteByte('}')
+			}
+			w.WriteByte(' ')
+			w.WriteString(strconv.FormatFloat(sample.Value, 'g', -1, 64))
+			w.WriteByte('\n')
+		}
+	}
+	return w.Flush()
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
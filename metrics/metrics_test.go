@@ -0,0 +1,101 @@
+package metrics
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	eio "github.com/jjeffcaii/engine.io"
+)
+
+func TestEngineCollector(t *testing.T) {
+	eng := eio.NewEngineBuilder().Build()
+	defer eng.Close()
+	sockets := make(chan eio.Socket, 1)
+	eng.OnConnect(func(socket eio.Socket) { sockets <- socket })
+	client, err := eng.Loopback()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Receive()
+	socket := <-sockets
+	socket.Send("hello")
+	client.Receive()
+
+	registry := NewRegistry()
+	registry.MustRegister(NewEngineCollector(eng, "", Label{"node", "a"}), NewCodecCollector(""))
+	if err := registry.Register(NewEngineCollector(eng, "")); err != nil {
+		t.Errorf("engines should share names: %s", err)
+	}
+	server := httptest.NewServer(registry)
+	defer server.Close()
+	res, err := server.Client().Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	text := string(body)
+	for _, it := range []string{
+		"# TYPE eio_connections gauge\neio_connections{node=\"a\"} 1\neio_connections 1\n",
+		`eio_handshakes_total{node="a"} 1`,
+		`eio_upgrades_total{node="a",result="failure"} 0`,
+		`eio_packets_sent_total{node="a",transport="loopback"} 2`,
+		`eio_sent_bytes_total{node="a",transport="loopback"}`,
+		"# TYPE eio_message_size_bytes histogram\n",
+		`eio_message_size_bytes_bucket{node="a",transport="loopback",direction="out",le="64"} 1`,
+		`eio_message_size_bytes_bucket{node="a",transport="loopback",direction="out",le="+Inf"} 1`,
+		`eio_message_size_bytes_sum{node="a",transport="loopback",direction="out"} 5`,
+		`eio_message_size_bytes_count{node="a",transport="loopback",direction="in"} 0`,
+		`eio_heartbeat_rtt_seconds_count{node="a",transport="loopback"} 0`,
+		"# TYPE eio_codec_packets_encoded_total counter",
+	} {
+		if !strings.Contains(text, it) {
+			t.Errorf("%q should be scraped:\n%s", it, text)
+		}
+	}
+	if ct := res.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("bad content type: %s", ct)
+	}
+
+	socket.Close()
+	client.Close()
+	// close handlers run in background.
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if eng.Stats().Closes[eio.CloseForced] > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("close should be counted")
+		}
+	}
+	for _, it := range registry.Gather() {
+		if it.Name == "eio_closes_total" && (len(it.Samples) < 1 || it.Samples[0].Labels[1].Value != eio.CloseForced.String()) {
+			t.Errorf("bad closes: %v", it.Samples)
+		}
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	registry := NewRegistry()
+	eng := eio.NewEngineBuilder().Build()
+	defer eng.Close()
+	c := NewEngineCollector(eng, "x")
+	registry.MustRegister(c)
+	if err := registry.Register(c); err == nil {
+		t.Error("collector shouldn't be registered twice")
+	}
+	if !registry.Unregister(c) || registry.Unregister(c) {
+		t.Error("collector should be unregistered once")
+	}
+	if n := len(registry.Gather()); n != 0 {
+		t.Errorf("empty registry shouldn't gather: %d", n)
+	}
+	var b strings.Builder
+	WriteText(&b, []Family{{Name: "x", Help: "a\\b\nc", Type: Counter, Samples: []Sample{{Labels: []Label{{"l", "\"q\"\n"}}, Value: 1.5}}}})
+	if want := "# HELP x a\\\\b\\nc\n# TYPE x counter\nx{l=\"\\\"q\\\"\\n\"} 1.5\n"; b.String() != want {
+		t.Errorf("bad text:\n%s", b.String())
+	}
+}
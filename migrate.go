@@ -0,0 +1,179 @@
+package eio
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/jjeffcaii/engine.io/parser"
+)
+
+var (
+	// ErrMigrateWebsocket is returned by migrating a websocket session, an upgraded connection can't be handed over.
+	// The session isn't paused nor changed by the failed migration.
+	ErrMigrateWebsocket = errors.New("transport: cannot migrate websocket sessions")
+	errNoSessionStore   = errors.New("transport: migration needs a session store")
+)
+
+// MigratedSession is the state of a session handed over to another node, see Engine.Migrate.
+type MigratedSession struct {
+	ID         string `json:"id"`
+	Protocol   uint8  `json:"protocol"`
+	RemoteAddr string `json:"remoteAddr"`
+	// Metadata are the values of Socket.Set, they are passed as JSON.
+	Metadata map[string]interface{} `json:"metadata,omitempty"`
+	Rooms    []string               `json:"rooms,omitempty"`
+	// Packets are the packets not received by the client yet.
+	Packets []MigratedPacket `json:"packets,omitempty"`
+}
+
+// MigratedPacket is a packet of a MigratedSession.
+type MigratedPacket struct {
+	Type   parser.PacketType `json:"type"`
+	Data   []byte            `json:"data,omitempty"`
+	Binary bool              `json:"binary,omitempty"`
+}
+
+func migratedPackets(packets []*parser.Packet) []MigratedPacket {
+	ret := make([]MigratedPacket, 0, len(packets))
+	for _, it := range packets {
+		ret = append(ret, MigratedPacket{Type: it.Type, Data: it.Data, Binary: it.Option&parser.BINARY == parser.BINARY})
+	}
+	return ret
+}
+
+func (p *engineImpl) Migrate(ctx context.Context, sid, node string) error {
+	if p.store == nil {
+		return errNoSessionStore
+	}
+	if node == p.node.id {
+		return fmt.Errorf("socket#%s is owned by node %s already", sid, node)
+	}
+	socket, ok := p.sockets.Get(sid)
+	if !ok {
+		return fmt.Errorf("socket#%s doesn't exist", sid)
+	}
+	if socket.getTransportOld() != nil {
+		return fmt.Errorf("socket#%s is being upgraded", sid)
+	}
+	tp, ok := socket.getTransport().(*xhrTransport)
+	if !ok {
+		return ErrMigrateWebsocket
+	}
+	// packets sent from now on are held, so the queued ones are all the client misses.
+	tp.Pause()
+	var packets []*parser.Packet
+	for {
+		pk, ok := tp.outbox.pop()
+		if !ok {
+			break
+		}
+		if pk.Type != parser.NOOP {
+			packets = append(packets, pk)
+		}
+	}
+	session := &MigratedSession{
+		ID:         socket.id,
+		Protocol:   uint8(socket.protocol),
+		RemoteAddr: socket.remoteAddr,
+		Rooms:      socket.Rooms(),
+		Packets:    migratedPackets(append(packets, tp.takeHeld()...)),
+	}
+	socket.metaLock.RLock()
+	if len(socket.meta) > 0 {
+		session.Metadata = make(map[string]interface{}, len(socket.meta))
+		for k, v := range socket.meta {
+			session.Metadata[k] = v
+		}
+	}
+	socket.metaLock.RUnlock()
+	if err := p.handOver(ctx, node, session); err != nil {
+		// the client goes on with this node, packets handed over are lost.
+		if err := tp.Resume(); err != nil {
+			p.log(LogCluster, slog.LevelError, "resume_failed", "resume transport failed",
+				LogKeySid, sid, LogKeyTransport, tp.GetType().String(), LogKeyError, err)
+		}
+		return err
+	}
+	// requests of the session are relayed to the owner from now on, the pending poll ends with a NOOP.
+	p.sockets.Remove(socket)
+	tp.send(parser.NewPacketCustom(parser.NOOP, make([]byte, 0), 0))
+	if held := tp.takeHeld(); len(held) > 0 {
+		if err := p.handOver(ctx, node, &MigratedSession{ID: sid, Packets: migratedPackets(held)}); err != nil {
+			p.log(LogCluster, slog.LevelError, "migrate_failed", "hand over packets failed",
+				LogKeySid, sid, LogKeyNode, node, "packets", len(held), LogKeyError, err)
+		}
+	}
+	socket.closeWith(CloseMigrated, nil)
+	return nil
+}
+
+// handOver relays session to node, which owns it once it's done.
+func (p *engineImpl) handOver(ctx context.Context, node string, session *MigratedSession) error {
+	res, err := p.store.Relay(ctx, node, &RelayedRequest{Session: session})
+	if err != nil {
+		return err
+	}
+	if res.Status != http.StatusOK {
+		return fmt.Errorf("socket#%s: migrate to node %s failed: %s", session.ID, node, res.Body)
+	}
+	return nil
+}
+
+// adopt opens a session migrated from another node on a polling transport. The packets of a session adopted already
+// are sent to it. The OnConnect handlers run as the socket is new to the node.
+func (p *engineImpl) adopt(session *MigratedSession) error {
+	if socket, ok := p.sockets.Get(session.ID); ok {
+		socket.queueMigrated(session.Packets)
+		return nil
+	}
+	release, err := p.admit(nil)
+	if err != nil {
+		return err
+	}
+	socket := newSocket(context.Background(), session.ID, p, session.RemoteAddr)
+	socket.protocol = parser.Protocol(session.Protocol)
+	if !p.sockets.Put(socket) {
+		socket.cancel()
+		release()
+		return fmt.Errorf("socket#%s exists", session.ID)
+	}
+	tp := newXhrTransport(p)
+	socket.setTransport(tp)
+	tp.setSocket(socket)
+	p.watchSocket(socket, release, true)
+	for k, v := range session.Metadata {
+		socket.Set(k, v)
+	}
+	socket.Join(session.Rooms...)
+	p.saveSession(socket)
+	socket.queueMigrated(session.Packets)
+	if p.presence != nil && len(p.presence.key) < 1 {
+		p.presence.track(socket, socket.id)
+	}
+	if socket.protocol == parser.V4 {
+		socket.startPing()
+	}
+	p.socketCreated(socket)
+	return nil
+}
+
+// queueMigrated sends packets in order in background, the queue may be full until the client polls.
+func (p *socketImpl) queueMigrated(packets []MigratedPacket) {
+	if len(packets) < 1 {
+		return
+	}
+	go func() {
+		for _, it := range packets {
+			var option parser.PacketOption
+			if it.Binary {
+				option = parser.BINARY
+			}
+			if err := p.write(parser.NewPacketCustom(it.Type, it.Data, option)); err != nil {
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,95 @@
+package eio
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestMigrate(t *testing.T) {
+	store := &memoryStore{sessions: make(map[string]*SessionInfo), nodes: make(map[string]SessionNode)}
+	a, b := NewServer(WithSessionStore(store)), NewServer(WithSessionStore(store))
+	defer a.Close()
+	defer b.Close()
+	sockets := make(chan Socket, 1)
+	migrated := make(chan Socket, 1)
+	reasons := make(chan CloseReason, 1)
+	a.OnConnect(func(socket Socket) { sockets <- socket })
+	a.OnDisconnect(func(socket Socket, reason CloseReason) { reasons <- reason })
+	b.OnConnect(func(socket Socket) { migrated <- socket })
+	ha := httptest.NewServer(a)
+	defer ha.Close()
+	poll(t, http.MethodGet, ha.URL+"/engine.io/?EIO=3&transport=polling", "", "")
+	socket := <-sockets
+	socket.Set("user", "alice")
+	socket.Join("news")
+	socket.Send("queued")
+
+	node := b.Nodes()[0]
+	if err := a.Migrate(context.Background(), socket.ID(), node); err != nil {
+		t.Fatal(err)
+	}
+	if reason := <-reasons; reason != CloseMigrated {
+		t.Errorf("bad close reason: %s", reason)
+	}
+	target := <-migrated
+	if user, _ := target.Get("user"); target.ID() != socket.ID() || user != "alice" || !reflect.DeepEqual(target.Rooms(), []string{"news"}) {
+		t.Errorf("bad migrated socket: %s %v %v", target.ID(), user, target.Rooms())
+	}
+	if info, _ := store.Load(socket.ID()); info == nil || info.Node != node {
+		t.Errorf("session should be owned by the target: %v", info)
+	}
+	query := "/engine.io/?EIO=3&transport=polling&sid=" + socket.ID()
+	if res, body := poll(t, http.MethodGet, ha.URL+query, "", ""); res.StatusCode != http.StatusOK || !strings.Contains(body, "4queued") {
+		t.Errorf("queued packet should be polled from the target: %d %q", res.StatusCode, body)
+	}
+	if err := a.Migrate(context.Background(), socket.ID(), node); err == nil {
+		t.Error("migrated socket isn't local")
+	}
+	if err := NewServer().Migrate(context.Background(), socket.ID(), node); err != errNoSessionStore {
+		t.Errorf("migration should need a session store: %v", err)
+	}
+}
+
+func TestMigrateWebsocket(t *testing.T) {
+	store := &memoryStore{sessions: make(map[string]*SessionInfo), nodes: make(map[string]SessionNode)}
+	a, b := NewServer(WithSessionStore(store)), NewServer(WithSessionStore(store))
+	defer a.Close()
+	defer b.Close()
+	sockets := make(chan Socket, 1)
+	migrated := make(chan Socket, 1)
+	a.OnConnect(func(socket Socket) { sockets <- socket })
+	b.OnConnect(func(socket Socket) { migrated <- socket })
+	ha := httptest.NewServer(a)
+	defer ha.Close()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ha.URL, "http")+"/engine.io/?EIO=4&transport=websocket", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	readFrame(t, conn)
+	socket := <-sockets
+
+	if err := a.Migrate(context.Background(), socket.ID(), b.Nodes()[0]); err != ErrMigrateWebsocket {
+		t.Fatalf("websocket session shouldn't be migrated: %v", err)
+	}
+	socket.Send("still")
+	if _, msg := readFrame(t, conn); msg != "4still" {
+		t.Errorf("session should go on with this node: %q", msg)
+	}
+	if info, _ := store.Load(socket.ID()); info == nil || info.Node != a.Nodes()[0] {
+		t.Errorf("session should be owned by this node still: %v", info)
+	}
+	select {
+	case it := <-migrated:
+		t.Errorf("target shouldn't adopt the session: %s", it.ID())
+	default:
+	}
+}
@@ -0,0 +1,658 @@
+// Package nats is an adapter of engine.io clusters on NATS JetStream, see eio.Adapter.
+// Broadcasts are published to a stream and consumed by a durable consumer of every node, which acknowledges
+// them once they are delivered to local sockets, so they are delivered at least once even if a node
+// reconnects. It speaks the NATS protocol without any client library.
+package nats
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	eio "github.com/jjeffcaii/engine.io"
+)
+
+const (
+	defaultAddr   = "localhost:4222"
+	defaultStream = "EIO"
+	defaultPrefix = "eio"
+	// defaultTimeout is the timeout of dials and requests.
+	defaultTimeout        = 5 * time.Second
+	defaultReconnectWait  = time.Second
+	defaultAckWait        = 30 * time.Second
+	defaultMembersTimeout = 200 * time.Millisecond
+	defaultSendTimeout    = 200 * time.Millisecond
+	defaultMaxAge         = 10 * time.Minute
+	defaultMaxMsgs        = 1000000
+	defaultMaxBytes       = 1 << 30
+	// inactiveThreshold removes consumers of nodes gone if their names are generated.
+	inactiveThreshold = time.Hour
+	// errCodeStreamInUse is the JetStream error of creating a stream which exists.
+	errCodeStreamInUse = 10058
+)
+
+// sids of subscriptions.
+const (
+	sidInbox = iota + 1
+	sidMembers
+	sidDeliver
+	sidSendTo
+	sidPresence
+	sidOwner
+)
+
+var (
+	errAdapterClosed = errors.New("nats: adapter closed")
+	errNotConnected  = errors.New("nats: not connected")
+	errTimeout       = errors.New("nats: request timeout")
+)
+
+// Options configures an Adapter.
+type Options struct {
+	// Addr is the host:port of NATS. (default is localhost:4222)
+	Addr string
+	// Name is the name of connection, which is shown by the server.
+	Name string
+	// User and Password, or Token authenticate the connection if they are not empty.
+	User, Password, Token string
+	// Stream is the JetStream stream of broadcasts, it's created if it doesn't exist. (default is EIO)
+	// It has the interest retention, so a broadcast is removed once every consumer acknowledges it.
+	Stream string
+	// MaxAge, MaxMsgs and MaxBytes bound the broadcasts kept by the stream, e.g. for the consumers of nodes gone,
+	// the oldest are discarded beyond them. They apply when the stream is created, an existing stream keeps its
+	// config. (default is 10 minutes, 1000000 messages and 1 GB, a negative value means unlimited)
+	MaxAge   time.Duration
+	MaxMsgs  int64
+	MaxBytes int64
+	// Prefix is the prefix of subjects, nodes of a cluster must have the same one. (default is eio)
+	Prefix string
+	// Durable is the name of the consumer of node, a node restarted with the same name gets the broadcasts
+	// it hasn't acknowledged. Nodes must have different names. (default is generated, which is removed by the
+	// server once it's inactive for an hour)
+	Durable string
+	// AckWait is how long a broadcast is redelivered after if it's not acknowledged. (default is 30 seconds)
+	AckWait time.Duration
+	// Timeout is the timeout of dials and requests. (default is 5 seconds)
+	Timeout time.Duration
+	// ReconnectWait is how long the adapter waits before it reconnects. (default is 1 second)
+	ReconnectWait time.Duration
+	// MembersTimeout is how long Members waits for the answers of other nodes. (default is 200 milliseconds)
+	MembersTimeout time.Duration
+	// SendTimeout is how long SendTo waits for the node which owns the sid. (default is 200 milliseconds)
+	SendTimeout time.Duration
+	// OnError receives errors of the connection, which reconnects after them.
+	OnError func(err error)
+}
+
+// Adapter is an eio.Adapter on NATS JetStream. Rooms and presences are kept by every node for its own sockets,
+// Members and Presences ask the other nodes by requests of core NATS.
+type Adapter struct {
+	options Options
+	node    eio.Node
+	inbox   string
+	// lock guards conn, which is nil while the adapter is reconnecting.
+	lock    sync.RWMutex
+	conn    *conn
+	pending sync.Map
+	tokens  int64
+	// roomLock guards rooms, the rooms of local sockets.
+	roomLock sync.RWMutex
+	rooms    map[string]map[string]struct{}
+	// presences are the users of local node.
+	presenceLock sync.RWMutex
+	presences    map[string]eio.PresenceInfo
+	done         chan struct{}
+	closed       sync.Once
+}
+
+// NewAdapter returns an adapter of options, it connects once it's started by eio.EngineBuilder.Build.
+func NewAdapter(options Options) *Adapter {
+	if len(options.Addr) < 1 {
+		options.Addr = defaultAddr
+	}
+	if len(options.Stream) < 1 {
+		options.Stream = defaultStream
+	}
+	if len(options.Prefix) < 1 {
+		options.Prefix = defaultPrefix
+	}
+	if options.AckWait <= 0 {
+		options.AckWait = defaultAckWait
+	}
+	if options.MaxAge == 0 {
+		options.MaxAge = defaultMaxAge
+	}
+	if options.MaxMsgs == 0 {
+		options.MaxMsgs = defaultMaxMsgs
+	}
+	if options.MaxBytes == 0 {
+		options.MaxBytes = defaultMaxBytes
+	}
+	if options.Timeout <= 0 {
+		options.Timeout = defaultTimeout
+	}
+	if options.ReconnectWait <= 0 {
+		options.ReconnectWait = defaultReconnectWait
+	}
+	if options.MembersTimeout <= 0 {
+		options.MembersTimeout = defaultMembersTimeout
+	}
+	if options.SendTimeout <= 0 {
+		options.SendTimeout = defaultSendTimeout
+	}
+	return &Adapter{
+		options:   options,
+		inbox:     "_INBOX." + randomID(),
+		rooms:     make(map[string]map[string]struct{}),
+		presences: make(map[string]eio.PresenceInfo),
+		done:      make(chan struct{}),
+	}
+}
+
+func randomID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Start connects in background, broadcasts of other nodes are delivered to node.
+func (p *Adapter) Start(node eio.Node) {
+	p.node = node
+	go p.run()
+}
+
+func (p *Adapter) broadcastSubject() string {
+	return p.options.Prefix + ".broadcast"
+}
+
+func (p *Adapter) membersSubject() string {
+	return p.options.Prefix + ".members"
+}
+
+func (p *Adapter) sendToSubject() string {
+	return p.options.Prefix + ".sendto"
+}
+
+// ownerSubject is the subject of SendTo of the sids owned by node, see eio.Node.Owner.
+func (p *Adapter) ownerSubject(node string) string {
+	return p.options.Prefix + ".sendto." + node
+}
+
+func (p *Adapter) presenceSubject() string {
+	return p.options.Prefix + ".presence"
+}
+
+func (p *Adapter) durable() string {
+	if len(p.options.Durable) > 0 {
+		return p.options.Durable
+	}
+	return "eio-" + p.node.ID()
+}
+
+// run keeps the adapter connected until it's closed.
+func (p *Adapter) run() {
+	for {
+		err := p.session()
+		select {
+		case <-p.done:
+			return
+		default:
+		}
+		if p.options.OnError != nil {
+			p.options.OnError(err)
+		}
+		select {
+		case <-p.done:
+			return
+		case <-time.After(p.options.ReconnectWait):
+		}
+	}
+}
+
+// session serves a connection until it fails.
+func (p *Adapter) session() error {
+	c, err := dial(&p.options)
+	if err != nil {
+		return err
+	}
+	p.lock.Lock()
+	select {
+	case <-p.done:
+		p.lock.Unlock()
+		c.close()
+		return errAdapterClosed
+	default:
+	}
+	p.conn = c
+	p.lock.Unlock()
+	defer func() {
+		p.lock.Lock()
+		p.conn = nil
+		p.lock.Unlock()
+		c.close()
+	}()
+	if err := c.sub(p.inbox+".*", sidInbox); err != nil {
+		return err
+	}
+	if err := c.sub(p.membersSubject(), sidMembers); err != nil {
+		return err
+	}
+	if err := c.sub(p.sendToSubject(), sidSendTo); err != nil {
+		return err
+	}
+	if err := c.sub(p.presenceSubject(), sidPresence); err != nil {
+		return err
+	}
+	if err := c.sub(p.ownerSubject(p.node.ID()), sidOwner); err != nil {
+		return err
+	}
+	read := make(chan error, 1)
+	go func() { read <- p.read(c) }()
+	if err := p.setup(c); err != nil {
+		c.close()
+		<-read
+		return err
+	}
+	return <-read
+}
+
+// jsResponse is a response of JetStream API.
+type jsResponse struct {
+	Error *struct {
+		Code        int    `json:"code"`
+		ErrCode     int    `json:"err_code"`
+		Description string `json:"description"`
+	} `json:"error"`
+}
+
+func (r *jsResponse) err() error {
+	if r.Error == nil {
+		return nil
+	}
+	return fmt.Errorf("nats: %s (%d)", r.Error.Description, r.Error.ErrCode)
+}
+
+// setup creates the stream and the consumer of node, then subscribes the deliveries.
+func (p *Adapter) setup(c *conn) error {
+	config := map[string]interface{}{
+		"name":      p.options.Stream,
+		"subjects":  []string{p.broadcastSubject()},
+		"retention": "interest",
+		"discard":   "old",
+	}
+	// JetStream takes -1 as unlimited and max_age of 0 as forever.
+	if p.options.MaxAge > 0 {
+		config["max_age"] = p.options.MaxAge.Nanoseconds()
+	}
+	for k, v := range map[string]int64{"max_msgs": p.options.MaxMsgs, "max_bytes": p.options.MaxBytes} {
+		if v < 0 {
+			v = -1
+		}
+		config[k] = v
+	}
+	stream, _ := json.Marshal(config)
+	var res jsResponse
+	if err := p.requestJSON(c, "$JS.API.STREAM.CREATE."+p.options.Stream, stream, &res); err != nil {
+		return err
+	}
+	if res.Error != nil && res.Error.ErrCode != errCodeStreamInUse {
+		return res.err()
+	}
+	deliver := p.options.Prefix + ".deliver." + p.durable()
+	// subscribe first, so no delivery is missed once the consumer is created.
+	if err := c.sub(deliver, sidDeliver); err != nil {
+		return err
+	}
+	config = map[string]interface{}{
+		"durable_name":    p.durable(),
+		"deliver_subject": deliver,
+		"deliver_policy":  "new",
+		"ack_policy":      "explicit",
+		"ack_wait":        p.options.AckWait.Nanoseconds(),
+	}
+	if len(p.options.Durable) < 1 {
+		config["inactive_threshold"] = inactiveThreshold.Nanoseconds()
+	}
+	consumer, _ := json.Marshal(map[string]interface{}{"stream_name": p.options.Stream, "config": config})
+	res = jsResponse{}
+	if err := p.requestJSON(c, "$JS.API.CONSUMER.CREATE."+p.options.Stream+"."+p.durable(), consumer, &res); err != nil {
+		return err
+	}
+	return res.err()
+}
+
+// read dispatches messages of connection until it fails.
+func (p *Adapter) read(c *conn) error {
+	for {
+		m, err := c.next()
+		if err != nil {
+			return err
+		}
+		switch m.sid {
+		case sidInbox:
+			token := m.subject[strings.LastIndexByte(m.subject, '.')+1:]
+			if ch, ok := p.pending.Load(token); ok {
+				select {
+				case ch.(chan []byte) <- m.data:
+				default:
+				}
+			}
+		case sidMembers:
+			p.answerMembers(c, m)
+		case sidSendTo, sidOwner:
+			p.answerSendTo(c, m)
+		case sidPresence:
+			p.answerPresences(c, m)
+		case sidDeliver:
+			var message eio.ClusterMessage
+			if err := json.Unmarshal(m.data, &message); err != nil {
+				if p.options.OnError != nil {
+					p.options.OnError(fmt.Errorf("nats: bad message: %w", err))
+				}
+			} else {
+				p.node.Deliver(&message)
+			}
+			// a bad message is acknowledged too, it would be redelivered forever.
+			if len(m.reply) > 0 {
+				if err := c.pub(m.reply, "", []byte("+ACK")); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+// request publishes data to subject and returns the channel of replies, cancel must be called once it's done.
+func (p *Adapter) request(c *conn, subject string, data []byte, replies int) (<-chan []byte, func(), error) {
+	token := strconv.FormatInt(atomic.AddInt64(&(p.tokens), 1), 36)
+	ch := make(chan []byte, replies)
+	p.pending.Store(token, ch)
+	cancel := func() { p.pending.Delete(token) }
+	if err := c.pub(subject, p.inbox+"."+token, data); err != nil {
+		cancel()
+		return nil, nil, err
+	}
+	return ch, cancel, nil
+}
+
+// requestJSON sends a request and decodes its reply into v.
+func (p *Adapter) requestJSON(c *conn, subject string, data []byte, v interface{}) error {
+	ch, cancel, err := p.request(c, subject, data, 1)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	timer := time.NewTimer(p.options.Timeout)
+	defer timer.Stop()
+	select {
+	case reply := <-ch:
+		return json.Unmarshal(reply, v)
+	case <-timer.C:
+		return errTimeout
+	case <-p.done:
+		return errAdapterClosed
+	}
+}
+
+func (p *Adapter) current() (*conn, error) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	select {
+	case <-p.done:
+		return nil, errAdapterClosed
+	default:
+	}
+	if p.conn == nil {
+		return nil, errNotConnected
+	}
+	return p.conn, nil
+}
+
+// Publish publishes message to the stream, it returns once the stream stores it.
+func (p *Adapter) Publish(message *eio.ClusterMessage) error {
+	c, err := p.current()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	var res jsResponse
+	if err := p.requestJSON(c, p.broadcastSubject(), data, &res); err != nil {
+		return err
+	}
+	return res.err()
+}
+
+func (p *Adapter) Join(sid string, rooms []string) error {
+	p.roomLock.Lock()
+	defer p.roomLock.Unlock()
+	for _, it := range rooms {
+		members, ok := p.rooms[it]
+		if !ok {
+			members = make(map[string]struct{})
+			p.rooms[it] = members
+		}
+		members[sid] = struct{}{}
+	}
+	return nil
+}
+
+func (p *Adapter) Leave(sid string, rooms []string) error {
+	p.roomLock.Lock()
+	defer p.roomLock.Unlock()
+	if rooms == nil {
+		for room := range p.rooms {
+			rooms = append(rooms, room)
+		}
+	}
+	for _, it := range rooms {
+		if members, ok := p.rooms[it]; ok {
+			delete(members, sid)
+			if len(members) < 1 {
+				delete(p.rooms, it)
+			}
+		}
+	}
+	return nil
+}
+
+func (p *Adapter) localMembers(room string) []string {
+	p.roomLock.RLock()
+	defer p.roomLock.RUnlock()
+	ret := make([]string, 0, len(p.rooms[room]))
+	for it := range p.rooms[room] {
+		ret = append(ret, it)
+	}
+	return ret
+}
+
+// answerMembers answers a request of Members of another node, nodes without the room keep silent.
+func (p *Adapter) answerMembers(c *conn, m *msg) {
+	if len(m.reply) < 1 || strings.HasPrefix(m.reply, p.inbox+".") {
+		return
+	}
+	members := p.localMembers(string(m.data))
+	if len(members) < 1 {
+		return
+	}
+	data, _ := json.Marshal(members)
+	c.pub(m.reply, "", data)
+}
+
+// Members returns the sids of room of the nodes which answer in MembersTimeout.
+func (p *Adapter) Members(room string) ([]string, error) {
+	c, err := p.current()
+	if err != nil {
+		return nil, err
+	}
+	ch, cancel, err := p.request(c, p.membersSubject(), []byte(room), 64)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+	ret := p.localMembers(room)
+	timer := time.NewTimer(p.options.MembersTimeout)
+	defer timer.Stop()
+	for {
+		select {
+		case reply := <-ch:
+			var members []string
+			if json.Unmarshal(reply, &members) == nil {
+				ret = append(ret, members...)
+			}
+		case <-timer.C:
+			return ret, nil
+		case <-p.done:
+			return nil, errAdapterClosed
+		}
+	}
+}
+
+// answerSendTo delivers a message of SendTo of another node, only the node which owns the sid answers.
+func (p *Adapter) answerSendTo(c *conn, m *msg) {
+	if len(m.reply) < 1 || strings.HasPrefix(m.reply, p.inbox+".") {
+		return
+	}
+	var message eio.ClusterMessage
+	if json.Unmarshal(m.data, &message) != nil || p.node.Deliver(&message) < 1 {
+		return
+	}
+	c.pub(m.reply, "", []byte("+OK"))
+}
+
+// SendTo requests the nodes to deliver message by core NATS, it returns false if no node answers in SendTimeout.
+// It's requested to the owner of sid first if the node has a session router, then to all nodes if the owner
+// doesn't answer.
+func (p *Adapter) SendTo(sid string, message *eio.ClusterMessage) (bool, error) {
+	c, err := p.current()
+	if err != nil {
+		return false, err
+	}
+	data, err := json.Marshal(message)
+	if err != nil {
+		return false, err
+	}
+	if owner := p.node.Owner(sid); len(owner) > 0 && owner != p.node.ID() {
+		if ok, err := p.sendTo(c, p.ownerSubject(owner), data); ok || err != nil {
+			return ok, err
+		}
+	}
+	return p.sendTo(c, p.sendToSubject(), data)
+}
+
+// sendTo requests subject to deliver data, it returns false if no node answers in SendTimeout.
+func (p *Adapter) sendTo(c *conn, subject string, data []byte) (bool, error) {
+	ch, cancel, err := p.request(c, subject, data, 1)
+	if err != nil {
+		return false, err
+	}
+	defer cancel()
+	timer := time.NewTimer(p.options.SendTimeout)
+	defer timer.Stop()
+	select {
+	case <-ch:
+		return true, nil
+	case <-timer.C:
+		return false, nil
+	case <-p.done:
+		return false, errAdapterClosed
+	}
+}
+
+// Track keeps the user of the local node, an offline one is kept for its last seen.
+func (p *Adapter) Track(info eio.PresenceInfo) error {
+	p.presenceLock.Lock()
+	defer p.presenceLock.Unlock()
+	p.presences[info.User] = info
+	return nil
+}
+
+func (p *Adapter) localPresences() []eio.PresenceInfo {
+	p.presenceLock.RLock()
+	defer p.presenceLock.RUnlock()
+	ret := make([]eio.PresenceInfo, 0, len(p.presences))
+	for _, it := range p.presences {
+		ret = append(ret, it)
+	}
+	return ret
+}
+
+// answerPresences answers a request of Presences of another node, nodes without users keep silent.
+func (p *Adapter) answerPresences(c *conn, m *msg) {
+	if len(m.reply) < 1 || strings.HasPrefix(m.reply, p.inbox+".") {
+		return
+	}
+	presences := p.localPresences()
+	if len(presences) < 1 {
+		return
+	}
+	data, _ := json.Marshal(presences)
+	c.pub(m.reply, "", data)
+}
+
+// Presences returns the users of the nodes which answer in MembersTimeout.
+func (p *Adapter) Presences() ([]eio.PresenceInfo, error) {
+	c, err := p.current()
+	if err != nil {
+		return nil, err
+	}
+	ch, cancel, err := p.request(c, p.presenceSubject(), nil, 64)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+	users := make(map[string]eio.PresenceInfo)
+	merge := func(presences []eio.PresenceInfo) {
+		for _, it := range presences {
+			if old, ok := users[it.User]; ok {
+				it.Sockets += old.Sockets
+				if old.LastSeen.After(it.LastSeen) {
+					it.LastSeen = old.LastSeen
+				}
+			}
+			users[it.User] = it
+		}
+	}
+	merge(p.localPresences())
+	timer := time.NewTimer(p.options.MembersTimeout)
+	defer timer.Stop()
+	for {
+		select {
+		case reply := <-ch:
+			var presences []eio.PresenceInfo
+			if json.Unmarshal(reply, &presences) == nil {
+				merge(presences)
+			}
+		case <-timer.C:
+			ret := make([]eio.PresenceInfo, 0, len(users))
+			for _, it := range users {
+				ret = append(ret, it)
+			}
+			return ret, nil
+		case <-p.done:
+			return nil, errAdapterClosed
+		}
+	}
+}
+
+// Close closes the connection, the durable consumer is kept by the server.
+func (p *Adapter) Close() error {
+	p.closed.Do(func() {
+		p.lock.Lock()
+		close(p.done)
+		if p.conn != nil {
+			p.conn.close()
+		}
+		p.lock.Unlock()
+	})
+	return nil
+}
@@ -0,0 +1,355 @@
+package nats
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	eio "github.com/jjeffcaii/engine.io"
+)
+
+// fakeNATS serves core NATS and the parts of JetStream used by Adapter in memory.
+// The pending deliveries of a consumer are redelivered once its deliver subject is subscribed again.
+type fakeNATS struct {
+	listener net.Listener
+	lock     sync.Mutex
+	subs     []*fakeSub
+	streams  map[string][]string
+	// configs are the configs of streams created.
+	configs   map[string]map[string]interface{}
+	consumers map[string]*fakeConsumer
+	seq       int
+	// refused are the names of clients whose connections are refused.
+	refused map[string]bool
+	clients map[*conn]string
+}
+
+type fakeSub struct {
+	client  *conn
+	subject string
+	sid     string
+}
+
+type fakeConsumer struct {
+	stream, deliver string
+	pending         map[int][]byte
+}
+
+func newFakeNATS(t *testing.T) *fakeNATS {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &fakeNATS{
+		listener:  listener,
+		streams:   make(map[string][]string),
+		configs:   make(map[string]map[string]interface{}),
+		consumers: make(map[string]*fakeConsumer),
+		refused:   make(map[string]bool),
+		clients:   make(map[*conn]string),
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			c, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go p.serve(&conn{c: c, r: bufio.NewReader(c), w: bufio.NewWriter(c)})
+		}
+	}()
+	return p
+}
+
+func (p *fakeNATS) addr() string {
+	return p.listener.Addr().String()
+}
+
+func matchSubject(pattern, subject string) bool {
+	a, b := strings.Split(pattern, "."), strings.Split(subject, ".")
+	for i, it := range a {
+		if it == ">" {
+			return len(b) > i
+		}
+		if i >= len(b) || it != "*" && it != b[i] {
+			return false
+		}
+	}
+	return len(a) == len(b)
+}
+
+func (p *fakeNATS) serve(c *conn) {
+	defer p.drop(c)
+	c.write("INFO {\"server_id\":\"fake\",\"jetstream\":true}\r\n")
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return
+		}
+		args := strings.Fields(line)
+		if len(args) < 1 {
+			continue
+		}
+		switch args[0] {
+		case "CONNECT":
+			var options connectOptions
+			json.Unmarshal([]byte(line[len("CONNECT "):]), &options)
+			p.lock.Lock()
+			refused := p.refused[options.Name]
+			p.clients[c] = options.Name
+			p.lock.Unlock()
+			if refused {
+				c.write("-ERR 'Authorization Violation'\r\n")
+				return
+			}
+		case "PING":
+			c.write("PONG\r\n")
+		case "SUB":
+			p.lock.Lock()
+			p.subs = append(p.subs, &fakeSub{client: c, subject: args[1], sid: args[len(args)-1]})
+			for name, it := range p.consumers {
+				if it.deliver == args[1] {
+					p.redeliver(name, it)
+				}
+			}
+			p.lock.Unlock()
+		case "PUB":
+			size, _ := strconv.Atoi(args[len(args)-1])
+			data := make([]byte, size+2)
+			if _, err := io.ReadFull(c.r, data); err != nil {
+				return
+			}
+			reply := ""
+			if len(args) == 4 {
+				reply = args[2]
+			}
+			p.lock.Lock()
+			p.publish(args[1], reply, data[:size])
+			p.lock.Unlock()
+		}
+	}
+}
+
+// publish handles a PUB while lock is held.
+func (p *fakeNATS) publish(subject, reply string, data []byte) {
+	switch {
+	case strings.HasPrefix(subject, "$JS.API.STREAM.CREATE."):
+		name := strings.TrimPrefix(subject, "$JS.API.STREAM.CREATE.")
+		if _, ok := p.streams[name]; ok {
+			p.route(reply, "", []byte(`{"error":{"code":400,"err_code":10058,"description":"stream name already in use"}}`))
+			return
+		}
+		var config struct {
+			Subjects []string `json:"subjects"`
+		}
+		json.Unmarshal(data, &config)
+		p.streams[name] = config.Subjects
+		raw := make(map[string]interface{})
+		json.Unmarshal(data, &raw)
+		p.configs[name] = raw
+		p.route(reply, "", []byte(`{"type":"io.nats.jetstream.api.v1.stream_create_response"}`))
+	case strings.HasPrefix(subject, "$JS.API.CONSUMER.CREATE."):
+		parts := strings.Split(strings.TrimPrefix(subject, "$JS.API.CONSUMER.CREATE."), ".")
+		var request struct {
+			Config struct {
+				Deliver string `json:"deliver_subject"`
+			} `json:"config"`
+		}
+		json.Unmarshal(data, &request)
+		if _, ok := p.consumers[parts[1]]; !ok {
+			p.consumers[parts[1]] = &fakeConsumer{stream: parts[0], deliver: request.Config.Deliver, pending: make(map[int][]byte)}
+		}
+		p.route(reply, "", []byte(`{"type":"io.nats.jetstream.api.v1.consumer_create_response"}`))
+	case strings.HasPrefix(subject, "$JS.ACK."):
+		parts := strings.Split(strings.TrimPrefix(subject, "$JS.ACK."), ".")
+		seq, _ := strconv.Atoi(parts[1])
+		if it, ok := p.consumers[parts[0]]; ok {
+			delete(it.pending, seq)
+		}
+	default:
+		for stream, subjects := range p.streams {
+			for _, it := range subjects {
+				if !matchSubject(it, subject) {
+					continue
+				}
+				p.seq++
+				for name, consumer := range p.consumers {
+					if consumer.stream == stream {
+						consumer.pending[p.seq] = data
+						p.route(consumer.deliver, fmt.Sprintf("$JS.ACK.%s.%d", name, p.seq), data)
+					}
+				}
+				p.route(reply, "", []byte(fmt.Sprintf(`{"stream":%q,"seq":%d}`, stream, p.seq)))
+				return
+			}
+		}
+		p.route(subject, reply, data)
+	}
+}
+
+// redeliver sends the pending deliveries of consumer in order while lock is held.
+func (p *fakeNATS) redeliver(name string, consumer *fakeConsumer) {
+	seqs := make([]int, 0, len(consumer.pending))
+	for it := range consumer.pending {
+		seqs = append(seqs, it)
+	}
+	sort.Ints(seqs)
+	for _, it := range seqs {
+		p.route(consumer.deliver, fmt.Sprintf("$JS.ACK.%s.%d", name, it), consumer.pending[it])
+	}
+}
+
+// route sends a MSG to the subscriptions of subject while lock is held.
+func (p *fakeNATS) route(subject, reply string, data []byte) {
+	if len(subject) < 1 {
+		return
+	}
+	for _, it := range p.subs {
+		if !matchSubject(it.subject, subject) {
+			continue
+		}
+		if len(reply) > 0 {
+			it.client.write("MSG %s %s %s %d\r\n%s\r\n", subject, it.sid, reply, len(data), data)
+		} else {
+			it.client.write("MSG %s %s %d\r\n%s\r\n", subject, it.sid, len(data), data)
+		}
+	}
+}
+
+func (p *fakeNATS) drop(c *conn) {
+	c.close()
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	delete(p.clients, c)
+	subs := p.subs[:0]
+	for _, it := range p.subs {
+		if it.client != c {
+			subs = append(subs, it)
+		}
+	}
+	p.subs = subs
+}
+
+// kill closes the connections of client name and refuses new ones if refuse is true.
+func (p *fakeNATS) kill(name string, refuse bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.refused[name] = refuse
+	for c, it := range p.clients {
+		if it == name {
+			c.close()
+		}
+	}
+}
+
+// subscribed returns true if the deliver subject of every consumer of names is subscribed.
+func (p *fakeNATS) subscribed(names ...string) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for _, name := range names {
+		consumer, ok := p.consumers[name]
+		if !ok {
+			return false
+		}
+		found := false
+		for _, it := range p.subs {
+			found = found || it.subject == consumer.deliver
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func waitFor(t *testing.T, what string, fn func() bool) {
+	for deadline := time.Now().Add(5 * time.Second); !fn(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal(what)
+		}
+	}
+}
+
+func TestAdapter(t *testing.T) {
+	server := newFakeNATS(t)
+	sockets := make(chan eio.Socket, 2)
+	var engines []eio.Engine
+	var adapters []*Adapter
+	for _, name := range []string{"a", "b"} {
+		adapter := NewAdapter(Options{Addr: server.addr(), Name: name, Durable: name, ReconnectWait: 20 * time.Millisecond})
+		eng := eio.NewEngineBuilder().SetAdapter(adapter).SetPresence("", 0).Build()
+		defer eng.Close()
+		eng.OnConnect(func(socket eio.Socket) { sockets <- socket })
+		engines, adapters = append(engines, eng), append(adapters, adapter)
+	}
+	waitFor(t, "consumers should subscribe", func() bool { return server.subscribed("a", "b") })
+	server.lock.Lock()
+	config := server.configs[defaultStream]
+	server.lock.Unlock()
+	if config["retention"] != "interest" || config["max_age"] != float64(defaultMaxAge) ||
+		config["max_msgs"] != float64(defaultMaxMsgs) || config["max_bytes"] != float64(defaultMaxBytes) {
+		t.Errorf("stream should be bounded: %v", config)
+	}
+
+	client, err := engines[1].Loopback()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	client.Receive()
+	socket := <-sockets
+	socket.Join("news")
+
+	engines[0].Broadcast([]byte("all"), false, nil)
+	if pack, err := client.Receive(); err != nil || string(pack.Data) != "all" {
+		t.Errorf("bad broadcast: %v %v", pack, err)
+	}
+	engines[0].To("sports").Send([]byte("sports"), false)
+	engines[0].To("news").Send([]byte("news"), false)
+	if pack, err := client.Receive(); err != nil || string(pack.Data) != "news" {
+		t.Errorf("only the message of news should be sent: %v %v", pack, err)
+	}
+	if members, err := adapters[0].Members("news"); err != nil || len(members) != 1 || members[0] != socket.ID() {
+		t.Errorf("bad members: %v %v", members, err)
+	}
+
+	if d, err := engines[0].SendTo(socket.ID(), []byte("direct"), false); err != nil || d != eio.DeliveryForwarded {
+		t.Errorf("should be forwarded: %s %v", d, err)
+	}
+	if pack, err := client.Receive(); err != nil || string(pack.Data) != "direct" {
+		t.Errorf("bad direct message: %v %v", pack, err)
+	}
+	if users, err := engines[0].Presence().Cluster(); err != nil || len(users) != 1 || users[0].User != socket.ID() || users[0].Sockets != 1 {
+		t.Errorf("socket of b should be online: %v %v", users, err)
+	}
+	if d, err := engines[0].SendTo("nope", []byte("direct"), false); err != nil || d != eio.DeliveryUnknown {
+		t.Errorf("sid should be unknown: %s %v", d, err)
+	}
+
+	// a broadcast published while b is away is delivered once it's back.
+	server.kill("b", true)
+	waitFor(t, "b should be gone", func() bool { return !server.subscribed("b") })
+	if err := adapters[0].Publish(&eio.ClusterMessage{Node: "a", Data: []byte("missed")}); err != nil {
+		t.Fatal(err)
+	}
+	server.kill("b", false)
+	if pack, err := client.Receive(); err != nil || string(pack.Data) != "missed" {
+		t.Errorf("should be redelivered: %v %v", pack, err)
+	}
+	waitFor(t, "delivery should be acknowledged", func() bool {
+		server.lock.Lock()
+		defer server.lock.Unlock()
+		return len(server.consumers["b"].pending) == 0
+	})
+
+	socket.Close()
+	waitFor(t, "closed socket should leave", func() bool { return len(adapters[1].localMembers("news")) == 0 })
+}
@@ -0,0 +1,164 @@
+package nats
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var errBadProtocol = errors.New("nats: bad protocol line")
+
+// msg is a message of a subscription.
+type msg struct {
+	subject string
+	sid     int
+	reply   string
+	data    []byte
+}
+
+// conn is a connection speaking the client protocol of NATS, writes are safe for concurrent use
+// but only one goroutine should read by next.
+type conn struct {
+	c    net.Conn
+	r    *bufio.Reader
+	lock sync.Mutex
+	w    *bufio.Writer
+}
+
+// connectOptions is the CONNECT message of client.
+type connectOptions struct {
+	Verbose  bool   `json:"verbose"`
+	Pedantic bool   `json:"pedantic"`
+	Name     string `json:"name,omitempty"`
+	User     string `json:"user,omitempty"`
+	Pass     string `json:"pass,omitempty"`
+	Token    string `json:"auth_token,omitempty"`
+	Lang     string `json:"lang"`
+}
+
+// dial connects to the server of options, it returns once the server answers the first PING.
+func dial(options *Options) (*conn, error) {
+	c, err := net.DialTimeout("tcp", options.Addr, options.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	ret := &conn{c: c, r: bufio.NewReader(c), w: bufio.NewWriter(c)}
+	c.SetDeadline(time.Now().Add(options.Timeout))
+	defer c.SetDeadline(time.Time{})
+	line, err := ret.readLine()
+	if err != nil || !strings.HasPrefix(line, "INFO ") {
+		c.Close()
+		return nil, errBadProtocol
+	}
+	connect, _ := json.Marshal(connectOptions{Name: options.Name, User: options.User, Pass: options.Password, Token: options.Token, Lang: "go"})
+	ret.lock.Lock()
+	fmt.Fprintf(ret.w, "CONNECT %s\r\nPING\r\n", connect)
+	err = ret.w.Flush()
+	ret.lock.Unlock()
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	for {
+		line, err := ret.readLine()
+		switch {
+		case err != nil:
+			c.Close()
+			return nil, err
+		case strings.HasPrefix(line, "-ERR"):
+			c.Close()
+			return nil, fmt.Errorf("nats: %s", strings.TrimSpace(line[4:]))
+		case line == "PONG":
+			return ret, nil
+		}
+	}
+}
+
+func (p *conn) readLine() (string, error) {
+	line, err := p.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func (p *conn) write(format string, args ...interface{}) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	fmt.Fprintf(p.w, format, args...)
+	return p.w.Flush()
+}
+
+func (p *conn) sub(subject string, sid int) error {
+	return p.write("SUB %s %d\r\n", subject, sid)
+}
+
+// pub publishes data to subject, the replies are sent to reply if it's not empty.
+func (p *conn) pub(subject, reply string, data []byte) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if len(reply) > 0 {
+		fmt.Fprintf(p.w, "PUB %s %s %d\r\n", subject, reply, len(data))
+	} else {
+		fmt.Fprintf(p.w, "PUB %s %d\r\n", subject, len(data))
+	}
+	p.w.Write(data)
+	p.w.WriteString("\r\n")
+	return p.w.Flush()
+}
+
+// next returns the next message, PINGs of server are answered on the way. An -ERR of server is returned as error.
+func (p *conn) next() (*msg, error) {
+	for {
+		line, err := p.readLine()
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case line == "PING":
+			if err := p.write("PONG\r\n"); err != nil {
+				return nil, err
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			return nil, fmt.Errorf("nats: %s", strings.TrimSpace(line[4:]))
+		case strings.HasPrefix(line, "MSG "):
+			return p.readMsg(strings.Fields(line[4:]))
+		}
+	}
+}
+
+// readMsg reads the payload of a MSG whose arguments are subject, sid, the optional reply and size.
+func (p *conn) readMsg(args []string) (*msg, error) {
+	if len(args) != 3 && len(args) != 4 {
+		return nil, errBadProtocol
+	}
+	sid, err := strconv.Atoi(args[1])
+	if err != nil {
+		return nil, errBadProtocol
+	}
+	size, err := strconv.Atoi(args[len(args)-1])
+	if err != nil || size < 0 {
+		return nil, errBadProtocol
+	}
+	ret := &msg{subject: args[0], sid: sid}
+	if len(args) == 4 {
+		ret.reply = args[2]
+	}
+	data := make([]byte, size+2)
+	if _, err := io.ReadFull(p.r, data); err != nil {
+		return nil, err
+	}
+	ret.data = data[:size]
+	return ret, nil
+}
+
+func (p *conn) close() error {
+	return p.c.Close()
+}
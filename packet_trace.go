@@ -0,0 +1,114 @@
+package eio
+
+import (
+	"time"
+
+	"github.com/jjeffcaii/engine.io/parser"
+)
+
+// PacketDirection tells the packets received from those sent, see PacketTrace.
+type PacketDirection uint8
+
+const (
+	// PacketIn is a packet received from the client.
+	PacketIn PacketDirection = iota
+	// PacketOut is a packet sent to the client.
+	PacketOut
+)
+
+func (d PacketDirection) String() string {
+	if d == PacketIn {
+		return "in"
+	}
+	return "out"
+}
+
+// PacketTrace is a packet received or sent by a transport, see PacketTracer.
+type PacketTrace struct {
+	Time      time.Time
+	Direction PacketDirection
+	Sid       string
+	Transport TransportType
+	Type      parser.PacketType
+	// Data is the packet encoded alone, string packets as text and binary ones in the binary form of v3,
+	// regardless of the framing of transport. It holds the first bytes up to the limit of EngineBuilder.SetPacketTracer.
+	// Streamed bodies are never read, so Data of such a packet holds its type only.
+	Data []byte
+	// Size is the length of the whole encoded packet, Data is truncated if it's larger than len(Data).
+	// It's -1 if the packet has a streamed body of unknown length.
+	Size int
+}
+
+// PacketTracer is called with every packet received or sent by the transports of an engine, such as handshakes,
+// heartbeats and messages, see EngineBuilder.SetPacketTracer. A sent packet is traced once its transport takes it,
+// packets held while the transport is paused are traced when they're resumed. It's called by the goroutines of
+// transports and sockets, so it must be fast and safe for concurrent use. trace can be kept.
+type PacketTracer func(trace *PacketTrace)
+
+var (
+	traceStringCodec = parser.NewStringCodec(parser.CodecOptions{})
+	traceBinaryCodec = parser.NewBinaryCodec(parser.CodecOptions{})
+)
+
+// tracePacket calls the packet tracer of engine with packet of transport t.
+func (p *engineImpl) tracePacket(direction PacketDirection, t Transport, packet *parser.Packet) {
+	trace := &PacketTrace{Time: time.Now(), Direction: direction, Transport: t.GetType(), Type: packet.Type}
+	if socket, _ := t.GetSocket().(*socketImpl); socket != nil {
+		trace.Sid = socket.id
+	}
+	codec := traceStringCodec
+	if packet.Option&parser.BINARY == parser.BINARY {
+		codec = traceBinaryCodec
+	}
+	data := packet.Data
+	if packet.Body != nil {
+		data, trace.Size = nil, -1
+		if packet.BodyLen >= 0 {
+			trace.Size = codec.EncodedLen(packet)
+		}
+	} else {
+		trace.Size = codec.EncodedLen(packet)
+	}
+	if len(data) > p.packetTraceLimit {
+		data = data[:p.packetTraceLimit]
+	}
+	// the encoded type prefixes the data, so truncated data is encoded still.
+	if encoded, err := codec.Encode(parser.NewPacketCustom(packet.Type, data, packet.Option)); err == nil {
+		if len(encoded) > p.packetTraceLimit {
+			encoded = encoded[:p.packetTraceLimit]
+		}
+		trace.Data = encoded
+	}
+	p.packetTracer(trace)
+}
+
+// received counts, traces and captures packet received by transport t.
+func (p *engineImpl) received(t Transport, packet *parser.Packet) {
+	p.stats.received(t.GetType(), packet)
+	p.observe(PacketIn, t, packet)
+}
+
+// counting returns send which counts, traces and captures the packets sent by transport t.
+func (p *engineImpl) counting(t Transport, send func(packet *parser.Packet) error) func(packet *parser.Packet) error {
+	send = p.stats.counting(t.GetType(), send)
+	if p.packetTracer == nil && p.capture == nil {
+		return send
+	}
+	return func(packet *parser.Packet) error {
+		if err := send(packet); err != nil {
+			return err
+		}
+		p.observe(PacketOut, t, packet)
+		return nil
+	}
+}
+
+// observe passes packet of transport t to the packet tracer and the capture of engine.
+func (p *engineImpl) observe(direction PacketDirection, t Transport, packet *parser.Packet) {
+	if p.packetTracer != nil {
+		p.tracePacket(direction, t, packet)
+	}
+	if p.capture != nil {
+		p.capturePacket(direction, t, packet)
+	}
+}
@@ -0,0 +1,52 @@
+package eio
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/jjeffcaii/engine.io/parser"
+)
+
+func TestPacketTracer(t *testing.T) {
+	traces := make(chan *PacketTrace, 16)
+	eng := NewEngineBuilder().SetPacketTracer(func(trace *PacketTrace) { traces <- trace }, 4).Build()
+	defer eng.Close()
+	sockets := make(chan Socket, 1)
+	messages := make(chan string, 1)
+	eng.OnConnect(func(socket Socket) {
+		socket.OnMessage(func(data []byte) { messages <- string(data) })
+		sockets <- socket
+	})
+	client, err := eng.Loopback()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	client.Receive()
+	socket := <-sockets
+	next := func() *PacketTrace {
+		select {
+		case it := <-traces:
+			return it
+		case <-time.After(5 * time.Second):
+			t.Fatal("no packet traced")
+			return nil
+		}
+	}
+	if it := next(); it.Direction != PacketOut || it.Type != parser.OPEN || it.Sid != socket.ID() || it.Transport != LOOPBACK ||
+		len(it.Data) != 4 || it.Size <= 4 {
+		t.Errorf("bad trace of handshake: %+v", it)
+	}
+
+	client.Send(parser.NewPacketCustom(parser.MESSAGE, []byte("hello world"), 0))
+	<-messages
+	if it := next(); it.Direction != PacketIn || it.Type != parser.MESSAGE || string(it.Data) != "4hel" || it.Size != 12 {
+		t.Errorf("bad trace of message received: %+v %q", it, it.Data)
+	}
+	socket.Send([]byte{1, 2})
+	client.Receive()
+	if it := next(); it.Direction != PacketOut || !bytes.Equal(it.Data, []byte{4, 1, 2}) || it.Size != 3 {
+		t.Errorf("bad trace of binary message sent: %+v", it)
+	}
+}
@@ -0,0 +1,57 @@
+package parser
+
+// Arena allocates decoded packets and their bodies from reusable blocks, it's used by codecs
+// whose CodecOptions.Arena is set. It lowers the GC pressure of decoding payloads of many small packets:
+// a batch of packets is decoded into the arena and Reset is called when all of them are handled.
+//
+// Packets of an arena must not be used after Reset, Clone them to retain copies, and must not be released
+// to the packet pool. An arena is not safe for concurrent use, give each decoding goroutine its own.
+type Arena struct {
+	packets []Packet
+	used    int
+	block   []byte
+}
+
+// NewArena returns an arena whose first blocks hold packets packets and size bytes of bodies,
+// blocks grow when a batch needs more.
+func NewArena(packets, size int) *Arena {
+	return &Arena{
+		packets: make([]Packet, packets),
+		block:   make([]byte, 0, size),
+	}
+}
+
+// Reset makes the blocks reusable for the next batch, it keeps the largest blocks allocated so far.
+func (p *Arena) Reset() {
+	for i := 0; i < p.used; i++ {
+		p.packets[i] = Packet{}
+	}
+	p.used = 0
+	p.block = p.block[:0]
+}
+
+// packet returns a zeroed packet of arena.
+func (p *Arena) packet() *Packet {
+	if p.used == len(p.packets) {
+		// packets of the old block are still in use, they are left to GC.
+		p.packets = make([]Packet, 2*len(p.packets)+8)
+		p.used = 0
+	}
+	p.used++
+	return &p.packets[p.used-1]
+}
+
+// bytes returns n bytes of arena, the capacity is limited to n so appending never overwrites others.
+func (p *Arena) bytes(n int) []byte {
+	l := len(p.block)
+	if cap(p.block)-l < n {
+		size := 2 * cap(p.block)
+		if size < n {
+			size = n
+		}
+		p.block = make([]byte, 0, size)
+		l = 0
+	}
+	p.block = p.block[:l+n]
+	return p.block[l : l+n : l+n]
+}
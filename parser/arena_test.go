@@ -0,0 +1,50 @@
+package parser
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestArena_Decode(t *testing.T) {
+	arena := NewArena(2, 8)
+	codec := NewPayloadCodecV3(false, CodecOptions{Arena: arena})
+	input := []byte("6:4hello6:4world1:210:b4AQIDBA==")
+	for round := 0; round < 3; round++ {
+		packets, err := codec.Decode(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(packets) != 4 || string(packets[0].Data) != "hello" || string(packets[1].Data) != "world" ||
+			packets[2].Type != PING || !bytes.Equal(packets[3].Data, []byte{1, 2, 3, 4}) {
+			t.Fatalf("bad packets: %s", DumpPayload(packets...))
+		}
+		arena.Reset()
+	}
+	if len(arena.packets) < 4 || cap(arena.block) < 10 {
+		t.Errorf("arena should keep the grown blocks: %d packets, %d bytes", len(arena.packets), cap(arena.block))
+	}
+}
+
+func TestArena_Bytes(t *testing.T) {
+	arena := NewArena(0, 16)
+	a := arena.bytes(4)
+	b := arena.bytes(4)
+	copy(b, "bbbb")
+	_ = append(a, 'x')
+	if string(b) != "bbbb" {
+		t.Error("appending to arena bytes should not overwrite others")
+	}
+}
+
+func BenchmarkArena_Decode(b *testing.B) {
+	arena := NewArena(64, 4096)
+	codec := NewPayloadCodecV3(false, CodecOptions{Arena: arena})
+	input := bytes.Repeat([]byte("6:4hello"), 64)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := codec.DecodeFunc(input, func(*Packet) error { return nil }); err != nil {
+			b.Fatal(err)
+		}
+		arena.Reset()
+	}
+}
@@ -0,0 +1,199 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+)
+
+// StreamDecoder is implemented by codecs which can decode a packet from a stream,
+// the body is decoded while reading so the encoded text is never held in memory.
+type StreamDecoder interface {
+	// DecodeFrom decode a packet by reading reader until EOF.
+	DecodeFrom(reader io.Reader) (*Packet, error)
+}
+
+// DecodeFrom decode a base64 packet by reading reader until EOF.
+func (p *b64Codec) DecodeFrom(reader io.Reader) (*Packet, error) {
+	head := make([]byte, 2)
+	n, err := io.ReadFull(reader, head)
+	switch {
+	case n == 0 && err == io.EOF:
+		return nil, errEmptyPacket(0)
+	case n == 1 && err == io.ErrUnexpectedEOF:
+		if p.options.Strict {
+			return nil, newDecodeError(ErrInvalidFormat, 0, "'b' and packet type", fmt.Sprintf("%q", head[:1]))
+		}
+		t, err := convertCharToType(head[0])
+		if err != nil {
+			return nil, err
+		}
+		return p.options.newPacket(t, head[1:1], BINARY)
+	case err != nil:
+		return nil, err
+	case head[0] != 'b':
+		return nil, newDecodeError(ErrInvalidFormat, 0, "'b'", string(head[:1]))
+	}
+	t, err := convertCharToType(head[1])
+	if err != nil {
+		return nil, withOffset(err, 1)
+	}
+	body, err := p.options.readBase64(reader, 2)
+	if err != nil {
+		return nil, err
+	}
+	return p.options.ownedPacket(t, body, BINARY)
+}
+
+// DecodeFrom decode a base64 packet of protocol v4 by reading reader until EOF.
+func (p *b64CodecV4) DecodeFrom(reader io.Reader) (*Packet, error) {
+	head := make([]byte, 1)
+	if _, err := io.ReadFull(reader, head); err == io.EOF {
+		return nil, errEmptyPacket(0)
+	} else if err != nil {
+		return nil, err
+	}
+	if head[0] != 'b' {
+		return nil, newDecodeError(ErrInvalidFormat, 0, "'b'", string(head))
+	}
+	body, err := p.options.readBase64(reader, 1)
+	if err != nil {
+		return nil, err
+	}
+	return p.options.ownedPacket(MESSAGE, body, BINARY)
+}
+
+// readBase64 decode base64 text from reader until EOF, the text is limited by the max packet size.
+func (p CodecOptions) readBase64(reader io.Reader, offset int) ([]byte, error) {
+	if p.MaxPacketSize < 1 {
+		return readBase64(reader, -1, offset, p)
+	}
+	limited := &io.LimitedReader{R: reader, N: int64(p.MaxPacketSize - offset + 1)}
+	body, err := readBase64(limited, -1, offset, p)
+	if limited.N < 1 {
+		return nil, p.checkSize(p.MaxPacketSize+1, 0)
+	}
+	return body, err
+}
+
+// writeBase64 encode src as base64 and write to writer in chunks.
+func writeBase64(writer io.Writer, src []byte, encoding *base64.Encoding) error {
+	scratch := acquireScratch()
+	defer releaseScratch(scratch)
+	buf := (*scratch)[:cap(*scratch)]
+	chunk := len(buf) / 4 * 3
+	for len(src) > 0 {
+		n := chunk
+		if n > len(src) {
+			n = len(src)
+		}
+		m := encoding.EncodedLen(n)
+		encoding.Encode(buf[:m], src[:n])
+		if _, err := writer.Write(buf[:m]); err != nil {
+			return err
+		}
+		src = src[n:]
+	}
+	return nil
+}
+
+// writeBase64Body encode the data of packet as base64 and write to writer, Body is encoded while reading.
+func writeBase64Body(writer io.Writer, packet *Packet, encoding *base64.Encoding) error {
+	if packet.Body == nil {
+		return writeBase64(writer, packet.Data, encoding)
+	}
+	encoder := base64.NewEncoder(encoding, writer)
+	if err := writeBody(encoder, packet); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+// readBase64 decode base64 text from reader.
+// Size is the length of encoded text, or negative if reading until EOF.
+// Offset is the position of the text in input, used for reporting errors.
+// URL-safe text is translated to the standard alphabet while reading if options accept it,
+// line breaks and non-canonical padding bits are rejected in strict mode.
+func readBase64(reader io.Reader, size int, offset int, options CodecOptions) ([]byte, error) {
+	bf := new(bytes.Buffer)
+	var limited *io.LimitedReader
+	if size >= 0 {
+		bf.Grow(base64.StdEncoding.DecodedLen(size))
+		limited = &io.LimitedReader{R: reader, N: int64(size)}
+		reader = limited
+	}
+	encoding := base64.StdEncoding
+	if options.Strict {
+		reader = &lineBreakReader{reader: reader}
+		encoding = encoding.Strict()
+	}
+	if options.acceptURLSafe() {
+		reader = &urlSafeReader{reader: reader}
+	}
+	if _, err := bf.ReadFrom(base64.NewDecoder(encoding, reader)); err != nil {
+		if _, ok := err.(base64.CorruptInputError); ok {
+			return nil, errBase64(err, offset)
+		}
+		return nil, err
+	}
+	if limited != nil && limited.N > 0 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return bf.Bytes(), nil
+}
+
+// lineBreakReader fails at line breaks, which are skipped by base64 decoders otherwise.
+type lineBreakReader struct {
+	reader io.Reader
+	count  int
+}
+
+func (p *lineBreakReader) Read(b []byte) (int, error) {
+	n, err := p.reader.Read(b)
+	if i := bytes.IndexAny(b[:n], "\r\n"); i >= 0 {
+		return i, base64.CorruptInputError(p.count + i)
+	}
+	p.count += n
+	return n, err
+}
+
+// urlSafeReader translates base64 text of URL-safe alphabet into the standard one,
+// and completes the padding of unpadded text at EOF.
+type urlSafeReader struct {
+	reader  io.Reader
+	count   int
+	padding int
+	eof     bool
+}
+
+func (p *urlSafeReader) Read(b []byte) (int, error) {
+	if p.eof {
+		n := copy(b, "==="[:p.padding])
+		p.padding -= n
+		if p.padding > 0 {
+			return n, nil
+		}
+		return n, io.EOF
+	}
+	n, err := p.reader.Read(b)
+	for i := 0; i < n; i++ {
+		switch b[i] {
+		case '-':
+			b[i] = '+'
+		case '_':
+			b[i] = '/'
+		}
+	}
+	p.count += n
+	if err == io.EOF {
+		p.eof = true
+		if rest := p.count % 4; rest > 1 {
+			p.padding = 4 - rest
+		}
+		if p.padding > 0 {
+			return n, nil
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,69 @@
+package parser
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestStreamBase64(t *testing.T) {
+	body := bytes.Repeat([]byte("binary fuck 2!"), 100000)
+	bf := new(bytes.Buffer)
+	if err := WritePayloadTo(bf, false, NewPacket(MESSAGE, body), NewPacket(MESSAGE, "tail")); err != nil {
+		t.Fatal(err)
+	}
+	exp, _ := EncodePayload(NewPacket(MESSAGE, body), NewPacket(MESSAGE, "tail"))
+	if !bytes.Equal(bf.Bytes(), exp) {
+		t.Fatal("streaming encode should equal to encode")
+	}
+	decoder := NewDecoder(iotest.HalfReader(bf))
+	packet, err := decoder.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if packet.Option&BINARY != BINARY || !bytes.Equal(packet.Data, body) {
+		t.Error("illegal binary packet")
+	}
+	if packet, err = decoder.Decode(); err != nil || string(packet.Data) != "tail" {
+		t.Error("illegal tail packet:", err)
+	}
+	if _, err := decoder.Decode(); err != io.EOF {
+		t.Error("should be EOF")
+	}
+}
+
+func TestStreamBase64Errors(t *testing.T) {
+	if _, err := NewDecoder(strings.NewReader("10:b4AAAA")).Decode(); err != io.ErrUnexpectedEOF {
+		t.Error("should be unexpected EOF:", err)
+	}
+	if _, err := NewDecoder(strings.NewReader("6:b4AA!A")).Decode(); !errors.Is(err, ErrInvalidBase64) {
+		t.Error("should be illegal base64:", err)
+	}
+}
+
+func TestDecodeFrom(t *testing.T) {
+	for _, it := range []struct {
+		codec Codec
+		input string
+	}{
+		{base64Encoder, "b4AQID"},
+		{NewPayloadCodecV4(CodecOptions{}).(*payloadV4).b64, "bAQID"},
+	} {
+		packet, err := it.codec.(StreamDecoder).DecodeFrom(strings.NewReader(it.input))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if packet.Type != MESSAGE || !bytes.Equal(packet.Data, []byte{1, 2, 3}) {
+			t.Error("illegal result")
+		}
+	}
+	if packet, err := base64Encoder.(StreamDecoder).DecodeFrom(strings.NewReader("2")); err != nil || packet.Type != PING {
+		t.Error("illegal result:", err)
+	}
+	if _, err := base64Encoder.(StreamDecoder).DecodeFrom(strings.NewReader("")); !errors.Is(err, ErrEmptyPacket) {
+		t.Error("should be empty packet:", err)
+	}
+}
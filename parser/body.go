@@ -0,0 +1,86 @@
+package parser
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// NewPacketFromReader create a binary packet whose data is streamed from body, size is the length of body
+// or negative if it's unknown. The body is closed after it's read if it's an io.Closer.
+func NewPacketFromReader(ptype PacketType, body io.Reader, size int64) *Packet {
+	return &Packet{
+		Type:    ptype,
+		Option:  BINARY,
+		Body:    body,
+		BodyLen: size,
+	}
+}
+
+// ReadBody reads Body of packet into Data, it does nothing if Body is nil.
+// It's called by codecs which can not stream the body.
+func (p *Packet) ReadBody() error {
+	if p.Body == nil {
+		return nil
+	}
+	body := p.Body
+	p.Body = nil
+	defer closeBody(body)
+	bf := new(bytes.Buffer)
+	if p.BodyLen > 0 {
+		bf.Grow(int(p.BodyLen))
+	}
+	n, err := bf.ReadFrom(body)
+	if err != nil {
+		return err
+	}
+	if err := p.checkBodyLen(n); err != nil {
+		return err
+	}
+	p.Data = bf.Bytes()
+	return nil
+}
+
+func (p *Packet) checkBodyLen(n int64) error {
+	if p.BodyLen >= 0 && n != p.BodyLen {
+		return fmt.Errorf("parser: body of packet is %d bytes, expected %d", n, p.BodyLen)
+	}
+	return nil
+}
+
+// dataLen returns the length of packet data, a body of unknown length is read into Data.
+func dataLen(packet *Packet) int {
+	if packet.Body == nil {
+		return len(packet.Data)
+	}
+	if packet.BodyLen >= 0 {
+		return int(packet.BodyLen)
+	}
+	packet.ReadBody()
+	return len(packet.Data)
+}
+
+// writeBody writes the data of packet to writer, Body is copied without buffering the whole of it.
+func writeBody(writer io.Writer, packet *Packet) error {
+	if packet.Body == nil {
+		if len(packet.Data) < 1 {
+			return nil
+		}
+		_, err := writer.Write(packet.Data)
+		return err
+	}
+	body := packet.Body
+	packet.Body = nil
+	defer closeBody(body)
+	n, err := io.Copy(writer, body)
+	if err != nil {
+		return err
+	}
+	return packet.checkBodyLen(n)
+}
+
+func closeBody(body io.Reader) {
+	if c, ok := body.(io.Closer); ok {
+		c.Close()
+	}
+}
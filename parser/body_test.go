@@ -0,0 +1,87 @@
+package parser
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+type closeRecorder struct {
+	io.Reader
+	closed bool
+}
+
+func (p *closeRecorder) Close() error {
+	p.closed = true
+	return nil
+}
+
+func TestPacket_ReadBody(t *testing.T) {
+	body := &closeRecorder{Reader: strings.NewReader("hello")}
+	packet := NewPacketFromReader(MESSAGE, body, 5)
+	if err := packet.ReadBody(); err != nil {
+		t.Fatal(err)
+	}
+	if string(packet.Data) != "hello" || packet.Body != nil || !body.closed {
+		t.Errorf("bad body: data=%q body=%v closed=%v", packet.Data, packet.Body, body.closed)
+	}
+	packet = NewPacketFromReader(MESSAGE, strings.NewReader("hello"), 4)
+	if err := packet.ReadBody(); err == nil {
+		t.Error("body longer than BodyLen should fail")
+	}
+}
+
+func TestCodec_WriteToBody(t *testing.T) {
+	data := bytes.Repeat([]byte("abc"), 1000)
+	codecs := map[string]Codec{
+		"binary": binaryEncoder,
+		"base64": base64Encoder,
+		"string": stringEncoder,
+		"v4":     V4.PacketCodec(true),
+	}
+	for name, codec := range codecs {
+		for _, size := range []int64{int64(len(data)), -1} {
+			expect, err := codec.Encode(NewPacketCustom(MESSAGE, data, BINARY))
+			if err != nil {
+				t.Fatal(err)
+			}
+			packet := NewPacketFromReader(MESSAGE, bytes.NewReader(data), size)
+			if n := codec.EncodedLen(packet); n != len(expect) {
+				t.Errorf("%s: EncodedLen %d, expected %d", name, n, len(expect))
+			}
+			bf := new(bytes.Buffer)
+			if err := codec.WriteTo(bf, packet); err != nil {
+				t.Fatal(name, err)
+			}
+			if !bytes.Equal(bf.Bytes(), expect) {
+				t.Errorf("%s: WriteTo mismatch with size %d", name, size)
+			}
+			bs, err := codec.Encode(NewPacketFromReader(MESSAGE, bytes.NewReader(data), size))
+			if err != nil {
+				t.Fatal(name, err)
+			}
+			if !bytes.Equal(bs, expect) {
+				t.Errorf("%s: Encode mismatch with size %d", name, size)
+			}
+		}
+	}
+}
+
+func TestCodec_WriteToBodyShort(t *testing.T) {
+	packet := NewPacketFromReader(MESSAGE, strings.NewReader("abc"), 10)
+	if err := binaryEncoder.WriteTo(new(bytes.Buffer), packet); err == nil {
+		t.Error("short body should fail")
+	}
+}
+
+func TestPayload_Body(t *testing.T) {
+	packet := NewPacketFromReader(MESSAGE, strings.NewReader("hello"), -1)
+	bs, err := EncodePayload(packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(bs) != "10:b4aGVsbG8=" {
+		t.Errorf("bad payload: %s", bs)
+	}
+}
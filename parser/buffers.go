@@ -0,0 +1,158 @@
+package parser
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"unicode/utf8"
+)
+
+// VectorEncoder is implemented by codecs which can encode a packet as a list of slices,
+// bodies are referenced instead of copied so the result can be written with writev by net.Buffers.
+// The packet data must not be modified until the buffers are written.
+type VectorEncoder interface {
+	// EncodeBuffers encode a packet to buffers.
+	EncodeBuffers(packet *Packet) (net.Buffers, error)
+}
+
+// EncodeBuffers encode a packet to buffers which reference the packet data.
+func EncodeBuffers(packet *Packet) (net.Buffers, error) {
+	return packetCodecOf(packet).(VectorEncoder).EncodeBuffers(packet)
+}
+
+func (p *binCodec) EncodeBuffers(packet *Packet) (net.Buffers, error) {
+	if err := packet.ReadBody(); err != nil {
+		return nil, err
+	}
+	return appendBody(net.Buffers{{byte(packet.Type)}}, packet.Data), nil
+}
+
+func (p *strCodec) EncodeBuffers(packet *Packet) (net.Buffers, error) {
+	t, err := convertTypeToChar(packet.Type)
+	if err != nil {
+		return nil, err
+	}
+	if err = packet.ReadBody(); err != nil {
+		return nil, err
+	}
+	text, err := p.options.UTF8.encodeText(packet.Data)
+	if err != nil {
+		return nil, err
+	}
+	return appendBody(net.Buffers{{t}}, text), nil
+}
+
+func (p *b64Codec) EncodeBuffers(packet *Packet) (net.Buffers, error) {
+	// base64 text is a new buffer anyway.
+	bs, err := p.Encode(packet)
+	if err != nil {
+		return nil, err
+	}
+	return net.Buffers{bs}, nil
+}
+
+func (p *b64CodecV4) EncodeBuffers(packet *Packet) (net.Buffers, error) {
+	bs, err := p.Encode(packet)
+	if err != nil {
+		return nil, err
+	}
+	return net.Buffers{bs}, nil
+}
+
+func (p *msgpackCodec) EncodeBuffers(packet *Packet) (net.Buffers, error) {
+	if !validType(packet.Type) {
+		return nil, fmt.Errorf("%w: %d", ErrInvalidType, packet.Type)
+	}
+	if err := packet.ReadBody(); err != nil {
+		return nil, err
+	}
+	data := packet.Data
+	if packet.Option&BINARY != BINARY {
+		text, err := p.options.UTF8.encodeText(data)
+		if err != nil {
+			return nil, err
+		}
+		data = text
+	}
+	header := appendBodyHeader([]byte{mpFixArray2, byte(packet.Type)}, len(data), packet.Option&BINARY == BINARY)
+	return appendBody(net.Buffers{header}, data), nil
+}
+
+func (p *payloadV3) EncodeBuffers(packets ...*Packet) (net.Buffers, error) {
+	if len(packets) < 1 {
+		return nil, ErrEmptyPayload
+	}
+	bufs := make(net.Buffers, 0, 2*len(packets))
+	for _, it := range packets {
+		var codec Codec
+		var marker byte
+		if !p.binary && it.Option&BINARY == BINARY {
+			codec = p.b64
+		} else if it.Option&BINARY == BINARY {
+			codec, marker = p.bin, binaryMarkerBinary
+		} else {
+			codec, marker = p.str, binaryMarkerString
+		}
+		packet, err := codec.(VectorEncoder).EncodeBuffers(it)
+		if err != nil {
+			return nil, err
+		}
+		var size int
+		for _, b := range packet {
+			if p.binary {
+				size += len(b)
+			} else if p.options.UTF8 == UTF8JavaScript {
+				size += utf16Len(b)
+			} else {
+				size += utf8.RuneCount(b)
+			}
+		}
+		// the type byte of packet is merged into the length header.
+		digits := strconv.Itoa(size)
+		var header []byte
+		if p.binary {
+			header = make([]byte, 0, len(digits)+2+len(packet[0]))
+			header = append(header, marker)
+			for i := 0; i < len(digits); i++ {
+				header = append(header, digits[i]-'0')
+			}
+			header = append(header, binaryLengthEnd)
+		} else {
+			header = make([]byte, 0, len(digits)+1+len(packet[0]))
+			header = append(append(header, digits...), ':')
+		}
+		bufs = append(bufs, append(header, packet[0]...))
+		bufs = append(bufs, packet[1:]...)
+	}
+	return bufs, nil
+}
+
+func (p *payloadV4) EncodeBuffers(packets ...*Packet) (net.Buffers, error) {
+	if len(packets) < 1 {
+		return nil, ErrEmptyPayload
+	}
+	bufs := make(net.Buffers, 0, 2*len(packets))
+	for i, it := range packets {
+		var codec Codec = p.str
+		if it.Option&BINARY == BINARY {
+			codec = p.b64
+		}
+		packet, err := codec.(VectorEncoder).EncodeBuffers(it)
+		if err != nil {
+			return nil, err
+		}
+		if i > 0 {
+			packet[0] = append([]byte{recordSeparator}, packet[0]...)
+		}
+		bufs = append(bufs, packet...)
+	}
+	return bufs, nil
+}
+
+// appendBody appends body to bufs unless it's empty.
+func appendBody(bufs net.Buffers, body []byte) net.Buffers {
+	if len(body) < 1 {
+		return bufs
+	}
+	return append(bufs, body)
+}
@@ -0,0 +1,65 @@
+package parser
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEncodeBuffers(t *testing.T) {
+	body := bytes.Repeat([]byte{0x01, 0x02}, 512)
+	packets := []*Packet{
+		NewPacket(MESSAGE, "你好"),
+		NewPacket(MESSAGE, body),
+		NewPacketCustom(PING, nil, 0),
+		NewPacketCustom(MESSAGE, []byte("abc"), BINARY|BASE64),
+	}
+	for _, it := range packets {
+		bufs, err := EncodeBuffers(it)
+		if err != nil {
+			t.Fatal(err)
+		}
+		exp, _ := Encode(it)
+		if got := bytes.Join(bufs, nil); !bytes.Equal(got, exp) {
+			t.Errorf("should be %q, got %q", exp, got)
+		}
+	}
+	bufs, _ := EncodeBuffers(packets[1])
+	if &bufs[len(bufs)-1][0] != &body[0] {
+		t.Error("binary body should not be copied")
+	}
+	for _, codec := range []PayloadCodec{ProtocolV3, ProtocolV3Binary, ProtocolV4} {
+		v4 := codec == ProtocolV4
+		input := packets
+		if v4 {
+			input = packets[:3]
+		}
+		exp, err := codec.Encode(input...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bufs, err := codec.EncodeBuffers(input...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := bytes.Join(bufs, nil); !bytes.Equal(got, exp) {
+			t.Errorf("should be %q, got %q", exp, got)
+		}
+	}
+	if _, err := ProtocolV4.EncodeBuffers(); err != ErrEmptyPayload {
+		t.Error("should be empty payload error:", err)
+	}
+}
+
+func TestMsgpackEncodeBuffers(t *testing.T) {
+	codec := NewMsgpackCodec(CodecOptions{}).(VectorEncoder)
+	for _, it := range []*Packet{NewPacket(MESSAGE, "hello"), NewPacket(MESSAGE, []byte{1, 2, 3})} {
+		bufs, err := codec.EncodeBuffers(it)
+		if err != nil {
+			t.Fatal(err)
+		}
+		exp, _ := msgpackEncoder.Encode(it)
+		if got := bytes.Join(bufs, nil); !bytes.Equal(got, exp) {
+			t.Errorf("should be % x, got % x", exp, got)
+		}
+	}
+}
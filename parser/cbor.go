@@ -0,0 +1,186 @@
+package parser
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+)
+
+// CBOR major types and simple values used by the envelope.
+const (
+	cborUint   byte = 0 << 5
+	cborBytes  byte = 2 << 5
+	cborText   byte = 3 << 5
+	cborArray2 byte = 4<<5 | 2
+	cborNull   byte = 0xf6
+)
+
+// NewCBORCodec returns a CBOR (RFC 7049) packet codec with options.
+//
+// A packet is encoded as a CBOR array of two items: the packet type as an unsigned integer
+// and the body, which is a byte string for binary packets and a text string for string packets,
+// null for an empty body is accepted. Indefinite-length items are not supported.
+func NewCBORCodec(options CodecOptions) Codec {
+	return &cborCodec{options: options}
+}
+
+type cborCodec struct {
+	options CodecOptions
+}
+
+func (p *cborCodec) Decode(data []byte) (*Packet, error) {
+	if len(data) < 1 {
+		return nil, errEmptyPacket(0)
+	}
+	if err := p.options.checkSize(len(data), 0); err != nil {
+		return nil, err
+	}
+	if data[0] != cborArray2 {
+		return nil, newDecodeError(ErrInvalidFormat, 0, "CBOR array of 2", fmt.Sprintf("0x%02X", data[0]))
+	}
+	offset := 1
+	if offset >= len(data) || data[offset]&0xe0 != cborUint {
+		return nil, newDecodeError(ErrInvalidType, offset, "CBOR unsigned integer", describeCBOR(data, offset))
+	}
+	n, width := readCBORArgument(data[offset:])
+	if width < 1 || n > 0xff || !validType(PacketType(n)) {
+		return nil, newDecodeError(ErrInvalidType, offset, "packet type", describeCBOR(data, offset))
+	}
+	t := PacketType(n)
+	offset += width
+	if offset >= len(data) {
+		return nil, newDecodeError(ErrInvalidFormat, offset, "CBOR string or null", "end of packet")
+	}
+	var opt PacketOption
+	var size uint64
+	switch major := data[offset] & 0xe0; {
+	case data[offset] == cborNull:
+		width = 1
+	case major == cborBytes || major == cborText:
+		if size, width = readCBORArgument(data[offset:]); width < 1 {
+			return nil, newDecodeError(ErrInvalidLength, offset, "definite length", describeCBOR(data, offset))
+		}
+		if major == cborBytes {
+			opt = BINARY
+		}
+	default:
+		return nil, newDecodeError(ErrInvalidFormat, offset, "CBOR string or null", describeCBOR(data, offset))
+	}
+	offset += width
+	if uint64(len(data)-offset) != size {
+		return nil, newDecodeError(ErrInvalidLength, offset, fmt.Sprintf("%d bytes of body", size), fmt.Sprintf("%d bytes", len(data)-offset))
+	}
+	body := data[offset:]
+	if opt != BINARY {
+		text, err := p.options.UTF8.decodeText(body, offset)
+		if err != nil {
+			return nil, err
+		}
+		body = text
+	}
+	return p.options.newPacket(t, body, opt)
+}
+
+func (p *cborCodec) WriteTo(writer io.Writer, packet *Packet) error {
+	bufs, err := p.EncodeBuffers(packet)
+	if err != nil {
+		return err
+	}
+	_, err = bufs.WriteTo(writer)
+	return err
+}
+
+func (p *cborCodec) Encode(packet *Packet) ([]byte, error) {
+	return p.EncodeAppend(make([]byte, 0, p.EncodedLen(packet)), packet)
+}
+
+func (p *cborCodec) EncodeAppend(dst []byte, packet *Packet) ([]byte, error) {
+	header, data, err := p.header(packet)
+	if err != nil {
+		return dst, err
+	}
+	return append(append(dst, header...), data...), nil
+}
+
+func (p *cborCodec) EncodedLen(packet *Packet) int {
+	header, data, err := p.header(packet)
+	if err != nil {
+		return 0
+	}
+	return len(header) + len(data)
+}
+
+func (p *cborCodec) EncodeBuffers(packet *Packet) (net.Buffers, error) {
+	header, data, err := p.header(packet)
+	if err != nil {
+		return nil, err
+	}
+	return appendBody(net.Buffers{header}, data), nil
+}
+
+// header returns the envelope of packet before the body, and the body to be encoded.
+func (p *cborCodec) header(packet *Packet) ([]byte, []byte, error) {
+	if !validType(packet.Type) {
+		return nil, nil, fmt.Errorf("%w: %d", ErrInvalidType, packet.Type)
+	}
+	if err := packet.ReadBody(); err != nil {
+		return nil, nil, err
+	}
+	data := packet.Data
+	major := cborBytes
+	if packet.Option&BINARY != BINARY {
+		text, err := p.options.UTF8.encodeText(data)
+		if err != nil {
+			return nil, nil, err
+		}
+		data, major = text, cborText
+	}
+	header := make([]byte, 1, 12)
+	header[0] = cborArray2
+	header = appendCBORArgument(header, cborUint, uint64(packet.Type))
+	return appendCBORArgument(header, major, uint64(len(data))), data, nil
+}
+
+func appendCBORArgument(dst []byte, major byte, n uint64) []byte {
+	switch {
+	case n < 24:
+		return append(dst, major|byte(n))
+	case n <= 0xff:
+		return append(dst, major|24, byte(n))
+	case n <= 0xffff:
+		return append(dst, major|25, byte(n>>8), byte(n))
+	case n <= 0xffffffff:
+		return append(dst, major|26, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	default:
+		b := make([]byte, 8)
+		binary.BigEndian.PutUint64(b, n)
+		return append(append(dst, major|27), b...)
+	}
+}
+
+// readCBORArgument reads the argument of the item at the head of b, width is zero if it's malformed.
+func readCBORArgument(b []byte) (n uint64, width int) {
+	info := b[0] & 0x1f
+	switch {
+	case info < 24:
+		return uint64(info), 1
+	case info == 24 && len(b) > 1:
+		return uint64(b[1]), 2
+	case info == 25 && len(b) > 2:
+		return uint64(binary.BigEndian.Uint16(b[1:])), 3
+	case info == 26 && len(b) > 4:
+		return uint64(binary.BigEndian.Uint32(b[1:])), 5
+	case info == 27 && len(b) > 8:
+		return binary.BigEndian.Uint64(b[1:]), 9
+	default:
+		return 0, 0
+	}
+}
+
+func describeCBOR(data []byte, offset int) string {
+	if offset >= len(data) {
+		return "end of packet"
+	}
+	return fmt.Sprintf("0x%02X", data[offset])
+}
@@ -0,0 +1,81 @@
+package parser
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCBORCodec(t *testing.T) {
+	codec, ok := LookupCodec(CodecCBOR)
+	if !ok {
+		t.Fatal("cbor codec should be registered")
+	}
+	packets := []*Packet{
+		NewPacketCustom(OPEN, nil, BINARY),
+		NewPacketCustom(PING, []byte("probe"), BINARY),
+		NewPacket(MESSAGE, []byte{0x00, 0x01, 0xFF}),
+		NewPacket(MESSAGE, bytes.Repeat([]byte{0xAB}, 300)),
+		NewPacket(MESSAGE, bytes.Repeat([]byte{0xCD}, 70000)),
+		NewPacketCustom(NOOP, []byte{}, BINARY),
+	}
+	// round trip has the same semantics as the binary codec.
+	for _, it := range packets {
+		bs, err := codec.Encode(it)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(bs) != codec.EncodedLen(it) {
+			t.Errorf("encoded length should be %d, got %d", codec.EncodedLen(it), len(bs))
+		}
+		decoded, err := codec.Decode(bs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw, _ := binaryEncoder.Encode(it)
+		exp, _ := binaryEncoder.Decode(raw)
+		if decoded.Type != exp.Type || decoded.Option != exp.Option || !bytes.Equal(decoded.Data, exp.Data) {
+			t.Errorf("should be %v, got %v", exp, decoded)
+		}
+		bf := new(bytes.Buffer)
+		if err := codec.WriteTo(bf, it); err != nil || !bytes.Equal(bf.Bytes(), bs) {
+			t.Error("WriteTo should be the same as Encode:", err)
+		}
+	}
+	text := NewPacket(MESSAGE, strings.Repeat("a", 30))
+	bs, _ := codec.Encode(text)
+	if !bytes.Equal(bs[:4], []byte{0x82, 0x04, 0x78, 30}) {
+		t.Errorf("bad envelope: % x", bs[:4])
+	}
+	if decoded, err := codec.Decode(bs); err != nil || decoded.Option != 0 || !bytes.Equal(decoded.Data, text.Data) {
+		t.Error("text body should be decoded as string packet:", err)
+	}
+	bs, _ = codec.Encode(NewPacket(MESSAGE, []byte{0x01}))
+	if !bytes.Equal(bs, []byte{0x82, 0x04, 0x41, 0x01}) {
+		t.Errorf("bad envelope: % x", bs)
+	}
+}
+
+func TestCBORDecodeError(t *testing.T) {
+	codec := NewCBORCodec(CodecOptions{})
+	cases := map[string]error{
+		"":                 ErrEmptyPacket,
+		"\x83\x04\x40":     ErrInvalidFormat,
+		"\x82\x07\x40":     ErrInvalidType,
+		"\x82\x20\x40":     ErrInvalidType,
+		"\x82\x18\x04\x40": nil,
+		"\x82\x04\xf6":     nil,
+		"\x82\x04\x42a":    ErrInvalidLength,
+		"\x82\x04\x5f":     ErrInvalidLength,
+		"\x82\x04\x01":     ErrInvalidFormat,
+		"\x82\x04":         ErrInvalidFormat,
+		"\x82\x04\x61ab":   ErrInvalidLength,
+	}
+	for input, exp := range cases {
+		_, err := codec.Decode([]byte(input))
+		if !errors.Is(err, exp) {
+			t.Errorf("decode % x: should be %v, got %v", input, exp, err)
+		}
+	}
+}
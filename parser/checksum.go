@@ -0,0 +1,136 @@
+package parser
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// checksumSize is the length in bytes of the checksum trailer.
+const checksumSize = 4
+
+// ChecksumAlgorithm is the algorithm of the checksum trailer of binary packets, negotiated by the checksum
+// query parameter of handshake.
+type ChecksumAlgorithm uint8
+
+const (
+	// ChecksumCRC32 is CRC-32 with the IEEE polynomial, as used by zlib and PNG.
+	ChecksumCRC32 ChecksumAlgorithm = iota + 1
+	// ChecksumCRC32C is CRC-32 with the Castagnoli polynomial, which is accelerated by SSE4.2 and ARMv8.
+	ChecksumCRC32C
+)
+
+var castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
+// ParseChecksum parses the value of checksum query parameter, "crc32" or "crc32c".
+func ParseChecksum(name string) (ChecksumAlgorithm, error) {
+	switch name {
+	case "crc32":
+		return ChecksumCRC32, nil
+	case "crc32c":
+		return ChecksumCRC32C, nil
+	}
+	return 0, fmt.Errorf("parser: unsupported checksum '%s'", name)
+}
+
+// String returns the value of checksum query parameter.
+func (p ChecksumAlgorithm) String() string {
+	switch p {
+	case ChecksumCRC32:
+		return "crc32"
+	case ChecksumCRC32C:
+		return "crc32c"
+	}
+	return fmt.Sprintf("ChecksumAlgorithm(%d)", uint8(p))
+}
+
+func (p ChecksumAlgorithm) sum(data []byte) uint32 {
+	if p == ChecksumCRC32C {
+		return crc32.Checksum(data, castagnoliTable)
+	}
+	return crc32.ChecksumIEEE(data)
+}
+
+// NewChecksumCodec returns a codec which appends a checksum of the body to binary packets before encoding
+// them with inner, and verifies it after decoding. A corrupted packet is rejected with ErrChecksumMismatch
+// instead of being delivered.
+//
+// The trailer is the 4 bytes big-endian checksum of body, string packets are left as is because
+// corrupted text is usually caught by UTF-8 or JSON decoding. Both of peers must use it.
+func NewChecksumCodec(inner Codec, algorithm ChecksumAlgorithm) Codec {
+	if algorithm != ChecksumCRC32 && algorithm != ChecksumCRC32C {
+		panic(fmt.Errorf("parser: illegal checksum algorithm %d", algorithm))
+	}
+	return &checksumCodec{inner: inner, algorithm: algorithm}
+}
+
+type checksumCodec struct {
+	inner     Codec
+	algorithm ChecksumAlgorithm
+}
+
+func (p *checksumCodec) Decode(data []byte) (*Packet, error) {
+	packet, err := p.inner.Decode(data)
+	if err != nil || packet.Option&BINARY != BINARY {
+		return packet, err
+	}
+	n := len(packet.Data) - checksumSize
+	if n < 0 {
+		return nil, newDecodeError(ErrInvalidFormat, len(data), "checksum trailer", fmt.Sprintf("%d bytes", len(packet.Data)))
+	}
+	expected := binary.BigEndian.Uint32(packet.Data[n:])
+	if got := p.algorithm.sum(packet.Data[:n]); got != expected {
+		return nil, newDecodeError(ErrChecksumMismatch, len(data), fmt.Sprintf("%s 0x%08X", p.algorithm, expected), fmt.Sprintf("0x%08X", got))
+	}
+	packet.Data = packet.Data[:n]
+	return packet, nil
+}
+
+func (p *checksumCodec) WriteTo(writer io.Writer, packet *Packet) error {
+	wrapped, err := p.wrap(packet)
+	if err != nil {
+		return err
+	}
+	return p.inner.WriteTo(writer, wrapped)
+}
+
+func (p *checksumCodec) Encode(packet *Packet) ([]byte, error) {
+	wrapped, err := p.wrap(packet)
+	if err != nil {
+		return nil, err
+	}
+	return p.inner.Encode(wrapped)
+}
+
+func (p *checksumCodec) EncodeAppend(dst []byte, packet *Packet) ([]byte, error) {
+	wrapped, err := p.wrap(packet)
+	if err != nil {
+		return dst, err
+	}
+	return p.inner.EncodeAppend(dst, wrapped)
+}
+
+func (p *checksumCodec) EncodedLen(packet *Packet) int {
+	wrapped, err := p.wrap(packet)
+	if err != nil {
+		return p.inner.EncodedLen(packet)
+	}
+	return p.inner.EncodedLen(wrapped)
+}
+
+// wrap returns a copy of binary packet whose body is followed by the checksum trailer.
+func (p *checksumCodec) wrap(packet *Packet) (*Packet, error) {
+	if packet.Option&BINARY != BINARY {
+		return packet, nil
+	}
+	if err := packet.ReadBody(); err != nil {
+		return nil, err
+	}
+	wrapped := *packet
+	wrapped.buf = nil
+	wrapped.Data = make([]byte, len(packet.Data)+checksumSize)
+	copy(wrapped.Data, packet.Data)
+	binary.BigEndian.PutUint32(wrapped.Data[len(packet.Data):], p.algorithm.sum(packet.Data))
+	return &wrapped, nil
+}
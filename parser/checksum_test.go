@@ -0,0 +1,65 @@
+package parser
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestChecksumCodec(t *testing.T) {
+	for _, algorithm := range []ChecksumAlgorithm{ChecksumCRC32, ChecksumCRC32C} {
+		for name, inner := range map[string]Codec{
+			"binary":  binaryEncoder,
+			"base64":  base64Encoder,
+			"msgpack": msgpackEncoder,
+		} {
+			codec := NewChecksumCodec(inner, algorithm)
+			packet := NewPacketCustom(MESSAGE, []byte{1, 2, 3, 4, 5}, BINARY)
+			bs, err := codec.Encode(packet)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if codec.EncodedLen(packet) != len(bs) {
+				t.Errorf("%s/%s: bad encoded len", algorithm, name)
+			}
+			decoded, err := codec.Decode(bs)
+			if err != nil {
+				t.Fatalf("%s/%s: %v", algorithm, name, err)
+			}
+			if !bytes.Equal(decoded.Data, packet.Data) {
+				t.Errorf("%s/%s: bad body %v", algorithm, name, decoded.Data)
+			}
+		}
+	}
+}
+
+func TestChecksumCodec_Mismatch(t *testing.T) {
+	codec := NewChecksumCodec(binaryEncoder, ChecksumCRC32)
+	bs, _ := codec.Encode(NewPacketCustom(MESSAGE, []byte("hello"), BINARY))
+	bs[2] ^= 0x10
+	if _, err := codec.Decode(bs); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("bad error: %v", err)
+	}
+	if _, err := codec.Decode([]byte{4, 1}); !errors.Is(err, ErrInvalidFormat) {
+		t.Errorf("bad error of short body: %v", err)
+	}
+}
+
+func TestChecksumCodec_String(t *testing.T) {
+	codec := NewChecksumCodec(stringEncoder, ChecksumCRC32C)
+	bs, _ := codec.Encode(NewPacketByString(MESSAGE, "hello"))
+	if string(bs) != "4hello" {
+		t.Errorf("string packets should be left as is: %s", bs)
+	}
+}
+
+func TestParseChecksum(t *testing.T) {
+	for _, it := range []ChecksumAlgorithm{ChecksumCRC32, ChecksumCRC32C} {
+		if a, err := ParseChecksum(it.String()); err != nil || a != it {
+			t.Errorf("bad parsed checksum of %s", it)
+		}
+	}
+	if _, err := ParseChecksum("xxhash"); err == nil {
+		t.Error("unknown checksum should fail")
+	}
+}
@@ -0,0 +1,132 @@
+package parser
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+	"io"
+)
+
+// flags of MESSAGE bodies encrypted by the cipher codec, they keep whether the plaintext is text or binary.
+const (
+	cipherFlagText   byte = 0x00
+	cipherFlagBinary byte = 0x01
+)
+
+// NewAESGCMCodec returns a codec which encrypts MESSAGE bodies with AES-GCM, see NewAEADCodec.
+// The key must be 16, 24 or 32 bytes to select AES-128, AES-192 or AES-256.
+func NewAESGCMCodec(inner Codec, key []byte) (Codec, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return NewAEADCodec(inner, aead), nil
+}
+
+// NewAEADCodec returns a codec which encrypts MESSAGE bodies with aead before encoding them with inner,
+// and decrypts them after decoding. Messages which fail authentication are rejected with ErrDecryptionFailed.
+//
+// The body of an encrypted MESSAGE packet is a flag byte, 0 for text and 1 for binary plaintext,
+// followed by a random nonce and the sealed plaintext; the flag is authenticated as additional data.
+// Encrypted packets are binary, so inner should be a binary or base64 codec; other packets are left as is.
+// The key is shared by both of peers out of band, e.g. by the session key hook of engine.
+func NewAEADCodec(inner Codec, aead cipher.AEAD) Codec {
+	return &aeadCodec{inner: inner, aead: aead}
+}
+
+type aeadCodec struct {
+	inner Codec
+	aead  cipher.AEAD
+}
+
+func (p *aeadCodec) Decode(data []byte) (*Packet, error) {
+	packet, err := p.inner.Decode(data)
+	if err != nil || packet.Type != MESSAGE {
+		return packet, err
+	}
+	nonceSize := p.aead.NonceSize()
+	if len(packet.Data) < 1+nonceSize+p.aead.Overhead() {
+		return nil, newDecodeError(ErrInvalidFormat, 1, "encrypted body", fmt.Sprintf("%d bytes", len(packet.Data)))
+	}
+	flag := packet.Data[0]
+	if flag != cipherFlagText && flag != cipherFlagBinary {
+		return nil, newDecodeError(ErrInvalidFormat, 1, "cipher flag 0 or 1", fmt.Sprintf("0x%02X", flag))
+	}
+	nonce := packet.Data[1 : 1+nonceSize]
+	plain, err := p.aead.Open(nil, nonce, packet.Data[1+nonceSize:], packet.Data[:1])
+	if err != nil {
+		return nil, newDecodeError(ErrDecryptionFailed, 1, "", "")
+	}
+	packet.Data = plain
+	if flag == cipherFlagText {
+		packet.Option &^= BINARY | BASE64
+	} else {
+		packet.Option |= BINARY
+	}
+	return packet, nil
+}
+
+func (p *aeadCodec) WriteTo(writer io.Writer, packet *Packet) error {
+	wrapped, err := p.wrap(packet)
+	if err != nil {
+		return err
+	}
+	return p.inner.WriteTo(writer, wrapped)
+}
+
+func (p *aeadCodec) Encode(packet *Packet) ([]byte, error) {
+	wrapped, err := p.wrap(packet)
+	if err != nil {
+		return nil, err
+	}
+	return p.inner.Encode(wrapped)
+}
+
+func (p *aeadCodec) EncodeAppend(dst []byte, packet *Packet) ([]byte, error) {
+	wrapped, err := p.wrap(packet)
+	if err != nil {
+		return dst, err
+	}
+	return p.inner.EncodeAppend(dst, wrapped)
+}
+
+// EncodedLen returns the length without encrypting, because the ciphertext is as long as plaintext plus overhead.
+func (p *aeadCodec) EncodedLen(packet *Packet) int {
+	if packet.Type != MESSAGE {
+		return p.inner.EncodedLen(packet)
+	}
+	sized := *packet
+	sized.Body = nil
+	sized.Data = make([]byte, 1+p.aead.NonceSize()+dataLen(packet)+p.aead.Overhead())
+	sized.Option |= BINARY
+	return p.inner.EncodedLen(&sized)
+}
+
+// wrap returns a copy of MESSAGE packet whose body is encrypted.
+func (p *aeadCodec) wrap(packet *Packet) (*Packet, error) {
+	if packet.Type != MESSAGE {
+		return packet, nil
+	}
+	if err := packet.ReadBody(); err != nil {
+		return nil, err
+	}
+	nonceSize := p.aead.NonceSize()
+	data := make([]byte, 1+nonceSize, 1+nonceSize+len(packet.Data)+p.aead.Overhead())
+	data[0] = cipherFlagText
+	if packet.Option&BINARY == BINARY {
+		data[0] = cipherFlagBinary
+	}
+	if _, err := io.ReadFull(rand.Reader, data[1:]); err != nil {
+		return nil, err
+	}
+	wrapped := *packet
+	wrapped.buf = nil
+	wrapped.Data = p.aead.Seal(data, data[1:], packet.Data, data[:1])
+	wrapped.Option |= BINARY
+	return &wrapped, nil
+}
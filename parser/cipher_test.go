@@ -0,0 +1,71 @@
+package parser
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+var testKey = []byte("0123456789abcdef0123456789abcdef")
+
+func TestAEADCodec(t *testing.T) {
+	for name, inner := range map[string]Codec{
+		"binary": binaryEncoder,
+		"base64": base64Encoder,
+		"v4":     V4.PacketCodec(true),
+	} {
+		codec, err := NewAESGCMCodec(inner, testKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, packet := range []*Packet{
+			NewPacketByString(MESSAGE, "hello"),
+			NewPacketCustom(MESSAGE, []byte{1, 2, 3}, BINARY),
+		} {
+			bs, err := codec.Encode(packet)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bytes.Contains(bs, packet.Data) {
+				t.Errorf("%s: body is not encrypted", name)
+			}
+			if n := codec.EncodedLen(packet); n != len(bs) {
+				t.Errorf("%s: EncodedLen %d, expected %d", name, n, len(bs))
+			}
+			decoded, err := codec.Decode(bs)
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			if !bytes.Equal(decoded.Data, packet.Data) || decoded.Option&BINARY != packet.Option&BINARY {
+				t.Errorf("%s: bad decoded packet %s", name, Dump(decoded))
+			}
+		}
+	}
+}
+
+func TestAEADCodec_WrongKey(t *testing.T) {
+	codec, _ := NewAESGCMCodec(binaryEncoder, testKey)
+	other, _ := NewAESGCMCodec(binaryEncoder, bytes.Repeat([]byte{1}, 16))
+	bs, _ := codec.Encode(NewPacketByString(MESSAGE, "hello"))
+	if _, err := other.Decode(bs); !errors.Is(err, ErrDecryptionFailed) {
+		t.Errorf("bad error: %v", err)
+	}
+	bs[len(bs)-1] ^= 1
+	if _, err := codec.Decode(bs); !errors.Is(err, ErrDecryptionFailed) {
+		t.Errorf("bad error of tampered body: %v", err)
+	}
+	if _, err := codec.Decode([]byte{4, 0, 1}); !errors.Is(err, ErrInvalidFormat) {
+		t.Errorf("bad error of short body: %v", err)
+	}
+}
+
+func TestAEADCodec_Control(t *testing.T) {
+	codec, _ := NewAESGCMCodec(binaryEncoder, testKey)
+	bs, _ := codec.Encode(NewPacketCustom(PING, []byte("probe"), BINARY))
+	if string(bs) != "\x02probe" {
+		t.Errorf("control packets should be left as is: %q", bs)
+	}
+	if _, err := NewAESGCMCodec(binaryEncoder, []byte("short")); err == nil {
+		t.Error("illegal key should fail")
+	}
+}
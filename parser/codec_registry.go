@@ -0,0 +1,62 @@
+package parser
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+const (
+	// CodecString is the name of the UTF-8 string packet codec.
+	CodecString = "string"
+	// CodecBinary is the name of the raw binary packet codec.
+	CodecBinary = "binary"
+	// CodecBase64 is the name of the base64 packet codec for binary packets over text transports.
+	CodecBase64 = "base64"
+	// CodecMsgpack is the name of the MessagePack packet codec for binary transports.
+	CodecMsgpack = "msgpack"
+	// CodecCBOR is the name of the CBOR packet codec for binary transports.
+	CodecCBOR = "cbor"
+)
+
+var codecs = struct {
+	sync.RWMutex
+	m map[string]Codec
+}{m: make(map[string]Codec)}
+
+// RegisterCodec makes a packet codec available by the provided name.
+// It panics if the name is blank, the codec is nil or the name is registered already.
+func RegisterCodec(name string, c Codec) {
+	if len(name) < 1 {
+		panic("parser: register codec with blank name")
+	}
+	if c == nil {
+		panic(fmt.Errorf("parser: register nil codec '%s'", name))
+	}
+	codecs.Lock()
+	defer codecs.Unlock()
+	if _, ok := codecs.m[name]; ok {
+		panic(fmt.Errorf("parser: codec '%s' exists already", name))
+	}
+	codecs.m[name] = c
+}
+
+// LookupCodec returns the packet codec registered with the name.
+func LookupCodec(name string) (Codec, bool) {
+	codecs.RLock()
+	defer codecs.RUnlock()
+	c, ok := codecs.m[name]
+	return c, ok
+}
+
+// Codecs returns a sorted list of the names of registered codecs.
+func Codecs() []string {
+	codecs.RLock()
+	defer codecs.RUnlock()
+	names := make([]string, 0, len(codecs.m))
+	for name := range codecs.m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
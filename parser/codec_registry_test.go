@@ -0,0 +1,84 @@
+package parser
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+// reverseCodec is a toy wire format which writes the string packet backwards.
+type reverseCodec struct {
+}
+
+func (p *reverseCodec) Decode(data []byte) (*Packet, error) {
+	return stringEncoder.Decode(reverse(data))
+}
+
+func (p *reverseCodec) Encode(packet *Packet) ([]byte, error) {
+	bs, err := stringEncoder.Encode(packet)
+	if err != nil {
+		return nil, err
+	}
+	return reverse(bs), nil
+}
+
+func (p *reverseCodec) EncodeAppend(dst []byte, packet *Packet) ([]byte, error) {
+	bs, err := p.Encode(packet)
+	if err != nil {
+		return dst, err
+	}
+	return append(dst, bs...), nil
+}
+
+func (p *reverseCodec) EncodedLen(packet *Packet) int {
+	return stringEncoder.EncodedLen(packet)
+}
+
+func (p *reverseCodec) WriteTo(writer io.Writer, packet *Packet) error {
+	bs, err := p.Encode(packet)
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write(bs)
+	return err
+}
+
+func reverse(input []byte) []byte {
+	ret := make([]byte, len(input))
+	for i, c := range input {
+		ret[len(input)-1-i] = c
+	}
+	return ret
+}
+
+func TestRegisterCodec(t *testing.T) {
+	for _, name := range []string{CodecString, CodecBinary, CodecBase64} {
+		if _, ok := LookupCodec(name); !ok {
+			t.Error("missing builtin codec:", name)
+		}
+	}
+	RegisterCodec("reverse", new(reverseCodec))
+	c, ok := LookupCodec("reverse")
+	if !ok {
+		t.Fatal("codec should be registered")
+	}
+	bs, err := c.Encode(NewPacket(MESSAGE, "abc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(bs, []byte("cba4")) {
+		t.Error("illegal result:", string(bs))
+	}
+	packet, err := c.Decode(bs)
+	if err != nil || packet.Type != MESSAGE || string(packet.Data) != "abc" {
+		t.Error("illegal result:", packet, err)
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("register twice should panic")
+			}
+		}()
+		RegisterCodec("reverse", new(reverseCodec))
+	}()
+}
@@ -0,0 +1,181 @@
+// Package conformance checks codecs against the corpus of encoded packets and payloads in parser/testdata,
+// which follows the wire format of the reference JS implementation of protocol v3 and v4.
+// Alternative codecs and refactors can use it to prove wire compatibility.
+package conformance
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/jjeffcaii/engine.io/parser"
+)
+
+const (
+	// KindPacket is the kind of vectors of a single packet.
+	KindPacket = "packet"
+	// KindPayload is the kind of vectors of a polling payload.
+	KindPayload = "payload"
+
+	// FormatString is the format of vectors encoded as text, binary packets are base64 in payloads.
+	FormatString = "string"
+	// FormatBinary is the format of vectors encoded in binary, e.g. websocket binary frames or XHR2 payloads.
+	FormatBinary = "binary"
+	// FormatBase64 is the format of vectors of binary packets encoded as base64 text.
+	FormatBase64 = "base64"
+)
+
+// corpus files of protocols.
+var files = map[parser.Protocol]string{
+	parser.V3: "v3.json",
+	parser.V4: "v4.json",
+}
+
+// Vector is an encoded packet or payload and the packets it contains.
+type Vector struct {
+	Name    string           `json:"name"`
+	Kind    string           `json:"kind"`
+	Format  string           `json:"format"`
+	Packets []*parser.Packet `json:"packets"`
+	// Encoded is the encoded text, Hex is used instead for binary data.
+	Encoded string `json:"encoded,omitempty"`
+	Hex     string `json:"hex,omitempty"`
+	// Protocol is set from the corpus file.
+	Protocol parser.Protocol `json:"-"`
+}
+
+// String returns a readable description of vector.
+func (v *Vector) String() string {
+	return fmt.Sprintf("v%d %s %s '%s'", v.Protocol, v.Format, v.Kind, v.Name)
+}
+
+// Bytes returns the encoded bytes of vector.
+func (v *Vector) Bytes() ([]byte, error) {
+	if len(v.Hex) > 0 {
+		return hex.DecodeString(v.Hex)
+	}
+	return []byte(v.Encoded), nil
+}
+
+// Load reads the corpus in dir, which is usually the testdata directory of package parser.
+func Load(dir string) ([]*Vector, error) {
+	var vectors []*Vector
+	for _, protocol := range []parser.Protocol{parser.V3, parser.V4} {
+		bs, err := ioutil.ReadFile(filepath.Join(dir, files[protocol]))
+		if err != nil {
+			return nil, err
+		}
+		var list []*Vector
+		if err := json.Unmarshal(bs, &list); err != nil {
+			return nil, fmt.Errorf("conformance: parse %s failed: %w", files[protocol], err)
+		}
+		for _, it := range list {
+			it.Protocol = protocol
+		}
+		vectors = append(vectors, list...)
+	}
+	return vectors, nil
+}
+
+// Select returns the vectors of protocol, kind and format.
+func Select(vectors []*Vector, protocol parser.Protocol, kind, format string) []*Vector {
+	var ret []*Vector
+	for _, it := range vectors {
+		if it.Protocol == protocol && it.Kind == kind && it.Format == format {
+			ret = append(ret, it)
+		}
+	}
+	return ret
+}
+
+// CheckCodec runs a packet codec against vectors of packets, returns an error for each mismatch.
+// Vectors of payloads are ignored.
+func CheckCodec(codec parser.Codec, vectors []*Vector) []error {
+	var errs []error
+	for _, it := range vectors {
+		if it.Kind != KindPacket {
+			continue
+		}
+		if err := check(it, func() ([]byte, error) {
+			return codec.Encode(it.Packets[0])
+		}, func(input []byte) ([]*parser.Packet, error) {
+			packet, err := codec.Decode(input)
+			if err != nil {
+				return nil, err
+			}
+			return []*parser.Packet{packet}, nil
+		}); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// CheckPayloadCodec runs a payload codec against vectors of payloads, returns an error for each mismatch.
+// Vectors of packets are ignored.
+func CheckPayloadCodec(codec parser.PayloadCodec, vectors []*Vector) []error {
+	var errs []error
+	for _, it := range vectors {
+		if it.Kind != KindPayload {
+			continue
+		}
+		if err := check(it, func() ([]byte, error) {
+			return codec.Encode(it.Packets...)
+		}, codec.Decode); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// RunCodec reports mismatches of CheckCodec as errors of test.
+func RunCodec(t testing.TB, codec parser.Codec, vectors []*Vector) {
+	for _, err := range CheckCodec(codec, vectors) {
+		t.Error(err)
+	}
+}
+
+// RunPayloadCodec reports mismatches of CheckPayloadCodec as errors of test.
+func RunPayloadCodec(t testing.TB, codec parser.PayloadCodec, vectors []*Vector) {
+	for _, err := range CheckPayloadCodec(codec, vectors) {
+		t.Error(err)
+	}
+}
+
+func check(v *Vector, encode func() ([]byte, error), decode func([]byte) ([]*parser.Packet, error)) error {
+	exp, err := v.Bytes()
+	if err != nil {
+		return fmt.Errorf("%s: bad vector: %w", v, err)
+	}
+	got, err := encode()
+	if err != nil {
+		return fmt.Errorf("%s: encode failed: %w", v, err)
+	}
+	if !bytes.Equal(got, exp) {
+		return fmt.Errorf("%s: encoded %q, expected %q", v, got, exp)
+	}
+	packets, err := decode(exp)
+	if err != nil {
+		return fmt.Errorf("%s: decode failed: %w", v, err)
+	}
+	if len(packets) != len(v.Packets) {
+		return fmt.Errorf("%s: decoded %d packets, expected %d", v, len(packets), len(v.Packets))
+	}
+	for i, it := range v.Packets {
+		if !samePacket(packets[i], it) {
+			got, _ := json.Marshal(packets[i])
+			exp, _ := json.Marshal(it)
+			return fmt.Errorf("%s: decoded packet %d is %s, expected %s", v, i, got, exp)
+		}
+	}
+	return nil
+}
+
+// samePacket compares type, data and binary flag of packets.
+func samePacket(a, b *parser.Packet) bool {
+	return a.Type == b.Type && a.Option&parser.BINARY == b.Option&parser.BINARY && bytes.Equal(a.Data, b.Data)
+}
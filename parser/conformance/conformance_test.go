@@ -0,0 +1,49 @@
+package conformance
+
+import (
+	"testing"
+
+	"github.com/jjeffcaii/engine.io/parser"
+)
+
+func TestBuiltinCodecs(t *testing.T) {
+	vectors, err := Load("../testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vectors) < 1 {
+		t.Fatal("corpus is empty")
+	}
+	sel := func(protocol parser.Protocol, kind, format string) []*Vector {
+		ret := Select(vectors, protocol, kind, format)
+		if len(ret) < 1 {
+			t.Fatalf("no vectors of v%d %s %s", protocol, format, kind)
+		}
+		return ret
+	}
+	codec := func(name string) parser.Codec {
+		c, ok := parser.LookupCodec(name)
+		if !ok {
+			t.Fatalf("codec %s is not registered", name)
+		}
+		return c
+	}
+	RunCodec(t, codec(parser.CodecString), sel(parser.V3, KindPacket, FormatString))
+	RunCodec(t, codec(parser.CodecBase64), sel(parser.V3, KindPacket, FormatBase64))
+	RunCodec(t, codec(parser.CodecBinary), sel(parser.V3, KindPacket, FormatBinary))
+	for _, protocol := range []parser.Protocol{parser.V3, parser.V4} {
+		RunCodec(t, protocol.PacketCodec(false), sel(protocol, KindPacket, FormatString))
+		RunCodec(t, protocol.PacketCodec(false), sel(protocol, KindPacket, FormatBase64))
+		RunCodec(t, protocol.PacketCodec(true), sel(protocol, KindPacket, FormatBinary))
+		RunPayloadCodec(t, protocol.PayloadCodec(), sel(protocol, KindPayload, FormatString))
+	}
+	RunPayloadCodec(t, parser.ProtocolV3Binary, sel(parser.V3, KindPayload, FormatBinary))
+}
+
+func TestCheckMismatch(t *testing.T) {
+	vectors := []*Vector{{Name: "bad", Kind: KindPacket, Format: FormatString, Encoded: "4hello",
+		Packets: []*parser.Packet{parser.NewPacket(parser.MESSAGE, "bye")}}}
+	if errs := CheckCodec(parser.V3.PacketCodec(false), vectors); len(errs) != 1 {
+		t.Error("mismatch should be reported:", errs)
+	}
+}
@@ -0,0 +1,229 @@
+package parser
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"unicode/utf8"
+)
+
+// errSkipped is returned by decodeNext when a malformed packet is skipped.
+var errSkipped = errors.New("parser: packet is skipped")
+
+// Decoder reads and decodes packets from a payload stream.
+// It can be used to parse a polling body without buffering the whole of it.
+type Decoder struct {
+	reader   *bufio.Reader
+	buffer   *bytes.Buffer
+	options  CodecOptions
+	str, b64 Codec
+	offset   int
+	err      error
+}
+
+// NewDecoder returns a new decoder that reads payload from r.
+func NewDecoder(r io.Reader) *Decoder {
+	return NewDecoderWithOptions(r, CodecOptions{})
+}
+
+// NewDecoderWithOptions returns a new decoder with options that reads payload from r.
+// Decoded packets always own their data, so the ZeroCopy option is ignored.
+func NewDecoderWithOptions(r io.Reader, options CodecOptions) *Decoder {
+	// content is copied out of buffer already.
+	inner := options
+	inner.ZeroCopy = true
+	return &Decoder{
+		reader:  bufio.NewReader(r),
+		buffer:  new(bytes.Buffer),
+		options: options,
+		str:     NewStringCodec(inner),
+		b64:     NewBase64Codec(inner),
+	}
+}
+
+// More reports whether there is another packet in the current payload stream.
+func (p *Decoder) More() bool {
+	if p.err != nil {
+		return false
+	}
+	_, err := p.reader.Peek(1)
+	return err != io.EOF
+}
+
+// Decode reads the next packet from its input.
+// It returns io.EOF when the payload stream ends at a packet boundary.
+func (p *Decoder) Decode() (*Packet, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+	for {
+		offset := p.offset
+		packet, err := p.decodeNext()
+		if err == errSkipped {
+			if p.More() {
+				continue
+			}
+			err = io.EOF
+		}
+		if err != nil {
+			p.err = err
+			if err != io.EOF {
+				payloadMetricsV3.failed(err)
+			}
+			return nil, err
+		}
+		payloadMetricsV3.decoded(1, len(packet.Data), p.offset-offset)
+		return packet, nil
+	}
+}
+
+func (p *Decoder) decodeNext() (*Packet, error) {
+	size, err := p.readLength()
+	if err != nil {
+		return nil, err
+	}
+	start := p.offset
+	if err := p.options.checkSize(size, start); err != nil {
+		return nil, err
+	}
+	if head, err := p.reader.Peek(1); err == nil && head[0] == 'b' && size > 2 {
+		return p.decodeBase64(size)
+	}
+	p.buffer.Reset()
+	var i int
+	for i < size {
+		units, err := p.readRune()
+		if err != nil {
+			if err == io.EOF {
+				return nil, io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		if err := p.options.checkSize(p.buffer.Len(), start); err != nil {
+			return nil, err
+		}
+		i += units
+	}
+	if p.options.Strict && i != size {
+		return nil, newDecodeError(ErrInvalidLength, start, fmt.Sprintf("%d characters", size), fmt.Sprintf("%d characters", i))
+	}
+	// packet must own its data because the buffer will be reused.
+	content := make([]byte, p.buffer.Len())
+	copy(content, p.buffer.Bytes())
+	packet, err := readPacket(content, p.str, p.b64)
+	if err != nil {
+		if err = withOffset(err, start); p.options.skip(err) {
+			return nil, errSkipped
+		}
+		return nil, err
+	}
+	return packet, nil
+}
+
+func (p *Decoder) readLength() (int, error) {
+	var size, digits int
+	for {
+		c, err := p.reader.ReadByte()
+		if err == io.EOF && digits > 0 {
+			return 0, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return 0, err
+		}
+		p.offset++
+		if c == ':' && digits > 0 {
+			return size, nil
+		}
+		if c < '0' || c > '9' {
+			return 0, newDecodeError(ErrInvalidLength, p.offset-1, "decimal length and ':'", fmt.Sprintf("%q", c))
+		}
+		size = size*10 + int(c-'0')
+		digits++
+		if err := p.options.checkSize(size, p.offset-digits); err != nil {
+			return 0, err
+		}
+		if digits > 10 {
+			return 0, newDecodeError(ErrInvalidLength, p.offset-digits, "decimal length", "too many digits")
+		}
+	}
+}
+
+// readRune reads a character into buffer, returns the length of it in the unit of payload length.
+func (p *Decoder) readRune() (int, error) {
+	r, width, err := p.reader.ReadRune()
+	if err != nil {
+		return 0, err
+	}
+	jsUnits := p.options.UTF8 == UTF8JavaScript
+	if r != utf8.RuneError || width != 1 {
+		p.offset += width
+		p.buffer.WriteRune(r)
+		if jsUnits && r >= 0x10000 {
+			return 2, nil
+		}
+		return 1, nil
+	}
+	// keep the original bytes of an invalid UTF-8 sequence.
+	if err := p.reader.UnreadRune(); err != nil {
+		return 0, err
+	}
+	if jsUnits {
+		// a lone surrogate is a single UTF-16 code unit.
+		if b, err := p.reader.Peek(3); err == nil && surrogateAt(b) != 0 {
+			p.buffer.Write(b)
+			p.offset += 3
+			_, err = p.reader.Discard(3)
+			return 1, err
+		}
+	}
+	c, err := p.reader.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	p.offset++
+	return 1, p.buffer.WriteByte(c)
+}
+
+// decodeBase64 decode the body of a base64 packet while reading, without buffering the encoded text.
+// A malformed packet is drained to its end, so it's skipped as a malformed text packet is.
+func (p *Decoder) decodeBase64(size int) (*Packet, error) {
+	start := p.offset
+	reader := &io.LimitedReader{R: p.reader, N: int64(size)}
+	head := make([]byte, 2)
+	if _, err := io.ReadFull(reader, head); err != nil {
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	t, err := convertCharToType(head[1])
+	if err != nil {
+		return nil, p.skipBase64(reader, size, withOffset(err, start+1))
+	}
+	body, err := readBase64(reader, size-2, start+2, p.options)
+	if err == io.ErrUnexpectedEOF {
+		return nil, err
+	} else if err != nil {
+		return nil, p.skipBase64(reader, size, err)
+	}
+	p.offset += size
+	return p.options.ownedPacket(t, body, BINARY)
+}
+
+// skipBase64 drains the rest of a malformed base64 packet of size from reader, it returns errSkipped if err is
+// skipped by the options.
+func (p *Decoder) skipBase64(reader *io.LimitedReader, size int, err error) error {
+	if _, e := io.Copy(io.Discard, reader); e != nil {
+		return e
+	}
+	if reader.N > 0 {
+		return io.ErrUnexpectedEOF
+	}
+	p.offset += size
+	if p.options.skip(err) {
+		return errSkipped
+	}
+	return err
+}
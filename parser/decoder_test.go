@@ -0,0 +1,66 @@
+package parser
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestDecoder(t *testing.T) {
+	input := "7:4你好，世界!1:522:b4YmluYXJ5IGZ1Y2sgMiE="
+	decoder := NewDecoder(iotest.OneByteReader(strings.NewReader(input)))
+	packets := make([]*Packet, 0)
+	for decoder.More() {
+		packet, err := decoder.Decode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		packets = append(packets, packet)
+	}
+	if len(packets) != 3 {
+		t.Fatal("should 3 packets")
+	}
+	if packets[0].Type != MESSAGE || string(packets[0].Data) != "你好，世界!" {
+		t.Error("illegal result")
+	}
+	if packets[1].Type != UPGRADE || len(packets[1].Data) != 0 {
+		t.Error("illegal result")
+	}
+	if packets[2].Option&BINARY != BINARY || string(packets[2].Data) != "binary fuck 2!" {
+		t.Error("illegal result")
+	}
+	if _, err := decoder.Decode(); err != io.EOF {
+		t.Error("should be EOF:", err)
+	}
+}
+
+func TestDecoderRoundTrip(t *testing.T) {
+	bs, err := EncodePayload(NewPacket(MESSAGE, "hello"), NewPacket(PING, "probe"), NewPacket(MESSAGE, []byte{0xFF, 0x00}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoder := NewDecoder(bytes.NewReader(bs))
+	for _, exp := range []string{"hello", "probe", "\xff\x00"} {
+		packet, err := decoder.Decode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(packet.Data) != exp {
+			t.Error("data should be:", exp)
+		}
+	}
+}
+
+func TestDecoderTruncated(t *testing.T) {
+	for _, input := range []string{"7:4你好", "12", "x:4a"} {
+		decoder := NewDecoder(strings.NewReader(input))
+		if _, err := decoder.Decode(); err == nil || err == io.EOF {
+			t.Error("should fail:", input)
+		}
+		if decoder.More() {
+			t.Error("should stop after failure:", input)
+		}
+	}
+}
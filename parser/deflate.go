@@ -0,0 +1,161 @@
+package parser
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// tags of MESSAGE bodies encoded by the deflate codec.
+const (
+	deflateTagPlain byte = 0x00
+	deflateTagFlate byte = 0x01
+)
+
+// DeflateOptions define the behaviors of deflate codec.
+type DeflateOptions struct {
+	// Threshold is the min length in bytes of MESSAGE bodies to be compressed, smaller bodies are sent as is
+	// unless packet option Compress is set.
+	Threshold int
+	// Level is the compression level of compress/flate, zero means flate.DefaultCompression.
+	Level int
+	// MaxSize is the max length in bytes of decompressed bodies, zero means unlimited.
+	// Larger bodies are rejected with ErrPacketTooLarge.
+	MaxSize int
+}
+
+// NewDeflateCodec returns a codec which compresses MESSAGE bodies with deflate before encoding them with inner.
+//
+// The body of every MESSAGE packet is prefixed with a tag byte, 0 for a plain body and 1 for a deflated one,
+// other packets are left as is. Compressed bodies are binary, so inner should be a binary or base64 codec.
+// It's for deployments which can't use permessage-deflate of websocket, both of peers must use it.
+func NewDeflateCodec(inner Codec, options DeflateOptions) Codec {
+	level := options.Level
+	if level == 0 {
+		level = flate.DefaultCompression
+	}
+	return &deflateCodec{
+		inner:   inner,
+		options: options,
+		writers: sync.Pool{
+			New: func() interface{} {
+				w, err := flate.NewWriter(nil, level)
+				if err != nil {
+					panic(err)
+				}
+				return w
+			},
+		},
+	}
+}
+
+type deflateCodec struct {
+	inner   Codec
+	options DeflateOptions
+	writers sync.Pool
+}
+
+func (p *deflateCodec) Decode(data []byte) (*Packet, error) {
+	packet, err := p.inner.Decode(data)
+	if err != nil || packet.Type != MESSAGE {
+		return packet, err
+	}
+	if len(packet.Data) < 1 {
+		return nil, newDecodeError(ErrInvalidFormat, 1, "deflate tag", "empty body")
+	}
+	switch packet.Data[0] {
+	default:
+		return nil, newDecodeError(ErrInvalidFormat, 1, "deflate tag 0 or 1", fmt.Sprintf("0x%02X", packet.Data[0]))
+	case deflateTagPlain:
+		packet.Data = packet.Data[1:]
+		return packet, nil
+	case deflateTagFlate:
+		body, err := p.inflate(packet.Data[1:])
+		if err != nil {
+			return nil, err
+		}
+		packet.Data = body
+		return packet, nil
+	}
+}
+
+func (p *deflateCodec) WriteTo(writer io.Writer, packet *Packet) error {
+	wrapped, err := p.wrap(packet)
+	if err != nil {
+		return err
+	}
+	return p.inner.WriteTo(writer, wrapped)
+}
+
+func (p *deflateCodec) Encode(packet *Packet) ([]byte, error) {
+	wrapped, err := p.wrap(packet)
+	if err != nil {
+		return nil, err
+	}
+	return p.inner.Encode(wrapped)
+}
+
+func (p *deflateCodec) EncodeAppend(dst []byte, packet *Packet) ([]byte, error) {
+	wrapped, err := p.wrap(packet)
+	if err != nil {
+		return dst, err
+	}
+	return p.inner.EncodeAppend(dst, wrapped)
+}
+
+func (p *deflateCodec) EncodedLen(packet *Packet) int {
+	wrapped, err := p.wrap(packet)
+	if err != nil {
+		return p.inner.EncodedLen(packet)
+	}
+	return p.inner.EncodedLen(wrapped)
+}
+
+// wrap returns a copy of MESSAGE packet whose body is tagged and compressed if it's large enough.
+func (p *deflateCodec) wrap(packet *Packet) (*Packet, error) {
+	if packet.Type != MESSAGE {
+		return packet, nil
+	}
+	if err := packet.ReadBody(); err != nil {
+		return nil, err
+	}
+	wrapped := *packet
+	wrapped.buf = nil
+	if !packet.Options.Compressible(len(packet.Data), p.options.Threshold) {
+		wrapped.Data = append([]byte{deflateTagPlain}, packet.Data...)
+		return &wrapped, nil
+	}
+	bf := bytes.NewBuffer(make([]byte, 0, len(packet.Data)/2+8))
+	bf.WriteByte(deflateTagFlate)
+	w := p.writers.Get().(*flate.Writer)
+	defer p.writers.Put(w)
+	w.Reset(bf)
+	if _, err := w.Write(packet.Data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	wrapped.Data = bf.Bytes()
+	wrapped.Option |= BINARY
+	return &wrapped, nil
+}
+
+func (p *deflateCodec) inflate(src []byte) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(src))
+	defer r.Close()
+	var reader io.Reader = r
+	if p.options.MaxSize > 0 {
+		reader = io.LimitReader(r, int64(p.options.MaxSize)+1)
+	}
+	bf := new(bytes.Buffer)
+	if _, err := bf.ReadFrom(reader); err != nil {
+		return nil, newDecodeError(ErrInvalidFormat, 2, "deflate data", err.Error())
+	}
+	if p.options.MaxSize > 0 && bf.Len() > p.options.MaxSize {
+		return nil, newDecodeError(ErrPacketTooLarge, 2, fmt.Sprintf("at most %d bytes", p.options.MaxSize), "more bytes")
+	}
+	return bf.Bytes(), nil
+}
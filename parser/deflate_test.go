@@ -0,0 +1,62 @@
+package parser
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestDeflateCodec(t *testing.T) {
+	codec := NewDeflateCodec(NewBinaryCodec(CodecOptions{}), DeflateOptions{Threshold: 64})
+	large := []byte(strings.Repeat("hello engine.io ", 64))
+	for _, it := range []*Packet{
+		NewPacket(MESSAGE, []byte("tiny")),
+		NewPacket(MESSAGE, large),
+		NewPacketCustom(PING, []byte("probe"), BINARY),
+	} {
+		bs, err := codec.Encode(it)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(bs) != codec.EncodedLen(it) {
+			t.Errorf("encoded length should be %d, got %d", codec.EncodedLen(it), len(bs))
+		}
+		decoded, err := codec.Decode(bs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decoded.Type != it.Type || !bytes.Equal(decoded.Data, it.Data) {
+			t.Errorf("should be %v, got %v", it, decoded)
+		}
+	}
+	bs, _ := codec.Encode(NewPacket(MESSAGE, large))
+	if len(bs) >= len(large)/4 || bs[1] != deflateTagFlate {
+		t.Error("large body should be compressed:", len(bs))
+	}
+	bs, _ = codec.Encode(NewPacket(MESSAGE, []byte("tiny")))
+	if !bytes.Equal(bs, []byte("\x04\x00tiny")) {
+		t.Errorf("small body should be plain: %q", bs)
+	}
+	noCompress := NewPacket(MESSAGE, large)
+	noCompress.Options.NoCompress = true
+	if bs, _ = codec.Encode(noCompress); bs[1] != deflateTagPlain {
+		t.Error("packet with NoCompress should be plain")
+	}
+	compress := NewPacket(MESSAGE, bytes.Repeat([]byte("tiny"), 4))
+	compress.Options.Compress = true
+	if bs, _ = codec.Encode(compress); bs[1] != deflateTagFlate {
+		t.Error("small packet with Compress should be compressed")
+	}
+	bs, _ = codec.Encode(NewPacket(MESSAGE, large))
+	limited := NewDeflateCodec(NewBinaryCodec(CodecOptions{}), DeflateOptions{MaxSize: 100})
+	if _, err := limited.Decode(bs); !errors.Is(err, ErrPacketTooLarge) {
+		t.Error("should be too large:", err)
+	}
+	if _, err := codec.Decode([]byte("\x04\x02x")); !errors.Is(err, ErrInvalidFormat) {
+		t.Error("should be invalid tag:", err)
+	}
+	if _, err := codec.Decode([]byte("\x04\x01garbage")); !errors.Is(err, ErrInvalidFormat) {
+		t.Error("should be invalid deflate data:", err)
+	}
+}
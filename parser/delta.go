@@ -0,0 +1,226 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+)
+
+// tags of MESSAGE bodies produced by DeltaEncoder.
+const (
+	deltaTagFull  byte = '='
+	deltaTagPatch byte = '+'
+)
+
+// DeltaEncoder encodes text MESSAGE packets whose body is a JSON object as a JSON merge patch (RFC 7386)
+// against the previous message, DeltaDecoder of the other peer reconstructs them.
+// It's for high-frequency state sync where consecutive messages differ in a few fields.
+//
+// The body of every text MESSAGE packet produced is tagged: '=' + body for a full message,
+// or '+' + patch for a delta. A full message is sent if the body isn't a JSON object, it's the first one,
+// the patch is not shorter, or the change can't be expressed by a merge patch such as setting a member to null.
+// Binary and other packets are left as is. Both of peers must use it, one pair per session and direction,
+// and packets must be delivered in order.
+type DeltaEncoder struct {
+	locker sync.Mutex
+	last   map[string]interface{}
+}
+
+// NewDeltaEncoder returns a delta encoder without previous message.
+func NewDeltaEncoder() *DeltaEncoder {
+	return new(DeltaEncoder)
+}
+
+// Encode returns the packet to be sent instead of packet.
+func (p *DeltaEncoder) Encode(packet *Packet) (*Packet, error) {
+	if packet.Type != MESSAGE || packet.Option&BINARY == BINARY {
+		return packet, nil
+	}
+	if err := packet.ReadBody(); err != nil {
+		return nil, err
+	}
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	doc, _ := parseJSONObject(packet.Data)
+	last := p.last
+	p.last = doc
+	data := append([]byte{deltaTagFull}, packet.Data...)
+	if last != nil && doc != nil {
+		if patch, ok := diffJSONObject(last, doc); ok {
+			if bs, err := marshalJSON(patch); err == nil && len(bs) < len(packet.Data) {
+				data = append([]byte{deltaTagPatch}, bs...)
+			}
+		}
+	}
+	encoded := NewPacketCustom(MESSAGE, data, packet.Option)
+	encoded.Options = packet.Options
+	return encoded, nil
+}
+
+// Reset forgets the previous message, e.g. when the session is reconnected. The decoder must be reset too.
+func (p *DeltaEncoder) Reset() {
+	p.locker.Lock()
+	p.last = nil
+	p.locker.Unlock()
+}
+
+// DeltaDecoder reconstructs messages encoded by DeltaEncoder.
+// Reconstructed bodies of deltas are marshaled again, so they are equal JSON but keys are sorted.
+type DeltaDecoder struct {
+	locker sync.Mutex
+	last   map[string]interface{}
+}
+
+// NewDeltaDecoder returns a delta decoder without previous message.
+func NewDeltaDecoder() *DeltaDecoder {
+	return new(DeltaDecoder)
+}
+
+// Decode consumes a received packet and returns the reconstructed message.
+func (p *DeltaDecoder) Decode(packet *Packet) (*Packet, error) {
+	if packet.Type != MESSAGE || packet.Option&BINARY == BINARY {
+		return packet, nil
+	}
+	if len(packet.Data) < 1 {
+		return nil, newDecodeError(ErrInvalidFormat, 0, "delta tag", "empty body")
+	}
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	switch packet.Data[0] {
+	default:
+		return nil, newDecodeError(ErrInvalidFormat, 0, "delta tag '=' or '+'", fmt.Sprintf("%q", packet.Data[0]))
+	case deltaTagFull:
+		packet.Data = packet.Data[1:]
+		p.last, _ = parseJSONObject(packet.Data)
+		return packet, nil
+	case deltaTagPatch:
+	}
+	if p.last == nil {
+		return nil, newDecodeError(ErrInvalidFormat, 0, "full message", "delta without previous message")
+	}
+	patch, err := parseJSONObject(packet.Data[1:])
+	if err != nil {
+		return nil, newDecodeError(ErrInvalidFormat, 1, "JSON merge patch", err.Error())
+	}
+	// the previous message is owned by decoder, so it's patched in place.
+	p.last = mergePatch(p.last, patch).(map[string]interface{})
+	data, err := marshalJSON(p.last)
+	if err != nil {
+		return nil, err
+	}
+	packet.Data = data
+	return packet, nil
+}
+
+// Reset forgets the previous message.
+func (p *DeltaDecoder) Reset() {
+	p.locker.Lock()
+	p.last = nil
+	p.locker.Unlock()
+}
+
+// parseJSONObject parses data as a JSON object, numbers are kept as json.Number so they are not rounded.
+func parseJSONObject(data []byte) (map[string]interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var doc map[string]interface{}
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, err
+	}
+	if doc == nil {
+		return nil, fmt.Errorf("parser: JSON null is not an object")
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, fmt.Errorf("parser: trailing data after JSON object")
+	}
+	return doc, nil
+}
+
+func marshalJSON(v interface{}) ([]byte, error) {
+	bf := new(bytes.Buffer)
+	encoder := json.NewEncoder(bf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(bf.Bytes(), []byte{'\n'}), nil
+}
+
+// diffJSONObject returns the merge patch which turns from into to, ok is false if it can't be expressed.
+func diffJSONObject(from, to map[string]interface{}) (patch map[string]interface{}, ok bool) {
+	patch = make(map[string]interface{})
+	for k, v := range to {
+		old, has := from[k]
+		if v == nil {
+			// null deletes a member in merge patch, so a member can't be set to null.
+			if has && old == nil {
+				continue
+			}
+			return nil, false
+		}
+		if has {
+			oldObject, ok1 := old.(map[string]interface{})
+			object, ok2 := v.(map[string]interface{})
+			if ok1 && ok2 {
+				sub, ok := diffJSONObject(oldObject, object)
+				if !ok {
+					return nil, false
+				}
+				if len(sub) > 0 {
+					patch[k] = sub
+				}
+				continue
+			}
+			if reflect.DeepEqual(old, v) {
+				continue
+			}
+		}
+		if hasNullMember(v) {
+			return nil, false
+		}
+		patch[k] = v
+	}
+	for k := range from {
+		if _, has := to[k]; !has {
+			patch[k] = nil
+		}
+	}
+	return patch, true
+}
+
+// hasNullMember reports whether v is an object which has a null member at any depth, merge patch drops them.
+func hasNullMember(v interface{}) bool {
+	object, ok := v.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	for _, it := range object {
+		if it == nil || hasNullMember(it) {
+			return true
+		}
+	}
+	return false
+}
+
+// mergePatch applies patch to target as RFC 7386, target is modified if it's an object.
+func mergePatch(target, patch interface{}) interface{} {
+	object, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	doc, ok := target.(map[string]interface{})
+	if !ok {
+		doc = make(map[string]interface{}, len(object))
+	}
+	for k, v := range object {
+		if v == nil {
+			delete(doc, k)
+		} else {
+			doc[k] = mergePatch(doc[k], v)
+		}
+	}
+	return doc
+}
@@ -0,0 +1,54 @@
+package parser
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDelta(t *testing.T) {
+	encoder, decoder := NewDeltaEncoder(), NewDeltaDecoder()
+	for _, it := range []struct {
+		body, wire, decoded string
+	}{
+		{`{"x":1,"y":2,"name":"player one","hp":100}`, `={"x":1,"y":2,"name":"player one","hp":100}`, `{"x":1,"y":2,"name":"player one","hp":100}`},
+		{`{"x":3,"y":2,"name":"player one","hp":100}`, `+{"x":3}`, `{"hp":100,"name":"player one","x":3,"y":2}`},
+		{`{"x":3,"name":"player one","hp":100,"pos":{"z":1}}`, `+{"pos":{"z":1},"y":null}`, `{"hp":100,"name":"player one","pos":{"z":1},"x":3}`},
+		{`{"x":3,"name":"player one","hp":null,"pos":{"z":1}}`, `={"x":3,"name":"player one","hp":null,"pos":{"z":1}}`, `{"x":3,"name":"player one","hp":null,"pos":{"z":1}}`},
+		{`hello`, `=hello`, `hello`},
+		{`{"a":12345678901234567890,"b":"hello"}`, `={"a":12345678901234567890,"b":"hello"}`, `{"a":12345678901234567890,"b":"hello"}`},
+		{`{"a":12345678901234567891,"b":"hello"}`, `+{"a":12345678901234567891}`, `{"a":12345678901234567891,"b":"hello"}`},
+	} {
+		encoded, err := encoder.Encode(NewPacketByString(MESSAGE, it.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(encoded.Data) != it.wire {
+			t.Errorf("bad wire: %s, expected %s", encoded.Data, it.wire)
+		}
+		decoded, err := decoder.Decode(encoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(decoded.Data) != it.decoded {
+			t.Errorf("bad decoded: %s, expected %s", decoded.Data, it.decoded)
+		}
+	}
+}
+
+func TestDelta_Reset(t *testing.T) {
+	encoder, decoder := NewDeltaEncoder(), NewDeltaDecoder()
+	encoder.Encode(NewPacketByString(MESSAGE, `{"a":"hello world","b":1}`))
+	patch, _ := encoder.Encode(NewPacketByString(MESSAGE, `{"a":"hello world","b":2}`))
+	if _, err := decoder.Decode(patch); !errors.Is(err, ErrInvalidFormat) {
+		t.Errorf("delta without previous message should fail: %v", err)
+	}
+	encoder.Reset()
+	full, _ := encoder.Encode(NewPacketByString(MESSAGE, `{"a":"hello world","b":2}`))
+	if full.Data[0] != deltaTagFull {
+		t.Error("first message after reset should be full")
+	}
+	ping := NewPacketCustom(PING, nil, 0)
+	if p, _ := encoder.Encode(ping); p != ping {
+		t.Error("ping should be left as is")
+	}
+}
@@ -0,0 +1,69 @@
+package parser
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// DumpLimit is the max count of body bytes rendered by Dump, the rest is omitted.
+var DumpLimit = 256
+
+// Dump renders a packet for debugging: type name, encoding, lengths and a hexdump of body bounded by DumpLimit.
+// The first line is like "message string len=5 encoded=6".
+func Dump(p *Packet) string {
+	sb := new(strings.Builder)
+	dumpTo(sb, p)
+	return sb.String()
+}
+
+// DumpPayload renders packets of a payload with Dump, each one is headed by its index.
+func DumpPayload(packets ...*Packet) string {
+	sb := new(strings.Builder)
+	fmt.Fprintf(sb, "payload packets=%d\n", len(packets))
+	for i, it := range packets {
+		fmt.Fprintf(sb, "#%d ", i)
+		dumpTo(sb, it)
+	}
+	return sb.String()
+}
+
+func dumpTo(sb *strings.Builder, p *Packet) {
+	if p == nil {
+		sb.WriteString("<nil>\n")
+		return
+	}
+	var encoding string
+	switch {
+	case p.Option&BINARY != BINARY:
+		encoding = "string"
+	case p.Option&BASE64 == BASE64:
+		encoding = "base64"
+	default:
+		encoding = "binary"
+	}
+	fmt.Fprintf(sb, "%s %s len=%d encoded=%d", p.Type, encoding, len(p.Data), EncodedLen(p))
+	if p.Options.NoCompress {
+		sb.WriteString(" nocompress")
+	}
+	if p.Options.Compress {
+		sb.WriteString(" compress")
+	}
+	if p.Options.Priority != 0 {
+		fmt.Fprintf(sb, " priority=%d", p.Options.Priority)
+	}
+	if p.Options.Seq != 0 {
+		fmt.Fprintf(sb, " seq=%d", p.Options.Seq)
+	}
+	sb.WriteByte('\n')
+	body := p.Data
+	if DumpLimit >= 0 && len(body) > DumpLimit {
+		body = body[:DumpLimit]
+	}
+	if len(body) > 0 {
+		sb.WriteString(hex.Dump(body))
+	}
+	if n := len(p.Data) - len(body); n > 0 {
+		fmt.Fprintf(sb, "... %d more bytes\n", n)
+	}
+}
@@ -0,0 +1,27 @@
+package parser
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestDump(t *testing.T) {
+	exp := "message string len=5 encoded=6\n00000000  68 65 6c 6c 6f                                    |hello|\n"
+	if got := Dump(NewPacket(MESSAGE, "hello")); got != exp {
+		t.Errorf("should be %q, got %q", exp, got)
+	}
+	packet := NewPacketCustom(PING, nil, BINARY|BASE64)
+	packet.Options = PacketOptions{NoCompress: true, Priority: 1}
+	if got := Dump(packet); got != "ping base64 len=0 encoded=2 nocompress priority=1\n" {
+		t.Error("bad dump:", got)
+	}
+	large := Dump(NewPacket(MESSAGE, bytes.Repeat([]byte{0xAB}, DumpLimit+10)))
+	if !strings.HasPrefix(large, "message binary len=266 encoded=267\n") || !strings.HasSuffix(large, "... 10 more bytes\n") {
+		t.Error("bad dump:", large)
+	}
+	payload := DumpPayload(NewPacket(PONG, "probe"), nil)
+	if !strings.HasPrefix(payload, "payload packets=2\n#0 pong string len=5") || !strings.HasSuffix(payload, "#1 <nil>\n") {
+		t.Error("bad dump:", payload)
+	}
+}
@@ -0,0 +1,91 @@
+package parser
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func benchPackets() []*Packet {
+	return []*Packet{
+		NewPacketByString(MESSAGE, strings.Repeat("hello world ", 8)),
+		NewPacketCustom(MESSAGE, []byte(strings.Repeat("binary", 16)), BINARY),
+		NewPacket(PING, nil),
+	}
+}
+
+func benchmarkPayloadEncode(b *testing.B, codec PayloadCodec) {
+	packets := benchPackets()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := codec.Encode(packets...); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func benchmarkPayloadEncodeAppend(b *testing.B, codec PayloadCodec) {
+	packets := benchPackets()
+	var dst []byte
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var err error
+		if dst, err = codec.EncodeAppend(dst[:0], packets...); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func benchmarkPayloadWriteTo(b *testing.B, codec PayloadCodec) {
+	packets := benchPackets()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := codec.WriteTo(ioutil.Discard, packets...); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEncodePayload(b *testing.B) {
+	packets := benchPackets()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := EncodePayload(packets...); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkWritePayloadTo(b *testing.B) {
+	packets := benchPackets()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := WritePayloadTo(ioutil.Discard, false, packets...); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkPayloadV3_Encode(b *testing.B)       { benchmarkPayloadEncode(b, ProtocolV3) }
+func BenchmarkPayloadV3_EncodeAppend(b *testing.B) { benchmarkPayloadEncodeAppend(b, ProtocolV3) }
+func BenchmarkPayloadV3_WriteTo(b *testing.B)      { benchmarkPayloadWriteTo(b, ProtocolV3) }
+
+func BenchmarkPayloadV3Binary_Encode(b *testing.B) { benchmarkPayloadEncode(b, ProtocolV3Binary) }
+func BenchmarkPayloadV3Binary_EncodeAppend(b *testing.B) {
+	benchmarkPayloadEncodeAppend(b, ProtocolV3Binary)
+}
+func BenchmarkPayloadV3Binary_WriteTo(b *testing.B) { benchmarkPayloadWriteTo(b, ProtocolV3Binary) }
+
+func BenchmarkPayloadV4_Encode(b *testing.B)       { benchmarkPayloadEncode(b, ProtocolV4) }
+func BenchmarkPayloadV4_EncodeAppend(b *testing.B) { benchmarkPayloadEncodeAppend(b, ProtocolV4) }
+func BenchmarkPayloadV4_WriteTo(b *testing.B)      { benchmarkPayloadWriteTo(b, ProtocolV4) }
+
+func BenchmarkPacket_Encode(b *testing.B) {
+	packet := benchPackets()[0]
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := Encode(packet); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package parser
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrEmptyPacket is returned when a packet is decoded from empty bytes.
+	ErrEmptyPacket = errors.New("parser: packet bytes is empty")
+	// ErrEmptyPayload is returned when a payload contains no packets.
+	ErrEmptyPayload = errors.New("parser: input packets is empty")
+	// ErrInvalidType is returned when the packet type is unknown.
+	ErrInvalidType = errors.New("parser: invalid packet type")
+	// ErrInvalidBase64 is returned when the body of a base64 packet is not legal base64 data.
+	ErrInvalidBase64 = errors.New("parser: invalid base64 data")
+	// ErrInvalidLength is returned when the length header of a payload is malformed.
+	ErrInvalidLength = errors.New("parser: invalid payload length")
+	// ErrPacketTooLarge is returned when a packet exceeds the max packet size.
+	ErrPacketTooLarge = errors.New("parser: packet is too large")
+	// ErrInvalidUTF8 is returned when the text of a string packet is not valid UTF-8.
+	ErrInvalidUTF8 = errors.New("parser: invalid UTF-8 text")
+	// ErrInvalidFormat is returned when the input doesn't follow the framing of the codec.
+	ErrInvalidFormat = errors.New("parser: invalid packet format")
+	// ErrEmptyBody is returned when a packet with empty body is rejected by the empty body policy.
+	ErrEmptyBody = errors.New("parser: packet body is empty")
+	// ErrChecksumMismatch is returned when the checksum trailer of a binary packet doesn't match its body.
+	ErrChecksumMismatch = errors.New("parser: checksum mismatch")
+	// ErrDecryptionFailed is returned when an encrypted body fails authentication, e.g. it's encrypted with another key.
+	ErrDecryptionFailed = errors.New("parser: message authentication failed")
+	// ErrTooManyFragmented is returned when a fragment starts a message while the reassembler has too many pending.
+	ErrTooManyFragmented = errors.New("parser: too many fragmented messages pending")
+)
+
+// DecodeError describes where and why decoding failed.
+// Use errors.Is with the sentinel errors of this package to check the cause.
+type DecodeError struct {
+	// Offset is the position in input where the error was found.
+	Offset int
+	// Expected describes the content the decoder wanted.
+	Expected string
+	// Got describes the content the decoder read.
+	Got string
+	// Err is the cause, usually one of the sentinel errors.
+	Err error
+}
+
+func (e *DecodeError) Error() string {
+	if len(e.Expected) < 1 && len(e.Got) < 1 {
+		return fmt.Sprintf("%s (offset %d)", e.Err, e.Offset)
+	}
+	return fmt.Sprintf("%s (offset %d): expected %s, got %s", e.Err, e.Offset, e.Expected, e.Got)
+}
+
+// Unwrap returns the cause of error.
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+func newDecodeError(cause error, offset int, expected, got string) *DecodeError {
+	return &DecodeError{
+		Offset:   offset,
+		Expected: expected,
+		Got:      got,
+		Err:      cause,
+	}
+}
+
+func errEmptyPacket(offset int) error {
+	return newDecodeError(ErrEmptyPacket, offset, "", "")
+}
+
+func errBase64(err error, offset int) error {
+	if pos, ok := err.(base64.CorruptInputError); ok {
+		return newDecodeError(ErrInvalidBase64, offset+int(pos), "base64 data", fmt.Sprintf("illegal data at %d", pos))
+	}
+	return newDecodeError(ErrInvalidBase64, offset, "base64 data", err.Error())
+}
+
+// withOffset shifts the offset of a decode error by base, used when a packet is decoded inside a payload.
+func withOffset(err error, base int) error {
+	if e, ok := err.(*DecodeError); ok && base != 0 {
+		return newDecodeError(e.Err, e.Offset+base, e.Expected, e.Got)
+	}
+	return err
+}
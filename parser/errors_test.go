@@ -0,0 +1,58 @@
+package parser
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDecodeErrors(t *testing.T) {
+	cases := []struct {
+		input  string
+		option PacketOption
+		cause  error
+		offset int
+	}{
+		{"", 0, ErrEmptyPacket, 0},
+		{"9hello", 0, ErrInvalidType, 0},
+		{"", BINARY, ErrEmptyPacket, 0},
+		{"\x09", BINARY, ErrInvalidType, 0},
+		{"x4AAAA", BINARY | BASE64, ErrInvalidFormat, 0},
+		{"b9AAAA", BINARY | BASE64, ErrInvalidType, 1},
+		{"b4AA!A", BINARY | BASE64, ErrInvalidBase64, 4},
+	}
+	for _, it := range cases {
+		_, err := Decode([]byte(it.input), it.option)
+		if !errors.Is(err, it.cause) {
+			t.Errorf("decode %q: error should be %v, got %v", it.input, it.cause, err)
+			continue
+		}
+		var e *DecodeError
+		if !errors.As(err, &e) {
+			t.Errorf("decode %q: should be a DecodeError", it.input)
+			continue
+		}
+		if e.Offset != it.offset {
+			t.Errorf("decode %q: offset should be %d, got %d", it.input, it.offset, e.Offset)
+		}
+	}
+}
+
+func TestDecodePayloadErrors(t *testing.T) {
+	_, err := DecodePayload([]byte("2:4a3:b4!"))
+	var e *DecodeError
+	if !errors.As(err, &e) || !errors.Is(err, ErrInvalidBase64) {
+		t.Fatal("should be illegal base64:", err)
+	}
+	if e.Offset != 8 {
+		t.Error("offset should be 8, got", e.Offset)
+	}
+	if _, err := DecodePayload([]byte("2:4a4a")); !errors.Is(err, ErrInvalidLength) {
+		t.Error("should be illegal length:", err)
+	}
+	if _, err := ProtocolV4.Decode([]byte("4a\x1e9")); !errors.As(err, &e) || e.Offset != 3 || !errors.Is(err, ErrInvalidType) {
+		t.Error("should be illegal type at 3:", err)
+	}
+	if _, err := Encode(NewPacket(PacketType(9), "a")); !errors.Is(err, ErrInvalidType) {
+		t.Error("should be illegal type:", err)
+	}
+}
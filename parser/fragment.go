@@ -0,0 +1,247 @@
+package parser
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+)
+
+// tags of MESSAGE bodies produced by Fragmenter, they are ASCII so string packets stay valid text.
+const (
+	fragmentTagWhole byte = '='
+	fragmentTagPart  byte = '~'
+)
+
+const (
+	// minFragmentSize is the least body length of a fragment, Fragmenter never produces an empty chunk.
+	// So a message of at most max bytes has at most max/minFragmentSize fragments.
+	minFragmentSize = 1
+	// maxFragments bounds the fragment count of messages of a reassembler without max size.
+	maxFragments = 1 << 20
+	// defaultMaxPending and defaultPendingTimeout are the limits of NewReassembler, see Reassembler.SetLimits.
+	defaultMaxPending     = 64
+	defaultPendingTimeout = 30 * time.Second
+)
+
+// Fragmenter splits MESSAGE packets whose body is larger than the frame size into numbered fragments,
+// Reassembler of the other peer joins them back.
+//
+// The body of every MESSAGE packet produced is tagged: '=' + body for a whole message,
+// or '~<id>.<index>.<count>:' + chunk for a fragment. Both of peers must use it.
+// Text bodies are split at character boundaries, so fragments of a string packet are valid text.
+type Fragmenter struct {
+	frameSize int
+	seq       uint32
+}
+
+// NewFragmenter returns a fragmenter which splits bodies into chunks of at most frameSize bytes.
+func NewFragmenter(frameSize int) *Fragmenter {
+	if frameSize < 1 {
+		panic(fmt.Errorf("parser: illegal frame size %d", frameSize))
+	}
+	return &Fragmenter{frameSize: frameSize}
+}
+
+// Split returns the packets to be sent instead of packet, packets other than MESSAGE are returned as is.
+func (p *Fragmenter) Split(packet *Packet) []*Packet {
+	if packet.Type != MESSAGE {
+		return []*Packet{packet}
+	}
+	if len(packet.Data) <= p.frameSize {
+		whole := NewPacketCustom(MESSAGE, append([]byte{fragmentTagWhole}, packet.Data...), packet.Option)
+		whole.Options = packet.Options
+		return []*Packet{whole}
+	}
+	text := packet.Option&BINARY != BINARY
+	chunks := make([][]byte, 0, len(packet.Data)/p.frameSize+1)
+	for rest := packet.Data; len(rest) > 0; {
+		n := p.frameSize
+		if n >= len(rest) {
+			n = len(rest)
+		} else if text {
+			// move back to the start of a character, a character longer than frame size is kept whole.
+			for n > 0 && !utf8.RuneStart(rest[n]) {
+				n--
+			}
+			if n == 0 {
+				_, n = utf8.DecodeRune(rest)
+			}
+		}
+		chunks = append(chunks, rest[:n])
+		rest = rest[n:]
+	}
+	id := strconv.FormatUint(uint64(atomic.AddUint32(&p.seq, 1)), 10)
+	count := strconv.Itoa(len(chunks))
+	packets := make([]*Packet, len(chunks))
+	for i, it := range chunks {
+		index := strconv.Itoa(i)
+		data := make([]byte, 0, len(id)+len(index)+len(count)+4+len(it))
+		data = append(data, fragmentTagPart)
+		data = append(data, id...)
+		data = append(append(data, '.'), index...)
+		data = append(append(data, '.'), count...)
+		data = append(append(data, ':'), it...)
+		packets[i] = NewPacketCustom(MESSAGE, data, packet.Option)
+		packets[i].Options = packet.Options
+	}
+	return packets
+}
+
+// Reassembler joins fragments produced by Fragmenter, it's safe for concurrent use.
+// Fragment headers come from the peer, so the count of fragments is bounded by the max size, and so are
+// the count of messages pending and how long they wait for the rest of their fragments.
+type Reassembler struct {
+	locker     sync.Mutex
+	maxSize    int
+	maxPending int
+	timeout    time.Duration
+	pending    map[uint64]*fragments
+}
+
+type fragments struct {
+	// parts are the chunks received by index, they're stored as they come rather than by the announced count.
+	parts    map[int][]byte
+	count    int
+	size     int
+	option   PacketOption
+	deadline time.Time
+}
+
+// NewReassembler returns a reassembler, maxSize is the max length in bytes of a reassembled body
+// and zero means unlimited. Messages exceeding it are dropped with ErrPacketTooLarge.
+// At most 64 messages are pending for 30 seconds each, see SetLimits.
+func NewReassembler(maxSize int) *Reassembler {
+	return &Reassembler{
+		maxSize:    maxSize,
+		maxPending: defaultMaxPending,
+		timeout:    defaultPendingTimeout,
+		pending:    make(map[uint64]*fragments),
+	}
+}
+
+// SetLimits define how many messages can be pending at once, the fragments starting more are dropped with
+// ErrTooManyFragmented, and how long a message waits for its fragments before it's dropped.
+// Zero means unlimited for both.
+func (p *Reassembler) SetLimits(maxPending int, timeout time.Duration) *Reassembler {
+	p.locker.Lock()
+	p.maxPending, p.timeout = maxPending, timeout
+	p.locker.Unlock()
+	return p
+}
+
+// Join consumes a received packet. It returns the whole message when a packet completes it,
+// or nil if more fragments are expected. Packets other than MESSAGE are returned as is.
+func (p *Reassembler) Join(packet *Packet) (*Packet, error) {
+	if packet.Type != MESSAGE {
+		return packet, nil
+	}
+	if len(packet.Data) < 1 {
+		return nil, newDecodeError(ErrInvalidFormat, 0, "fragment tag", "empty body")
+	}
+	switch packet.Data[0] {
+	default:
+		return nil, newDecodeError(ErrInvalidFormat, 0, "fragment tag '=' or '~'", fmt.Sprintf("%q", packet.Data[0]))
+	case fragmentTagWhole:
+		packet.Data = packet.Data[1:]
+		return packet, nil
+	case fragmentTagPart:
+	}
+	id, index, count, chunk, err := readFragmentHeader(packet.Data)
+	if err != nil {
+		return nil, err
+	}
+	if p.maxSize > 0 && count > p.maxSize/minFragmentSize {
+		return nil, newDecodeError(ErrPacketTooLarge, 1, fmt.Sprintf("at most %d fragments", p.maxSize/minFragmentSize), fmt.Sprintf("%d fragments", count))
+	}
+	if len(chunk) < minFragmentSize {
+		return nil, newDecodeError(ErrInvalidFormat, len(packet.Data), "fragment body", "empty body")
+	}
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	now := time.Now()
+	p.expire(now)
+	frags, ok := p.pending[id]
+	if !ok {
+		if p.maxPending > 0 && len(p.pending) >= p.maxPending {
+			return nil, newDecodeError(ErrTooManyFragmented, 0, fmt.Sprintf("at most %d messages", p.maxPending), fmt.Sprintf("fragment of message %d", id))
+		}
+		frags = &fragments{parts: make(map[int][]byte), count: count, option: packet.Option}
+		if p.timeout > 0 {
+			frags.deadline = now.Add(p.timeout)
+		}
+		p.pending[id] = frags
+	}
+	if frags.count != count || frags.parts[index] != nil {
+		delete(p.pending, id)
+		return nil, newDecodeError(ErrInvalidFormat, 1, fmt.Sprintf("fragment %d of %d", index, frags.count), "conflicting fragment")
+	}
+	frags.size += len(chunk)
+	if p.maxSize > 0 && frags.size > p.maxSize {
+		delete(p.pending, id)
+		return nil, newDecodeError(ErrPacketTooLarge, 0, fmt.Sprintf("at most %d bytes", p.maxSize), fmt.Sprintf("%d bytes", frags.size))
+	}
+	// the chunk is copied because packets may reference reused buffers.
+	frags.parts[index] = append([]byte{}, chunk...)
+	if len(frags.parts) < count {
+		return nil, nil
+	}
+	delete(p.pending, id)
+	body := make([]byte, 0, frags.size)
+	for i := 0; i < count; i++ {
+		body = append(body, frags.parts[i]...)
+	}
+	return NewPacketCustom(MESSAGE, body, frags.option), nil
+}
+
+// expire drops the messages pending beyond their deadlines, locker must be held.
+func (p *Reassembler) expire(now time.Time) {
+	for id, it := range p.pending {
+		if !it.deadline.IsZero() && now.After(it.deadline) {
+			delete(p.pending, id)
+		}
+	}
+}
+
+// Pending returns the count of messages waiting for more fragments.
+func (p *Reassembler) Pending() int {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	return len(p.pending)
+}
+
+// Reset drops all of incomplete messages, e.g. when the connection is closed.
+func (p *Reassembler) Reset() {
+	p.locker.Lock()
+	p.pending = make(map[uint64]*fragments)
+	p.locker.Unlock()
+}
+
+// readFragmentHeader parses '~<id>.<index>.<count>:' at the head of data.
+func readFragmentHeader(data []byte) (id uint64, index, count int, chunk []byte, err error) {
+	end := bytes.IndexByte(data, ':')
+	if end < 0 {
+		err = newDecodeError(ErrInvalidFormat, len(data), "':'", "end of body")
+		return
+	}
+	fields := bytes.Split(data[1:end], []byte{'.'})
+	if len(fields) != 3 {
+		err = newDecodeError(ErrInvalidFormat, 1, "<id>.<index>.<count>", fmt.Sprintf("%q", data[1:end]))
+		return
+	}
+	var n [3]uint64
+	for i, it := range fields {
+		if n[i], err = strconv.ParseUint(string(it), 10, 32); err != nil {
+			err = newDecodeError(ErrInvalidFormat, 1, "<id>.<index>.<count>", fmt.Sprintf("%q", data[1:end]))
+			return
+		}
+	}
+	if n[2] < 1 || n[1] >= n[2] || n[2] > maxFragments {
+		err = newDecodeError(ErrInvalidFormat, 1, "index less than count", fmt.Sprintf("%d of %d", n[1], n[2]))
+		return
+	}
+	return n[0], int(n[1]), int(n[2]), data[end+1:], nil
+}
@@ -0,0 +1,88 @@
+package parser
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestFragmentation(t *testing.T) {
+	fragmenter := NewFragmenter(10)
+	reassembler := NewReassembler(0)
+	short := NewPacket(MESSAGE, "hello")
+	text := NewPacket(MESSAGE, strings.Repeat("你好，世界!", 5))
+	blob := NewPacket(MESSAGE, bytes.Repeat([]byte{0x01, 0x02, 0x03}, 20))
+	for _, it := range []*Packet{short, text, blob, NewPacket(PING, "probe")} {
+		parts := fragmenter.Split(it)
+		if it == text || it == blob {
+			if len(parts) < 2 {
+				t.Fatal("should be split")
+			}
+		}
+		var joined *Packet
+		// deliver fragments in reverse order.
+		for i := len(parts) - 1; i >= 0; i-- {
+			if it == text && !utf8.Valid(parts[i].Data) {
+				t.Error("fragment of text should be valid text")
+			}
+			// fragments go through the wire.
+			bs, _ := Encode(parts[i])
+			decoded, _ := Decode(bs, parts[i].Option)
+			got, err := reassembler.Join(decoded)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if i > 0 && got != nil {
+				t.Error("message should be incomplete")
+			}
+			joined = got
+		}
+		if joined == nil || joined.Type != it.Type || joined.Option != it.Option || !bytes.Equal(joined.Data, it.Data) {
+			t.Errorf("should be %v, got %v", it, joined)
+		}
+	}
+	if reassembler.Pending() != 0 {
+		t.Error("no message should be pending")
+	}
+
+	limited := NewReassembler(15)
+	parts := fragmenter.Split(blob)
+	limited.Join(parts[0])
+	if _, err := limited.Join(parts[1]); !errors.Is(err, ErrPacketTooLarge) || limited.Pending() != 0 {
+		t.Error("should be too large:", err)
+	}
+	for _, it := range []string{"x", "~1.2.2:x", "~1.x.2:x", "~1.0.2", ""} {
+		if _, err := reassembler.Join(NewPacket(MESSAGE, it)); !errors.Is(err, ErrInvalidFormat) {
+			t.Errorf("%q should be invalid: %v", it, err)
+		}
+	}
+	reassembler.Join(NewPacket(MESSAGE, "~9.0.2:a"))
+	if _, err := reassembler.Join(NewPacket(MESSAGE, "~9.0.2:a")); !errors.Is(err, ErrInvalidFormat) {
+		t.Error("duplicate fragment should be invalid:", err)
+	}
+}
+
+func TestReassemblerLimits(t *testing.T) {
+	limited := NewReassembler(100).SetLimits(2, 50*time.Millisecond)
+	if _, err := limited.Join(NewPacket(MESSAGE, "~1.0.1000000:x")); !errors.Is(err, ErrPacketTooLarge) || limited.Pending() != 0 {
+		t.Error("count beyond the max size should be too large:", err)
+	}
+	if _, err := limited.Join(NewPacket(MESSAGE, "~1.0.2:")); !errors.Is(err, ErrInvalidFormat) {
+		t.Error("empty fragment should be invalid:", err)
+	}
+	limited.Join(NewPacket(MESSAGE, "~1.0.2:a"))
+	limited.Join(NewPacket(MESSAGE, "~2.0.2:a"))
+	if _, err := limited.Join(NewPacket(MESSAGE, "~3.0.2:a")); !errors.Is(err, ErrTooManyFragmented) || limited.Pending() != 2 {
+		t.Error("too many messages should be pending:", err)
+	}
+	if got, err := limited.Join(NewPacket(MESSAGE, "~1.1.2:b")); err != nil || got == nil || string(got.Data) != "ab" {
+		t.Errorf("pending message should be joined: %v %v", got, err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if _, err := limited.Join(NewPacket(MESSAGE, "~3.0.2:a")); err != nil || limited.Pending() != 1 {
+		t.Errorf("expired message should be dropped: %d %v", limited.Pending(), err)
+	}
+}
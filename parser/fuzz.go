@@ -0,0 +1,86 @@
+package parser
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// FuzzDecode is the go-fuzz entry point of packet codecs.
+// It decodes data with every packet codec and checks that a decoded packet survives a round trip,
+// it panics if an invariant is broken. It returns 1 if data is decoded by any codec, 0 otherwise.
+func FuzzDecode(data []byte) int {
+	var ret int
+	for _, it := range []Codec{stringEncoder, binaryEncoder, base64Encoder, msgpackEncoder, cborEncoder} {
+		ret |= fuzzCodec(it, data)
+	}
+	return ret
+}
+
+// FuzzDecodePayload is the go-fuzz entry point of payload codecs, see FuzzDecode.
+// The streaming decoder is run against data too, but its results are not compared.
+func FuzzDecodePayload(data []byte) int {
+	var ret int
+	for _, options := range []CodecOptions{{}, {Strict: true}, {UTF8: UTF8JavaScript}} {
+		for _, it := range []PayloadCodec{NewPayloadCodecV3(false, options), NewPayloadCodecV3(true, options), NewPayloadCodecV4(options)} {
+			ret |= fuzzPayloadCodec(it, data)
+		}
+		decoder := NewDecoderWithOptions(bytes.NewReader(data), options)
+		for decoder.More() {
+			if _, err := decoder.Decode(); err != nil {
+				break
+			}
+		}
+	}
+	return ret
+}
+
+func fuzzCodec(codec Codec, data []byte) int {
+	packet, err := codec.Decode(data)
+	if err != nil {
+		return 0
+	}
+	encoded, err := codec.Encode(packet)
+	if err != nil {
+		panic(fmt.Errorf("parser: encode decoded packet %v failed: %w", packet, err))
+	}
+	if n := codec.EncodedLen(packet); n != len(encoded) {
+		panic(fmt.Errorf("parser: encoded length of %v is %d, EncodedLen returns %d", packet, len(encoded), n))
+	}
+	again, err := codec.Decode(encoded)
+	if err != nil {
+		panic(fmt.Errorf("parser: decode encoded packet %q failed: %w", encoded, err))
+	}
+	if !samePacket(packet, again) {
+		panic(fmt.Errorf("parser: packet %v changed to %v after round trip", packet, again))
+	}
+	return 1
+}
+
+func fuzzPayloadCodec(codec PayloadCodec, data []byte) int {
+	packets, err := codec.Decode(data)
+	if err != nil || len(packets) < 1 {
+		return 0
+	}
+	encoded, err := codec.Encode(packets...)
+	if err != nil {
+		panic(fmt.Errorf("parser: encode decoded payload failed: %w", err))
+	}
+	again, err := codec.Decode(encoded)
+	if err != nil {
+		panic(fmt.Errorf("parser: decode encoded payload %q failed: %w", encoded, err))
+	}
+	if len(again) != len(packets) {
+		panic(fmt.Errorf("parser: %d packets changed to %d after round trip", len(packets), len(again)))
+	}
+	for i := range packets {
+		if !samePacket(packets[i], again[i]) {
+			panic(fmt.Errorf("parser: packet %v changed to %v after round trip", packets[i], again[i]))
+		}
+	}
+	return 1
+}
+
+// samePacket compares type, data and binary flag of packets.
+func samePacket(a, b *Packet) bool {
+	return a.Type == b.Type && a.Option&BINARY == b.Option&BINARY && bytes.Equal(a.Data, b.Data)
+}
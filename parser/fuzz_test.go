@@ -0,0 +1,26 @@
+//go:build go1.18
+// +build go1.18
+
+package parser
+
+import (
+	"testing"
+)
+
+func FuzzPacketCodecs(f *testing.F) {
+	for _, it := range []string{"4hello", "2probe", "b4AQIDBA==", "b4aGk", "\x04\x01\x02", "\x92\x04\xa2hi", "\x82\x04\x41\x01", ""} {
+		f.Add([]byte(it))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		FuzzDecode(data)
+	})
+}
+
+func FuzzPayloadCodecs(f *testing.F) {
+	for _, it := range []string{"6:4hello1:2", "10:b4AQIDBA==", "2:4€", "\x00\x06\xff4hello\x01\x04\xff\x04\x01\x02\x03", "4hello\x1ebAQIDBA==", "3:4\xed\xa0\x80"} {
+		f.Add([]byte(it))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		FuzzDecodePayload(data)
+	})
+}
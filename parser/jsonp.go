@@ -0,0 +1,86 @@
+package parser
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+var (
+	jsonpHead = []byte("___eio[")
+	jsonpTail = []byte("\");")
+)
+
+// jsonpReplacer escapes a payload as the content of a JS string literal,
+// it follows JSON.stringify and also escapes U+2028/U+2029 which are line terminators in JS.
+var jsonpReplacer = func() *strings.Replacer {
+	pairs := []string{"\\", "\\\\", "\"", "\\\"", "\n", "\\n", "\r", "\\r", "\t", "\\t", "\b", "\\b", "\f", "\\f",
+		"\u2028", "\\u2028", "\u2029", "\\u2029"}
+	for c := 0; c < 0x20; c++ {
+		switch c {
+		case '\n', '\r', '\t', '\b', '\f':
+			continue
+		}
+		pairs = append(pairs, string(rune(c)), fmt.Sprintf("\\u%04x", c))
+	}
+	return strings.NewReplacer(pairs...)
+}()
+
+// WriteJSONPTo wrap a payload with the framing of JSONP polling and write to writer.
+// The output is ___eio[<index>]("<escaped payload>"); where non-digits of index are dropped.
+func WriteJSONPTo(writer io.Writer, index string, payload []byte) error {
+	if _, err := writer.Write(jsonpHead); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(writer, jsonpIndex(index)); err != nil {
+		return err
+	}
+	if _, err := writer.Write([]byte("](\"")); err != nil {
+		return err
+	}
+	if _, err := jsonpReplacer.WriteString(writer, string(payload)); err != nil {
+		return err
+	}
+	_, err := writer.Write(jsonpTail)
+	return err
+}
+
+// EncodeJSONP wrap a payload with the framing of JSONP polling.
+func EncodeJSONP(index string, payload []byte) []byte {
+	bf := new(bytes.Buffer)
+	WriteJSONPTo(bf, index, payload)
+	return bf.Bytes()
+}
+
+// DecodeJSONP restores the payload posted by JSONP polling clients in the form field 'd'.
+// Clients escape newlines as "\n" and escaped newlines as "\\n" to keep them from being mangled by user agents.
+func DecodeJSONP(data []byte) []byte {
+	if bytes.IndexByte(data, '\\') < 0 {
+		return data
+	}
+	out := make([]byte, 0, len(data))
+	for i := 0; i < len(data); i++ {
+		switch {
+		case data[i] == '\\' && i+2 < len(data) && data[i+1] == '\\' && data[i+2] == 'n':
+			out = append(out, '\\', 'n')
+			i += 2
+		case data[i] == '\\' && i+1 < len(data) && data[i+1] == 'n':
+			out = append(out, '\n')
+			i++
+		default:
+			out = append(out, data[i])
+		}
+	}
+	return out
+}
+
+// jsonpIndex keeps digits of the JSONP callback index only, so it's safe to be put into script.
+func jsonpIndex(index string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, index)
+}
@@ -0,0 +1,35 @@
+package parser
+
+import (
+	"testing"
+)
+
+func TestEncodeJSONPFraming(t *testing.T) {
+	payload, err := EncodePayload(NewPacket(MESSAGE, "say \"hi\"\nback\\slash\u2028\u2029\x01"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := `___eio[3]("23:4say \"hi\"\nback\\slash\u2028\u2029\u0001");`
+	if got := string(EncodeJSONP("3", payload)); got != exp {
+		t.Errorf("should be %s, got %s", exp, got)
+	}
+	if got := string(EncodeJSONP("1</script>", []byte("1:2"))); got != `___eio[1]("1:2");` {
+		t.Error("index should be sanitized:", got)
+	}
+}
+
+func TestDecodeJSONP(t *testing.T) {
+	cases := map[string]string{
+		`8:4line\nx`: "8:4line\nx",
+		`9:4esc\\nx`: `9:4esc\nx`,
+		`5:4abc`:     "5:4abc",
+		`4:4tail\`:   `4:4tail\`,
+		`2:4\x`:      `2:4\x`,
+		`3:4\n\\n`:   "3:4\n\\n",
+	}
+	for input, exp := range cases {
+		if got := string(DecodeJSONP([]byte(input))); got != exp {
+			t.Errorf("decode %q: should be %q, got %q", input, exp, got)
+		}
+	}
+}
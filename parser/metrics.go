@@ -0,0 +1,353 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// names of the metrics of built-in codecs, see Protocol.PacketCodec and Protocol.PayloadCodec.
+// The package level payload functions and Decoder are counted as MetricsPayloadV3.
+const (
+	MetricsV3              = "v3"
+	MetricsV3Binary        = "v3-binary"
+	MetricsV4              = "v4"
+	MetricsV4Binary        = "v4-binary"
+	MetricsPayloadV3       = "payload-v3"
+	MetricsPayloadV3Binary = "payload-v3-binary"
+	MetricsPayloadV4       = "payload-v4"
+)
+
+// errorKinds are the causes of errors counted by CodecStats.Errors, other errors are counted as "other".
+var errorKinds = [...]struct {
+	name string
+	err  error
+}{
+	{"empty_packet", ErrEmptyPacket},
+	{"empty_payload", ErrEmptyPayload},
+	{"empty_body", ErrEmptyBody},
+	{"invalid_type", ErrInvalidType},
+	{"invalid_base64", ErrInvalidBase64},
+	{"invalid_length", ErrInvalidLength},
+	{"packet_too_large", ErrPacketTooLarge},
+	{"invalid_utf8", ErrInvalidUTF8},
+	{"invalid_format", ErrInvalidFormat},
+	{"checksum_mismatch", ErrChecksumMismatch},
+	{"decryption_failed", ErrDecryptionFailed},
+	{"other", nil},
+}
+
+// CodecStats is a snapshot of the counters of a codec.
+// The difference of EncodedBytes and EncodedBodyBytes is the framing overhead, e.g. of base64.
+type CodecStats struct {
+	// PacketsEncoded is the count of packets encoded.
+	PacketsEncoded uint64
+	// PacketsDecoded is the count of packets decoded.
+	PacketsDecoded uint64
+	// EncodedBytes is the length in bytes of encoded output.
+	EncodedBytes uint64
+	// DecodedBytes is the length in bytes of decoded input.
+	DecodedBytes uint64
+	// EncodedBodyBytes is the length in bytes of bodies of packets encoded.
+	EncodedBodyBytes uint64
+	// DecodedBodyBytes is the length in bytes of bodies of packets decoded.
+	DecodedBodyBytes uint64
+	// Errors is the count of encode and decode errors by cause, e.g. "invalid_type" for ErrInvalidType.
+	Errors map[string]uint64
+}
+
+// MetricsSource is implemented by the counters of codecs, metrics subsystems scrape it.
+type MetricsSource interface {
+	// Stats returns a snapshot of counters.
+	Stats() CodecStats
+}
+
+// Metrics counts the packets, bytes and errors of codecs, it's safe for concurrent use.
+type Metrics struct {
+	packetsEncoded, packetsDecoded     uint64
+	encodedBytes, decodedBytes         uint64
+	encodedBodyBytes, decodedBodyBytes uint64
+	errors                             [len(errorKinds)]uint64
+}
+
+// Stats returns a snapshot of counters.
+func (p *Metrics) Stats() CodecStats {
+	stats := CodecStats{
+		PacketsEncoded:   atomic.LoadUint64(&p.packetsEncoded),
+		PacketsDecoded:   atomic.LoadUint64(&p.packetsDecoded),
+		EncodedBytes:     atomic.LoadUint64(&p.encodedBytes),
+		DecodedBytes:     atomic.LoadUint64(&p.decodedBytes),
+		EncodedBodyBytes: atomic.LoadUint64(&p.encodedBodyBytes),
+		DecodedBodyBytes: atomic.LoadUint64(&p.decodedBodyBytes),
+		Errors:           make(map[string]uint64),
+	}
+	for i, it := range errorKinds {
+		if n := atomic.LoadUint64(&p.errors[i]); n > 0 {
+			stats.Errors[it.name] = n
+		}
+	}
+	return stats
+}
+
+func (p *Metrics) encoded(packets, body, n int) {
+	atomic.AddUint64(&p.packetsEncoded, uint64(packets))
+	atomic.AddUint64(&p.encodedBodyBytes, uint64(body))
+	atomic.AddUint64(&p.encodedBytes, uint64(n))
+}
+
+func (p *Metrics) decoded(packets, body, n int) {
+	atomic.AddUint64(&p.packetsDecoded, uint64(packets))
+	atomic.AddUint64(&p.decodedBodyBytes, uint64(body))
+	atomic.AddUint64(&p.decodedBytes, uint64(n))
+}
+
+// bodyLen returns the total length of bodies of packets, it's taken before encoding
+// because a streamed body is consumed.
+func bodyLen(packets []*Packet) int {
+	var n int
+	for _, it := range packets {
+		n += dataLen(it)
+	}
+	return n
+}
+
+// failed counts err by its cause and returns it as is.
+func (p *Metrics) failed(err error) error {
+	for i, it := range errorKinds {
+		if it.err == nil || errors.Is(err, it.err) {
+			atomic.AddUint64(&p.errors[i], 1)
+			break
+		}
+	}
+	return err
+}
+
+var metricsRegistry = struct {
+	sync.RWMutex
+	m map[string]*Metrics
+}{m: make(map[string]*Metrics)}
+
+// RegisterMetrics makes metrics available by the provided name for scraping.
+// It panics if the name is blank, the metrics is nil or the name is registered already.
+func RegisterMetrics(name string, m *Metrics) {
+	if len(name) < 1 {
+		panic("parser: register metrics with blank name")
+	}
+	if m == nil {
+		panic(fmt.Errorf("parser: register nil metrics '%s'", name))
+	}
+	metricsRegistry.Lock()
+	defer metricsRegistry.Unlock()
+	if _, ok := metricsRegistry.m[name]; ok {
+		panic(fmt.Errorf("parser: metrics '%s' exists already", name))
+	}
+	metricsRegistry.m[name] = m
+}
+
+// LookupMetrics returns the metrics registered with the name.
+func LookupMetrics(name string) (*Metrics, bool) {
+	metricsRegistry.RLock()
+	defer metricsRegistry.RUnlock()
+	m, ok := metricsRegistry.m[name]
+	return m, ok
+}
+
+// AllStats returns snapshots of all of registered metrics by name.
+func AllStats() map[string]CodecStats {
+	metricsRegistry.RLock()
+	defer metricsRegistry.RUnlock()
+	stats := make(map[string]CodecStats, len(metricsRegistry.m))
+	for name, it := range metricsRegistry.m {
+		stats[name] = it.Stats()
+	}
+	return stats
+}
+
+// MetricsNames returns a sorted list of the names of registered metrics.
+func MetricsNames() []string {
+	metricsRegistry.RLock()
+	defer metricsRegistry.RUnlock()
+	names := make([]string, 0, len(metricsRegistry.m))
+	for name := range metricsRegistry.m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// mustMetrics returns the metrics registered with the name, it's used for built-in metrics.
+func mustMetrics(name string) *Metrics {
+	m, ok := LookupMetrics(name)
+	if !ok {
+		m = new(Metrics)
+		RegisterMetrics(name, m)
+	}
+	return m
+}
+
+// NewMeteredCodec returns a codec which counts the packets, bytes and errors of inner into metrics.
+func NewMeteredCodec(inner Codec, metrics *Metrics) Codec {
+	return &meteredCodec{inner: inner, metrics: metrics}
+}
+
+type meteredCodec struct {
+	inner   Codec
+	metrics *Metrics
+}
+
+func (p *meteredCodec) Decode(data []byte) (*Packet, error) {
+	packet, err := p.inner.Decode(data)
+	if err != nil {
+		return nil, p.metrics.failed(err)
+	}
+	p.metrics.decoded(1, len(packet.Data), len(data))
+	return packet, nil
+}
+
+func (p *meteredCodec) WriteTo(writer io.Writer, packet *Packet) error {
+	counter := acquireCountingWriter(writer)
+	defer releaseCountingWriter(counter)
+	body := dataLen(packet)
+	if err := p.inner.WriteTo(counter, packet); err != nil {
+		return p.metrics.failed(err)
+	}
+	p.metrics.encoded(1, body, counter.n)
+	return nil
+}
+
+func (p *meteredCodec) Encode(packet *Packet) ([]byte, error) {
+	body := dataLen(packet)
+	bs, err := p.inner.Encode(packet)
+	if err != nil {
+		return nil, p.metrics.failed(err)
+	}
+	p.metrics.encoded(1, body, len(bs))
+	return bs, nil
+}
+
+func (p *meteredCodec) EncodeAppend(dst []byte, packet *Packet) ([]byte, error) {
+	l, body := len(dst), dataLen(packet)
+	dst, err := p.inner.EncodeAppend(dst, packet)
+	if err != nil {
+		return dst, p.metrics.failed(err)
+	}
+	p.metrics.encoded(1, body, len(dst)-l)
+	return dst, nil
+}
+
+func (p *meteredCodec) EncodedLen(packet *Packet) int {
+	return p.inner.EncodedLen(packet)
+}
+
+// NewMeteredPayloadCodec returns a payload codec which counts the packets, bytes and errors of inner into metrics.
+func NewMeteredPayloadCodec(inner PayloadCodec, metrics *Metrics) PayloadCodec {
+	return &meteredPayloadCodec{inner: inner, metrics: metrics}
+}
+
+type meteredPayloadCodec struct {
+	inner   PayloadCodec
+	metrics *Metrics
+}
+
+func (p *meteredPayloadCodec) Encode(packets ...*Packet) ([]byte, error) {
+	body := bodyLen(packets)
+	bs, err := p.inner.Encode(packets...)
+	if err != nil {
+		return nil, p.metrics.failed(err)
+	}
+	p.metrics.encoded(len(packets), body, len(bs))
+	return bs, nil
+}
+
+func (p *meteredPayloadCodec) EncodeAppend(dst []byte, packets ...*Packet) ([]byte, error) {
+	l, body := len(dst), bodyLen(packets)
+	dst, err := p.inner.EncodeAppend(dst, packets...)
+	if err != nil {
+		return dst, p.metrics.failed(err)
+	}
+	p.metrics.encoded(len(packets), body, len(dst)-l)
+	return dst, nil
+}
+
+func (p *meteredPayloadCodec) WriteTo(writer io.Writer, packets ...*Packet) error {
+	counter := acquireCountingWriter(writer)
+	defer releaseCountingWriter(counter)
+	body := bodyLen(packets)
+	if err := p.inner.WriteTo(counter, packets...); err != nil {
+		return p.metrics.failed(err)
+	}
+	p.metrics.encoded(len(packets), body, counter.n)
+	return nil
+}
+
+func (p *meteredPayloadCodec) EncodeBuffers(packets ...*Packet) (net.Buffers, error) {
+	body := bodyLen(packets)
+	bufs, err := p.inner.EncodeBuffers(packets...)
+	if err != nil {
+		return nil, p.metrics.failed(err)
+	}
+	var n int
+	for _, it := range bufs {
+		n += len(it)
+	}
+	p.metrics.encoded(len(packets), body, n)
+	return bufs, nil
+}
+
+func (p *meteredPayloadCodec) Decode(input []byte) ([]*Packet, error) {
+	packets, err := p.inner.Decode(input)
+	if err != nil {
+		return nil, p.metrics.failed(err)
+	}
+	p.metrics.decoded(len(packets), bodyLen(packets), len(input))
+	return packets, nil
+}
+
+func (p *meteredPayloadCodec) DecodeFunc(input []byte, fn func(*Packet) error) error {
+	var count, body int
+	err := p.inner.DecodeFunc(input, func(packet *Packet) error {
+		count++
+		body += len(packet.Data)
+		return fn(packet)
+	})
+	if err != nil {
+		p.metrics.decoded(count, body, 0)
+		return p.metrics.failed(err)
+	}
+	p.metrics.decoded(count, body, len(input))
+	return nil
+}
+
+// countingWriter counts the bytes written to writer.
+type countingWriter struct {
+	writer io.Writer
+	n      int
+}
+
+// countingWriters are pooled so counting a write doesn't allocate.
+var countingWriters = sync.Pool{
+	New: func() interface{} {
+		return new(countingWriter)
+	},
+}
+
+func acquireCountingWriter(writer io.Writer) *countingWriter {
+	counter := countingWriters.Get().(*countingWriter)
+	counter.writer, counter.n = writer, 0
+	return counter
+}
+
+func releaseCountingWriter(counter *countingWriter) {
+	counter.writer = nil
+	countingWriters.Put(counter)
+}
+
+func (p *countingWriter) Write(b []byte) (int, error) {
+	n, err := p.writer.Write(b)
+	p.n += n
+	return n, err
+}
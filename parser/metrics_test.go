@@ -0,0 +1,91 @@
+package parser
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestMeteredCodec(t *testing.T) {
+	metrics := new(Metrics)
+	codec := NewMeteredCodec(base64Encoder, metrics)
+	packet := NewPacketCustom(MESSAGE, []byte{1, 2, 3, 4, 5, 6}, BINARY)
+	bs, err := codec.Encode(packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := codec.WriteTo(new(bytes.Buffer), packet); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := codec.Decode(bs); err != nil {
+		t.Fatal(err)
+	}
+	codec.Decode([]byte("b9AAAA"))
+	codec.Decode([]byte("b4!!"))
+	stats := metrics.Stats()
+	if stats.PacketsEncoded != 2 || stats.EncodedBytes != 20 || stats.EncodedBodyBytes != 12 {
+		t.Errorf("bad encode stats: %+v", stats)
+	}
+	if stats.PacketsDecoded != 1 || stats.DecodedBytes != 10 || stats.DecodedBodyBytes != 6 {
+		t.Errorf("bad decode stats: %+v", stats)
+	}
+	if stats.Errors["invalid_type"] != 1 || stats.Errors["invalid_base64"] != 1 || len(stats.Errors) != 2 {
+		t.Errorf("bad errors: %v", stats.Errors)
+	}
+}
+
+func TestMeteredPayloadCodec(t *testing.T) {
+	metrics := new(Metrics)
+	codec := NewMeteredPayloadCodec(NewPayloadCodecV4(CodecOptions{}), metrics)
+	bs, err := codec.Encode(NewPacketByString(MESSAGE, "hello"), NewPacketCustom(PING, nil, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop := errors.New("stop")
+	codec.DecodeFunc(bs, func(*Packet) error { return stop })
+	if _, err := codec.Decode(bs); err != nil {
+		t.Fatal(err)
+	}
+	stats := metrics.Stats()
+	if stats.PacketsEncoded != 2 || stats.EncodedBytes != uint64(len(bs)) || stats.EncodedBodyBytes != 5 {
+		t.Errorf("bad encode stats: %+v", stats)
+	}
+	if stats.PacketsDecoded != 3 || stats.DecodedBytes != uint64(len(bs)) {
+		t.Errorf("bad decode stats: %+v", stats)
+	}
+	if stats.Errors["other"] != 1 {
+		t.Errorf("bad errors: %v", stats.Errors)
+	}
+}
+
+func TestBuiltinMetrics(t *testing.T) {
+	names := MetricsNames()
+	for _, it := range []string{MetricsV3, MetricsV3Binary, MetricsV4, MetricsV4Binary, MetricsPayloadV3, MetricsPayloadV3Binary, MetricsPayloadV4} {
+		if _, ok := LookupMetrics(it); !ok {
+			t.Errorf("metrics %s is not registered: %v", it, names)
+		}
+	}
+	before := AllStats()[MetricsPayloadV3]
+	if _, err := DecodePayload([]byte("6:4hello")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := V3.PacketCodec(true).Encode(NewPacketByString(MESSAGE, "hello")); err != nil {
+		t.Fatal(err)
+	}
+	after := AllStats()[MetricsPayloadV3]
+	if after.PacketsDecoded != before.PacketsDecoded+1 || after.DecodedBytes != before.DecodedBytes+8 {
+		t.Errorf("package functions should be counted: %+v -> %+v", before, after)
+	}
+	if V3.Metrics(true).Stats().PacketsEncoded < 1 {
+		t.Error("protocol codecs should be counted")
+	}
+}
+
+func TestRegisterMetrics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a name twice should panic")
+		}
+	}()
+	RegisterMetrics(MetricsV3, new(Metrics))
+}
@@ -0,0 +1,202 @@
+package parser
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// msgpack format bytes used by the envelope.
+const (
+	mpFixArray2 byte = 0x92
+	mpNil       byte = 0xc0
+	mpBin8      byte = 0xc4
+	mpBin16     byte = 0xc5
+	mpBin32     byte = 0xc6
+	mpUint8     byte = 0xcc
+	mpStr8      byte = 0xd9
+	mpStr16     byte = 0xda
+	mpStr32     byte = 0xdb
+	mpFixStr    byte = 0xa0
+)
+
+// NewMsgpackCodec returns a MessagePack packet codec with options.
+//
+// A packet is encoded as a msgpack array of two elements: the packet type as a positive integer
+// and the body, which is bin for binary packets and str for string packets, nil for an empty body is accepted.
+// It's meant to be used over binary frames by non-browser clients.
+func NewMsgpackCodec(options CodecOptions) Codec {
+	return &msgpackCodec{options: options}
+}
+
+type msgpackCodec struct {
+	options CodecOptions
+}
+
+func (p *msgpackCodec) Decode(data []byte) (*Packet, error) {
+	if len(data) < 1 {
+		return nil, errEmptyPacket(0)
+	}
+	if err := p.options.checkSize(len(data), 0); err != nil {
+		return nil, err
+	}
+	if data[0] != mpFixArray2 {
+		return nil, newDecodeError(ErrInvalidFormat, 0, "msgpack array of 2", fmt.Sprintf("0x%02X", data[0]))
+	}
+	if len(data) < 3 {
+		return nil, newDecodeError(ErrInvalidFormat, len(data), "packet type and body", "end of packet")
+	}
+	var t PacketType
+	offset := 1
+	switch c := data[offset]; {
+	case c < 0x80:
+		t = PacketType(c)
+		offset++
+	case c == mpUint8 && len(data) > 3:
+		t = PacketType(data[offset+1])
+		offset += 2
+	default:
+		return nil, newDecodeError(ErrInvalidType, offset, "msgpack positive integer", fmt.Sprintf("0x%02X", c))
+	}
+	if !validType(t) {
+		return nil, newDecodeError(ErrInvalidType, 1, "packet type 0-6", fmt.Sprintf("%d", t))
+	}
+	var size int
+	var opt PacketOption
+	header := data[offset]
+	switch {
+	case header == mpNil:
+		size = 0
+	case header&0xe0 == mpFixStr:
+		size = int(header & 0x1f)
+	case header == mpBin8 || header == mpStr8:
+		size = int(readUint(data[offset+1:], 1))
+	case header == mpBin16 || header == mpStr16:
+		size = int(readUint(data[offset+1:], 2))
+	case header == mpBin32 || header == mpStr32:
+		size = int(readUint(data[offset+1:], 4))
+	default:
+		return nil, newDecodeError(ErrInvalidFormat, offset, "msgpack bin, str or nil", fmt.Sprintf("0x%02X", header))
+	}
+	if header == mpBin8 || header == mpBin16 || header == mpBin32 {
+		opt = BINARY
+	}
+	offset += 1 + bodyHeaderLen(header)
+	if size < 0 || offset > len(data) || len(data)-offset != size {
+		return nil, newDecodeError(ErrInvalidLength, offset, fmt.Sprintf("%d bytes of body", size), fmt.Sprintf("%d bytes", len(data)-offset))
+	}
+	body := data[offset:]
+	if opt != BINARY {
+		text, err := p.options.UTF8.decodeText(body, offset)
+		if err != nil {
+			return nil, err
+		}
+		body = text
+	}
+	return p.options.newPacket(t, body, opt)
+}
+
+func (p *msgpackCodec) WriteTo(writer io.Writer, packet *Packet) error {
+	bs, err := p.Encode(packet)
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write(bs)
+	return err
+}
+
+func (p *msgpackCodec) Encode(packet *Packet) ([]byte, error) {
+	return p.EncodeAppend(make([]byte, 0, p.EncodedLen(packet)), packet)
+}
+
+func (p *msgpackCodec) EncodeAppend(dst []byte, packet *Packet) ([]byte, error) {
+	if !validType(packet.Type) {
+		return dst, fmt.Errorf("%w: %d", ErrInvalidType, packet.Type)
+	}
+	if err := packet.ReadBody(); err != nil {
+		return dst, err
+	}
+	data := packet.Data
+	if packet.Option&BINARY != BINARY {
+		text, err := p.options.UTF8.encodeText(data)
+		if err != nil {
+			return dst, err
+		}
+		data = text
+	}
+	dst = append(dst, mpFixArray2, byte(packet.Type))
+	dst = appendBodyHeader(dst, len(data), packet.Option&BINARY == BINARY)
+	return append(dst, data...), nil
+}
+
+func (p *msgpackCodec) EncodedLen(packet *Packet) int {
+	packet.ReadBody()
+	data := packet.Data
+	if packet.Option&BINARY != BINARY && p.options.UTF8 != UTF8Raw && p.options.UTF8 != UTF8Strict {
+		data, _ = p.options.UTF8.encodeText(data)
+	}
+	return 3 + bodyHeaderLen(bodyHeader(len(data), packet.Option&BINARY == BINARY)) + len(data)
+}
+
+// bodyHeader returns the format byte of a body of size bytes.
+func bodyHeader(size int, bin bool) byte {
+	switch {
+	case !bin && size < 32:
+		return mpFixStr | byte(size)
+	case size <= 0xff && bin:
+		return mpBin8
+	case size <= 0xff:
+		return mpStr8
+	case size <= 0xffff && bin:
+		return mpBin16
+	case size <= 0xffff:
+		return mpStr16
+	case bin:
+		return mpBin32
+	default:
+		return mpStr32
+	}
+}
+
+// bodyHeaderLen returns the count of length bytes following the format byte.
+func bodyHeaderLen(header byte) int {
+	switch header {
+	case mpBin8, mpStr8:
+		return 1
+	case mpBin16, mpStr16:
+		return 2
+	case mpBin32, mpStr32:
+		return 4
+	default:
+		return 0
+	}
+}
+
+func appendBodyHeader(dst []byte, size int, bin bool) []byte {
+	header := bodyHeader(size, bin)
+	dst = append(dst, header)
+	switch bodyHeaderLen(header) {
+	case 1:
+		dst = append(dst, byte(size))
+	case 2:
+		dst = append(dst, byte(size>>8), byte(size))
+	case 4:
+		dst = append(dst, byte(size>>24), byte(size>>16), byte(size>>8), byte(size))
+	}
+	return dst
+}
+
+// readUint reads a big-endian unsigned integer of n bytes, it returns -1 if b is too short.
+func readUint(b []byte, n int) int64 {
+	if len(b) < n {
+		return -1
+	}
+	switch n {
+	case 1:
+		return int64(b[0])
+	case 2:
+		return int64(binary.BigEndian.Uint16(b))
+	default:
+		return int64(binary.BigEndian.Uint32(b))
+	}
+}
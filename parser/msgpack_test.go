@@ -0,0 +1,70 @@
+package parser
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestMsgpackCodec(t *testing.T) {
+	codec, ok := LookupCodec(CodecMsgpack)
+	if !ok {
+		t.Fatal("msgpack codec should be registered")
+	}
+	long := strings.Repeat("x", 300)
+	packets := []*Packet{
+		NewPacket(PING, "probe"),
+		NewPacket(MESSAGE, []byte{0x00, 0x01, 0xFF}),
+		NewPacket(MESSAGE, long),
+		NewPacket(MESSAGE, bytes.Repeat([]byte{0xAB}, 70000)),
+		NewPacketCustom(NOOP, nil, 0),
+	}
+	for _, it := range packets {
+		bs, err := codec.Encode(it)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(bs) != codec.EncodedLen(it) {
+			t.Errorf("encoded length should be %d, got %d", codec.EncodedLen(it), len(bs))
+		}
+		decoded, err := codec.Decode(bs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decoded.Type != it.Type || decoded.Option != it.Option || !bytes.Equal(decoded.Data, it.Data) {
+			t.Errorf("should be %v, got %v", it, decoded)
+		}
+	}
+	bs, _ := codec.Encode(NewPacket(PING, "probe"))
+	if !bytes.Equal(bs, []byte{0x92, 0x02, 0xa5, 'p', 'r', 'o', 'b', 'e'}) {
+		t.Errorf("bad envelope: % x", bs)
+	}
+	bs, _ = codec.Encode(NewPacket(MESSAGE, []byte{0x01}))
+	if !bytes.Equal(bs, []byte{0x92, 0x04, 0xc4, 0x01, 0x01}) {
+		t.Errorf("bad envelope: % x", bs)
+	}
+}
+
+func TestMsgpackDecodeError(t *testing.T) {
+	codec := NewMsgpackCodec(CodecOptions{})
+	cases := map[string]error{
+		"\x93\x04\xa0":         ErrInvalidFormat,
+		"\x92\x07\xa0":         ErrInvalidType,
+		"\x92\xcc\x04\xa0":     nil,
+		"\x92\x04\xa3ab":       ErrInvalidLength,
+		"\x92\x04\xc5\x00":     ErrInvalidLength,
+		"\x92\x04\xc0":         nil,
+		"\x92\x04\x01":         ErrInvalidFormat,
+		"\x92\x04\xa2abc":      ErrInvalidLength,
+		"\x92\x04\xd9\x02hi":   nil,
+		"\x92\x04":             ErrInvalidFormat,
+		"\x92\x04\xc6\x00\x00": ErrInvalidLength,
+	}
+	for input, exp := range cases {
+		_, err := codec.Decode([]byte(input))
+		if !errors.Is(err, exp) {
+			t.Errorf("decode % x: should be %v, got %v", input, exp, err)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+)
+
+// CodecOptions define the optional behaviors of codecs, the zero value is the default behaviors.
+type CodecOptions struct {
+	// ZeroCopy makes decoded packets reference subslices of the input instead of copying it.
+	//
+	// The caller owns the input buffer: it must not be modified or reused while the decoded packets
+	// are in use, call Packet.Clone for packets which should be retained after that.
+	// Base64 bodies are always decoded into new buffers.
+	ZeroCopy bool
+	// Pooled makes codecs decode into packets acquired from the packet pool.
+	// Callers should call Packet.Release when the packet is fully handled.
+	Pooled bool
+	// Arena makes codecs decode into packets and bodies allocated from the arena if it's not nil,
+	// it overrides Pooled. Codecs sharing an arena must be used by one goroutine, see Arena.
+	Arena *Arena
+	// MaxPacketSize is the max length in bytes of an encoded packet, zero means unlimited.
+	// Oversized packets are rejected with ErrPacketTooLarge before decoding or copying body.
+	MaxPacketSize int
+	// URLSafeBase64 makes codecs emit base64 bodies in the URL-safe alphabet, it implies AcceptURLSafeBase64.
+	URLSafeBase64 bool
+	// AcceptURLSafeBase64 makes codecs accept base64 bodies in both of standard and URL-safe alphabets,
+	// with or without padding.
+	AcceptURLSafeBase64 bool
+	// UTF8 define how string packets deal with UTF-8 text, default is UTF8Raw.
+	UTF8 UTF8Mode
+	// Strict makes decoders fail instead of truncating or skipping malformed content:
+	// a packet shorter than its length header, a length ending inside a character,
+	// and base64 bodies with line breaks or non-canonical padding bits are rejected with ErrInvalidLength
+	// or ErrInvalidBase64.
+	Strict bool
+	// OnMalformed makes payload decoders skip a malformed packet and continue with the rest if it's not nil,
+	// the decode error of skipped packet is reported to it.
+	// Errors of payload framing such as a broken length header still fail the whole payload,
+	// because the boundary of next packet is unknown.
+	OnMalformed func(err error)
+	// EmptyBody define which packets may be decoded with an empty body, default is AllowEmptyBody.
+	// Whatever the policy is, an empty body is encoded as the packet type only ('b' + type for base64)
+	// and decoded packets with empty body have nil Data, so PING without "probe" and empty MESSAGE round-trip equally.
+	EmptyBody EmptyBodyPolicy
+}
+
+// EmptyBodyPolicy define how codecs deal with decoded packets whose body is empty.
+type EmptyBodyPolicy uint8
+
+const (
+	// AllowEmptyBody accepts empty bodies of all packet types, it's the default.
+	AllowEmptyBody EmptyBodyPolicy = iota
+	// RejectEmptyMessage rejects MESSAGE and OPEN packets with empty body with ErrEmptyBody,
+	// control packets such as a PING without "probe" are still accepted.
+	RejectEmptyMessage
+)
+
+// NewStringCodec returns a string packet codec with options.
+func NewStringCodec(options CodecOptions) Codec {
+	return &strCodec{options: options}
+}
+
+// NewBinaryCodec returns a binary packet codec with options.
+func NewBinaryCodec(options CodecOptions) Codec {
+	return &binCodec{options: options}
+}
+
+// NewBase64Codec returns a base64 packet codec with options.
+func NewBase64Codec(options CodecOptions) Codec {
+	return &b64Codec{options: options}
+}
+
+// NewPayloadCodecV3 returns a payload codec of protocol v3 with options.
+// The payload is encoded in binary format if binary is true, decoding detects the format of input.
+func NewPayloadCodecV3(binary bool, options CodecOptions) PayloadCodec {
+	return &payloadV3{
+		binary:  binary,
+		options: options,
+		str:     NewStringCodec(options),
+		bin:     NewBinaryCodec(options),
+		b64:     NewBase64Codec(options),
+	}
+}
+
+// NewPayloadCodecV4 returns a payload codec of protocol v4 with options.
+func NewPayloadCodecV4(options CodecOptions) PayloadCodec {
+	return &payloadV4{
+		options: options,
+		str:     NewStringCodec(options),
+		b64:     &b64CodecV4{options: options},
+	}
+}
+
+// skip reports whether a malformed packet should be skipped, it reports the error if so.
+func (p CodecOptions) skip(err error) bool {
+	if p.OnMalformed == nil {
+		return false
+	}
+	p.OnMalformed(err)
+	return true
+}
+
+// checkSize returns an error if the length of encoded packet exceeds the max packet size.
+func (p CodecOptions) checkSize(size int, offset int) error {
+	if p.MaxPacketSize > 0 && size > p.MaxPacketSize {
+		return newDecodeError(ErrPacketTooLarge, offset, fmt.Sprintf("at most %d bytes", p.MaxPacketSize), fmt.Sprintf("%d bytes", size))
+	}
+	return nil
+}
+
+// encoding returns the base64 encoding of output.
+func (p CodecOptions) encoding() *base64.Encoding {
+	if p.URLSafeBase64 {
+		return base64.URLEncoding
+	}
+	return base64.StdEncoding
+}
+
+func (p CodecOptions) acceptURLSafe() bool {
+	return p.URLSafeBase64 || p.AcceptURLSafeBase64
+}
+
+// decodingOf detects the base64 encoding of input src.
+func (p CodecOptions) decodingOf(src []byte) *base64.Encoding {
+	if !p.acceptURLSafe() {
+		return base64.StdEncoding
+	}
+	padded := len(src)%4 == 0
+	if bytes.IndexAny(src, "-_") < 0 {
+		if padded {
+			return base64.StdEncoding
+		}
+		return base64.RawStdEncoding
+	}
+	if padded {
+		return base64.URLEncoding
+	}
+	return base64.RawURLEncoding
+}
+
+// body returns the data of a decoded packet, which is copied unless zero-copy is enabled.
+func (p CodecOptions) body(data []byte) []byte {
+	if len(data) < 1 {
+		return nil
+	}
+	if p.ZeroCopy {
+		return data
+	}
+	ret := make([]byte, len(data))
+	copy(ret, data)
+	return ret
+}
+
+// checkBody returns an error if the empty body policy rejects a packet of type t whose body is n bytes.
+func (p CodecOptions) checkBody(t PacketType, n int) error {
+	if n > 0 || p.EmptyBody == AllowEmptyBody {
+		return nil
+	}
+	if t == MESSAGE || t == OPEN {
+		return newDecodeError(ErrEmptyBody, 0, fmt.Sprintf("body of %s packet", t), "empty body")
+	}
+	return nil
+}
+
+// newPacket creates a decoded packet.
+func (p CodecOptions) newPacket(t PacketType, data []byte, opt PacketOption) (*Packet, error) {
+	if err := p.checkBody(t, len(data)); err != nil {
+		return nil, err
+	}
+	if len(data) < 1 {
+		data = nil
+	}
+	if p.Arena != nil {
+		packet := p.Arena.packet()
+		packet.Type, packet.Option = t, opt
+		if p.ZeroCopy || data == nil {
+			packet.Data = data
+		} else {
+			packet.Data = p.Arena.bytes(len(data))
+			copy(packet.Data, data)
+		}
+		return packet, nil
+	}
+	if !p.Pooled {
+		return NewPacketCustom(t, p.body(data), opt), nil
+	}
+	packet := AcquirePacket()
+	packet.Type, packet.Option = t, opt
+	if p.ZeroCopy || data == nil {
+		packet.Data = data
+	} else {
+		packet.buf = append(packet.buf[:0], data...)
+		packet.Data = packet.buf
+	}
+	return packet, nil
+}
+
+// ownedPacket creates a decoded packet which takes the ownership of data.
+func (p CodecOptions) ownedPacket(t PacketType, data []byte, opt PacketOption) (*Packet, error) {
+	p.ZeroCopy = true
+	return p.newPacket(t, data, opt)
+}
+
+// newBase64Packet creates a decoded packet from base64 body, offset is the position of body in input.
+func (p CodecOptions) newBase64Packet(t PacketType, src []byte, offset int) (*Packet, error) {
+	if len(src) < 1 {
+		return p.newPacket(t, nil, BINARY)
+	}
+	var packet *Packet
+	var dst []byte
+	encoding := p.decodingOf(src)
+	if p.Strict {
+		if i := bytes.IndexAny(src, "\r\n"); i >= 0 {
+			return nil, errBase64(base64.CorruptInputError(i), offset)
+		}
+		encoding = encoding.Strict()
+	}
+	n := encoding.DecodedLen(len(src))
+	if p.Arena != nil {
+		packet = p.Arena.packet()
+		dst = p.Arena.bytes(n)
+	} else if p.Pooled {
+		packet = AcquirePacket()
+		if cap(packet.buf) < n {
+			packet.buf = make([]byte, n)
+		}
+		dst = packet.buf[:n]
+	} else {
+		packet = new(Packet)
+		dst = make([]byte, n)
+	}
+	n, err := encoding.Decode(dst, src)
+	if err != nil {
+		if p.Pooled && p.Arena == nil {
+			packet.Release()
+		}
+		return nil, errBase64(err, offset)
+	}
+	packet.Type, packet.Data, packet.Option = t, dst[:n], BINARY
+	return packet, nil
+}
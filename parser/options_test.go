@@ -0,0 +1,290 @@
+package parser
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestZeroCopy(t *testing.T) {
+	input := []byte("4hello")
+	packet, err := NewStringCodec(CodecOptions{ZeroCopy: true}).Decode(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clone := packet.Clone()
+	input[1] = 'j'
+	if string(packet.Data) != "jello" {
+		t.Error("zero-copy packet should reference input")
+	}
+	if string(clone.Data) != "hello" {
+		t.Error("clone should own its data")
+	}
+
+	input = []byte{byte(MESSAGE), 1, 2, 3}
+	packet, err = Decode(input, BINARY)
+	if err != nil {
+		t.Fatal(err)
+	}
+	input[1] = 0
+	if packet.Data[0] != 1 {
+		t.Error("packet should be copied by default")
+	}
+}
+
+func TestZeroCopyPayload(t *testing.T) {
+	input := []byte("2:4a2:4b")
+	packets, err := NewPayloadCodecV3(false, CodecOptions{ZeroCopy: true}).Decode(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	input[3], input[7] = 'x', 'y'
+	if string(packets[0].Data) != "x" || string(packets[1].Data) != "y" {
+		t.Error("zero-copy packets should reference input")
+	}
+	input = []byte("4a\x1e4b")
+	packets, err = ProtocolV4.Decode(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	input[1] = 'x'
+	if string(packets[0].Data) != "a" {
+		t.Error("packet should be copied by default")
+	}
+}
+
+func TestMaxPacketSize(t *testing.T) {
+	options := CodecOptions{MaxPacketSize: 8}
+	big := []byte("4this is a big packet")
+	cases := []func() error{
+		func() error { _, err := NewStringCodec(options).Decode(big); return err },
+		func() error { _, err := NewBinaryCodec(options).Decode(big); return err },
+		func() error { _, err := NewBase64Codec(options).Decode([]byte("b4AAAAAAAAAA")); return err },
+		func() error {
+			_, err := NewBase64Codec(options).(StreamDecoder).DecodeFrom(strings.NewReader("b4AAAAAAAAAA"))
+			return err
+		},
+		func() error { _, err := NewPayloadCodecV3(false, options).Decode([]byte("99999999999:4a")); return err },
+		func() error {
+			_, err := NewPayloadCodecV3(true, options).Decode([]byte{0, 9, 9, 0xFF, '4'})
+			return err
+		},
+		func() error { _, err := NewPayloadCodecV4(options).Decode([]byte("4a\x1e4aaaaaaaaaaa")); return err },
+		func() error {
+			_, err := NewDecoderWithOptions(strings.NewReader("99999999999999999:4a"), options).Decode()
+			return err
+		},
+		func() error {
+			_, err := NewDecoderWithOptions(strings.NewReader("14:b4AAAAAAAAAAAA"), options).Decode()
+			return err
+		},
+	}
+	for i, fn := range cases {
+		if err := fn(); !errors.Is(err, ErrPacketTooLarge) {
+			t.Errorf("case#%d should be too large, got %v", i, err)
+		}
+	}
+	if _, err := NewStringCodec(options).Decode([]byte("4hello")); err != nil {
+		t.Error(err)
+	}
+	if _, err := NewBase64Codec(options).(StreamDecoder).DecodeFrom(strings.NewReader("b4AAAA")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestURLSafeBase64(t *testing.T) {
+	body := []byte{0xFB, 0xFF, 0xBF, 0x01}
+	accept := CodecOptions{AcceptURLSafeBase64: true}
+	for _, input := range []string{"b4+/+/AQ==", "b4-_-_AQ==", "b4-_-_AQ", "b4+/+/AQ"} {
+		packet, err := NewBase64Codec(accept).Decode([]byte(input))
+		if err != nil {
+			t.Fatal(input, err)
+		}
+		if !bytes.Equal(packet.Data, body) {
+			t.Error("illegal result:", input)
+		}
+		packet, err = NewBase64Codec(accept).(StreamDecoder).DecodeFrom(strings.NewReader(input))
+		if err != nil {
+			t.Fatal(input, err)
+		}
+		if !bytes.Equal(packet.Data, body) {
+			t.Error("illegal stream result:", input)
+		}
+	}
+	if _, err := base64Encoder.Decode([]byte("b4-_-_AQ==")); !errors.Is(err, ErrInvalidBase64) {
+		t.Error("URL-safe base64 should be rejected by default")
+	}
+	emit := CodecOptions{URLSafeBase64: true}
+	bs, err := NewBase64Codec(emit).Encode(NewPacket(MESSAGE, body))
+	if err != nil || string(bs) != "b4-_-_AQ==" {
+		t.Error("should emit URL-safe base64:", string(bs), err)
+	}
+	bf := new(bytes.Buffer)
+	if err := NewPayloadCodecV4(emit).WriteTo(bf, NewPacket(MESSAGE, body)); err != nil || bf.String() != "b-_-_AQ==" {
+		t.Error("should write URL-safe base64:", bf.String(), err)
+	}
+	packet, err := NewDecoderWithOptions(strings.NewReader("8:b4-_-_AQ"), accept).Decode()
+	if err != nil || !bytes.Equal(packet.Data, body) {
+		t.Error("decoder should accept URL-safe base64:", err)
+	}
+}
+
+func TestStrict(t *testing.T) {
+	strict := CodecOptions{Strict: true}
+	js := CodecOptions{Strict: true, UTF8: UTF8JavaScript}
+	cases := []struct {
+		input   string
+		options CodecOptions
+		err     error
+	}{
+		{"10:4hello", strict, ErrInvalidLength},
+		{"2:4\U0001F600", js, ErrInvalidLength},
+		{"3:4\U0001F600", js, nil},
+		{"11:b4aGVs\nbG8=", strict, ErrInvalidBase64},
+		{"6:b4aGl=", strict, ErrInvalidBase64},
+		{"6:b4aGk=", strict, nil},
+	}
+	for _, it := range cases {
+		loose := it.options
+		loose.Strict = false
+		if _, err := NewPayloadCodecV3(false, loose).Decode([]byte(it.input)); err != nil {
+			t.Errorf("decode %q: should pass without strict, got %v", it.input, err)
+		}
+		if _, err := NewPayloadCodecV3(false, it.options).Decode([]byte(it.input)); !errors.Is(err, it.err) {
+			t.Errorf("decode %q: should be %v, got %v", it.input, it.err, err)
+		}
+		decoder := NewDecoderWithOptions(strings.NewReader(it.input), it.options)
+		if _, err := decoder.Decode(); it.err == nil && err != nil {
+			t.Errorf("stream %q: should pass, got %v", it.input, err)
+		} else if it.err != nil && err == nil {
+			t.Errorf("stream %q: should fail", it.input)
+		}
+	}
+}
+
+func TestOnMalformed(t *testing.T) {
+	var skipped []error
+	options := CodecOptions{OnMalformed: func(err error) {
+		skipped = append(skipped, err)
+	}}
+	check := func(name string, packets []*Packet, err error, errs int) {
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if len(packets) != 2 || string(packets[0].Data) != "a" || string(packets[1].Data) != "c" {
+			t.Errorf("%s: bad packets %v", name, packets)
+		}
+		if len(skipped) != errs {
+			t.Errorf("%s: should skip %d packets, got %v", name, errs, skipped)
+		}
+		skipped = nil
+	}
+	packets, err := NewPayloadCodecV3(false, options).Decode([]byte("2:4a2:9b0:2:4c"))
+	check("v3", packets, err, 2)
+	packets, err = NewPayloadCodecV3(true, options).Decode([]byte("\x00\x02\xff4a\x01\x02\xff\x09b\x00\x02\xff4c"))
+	check("v3 binary", packets, err, 1)
+	packets, err = NewPayloadCodecV4(options).Decode([]byte("4a\x1e9b\x1ebZZZ\x1e4c"))
+	check("v4", packets, err, 2)
+	decoder := NewDecoderWithOptions(strings.NewReader("2:4a2:9b2:4c0:"), options)
+	packets = nil
+	for decoder.More() {
+		packet, err := decoder.Decode()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		packets = append(packets, packet)
+	}
+	check("stream", packets, nil, 2)
+	decoder = NewDecoderWithOptions(strings.NewReader("2:4a6:b4!!!!4:b9YQ2:4c"), options)
+	packets = nil
+	for decoder.More() {
+		packet, err := decoder.Decode()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		packets = append(packets, packet)
+	}
+	check("stream base64", packets, nil, 2)
+
+	if _, err := NewPayloadCodecV3(false, options).Decode([]byte("2:4ax:4c")); !errors.Is(err, ErrInvalidLength) {
+		t.Error("broken length should fail the payload:", err)
+	}
+}
+
+func TestEmptyBody(t *testing.T) {
+	allow := CodecOptions{}
+	pooled := CodecOptions{Pooled: true}
+	arena := CodecOptions{Arena: NewArena(4, 16)}
+	for _, options := range []CodecOptions{allow, pooled, arena} {
+		for name, codec := range map[string]Codec{
+			"string":  NewStringCodec(options),
+			"binary":  NewBinaryCodec(options),
+			"base64":  NewBase64Codec(options),
+			"msgpack": NewMsgpackCodec(options),
+			"cbor":    NewCBORCodec(options),
+		} {
+			for _, it := range []*Packet{
+				NewPacketCustom(PING, nil, 0),
+				NewPacketCustom(MESSAGE, []byte{}, 0),
+				NewPacketCustom(MESSAGE, nil, BINARY),
+			} {
+				if name == "string" && it.Option&BINARY == BINARY || name == "binary" && it.Option&BINARY != BINARY {
+					continue
+				}
+				bs, err := codec.Encode(it)
+				if err != nil {
+					t.Fatal(err)
+				}
+				decoded, err := codec.Decode(bs)
+				if err != nil {
+					t.Fatalf("%s: %v", name, err)
+				}
+				if decoded.Type != it.Type || decoded.Data != nil {
+					t.Errorf("%s: empty body should be decoded as nil: %s", name, Dump(decoded))
+				}
+			}
+		}
+	}
+	bs, _ := base64Encoder.Encode(NewPacketCustom(PONG, nil, BINARY))
+	if string(bs) != "b3" {
+		t.Errorf("bad empty base64 packet: %s", bs)
+	}
+}
+
+func TestEmptyBody_Reject(t *testing.T) {
+	options := CodecOptions{EmptyBody: RejectEmptyMessage}
+	for _, it := range []struct {
+		codec Codec
+		input string
+	}{
+		{NewStringCodec(options), "4"},
+		{NewStringCodec(options), "0"},
+		{NewBinaryCodec(options), "\x04"},
+		{NewBase64Codec(options), "b4"},
+		{NewBase64Codec(options), "4"},
+	} {
+		if _, err := it.codec.Decode([]byte(it.input)); !errors.Is(err, ErrEmptyBody) {
+			t.Errorf("%q: bad error %v", it.input, err)
+		}
+	}
+	for _, it := range []string{"2", "3", "6", "3probe", "4x"} {
+		if _, err := NewStringCodec(options).Decode([]byte(it)); err != nil {
+			t.Errorf("%q should be accepted: %v", it, err)
+		}
+	}
+	_, err := NewPayloadCodecV4(options).Decode([]byte("4hello\x1e4"))
+	if !errors.Is(err, ErrEmptyBody) {
+		t.Errorf("bad error of payload: %v", err)
+	}
+	if _, err := NewBase64Codec(CodecOptions{Strict: true}).Decode([]byte("4")); !errors.Is(err, ErrInvalidFormat) {
+		t.Errorf("legacy empty base64 packet should be rejected in strict mode: %v", err)
+	}
+}
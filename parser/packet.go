@@ -3,6 +3,7 @@ package parser
 import (
 	"bytes"
 	"encoding/json"
+	"io"
 )
 
 // PacketType define type of packet.
@@ -37,6 +38,29 @@ const (
 	BASE64 PacketOption = 0x01 << 1
 )
 
+// PacketOptions is the metadata of a packet which isn't encoded, codecs and transports can consult it.
+// It's the counterpart of the options object of packets in the JS implementation,
+// the zero value keeps default behaviours.
+type PacketOptions struct {
+	// NoCompress asks transports not to compress the packet, e.g. permessage-deflate of websocket.
+	NoCompress bool `json:"noCompress,omitempty"`
+	// Compress asks transports to compress the packet even if it's smaller than their threshold, NoCompress wins.
+	Compress bool `json:"compress,omitempty"`
+	// Priority is a hint for transports which schedule outgoing packets, higher values are more urgent.
+	Priority int `json:"priority,omitempty"`
+	// Seq is the sequence ID stamped by Sequencer or accepted by Deduper, zero means the packet isn't sequenced.
+	Seq uint64 `json:"seq,omitempty"`
+}
+
+// Compressible returns true if a packet of n bytes should be compressed by a transport or codec
+// which compresses packets of at least threshold bytes.
+func (p PacketOptions) Compressible(n, threshold int) bool {
+	if p.NoCompress {
+		return false
+	}
+	return p.Compress || n >= threshold
+}
+
 // Packet is minimal transmission unit.
 // An encoded packet can be UTF-8 string or binary data.
 // The packet encoding format for a string is as follows
@@ -44,6 +68,30 @@ type Packet struct {
 	Type   PacketType
 	Data   []byte
 	Option PacketOption
+	// Options is the metadata of packet, it's dropped by codecs.
+	Options PacketOptions
+	// Body streams the data of packet instead of Data if it's not nil, it can be encoded once only.
+	// WriteTo of codecs copies it to writer without holding the whole of it in memory,
+	// codecs which need the whole data read it into Data by ReadBody first.
+	Body io.Reader
+	// BodyLen is the length in bytes of Body or negative if it's unknown, it's used only if Body is not nil.
+	BodyLen int64
+	// buf is the reusable buffer owned by a pooled packet.
+	buf []byte
+	// pre caches the encoded forms of packet once it's wrapped by NewPreEncodedPacket.
+	pre *PreEncodedPacket
+}
+
+// Clone returns a deep copy of packet which doesn't share data with the origin.
+// It should be used to retain a packet decoded in zero-copy mode. Body is not copied but shared by the clone.
+func (p *Packet) Clone() *Packet {
+	clone := *p
+	clone.buf, clone.pre = nil, nil
+	if p.Data != nil {
+		clone.Data = make([]byte, len(p.Data))
+		copy(clone.Data, p.Data)
+	}
+	return &clone
 }
 
 // NewPacketCustom create a packet with custom settings.
@@ -93,21 +141,34 @@ func NewPacket(ptype PacketType, any interface{}) *Packet {
 // Decode a packet from bytes.
 func Decode(input []byte, option PacketOption) (*Packet, error) {
 	if option&BINARY != BINARY {
-		return stringEncoder.decode(input)
+		return stringEncoder.Decode(input)
 	} else if option&BASE64 != BASE64 {
-		return binaryEncoder.decode(input)
+		return binaryEncoder.Decode(input)
 	} else {
-		return base64Encoder.decode(input)
+		return base64Encoder.Decode(input)
 	}
 }
 
 // Encode a packet to bytes.
 func Encode(packet *Packet) ([]byte, error) {
+	return packetCodecOf(packet).Encode(packet)
+}
+
+// EncodeAppend encode a packet and append it to dst, returns the extended buffer.
+func EncodeAppend(dst []byte, packet *Packet) ([]byte, error) {
+	return packetCodecOf(packet).EncodeAppend(dst, packet)
+}
+
+// EncodedLen returns the length in bytes of the encoded packet.
+func EncodedLen(packet *Packet) int {
+	return packetCodecOf(packet).EncodedLen(packet)
+}
+
+func packetCodecOf(packet *Packet) Codec {
 	if packet.Option&BINARY != BINARY {
-		return stringEncoder.encode(packet)
+		return stringEncoder
 	} else if packet.Option&BASE64 != BASE64 {
-		return binaryEncoder.encode(packet)
-	} else {
-		return base64Encoder.encode(packet)
+		return binaryEncoder
 	}
+	return base64Encoder
 }
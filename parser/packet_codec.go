@@ -1,157 +1,246 @@
 package parser
 
 import (
-	"bytes"
 	"encoding/base64"
-	"errors"
 	"fmt"
 	"io"
 )
 
-type packetCodec interface {
-	decode(data []byte) (*Packet, error)
-	encode(packet *Packet) ([]byte, error)
-	writeTo(writer io.Writer, packet *Packet) error
+// Codec encodes and decodes a single packet in a wire format.
+type Codec interface {
+	// Decode a packet from bytes.
+	Decode(data []byte) (*Packet, error)
+	// Encode a packet to bytes.
+	Encode(packet *Packet) ([]byte, error)
+	// WriteTo encode a packet and write to writer.
+	WriteTo(writer io.Writer, packet *Packet) error
+	// EncodeAppend encode a packet and append it to dst, returns the extended buffer.
+	EncodeAppend(dst []byte, packet *Packet) ([]byte, error)
+	// EncodedLen returns the length in bytes of the encoded packet.
+	EncodedLen(packet *Packet) int
 }
 
 var (
-	stringEncoder packetCodec = new(strCodec)
-	binaryEncoder packetCodec = new(binCodec)
-	base64Encoder packetCodec = new(b64Codec)
+	stringEncoder  Codec = new(strCodec)
+	binaryEncoder  Codec = new(binCodec)
+	base64Encoder  Codec = new(b64Codec)
+	msgpackEncoder Codec = new(msgpackCodec)
+	cborEncoder    Codec = new(cborCodec)
 )
 
+func init() {
+	RegisterCodec(CodecString, stringEncoder)
+	RegisterCodec(CodecBinary, binaryEncoder)
+	RegisterCodec(CodecBase64, base64Encoder)
+	RegisterCodec(CodecMsgpack, msgpackEncoder)
+	RegisterCodec(CodecCBOR, cborEncoder)
+}
+
 type binCodec struct {
+	options CodecOptions
 }
 
-func (p *binCodec) decode(data []byte) (*Packet, error) {
+func (p *binCodec) Decode(data []byte) (*Packet, error) {
 	if data == nil || len(data) < 1 {
-		return nil, errors.New("packet bytes is empty")
+		return nil, errEmptyPacket(0)
+	}
+	if err := p.options.checkSize(len(data), 0); err != nil {
+		return nil, err
 	}
 	t := PacketType(data[0])
-	switch t {
-	default:
-		return nil, fmt.Errorf("invalid packet type: %d", t)
-	case OPEN, CLOSE, PING, PONG, MESSAGE, UPGRADE, NOOP:
-		return NewPacketCustom(t, data[1:], BINARY), nil
+	if !validType(t) {
+		return nil, newDecodeError(ErrInvalidType, 0, "packet type 0-6", fmt.Sprintf("%d", t))
 	}
+	return p.options.newPacket(t, data[1:], BINARY)
 }
 
-func (p *binCodec) writeTo(writer io.Writer, packet *Packet) error {
+func (p *binCodec) WriteTo(writer io.Writer, packet *Packet) error {
 	if _, err := writer.Write([]byte{byte(packet.Type)}); err != nil {
 		return err
 	}
-	if packet.Data == nil || len(packet.Data) < 1 {
-		return nil
-	}
-	_, err := writer.Write(packet.Data)
-	return err
+	return writeBody(writer, packet)
 }
 
-func (p *binCodec) encode(packet *Packet) ([]byte, error) {
-	bf := new(bytes.Buffer)
-	if err := p.writeTo(bf, packet); err != nil {
-		return nil, err
+func (p *binCodec) Encode(packet *Packet) ([]byte, error) {
+	return p.EncodeAppend(make([]byte, 0, p.EncodedLen(packet)), packet)
+}
+
+func (p *binCodec) EncodeAppend(dst []byte, packet *Packet) ([]byte, error) {
+	if err := packet.ReadBody(); err != nil {
+		return dst, err
 	}
-	return bf.Bytes(), nil
+	dst = append(dst, byte(packet.Type))
+	return append(dst, packet.Data...), nil
+}
+
+func (p *binCodec) EncodedLen(packet *Packet) int {
+	return 1 + dataLen(packet)
 }
 
 type strCodec struct {
+	options CodecOptions
 }
 
-func (p *strCodec) decode(data []byte) (*Packet, error) {
+func (p *strCodec) Decode(data []byte) (*Packet, error) {
 	if data == nil || len(data) < 1 {
-		return nil, errors.New("packet bytes is empty")
+		return nil, errEmptyPacket(0)
+	}
+	if err := p.options.checkSize(len(data), 0); err != nil {
+		return nil, err
 	}
 	t, err := convertCharToType(data[0])
 	if err != nil {
 		return nil, err
 	}
-	return NewPacketCustom(t, data[1:], 0), nil
+	text, err := p.options.UTF8.decodeText(data[1:], 1)
+	if err != nil {
+		return nil, err
+	}
+	return p.options.newPacket(t, text, 0)
 }
 
-func (p *strCodec) writeTo(writer io.Writer, packet *Packet) error {
+func (p *strCodec) WriteTo(writer io.Writer, packet *Packet) error {
 	var t byte
 	var err error
 	if t, err = convertTypeToChar(packet.Type); err != nil {
 		return err
 	}
+	if packet.Body != nil && p.options.UTF8 == UTF8Raw {
+		if _, err = writer.Write([]byte{t}); err != nil {
+			return err
+		}
+		return writeBody(writer, packet)
+	}
+	if err = packet.ReadBody(); err != nil {
+		return err
+	}
+	var text []byte
+	if text, err = p.options.UTF8.encodeText(packet.Data); err != nil {
+		return err
+	}
 	if _, err = writer.Write([]byte{t}); err != nil {
 		return err
 	}
-	_, err = writer.Write(packet.Data)
+	_, err = writer.Write(text)
 	return err
 }
 
-func (p *strCodec) encode(packet *Packet) ([]byte, error) {
-	bf := new(bytes.Buffer)
-	if err := p.writeTo(bf, packet); err != nil {
-		return nil, err
+func (p *strCodec) Encode(packet *Packet) ([]byte, error) {
+	return p.EncodeAppend(make([]byte, 0, p.EncodedLen(packet)), packet)
+}
+
+func (p *strCodec) EncodeAppend(dst []byte, packet *Packet) ([]byte, error) {
+	t, err := convertTypeToChar(packet.Type)
+	if err != nil {
+		return dst, err
+	}
+	if err = packet.ReadBody(); err != nil {
+		return dst, err
 	}
-	return bf.Bytes(), nil
+	text, err := p.options.UTF8.encodeText(packet.Data)
+	if err != nil {
+		return dst, err
+	}
+	dst = append(dst, t)
+	return append(dst, text...), nil
+}
+
+func (p *strCodec) EncodedLen(packet *Packet) int {
+	if p.options.UTF8 == UTF8Raw || p.options.UTF8 == UTF8Strict {
+		return 1 + dataLen(packet)
+	}
+	packet.ReadBody()
+	text, _ := p.options.UTF8.encodeText(packet.Data)
+	return 1 + len(text)
 }
 
 type b64Codec struct {
+	options CodecOptions
 }
 
-func (p *b64Codec) decode(data []byte) (*Packet, error) {
+func (p *b64Codec) Decode(data []byte) (*Packet, error) {
 	l := len(data)
 	if l < 1 {
-		return nil, errors.New("packet bytes is empty")
+		return nil, errEmptyPacket(0)
+	}
+	if err := p.options.checkSize(l, 0); err != nil {
+		return nil, err
 	}
 	if l < 2 {
+		// a bare type is the legacy form of an empty base64 packet, 'b' + type is emitted instead.
+		if p.options.Strict {
+			return nil, newDecodeError(ErrInvalidFormat, 0, "'b' and packet type", fmt.Sprintf("%q", data))
+		}
 		t, err := convertCharToType(data[0])
 		if err != nil {
 			return nil, err
 		}
-		return NewPacketCustom(t, make([]byte, 0), BINARY), nil
+		return p.options.newPacket(t, data[1:], BINARY)
 	}
 	if data[0] != 'b' {
-		return nil, fmt.Errorf("invalid b64 packet: %s", data)
+		return nil, newDecodeError(ErrInvalidFormat, 0, "'b'", fmt.Sprintf("%q", data[0]))
 	}
-	if t, err := convertCharToType(data[1]); err != nil {
-		return nil, err
-	} else if data, err := base64.StdEncoding.DecodeString(string(data[2:])); err != nil {
-		return nil, err
-	} else {
-		return NewPacketCustom(t, data, BINARY), nil
+	t, err := convertCharToType(data[1])
+	if err != nil {
+		return nil, withOffset(err, 1)
 	}
+	return p.options.newBase64Packet(t, data[2:], 2)
 }
 
-func (p *b64Codec) writeTo(writer io.Writer, packet *Packet) error {
+func (p *b64Codec) WriteTo(writer io.Writer, packet *Packet) error {
 	var t byte
 	var err error
 	if t, err = convertTypeToChar(packet.Type); err != nil {
 		return err
 	}
-	if packet.Data == nil || len(packet.Data) < 1 {
-		if _, err = writer.Write([]byte{t}); err != nil {
-			return err
-		}
-		return nil
-	}
-	if _, err = writer.Write([]byte{'b'}); err != nil {
+	// an empty body is still prefixed with 'b' as the JS implementation does, so it stays binary.
+	if _, err = writer.Write([]byte{'b', t}); err != nil {
 		return err
 	}
-	if _, err = writer.Write([]byte{t}); err != nil {
-		return err
+	return writeBase64Body(writer, packet, p.options.encoding())
+}
+
+func (p *b64Codec) Encode(packet *Packet) ([]byte, error) {
+	return p.EncodeAppend(make([]byte, 0, p.EncodedLen(packet)), packet)
+}
+
+func (p *b64Codec) EncodeAppend(dst []byte, packet *Packet) ([]byte, error) {
+	t, err := convertTypeToChar(packet.Type)
+	if err != nil {
+		return dst, err
 	}
-	body := base64.StdEncoding.EncodeToString(packet.Data)
-	_, err = writer.Write([]byte(body))
-	return err
+	if err = packet.ReadBody(); err != nil {
+		return dst, err
+	}
+	dst = append(dst, 'b', t)
+	return appendBase64(dst, packet.Data, p.options.encoding()), nil
 }
 
-func (p *b64Codec) encode(packet *Packet) ([]byte, error) {
-	bf := new(bytes.Buffer)
-	if err := p.writeTo(bf, packet); err != nil {
-		return nil, err
+func (p *b64Codec) EncodedLen(packet *Packet) int {
+	return 2 + base64.StdEncoding.EncodedLen(dataLen(packet))
+}
+
+// appendBase64 appends the base64 encoding of src to dst.
+func appendBase64(dst, src []byte, encoding *base64.Encoding) []byte {
+	n := encoding.EncodedLen(len(src))
+	l := len(dst)
+	if cap(dst)-l < n {
+		grown := make([]byte, l, l+n)
+		copy(grown, dst)
+		dst = grown
 	}
-	return bf.Bytes(), nil
+	dst = dst[:l+n]
+	encoding.Encode(dst[l:], src)
+	return dst
 }
 
 func convertCharToType(c byte) (PacketType, error) {
 	switch c {
 	default:
-		return 0xFF, fmt.Errorf("invalid packet type: %s", c)
+		if t, ok := lookupCustomChar(c); ok {
+			return t, nil
+		}
+		return 0xFF, newDecodeError(ErrInvalidType, 0, "packet type '0'-'6'", fmt.Sprintf("%q", c))
 	case '0':
 		return OPEN, nil
 	case '1':
@@ -172,7 +261,10 @@ func convertCharToType(c byte) (PacketType, error) {
 func convertTypeToChar(ptype PacketType) (byte, error) {
 	switch ptype {
 	default:
-		return 0, fmt.Errorf("invalid packet type: %d", ptype)
+		if c, ok := lookupCustomType(ptype); ok {
+			return c.char, nil
+		}
+		return 0, fmt.Errorf("%w: %d", ErrInvalidType, ptype)
 	case OPEN:
 		return '0', nil
 	case CLOSE:
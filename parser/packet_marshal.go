@@ -0,0 +1,115 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+var packetTypeNames = [...]string{"open", "close", "ping", "pong", "message", "upgrade", "noop"}
+
+// String returns the name of packet type, e.g. "ping" or "message".
+func (p PacketType) String() string {
+	if int(p) < len(packetTypeNames) {
+		return packetTypeNames[p]
+	}
+	if c, ok := lookupCustomType(p); ok {
+		return c.name
+	}
+	return fmt.Sprintf("PacketType(%d)", uint8(p))
+}
+
+// MarshalText returns the name of packet type, it fails if the type is unknown.
+func (p PacketType) MarshalText() ([]byte, error) {
+	if int(p) < len(packetTypeNames) {
+		return []byte(packetTypeNames[p]), nil
+	}
+	if c, ok := lookupCustomType(p); ok {
+		return []byte(c.name), nil
+	}
+	return nil, fmt.Errorf("%w: %d", ErrInvalidType, p)
+}
+
+// UnmarshalText parses the name of packet type.
+func (p *PacketType) UnmarshalText(text []byte) error {
+	for i, it := range packetTypeNames {
+		if it == string(text) {
+			*p = PacketType(i)
+			return nil
+		}
+	}
+	if t, ok := lookupCustomName(string(text)); ok {
+		*p = t
+		return nil
+	}
+	return fmt.Errorf("%w: %q", ErrInvalidType, text)
+}
+
+// packetJSON is the JSON form of packet.
+// Data is a string for string packets and standard base64 for binary packets, as encoding/json does for []byte.
+type packetJSON struct {
+	Type    PacketType      `json:"type"`
+	Data    json.RawMessage `json:"data,omitempty"`
+	Binary  bool            `json:"binary,omitempty"`
+	Base64  bool            `json:"base64,omitempty"`
+	Options *PacketOptions  `json:"options,omitempty"`
+}
+
+// MarshalJSON returns the human-readable form of packet,
+// e.g. {"type":"message","data":"hello"} or {"type":"message","data":"AQI=","binary":true}.
+func (p *Packet) MarshalJSON() ([]byte, error) {
+	out := packetJSON{
+		Type:   p.Type,
+		Binary: p.Option&BINARY == BINARY,
+		Base64: p.Option&BASE64 == BASE64,
+	}
+	if len(p.Data) > 0 {
+		var err error
+		if out.Binary {
+			out.Data, err = json.Marshal(p.Data)
+		} else {
+			out.Data, err = json.Marshal(string(p.Data))
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if p.Options != (PacketOptions{}) {
+		out.Options = &p.Options
+	}
+	return json.Marshal(&out)
+}
+
+// UnmarshalJSON restores a packet from the form of MarshalJSON.
+func (p *Packet) UnmarshalJSON(data []byte) error {
+	var in packetJSON
+	if err := json.Unmarshal(data, &in); err != nil {
+		return err
+	}
+	var opt PacketOption
+	if in.Binary {
+		opt |= BINARY
+	}
+	if in.Base64 {
+		opt |= BASE64
+	}
+	var body []byte
+	if len(in.Data) > 0 && string(in.Data) != "null" {
+		if in.Binary {
+			if err := json.Unmarshal(in.Data, &body); err != nil {
+				return err
+			}
+		} else {
+			var text string
+			if err := json.Unmarshal(in.Data, &text); err != nil {
+				return err
+			}
+			body = []byte(text)
+		}
+	}
+	p.Type, p.Data, p.Option = in.Type, body, opt
+	p.Options = PacketOptions{}
+	if in.Options != nil {
+		p.Options = *in.Options
+	}
+	return nil
+}
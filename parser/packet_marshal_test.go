@@ -0,0 +1,58 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestPacketTypeText(t *testing.T) {
+	if PING.String() != "ping" || MESSAGE.String() != "message" || PacketType(9).String() != "PacketType(9)" {
+		t.Error("bad packet type names")
+	}
+	var pt PacketType
+	if err := pt.UnmarshalText([]byte("upgrade")); err != nil || pt != UPGRADE {
+		t.Error("should parse upgrade:", pt, err)
+	}
+	if err := pt.UnmarshalText([]byte("bad")); !errors.Is(err, ErrInvalidType) {
+		t.Error("should fail with invalid type:", err)
+	}
+	if _, err := PacketType(9).MarshalText(); !errors.Is(err, ErrInvalidType) {
+		t.Error("should fail with invalid type:", err)
+	}
+}
+
+func TestPacketJSON(t *testing.T) {
+	packets := []*Packet{
+		NewPacket(MESSAGE, "hello"),
+		NewPacket(MESSAGE, []byte{0x01, 0x02}),
+		NewPacketCustom(PING, nil, 0),
+		{Type: NOOP, Data: []byte("x"), Option: BINARY | BASE64, Options: PacketOptions{NoCompress: true, Priority: 2}},
+	}
+	expects := []string{
+		`{"type":"message","data":"hello"}`,
+		`{"type":"message","data":"AQI=","binary":true}`,
+		`{"type":"ping"}`,
+		`{"type":"noop","data":"eA==","binary":true,"base64":true,"options":{"noCompress":true,"priority":2}}`,
+	}
+	for i, it := range packets {
+		bs, err := json.Marshal(it)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(bs) != expects[i] {
+			t.Errorf("should be %s, got %s", expects[i], bs)
+		}
+		decoded := new(Packet)
+		if err := json.Unmarshal(bs, decoded); err != nil {
+			t.Fatal(err)
+		}
+		if decoded.Type != it.Type || decoded.Option != it.Option || decoded.Options != it.Options || !bytes.Equal(decoded.Data, it.Data) {
+			t.Errorf("should be %v, got %v", it, decoded)
+		}
+	}
+	if err := json.Unmarshal([]byte(`{"type":"bad"}`), new(Packet)); !errors.Is(err, ErrInvalidType) {
+		t.Error("should fail with invalid type:", err)
+	}
+}
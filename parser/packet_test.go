@@ -30,3 +30,75 @@ func TestEncode(t *testing.T) {
 	fmt.Println("cost:", cost, "ms")
 	fmt.Println("ops:", 1000*totals/cost, "op/sec")
 }
+
+func TestEncodeAppend(t *testing.T) {
+	packets := []*Packet{
+		NewPacket(MESSAGE, "你好"),
+		NewPacket(PING, ""),
+		NewPacket(MESSAGE, []byte{1, 2, 3, 4, 5}),
+		NewPacketCustom(MESSAGE, []byte{1, 2, 3, 4, 5}, BINARY|BASE64),
+		NewPacketCustom(NOOP, nil, BINARY|BASE64),
+	}
+	buf := make([]byte, 0, 64)
+	for _, it := range packets {
+		exp, err := Encode(it)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := EncodedLen(it); n != len(exp) {
+			t.Errorf("encoded length should be %d, got %d", len(exp), n)
+		}
+		buf, err = EncodeAppend(buf[:0], it)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(buf) != string(exp) {
+			t.Errorf("should be %q, got %q", exp, buf)
+		}
+	}
+	prefix := []byte("x")
+	bs, _ := EncodeAppend(prefix, packets[0])
+	if string(bs) != "x4你好" {
+		t.Error("should append to dst:", string(bs))
+	}
+}
+
+func TestPacketOptionsCompressible(t *testing.T) {
+	cases := []struct {
+		options PacketOptions
+		n       int
+		expect  bool
+	}{
+		{PacketOptions{}, 100, true},
+		{PacketOptions{}, 10, false},
+		{PacketOptions{Compress: true}, 10, true},
+		{PacketOptions{NoCompress: true}, 100, false},
+		{PacketOptions{NoCompress: true, Compress: true}, 100, false},
+	}
+	for i, it := range cases {
+		if got := it.options.Compressible(it.n, 50); got != it.expect {
+			t.Errorf("case %d: should be %v", i, it.expect)
+		}
+	}
+}
+
+func TestPacketOptions(t *testing.T) {
+	packet := NewPacket(MESSAGE, "hello")
+	packet.Options = PacketOptions{NoCompress: true, Priority: 3}
+	bs, err := Encode(packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(bs) != "4hello" {
+		t.Error("options should not be encoded:", string(bs))
+	}
+	if clone := packet.Clone(); clone.Options != packet.Options {
+		t.Error("clone should keep options:", clone.Options)
+	}
+	pooled := AcquirePacket()
+	pooled.Options.NoCompress = true
+	pooled.Release()
+	if pooled.Options != (PacketOptions{}) {
+		t.Error("release should reset options")
+	}
+}
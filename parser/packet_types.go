@@ -0,0 +1,93 @@
+package parser
+
+import (
+	"fmt"
+	"sync"
+)
+
+// customType is an application-defined packet type.
+type customType struct {
+	char byte
+	name string
+}
+
+var customTypes = struct {
+	sync.RWMutex
+	types map[PacketType]customType
+	chars map[byte]PacketType
+}{
+	types: make(map[PacketType]customType),
+	chars: make(map[byte]PacketType),
+}
+
+// RegisterPacketType makes an application-defined packet type available to codecs,
+// so internal control frames can flow through the same codecs as standard packets.
+// The type must be above NOOP, char is its type character in string packets and name is used by String.
+// It panics if the type, char or name is illegal or registered already.
+//
+// Both of peers must register the same types, they are unknown to other Engine.IO implementations.
+func RegisterPacketType(t PacketType, char byte, name string) {
+	if t <= NOOP || t == 0xFF {
+		panic(fmt.Errorf("parser: packet type %d is reserved", t))
+	}
+	if char <= ' ' || char >= 0x7F || char == 'b' || char == ':' || (char >= '0' && char <= '6') {
+		panic(fmt.Errorf("parser: packet type char %q is reserved", char))
+	}
+	if len(name) < 1 {
+		panic("parser: register packet type with blank name")
+	}
+	customTypes.Lock()
+	defer customTypes.Unlock()
+	if _, ok := customTypes.types[t]; ok {
+		panic(fmt.Errorf("parser: packet type %d exists already", t))
+	}
+	if _, ok := customTypes.chars[char]; ok {
+		panic(fmt.Errorf("parser: packet type char %q exists already", char))
+	}
+	for _, it := range customTypes.types {
+		if it.name == name {
+			panic(fmt.Errorf("parser: packet type '%s' exists already", name))
+		}
+	}
+	for _, it := range packetTypeNames {
+		if it == name {
+			panic(fmt.Errorf("parser: packet type '%s' exists already", name))
+		}
+	}
+	customTypes.types[t] = customType{char: char, name: name}
+	customTypes.chars[char] = t
+}
+
+// validType reports whether t is a standard or registered packet type.
+func validType(t PacketType) bool {
+	if t <= NOOP {
+		return true
+	}
+	_, ok := lookupCustomType(t)
+	return ok
+}
+
+func lookupCustomType(t PacketType) (customType, bool) {
+	customTypes.RLock()
+	defer customTypes.RUnlock()
+	c, ok := customTypes.types[t]
+	return c, ok
+}
+
+func lookupCustomChar(c byte) (PacketType, bool) {
+	customTypes.RLock()
+	defer customTypes.RUnlock()
+	t, ok := customTypes.chars[c]
+	return t, ok
+}
+
+func lookupCustomName(name string) (PacketType, bool) {
+	customTypes.RLock()
+	defer customTypes.RUnlock()
+	for t, it := range customTypes.types {
+		if it.name == name {
+			return t, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,82 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+const testRoute PacketType = 0x10
+
+func init() {
+	RegisterPacketType(testRoute, 'r', "route")
+}
+
+func TestCustomPacketType(t *testing.T) {
+	packet := NewPacket(testRoute, "node-1")
+	bs, err := Encode(packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(bs) != "rnode-1" {
+		t.Error("bad encoded packet:", string(bs))
+	}
+	payload, err := EncodePayload(packet, NewPacketCustom(testRoute, []byte{0x01}, BINARY|BASE64))
+	if err != nil {
+		t.Fatal(err)
+	}
+	packets, err := DecodePayload(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(packets) != 2 || packets[0].Type != testRoute || packets[1].Type != testRoute || !bytes.Equal(packets[1].Data, []byte{0x01}) {
+		t.Errorf("bad packets: %v", packets)
+	}
+	for _, codec := range []Codec{binaryEncoder, msgpackEncoder} {
+		bs, err := codec.Encode(NewPacket(testRoute, []byte("x")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decoded, err := codec.Decode(bs); err != nil || decoded.Type != testRoute {
+			t.Error("should decode custom type:", decoded, err)
+		}
+	}
+	if testRoute.String() != "route" {
+		t.Error("bad name:", testRoute.String())
+	}
+	bs, _ = json.Marshal(packet)
+	decoded := new(Packet)
+	if err := json.Unmarshal(bs, decoded); err != nil || decoded.Type != testRoute {
+		t.Error("should unmarshal custom type:", string(bs), err)
+	}
+	if _, err := Encode(NewPacket(0x11, "x")); err == nil {
+		t.Error("unregistered type should fail")
+	}
+}
+
+func TestRegisterPacketTypePanics(t *testing.T) {
+	cases := []struct {
+		t    PacketType
+		char byte
+		name string
+	}{
+		{MESSAGE, 'x', "x"},
+		{0x20, '4', "x"},
+		{0x20, 'b', "x"},
+		{0x20, 'r', "x"},
+		{testRoute, 'x', "x"},
+		{0x20, 'x', "route"},
+		{0x20, 'x', "ping"},
+		{0x20, 'x', ""},
+	}
+	for _, it := range cases {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("register %d %q %q should panic", it.t, it.char, it.name)
+				}
+			}()
+			RegisterPacketType(it.t, it.char, it.name)
+		}()
+	}
+}
@@ -1,64 +1,53 @@
 package parser
 
 import (
-	"bytes"
-	"errors"
 	"fmt"
 	"io"
 	"strconv"
-	"strings"
 	"unicode/utf8"
 )
 
-var (
-	errEmptyPackets = errors.New("input packets is empty")
-	jsonpReplacer   = strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\u2028", "\\u2028", "\u2029", "\\u2029")
-)
+// payloadMetricsV3 counts the package level payload functions, Decoder and ProtocolV3.
+var payloadMetricsV3 = mustMetrics(MetricsPayloadV3)
 
 // EncodePayload encode multi packets to payload bytes.
 func EncodePayload(packets ...*Packet) ([]byte, error) {
-	bf := new(bytes.Buffer)
-	if err := WritePayloadTo(bf, false, packets...); err != nil {
-		return nil, err
-	}
-	return bf.Bytes(), nil
+	return AppendPayload(make([]byte, 0, payloadSizeHint(packets)), packets...)
+}
+
+// AppendPayload encode multi packets and append the payload to dst, returns the extended buffer.
+func AppendPayload(dst []byte, packets ...*Packet) ([]byte, error) {
+	return ProtocolV3.EncodeAppend(dst, packets...)
 }
 
 // WritePayloadTo encode multi packets and write to writer.
 func WritePayloadTo(writer io.Writer, jsonp bool, packets ...*Packet) error {
+	if !jsonp {
+		return ProtocolV3.WriteTo(writer, packets...)
+	}
 	if len(packets) < 1 {
-		return errEmptyPackets
+		return payloadMetricsV3.failed(ErrEmptyPayload)
 	}
-
+	counter := acquireCountingWriter(writer)
+	defer releaseCountingWriter(counter)
+	body := bodyLen(packets)
 	for _, it := range packets {
-		if err := writePacket(writer, it, jsonp); err != nil {
-			return err
+		if err := writeStringPacket(counter, it, jsonp, CodecOptions{}, stringEncoder, base64Encoder); err != nil {
+			return payloadMetricsV3.failed(err)
 		}
 	}
+	payloadMetricsV3.encoded(len(packets), body, counter.n)
 	return nil
 }
 
 // DecodePayload decode multi packets from payload bytes.
 func DecodePayload(input []byte) ([]*Packet, error) {
-	var size int
-	var err error
-	var rest, content []byte = input, nil
-	var packets = make([]*Packet, 0)
-	var packet *Packet
-	for len(rest) > 0 {
-		if size == 0 {
-			size, rest, err = readPacketLength(rest)
-			continue
-		}
-		content, rest, err = readPacketString(rest, size)
-		packet, err = readPacket(content)
-		if err != nil {
-			return nil, err
-		}
-		packets = append(packets, packet)
-		size = 0
+	packets, err := decodeStringPayload(input, CodecOptions{}, stringEncoder, base64Encoder)
+	if err != nil {
+		return nil, payloadMetricsV3.failed(err)
 	}
-	return packets, err
+	payloadMetricsV3.decoded(len(packets), bodyLen(packets), len(input))
+	return packets, nil
 }
 
 // DecodePayloadString decode multi packets from payload string.
@@ -66,11 +55,52 @@ func DecodePayloadString(str string) ([]*Packet, error) {
 	return DecodePayload([]byte(str))
 }
 
-func readPacket(input []byte) (*Packet, error) {
-	if input[0] != 'b' {
-		return stringEncoder.decode(input)
+// DecodePayloadFunc decode multi packets from payload bytes and calls fn for each packet in order,
+// no slice of packets is built. Decoding stops at the first error returned by fn, which is returned as is.
+func DecodePayloadFunc(input []byte, fn func(*Packet) error) error {
+	var count, body int
+	err := visitStringPayload(input, CodecOptions{}, stringEncoder, base64Encoder, func(packet *Packet) error {
+		count++
+		body += len(packet.Data)
+		return fn(packet)
+	})
+	if err != nil {
+		payloadMetricsV3.decoded(count, body, 0)
+		return payloadMetricsV3.failed(err)
 	}
-	return base64Encoder.decode(input)
+	payloadMetricsV3.decoded(count, body, len(input))
+	return nil
+}
+
+// decodeStringPayload decode a string payload with codecs created from options.
+func decodeStringPayload(input []byte, options CodecOptions, str, b64 Codec) ([]*Packet, error) {
+	var packets = make([]*Packet, 0)
+	err := visitStringPayload(input, options, str, b64, func(packet *Packet) error {
+		packets = append(packets, packet)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return packets, nil
+}
+
+// visitStringPayload decode a string payload with codecs created from options and calls fn for each packet.
+func visitStringPayload(input []byte, options CodecOptions, str, b64 Codec, fn func(*Packet) error) error {
+	payload := newPayloadIterator(input, options, str, b64)
+	for payload.Next() {
+		if err := fn(payload.Packet()); err != nil {
+			return err
+		}
+	}
+	return payload.Err()
+}
+
+func readPacket(input []byte, str, b64 Codec) (*Packet, error) {
+	if len(input) < 1 || input[0] != 'b' {
+		return str.Decode(input)
+	}
+	return b64.Decode(input)
 }
 
 func readPacketLength(input []byte) (int, []byte, error) {
@@ -79,12 +109,12 @@ func readPacketLength(input []byte) (int, []byte, error) {
 			continue
 		}
 		size, err := strconv.Atoi(string(input[:i]))
-		if err != nil {
-			return 0, nil, err
+		if err != nil || size < 0 {
+			return 0, nil, newDecodeError(ErrInvalidLength, 0, "decimal length", fmt.Sprintf("%q", input[:i]))
 		}
 		return size, input[i+1:], nil
 	}
-	return 0, nil, fmt.Errorf("read payload length failed: %s", string(input))
+	return 0, nil, newDecodeError(ErrInvalidLength, len(input), "':'", "end of payload")
 }
 
 func readPacketString(input []byte, size int) ([]byte, []byte, error) {
@@ -98,31 +128,62 @@ func readPacketString(input []byte, size int) ([]byte, []byte, error) {
 	return input[:i], input[i:], nil
 }
 
-func writePacket(writer io.Writer, packet *Packet, jsonp bool) error {
-	var data []byte
+// writeStringPacket encode a packet of string payload with codecs created from options.
+func writeStringPacket(writer io.Writer, packet *Packet, jsonp bool, options CodecOptions, str, b64 Codec) error {
+	scratch := acquireScratch()
+	defer releaseScratch(scratch)
 	var err error
-	var length int
-	if packet.Option&BINARY != BINARY {
-		data, err = stringEncoder.encode(packet)
-		if err != nil {
+	if packet.Option&BINARY == BINARY {
+		// base64 text needs no escaping, so stream it without encoding the whole of body first.
+		*scratch = lengthHeader((*scratch)[:0], b64.EncodedLen(packet))
+		if _, err = writer.Write(*scratch); err != nil {
 			return err
 		}
-		length = utf8.RuneCount(data)
-	} else {
-		data, err = base64Encoder.encode(packet)
-		if err != nil {
-			return err
-		}
-		length = len(data)
+		return b64.WriteTo(writer, packet)
 	}
-	_, err = writer.Write([]byte(fmt.Sprintf("%d:", length)))
-	if err != nil {
+	if *scratch, err = appendStringPacket((*scratch)[:0], packet, options, str, b64); err != nil {
 		return err
 	}
 	if jsonp {
-		_, err = jsonpReplacer.WriteString(writer, string(data))
+		_, err = jsonpReplacer.WriteString(writer, string(*scratch))
 	} else {
-		_, err = writer.Write(data)
+		_, err = writer.Write(*scratch)
 	}
 	return err
 }
+
+// appendStringPayload encode multi packets of string payload with codecs created from options and append to dst.
+func appendStringPayload(dst []byte, packets []*Packet, options CodecOptions, str, b64 Codec) ([]byte, error) {
+	if len(packets) < 1 {
+		return dst, ErrEmptyPayload
+	}
+	var err error
+	for _, it := range packets {
+		if dst, err = appendStringPacket(dst, it, options, str, b64); err != nil {
+			return dst, err
+		}
+	}
+	return dst, nil
+}
+
+// appendStringPacket encode a packet of string payload with codecs created from options and append to dst.
+func appendStringPacket(dst []byte, packet *Packet, options CodecOptions, str, b64 Codec) ([]byte, error) {
+	var header [24]byte
+	if packet.Option&BINARY == BINARY {
+		dst = append(dst, lengthHeader(header[:0], b64.EncodedLen(packet))...)
+		return b64.EncodeAppend(dst, packet)
+	}
+	start := len(dst)
+	dst, err := str.EncodeAppend(dst, packet)
+	if err != nil {
+		return dst[:start], err
+	}
+	var length int
+	if options.UTF8 == UTF8JavaScript {
+		length = utf16Len(dst[start:])
+	} else {
+		length = utf8.RuneCount(dst[start:])
+	}
+	// the length in characters is known after encoding, so the header is inserted before the packet.
+	return insertHeader(dst, start, lengthHeader(header[:0], length)), nil
+}
@@ -0,0 +1,119 @@
+package parser
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+// PayloadIterator reads packets of a string payload one by one, the rest of payload is not decoded
+// if the caller stops early. Use it as:
+//
+//	payload := ParsePayload(input)
+//	for payload.Next() {
+//		packet := payload.Packet()
+//	}
+//	if err := payload.Err(); err != nil {
+//	}
+type PayloadIterator struct {
+	input    []byte
+	offset   int
+	options  CodecOptions
+	str, b64 Codec
+	packet   *Packet
+	err      error
+	metrics  *Metrics
+}
+
+// ParsePayload returns an iterator over packets of payload bytes.
+func ParsePayload(input []byte) *PayloadIterator {
+	payload := newPayloadIterator(input, CodecOptions{}, stringEncoder, base64Encoder)
+	payload.metrics = payloadMetricsV3
+	return payload
+}
+
+func newPayloadIterator(input []byte, options CodecOptions, str, b64 Codec) *PayloadIterator {
+	return &PayloadIterator{
+		input:   input,
+		options: options,
+		str:     str,
+		b64:     b64,
+	}
+}
+
+// Next decodes the next packet, it returns false when the payload is over or an error occurs.
+func (p *PayloadIterator) Next() bool {
+	p.packet = nil
+	for p.err == nil && p.offset < len(p.input) {
+		offset := p.offset
+		content, start, err := p.read()
+		if err != nil {
+			p.fail(err)
+			return false
+		}
+		packet, err := readPacket(content, p.str, p.b64)
+		if err != nil {
+			if err = withOffset(err, start); p.options.skip(err) {
+				continue
+			}
+			p.fail(err)
+			return false
+		}
+		if p.metrics != nil {
+			p.metrics.decoded(1, len(packet.Data), p.offset-offset)
+		}
+		p.packet = packet
+		return true
+	}
+	return false
+}
+
+func (p *PayloadIterator) fail(err error) {
+	p.err = err
+	if p.metrics != nil {
+		p.metrics.failed(err)
+	}
+}
+
+// Packet returns the packet decoded by the last call of Next.
+func (p *PayloadIterator) Packet() *Packet {
+	return p.packet
+}
+
+// Err returns the error which stopped the iteration, it's nil if the payload was read to the end.
+func (p *PayloadIterator) Err() error {
+	return p.err
+}
+
+// Offset returns the position in payload of the next packet.
+func (p *PayloadIterator) Offset() int {
+	return p.offset
+}
+
+// read returns the content of the next packet and its position in payload.
+func (p *PayloadIterator) read() ([]byte, int, error) {
+	size, rest, err := readPacketLength(p.input[p.offset:])
+	if err != nil {
+		return nil, 0, withOffset(err, p.offset)
+	}
+	start := len(p.input) - len(rest)
+	if err := p.options.checkSize(size, start); err != nil {
+		return nil, 0, err
+	}
+	var content []byte
+	if p.options.UTF8 == UTF8JavaScript {
+		content, rest = readUTF16(rest, size)
+	} else {
+		content, rest, _ = readPacketString(rest, size)
+	}
+	if p.options.Strict {
+		units := utf8.RuneCount(content)
+		if p.options.UTF8 == UTF8JavaScript {
+			units = utf16Len(content)
+		}
+		if units != size {
+			return nil, 0, newDecodeError(ErrInvalidLength, start, fmt.Sprintf("%d characters", size), fmt.Sprintf("%d characters", units))
+		}
+	}
+	p.offset = len(p.input) - len(rest)
+	return content, start, nil
+}
@@ -0,0 +1,51 @@
+package parser
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParsePayload(t *testing.T) {
+	payload := ParsePayload([]byte("6:4hello1:16:4world"))
+	var messages []string
+	for payload.Next() {
+		packet := payload.Packet()
+		if packet.Type == CLOSE {
+			break
+		}
+		messages = append(messages, string(packet.Data))
+	}
+	if err := payload.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 1 || messages[0] != "hello" {
+		t.Errorf("bad messages: %v", messages)
+	}
+	if payload.Offset() != 11 {
+		t.Errorf("bad offset: %d", payload.Offset())
+	}
+}
+
+func TestParsePayload_Error(t *testing.T) {
+	payload := ParsePayload([]byte("6:4hello6:9world"))
+	n := 0
+	for payload.Next() {
+		n++
+	}
+	if n != 1 {
+		t.Errorf("bad count: %d", n)
+	}
+	if !errors.Is(payload.Err(), ErrInvalidType) {
+		t.Errorf("bad error: %v", payload.Err())
+	}
+	if payload.Next() || payload.Packet() != nil {
+		t.Error("iterator should stay stopped after an error")
+	}
+}
+
+func TestParsePayload_Empty(t *testing.T) {
+	payload := ParsePayload(nil)
+	if payload.Next() || payload.Err() != nil {
+		t.Error("empty payload should have no packets")
+	}
+}
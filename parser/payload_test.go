@@ -2,6 +2,7 @@ package parser
 
 import (
 	"bytes"
+	"errors"
 	"log"
 	"testing"
 )
@@ -80,3 +81,30 @@ func TestEncodeJSONP(t *testing.T) {
 	}
 	log.Println(string(bf.Bytes()))
 }
+
+func TestDecodePayloadFunc(t *testing.T) {
+	var types []PacketType
+	err := DecodePayloadFunc([]byte("2:4a1:210:b4aGVsbG8="), func(packet *Packet) error {
+		types = append(types, packet.Type)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(types) != 3 || types[0] != MESSAGE || types[1] != PING || types[2] != MESSAGE {
+		t.Error("bad packets:", types)
+	}
+	stop := errors.New("stop")
+	var count int
+	for _, codec := range []PayloadCodec{ProtocolV3, ProtocolV3Binary, ProtocolV4} {
+		payload, _ := codec.Encode(NewPacket(MESSAGE, "a"), NewPacket(MESSAGE, "b"))
+		count = 0
+		err = codec.DecodeFunc(payload, func(packet *Packet) error {
+			count++
+			return stop
+		})
+		if err != stop || count != 1 {
+			t.Error("error of callback should stop decoding:", err, count)
+		}
+	}
+}
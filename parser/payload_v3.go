@@ -0,0 +1,174 @@
+package parser
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+)
+
+const (
+	binaryMarkerString byte = 0x00
+	binaryMarkerBinary byte = 0x01
+	binaryLengthEnd    byte = 0xFF
+)
+
+var (
+	// ProtocolV3 is the string payload codec of Engine.IO protocol v3.
+	// Every packet is prefixed with "<length>:", binary packets are encoded as base64.
+	ProtocolV3 = NewMeteredPayloadCodec(NewPayloadCodecV3(false, CodecOptions{}), payloadMetricsV3)
+	// ProtocolV3Binary is the binary payload codec of Engine.IO protocol v3, used by clients supporting XHR2.
+	// Every packet is prefixed with a 0/1 marker, the length digits and a 0xFF terminator.
+	ProtocolV3Binary = NewMeteredPayloadCodec(NewPayloadCodecV3(true, CodecOptions{}), mustMetrics(MetricsPayloadV3Binary))
+)
+
+// payloadV3 decodes both of string and binary payloads, the format is detected from the first byte.
+type payloadV3 struct {
+	binary        bool
+	options       CodecOptions
+	str, bin, b64 Codec
+}
+
+func (p *payloadV3) Encode(packets ...*Packet) ([]byte, error) {
+	return p.EncodeAppend(make([]byte, 0, payloadSizeHint(packets)), packets...)
+}
+
+func (p *payloadV3) EncodeAppend(dst []byte, packets ...*Packet) ([]byte, error) {
+	if !p.binary {
+		return appendStringPayload(dst, packets, p.options, p.str, p.b64)
+	}
+	if len(packets) < 1 {
+		return dst, ErrEmptyPayload
+	}
+	var err error
+	for _, it := range packets {
+		if dst, err = p.appendBinaryPacket(dst, it); err != nil {
+			return dst, err
+		}
+	}
+	return dst, nil
+}
+
+func (p *payloadV3) WriteTo(writer io.Writer, packets ...*Packet) error {
+	if len(packets) < 1 {
+		return ErrEmptyPayload
+	}
+	for _, it := range packets {
+		var err error
+		if p.binary {
+			err = p.writeBinaryPacket(writer, it)
+		} else {
+			err = writeStringPacket(writer, it, false, p.options, p.str, p.b64)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *payloadV3) Decode(input []byte) ([]*Packet, error) {
+	packets := make([]*Packet, 0)
+	err := p.DecodeFunc(input, func(packet *Packet) error {
+		packets = append(packets, packet)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return packets, nil
+}
+
+func (p *payloadV3) DecodeFunc(input []byte, fn func(*Packet) error) error {
+	if len(input) < 1 {
+		return ErrEmptyPayload
+	}
+	if input[0] != binaryMarkerString && input[0] != binaryMarkerBinary {
+		return visitStringPayload(input, p.options, p.str, p.b64, fn)
+	}
+	for rest := input; len(rest) > 0; {
+		offset := len(input) - len(rest)
+		packet, next, err := p.readBinaryPacket(rest)
+		if err != nil {
+			// the boundary of next packet is known if the body is read.
+			if err = withOffset(err, offset); next != nil && p.options.skip(err) {
+				rest = next
+				continue
+			}
+			return err
+		}
+		if err := fn(packet); err != nil {
+			return err
+		}
+		rest = next
+	}
+	return nil
+}
+
+func (p *payloadV3) writeBinaryPacket(writer io.Writer, packet *Packet) error {
+	scratch := acquireScratch()
+	defer releaseScratch(scratch)
+	var err error
+	if *scratch, err = p.appendBinaryPacket((*scratch)[:0], packet); err != nil {
+		return err
+	}
+	_, err = writer.Write(*scratch)
+	return err
+}
+
+func (p *payloadV3) appendBinaryPacket(dst []byte, packet *Packet) ([]byte, error) {
+	var marker byte
+	var err error
+	start := len(dst)
+	if packet.Option&BINARY != BINARY {
+		marker = binaryMarkerString
+		dst, err = p.str.EncodeAppend(dst, packet)
+	} else {
+		marker = binaryMarkerBinary
+		dst, err = p.bin.EncodeAppend(dst, packet)
+	}
+	if err != nil {
+		return dst[:start], err
+	}
+	var header [24]byte
+	h := strconv.AppendInt(append(header[:0], marker), int64(len(dst)-start), 10)
+	for i := 1; i < len(h); i++ {
+		h[i] -= '0'
+	}
+	return insertHeader(dst, start, append(h, binaryLengthEnd)), nil
+}
+
+func (p *payloadV3) readBinaryPacket(input []byte) (*Packet, []byte, error) {
+	marker := input[0]
+	if marker != binaryMarkerString && marker != binaryMarkerBinary {
+		return nil, nil, newDecodeError(ErrInvalidFormat, 0, "marker 0 or 1", fmt.Sprintf("%d", marker))
+	}
+	var size int
+	var i int
+	for i = 1; i < len(input) && input[i] != binaryLengthEnd; i++ {
+		if input[i] > 9 || i > 10 {
+			return nil, nil, newDecodeError(ErrInvalidLength, i, "length digit 0-9", fmt.Sprintf("%d", input[i]))
+		}
+		size = size*10 + int(input[i])
+	}
+	if i == 1 || i >= len(input) {
+		return nil, nil, newDecodeError(ErrInvalidLength, i, "length digits and 0xFF", "end of payload")
+	}
+	if err := p.options.checkSize(size, i+1); err != nil {
+		return nil, nil, err
+	}
+	content := input[i+1:]
+	if len(content) < size {
+		return nil, nil, newDecodeError(ErrInvalidLength, i+1, fmt.Sprintf("%d bytes", size), fmt.Sprintf("%d bytes", len(content)))
+	}
+	var packet *Packet
+	var err error
+	if marker == binaryMarkerString {
+		packet, err = p.str.Decode(content[:size])
+	} else {
+		packet, err = p.bin.Decode(content[:size])
+	}
+	if err != nil {
+		return nil, content[size:], withOffset(err, i+1)
+	}
+	return packet, content[size:], nil
+}
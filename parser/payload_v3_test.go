@@ -0,0 +1,66 @@
+package parser
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEncodePayloadV3Binary(t *testing.T) {
+	bs, err := ProtocolV3Binary.Encode(NewPacket(MESSAGE, "hello"), NewPacket(MESSAGE, []byte{1, 2, 3}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := []byte{0x00, 6, 0xFF, '4', 'h', 'e', 'l', 'l', 'o', 0x01, 4, 0xFF, 4, 1, 2, 3}
+	if !bytes.Equal(bs, exp) {
+		t.Errorf("payload should be %v, got %v", exp, bs)
+	}
+}
+
+func TestDecodePayloadV3Binary(t *testing.T) {
+	body := bytes.Repeat([]byte{0xAB}, 1234)
+	bs, err := ProtocolV3Binary.Encode(NewPacket(MESSAGE, "你好"), NewPacket(MESSAGE, body), NewPacket(PONG, "probe"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// both of v3 codecs detect the payload format.
+	for _, codec := range []PayloadCodec{ProtocolV3, ProtocolV3Binary} {
+		packets, err := codec.Decode(bs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(packets) != 3 {
+			t.Fatal("should 3 packets")
+		}
+		if string(packets[0].Data) != "你好" {
+			t.Error("illegal result")
+		}
+		if packets[1].Option&BINARY != BINARY || !bytes.Equal(packets[1].Data, body) {
+			t.Error("illegal result")
+		}
+		if packets[2].Type != PONG || string(packets[2].Data) != "probe" {
+			t.Error("illegal result")
+		}
+	}
+	for _, input := range [][]byte{{0x00, 5, 0xFF, '4'}, {0x01, 0xFF}, {0x00, 1, 2}, {0x02, 1, 0xFF, '4'}} {
+		if _, err := ProtocolV3Binary.Decode(input); err == nil {
+			t.Errorf("decode %v should fail", input)
+		}
+	}
+}
+
+func TestPayloadV3String(t *testing.T) {
+	bs, err := ProtocolV3.Encode(NewPacket(MESSAGE, "你好"), NewPacket(MESSAGE, []byte("binary fuck 2!")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(bs) != "3:4你好22:b4YmluYXJ5IGZ1Y2sgMiE=" {
+		t.Error("illegal payload:", string(bs))
+	}
+	packets, err := ProtocolV3.Decode(bs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(packets) != 2 || string(packets[1].Data) != "binary fuck 2!" {
+		t.Error("illegal result")
+	}
+}
@@ -0,0 +1,191 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+)
+
+// recordSeparator is used to join packets in a payload since protocol v4.
+const recordSeparator byte = 0x1e
+
+// PayloadCodec encodes and decodes multi packets as one polling payload.
+type PayloadCodec interface {
+	// Encode multi packets to payload bytes.
+	Encode(packets ...*Packet) ([]byte, error)
+	// WriteTo encode multi packets and write to writer.
+	WriteTo(writer io.Writer, packets ...*Packet) error
+	// Decode multi packets from payload bytes.
+	Decode(input []byte) ([]*Packet, error)
+	// DecodeFunc decode multi packets from payload bytes and calls fn for each packet in order,
+	// decoding stops at the first error returned by fn.
+	DecodeFunc(input []byte, fn func(*Packet) error) error
+	// EncodeAppend encode multi packets and append the payload to dst, returns the extended buffer.
+	EncodeAppend(dst []byte, packets ...*Packet) ([]byte, error)
+	// EncodeBuffers encode multi packets to buffers which reference the packet data, see VectorEncoder.
+	EncodeBuffers(packets ...*Packet) (net.Buffers, error)
+}
+
+var (
+	// ProtocolV4 is the payload codec of Engine.IO protocol v4.
+	// Packets are joined with the record separator (0x1e), binary packets are encoded as 'b' + base64.
+	ProtocolV4 = NewMeteredPayloadCodec(NewPayloadCodecV4(CodecOptions{}), mustMetrics(MetricsPayloadV4))
+)
+
+type payloadV4 struct {
+	options  CodecOptions
+	str, b64 Codec
+}
+
+func (p *payloadV4) Encode(packets ...*Packet) ([]byte, error) {
+	return p.EncodeAppend(make([]byte, 0, payloadSizeHint(packets)), packets...)
+}
+
+func (p *payloadV4) EncodeAppend(dst []byte, packets ...*Packet) ([]byte, error) {
+	if len(packets) < 1 {
+		return dst, ErrEmptyPayload
+	}
+	var err error
+	for i, it := range packets {
+		if i > 0 {
+			dst = append(dst, recordSeparator)
+		}
+		if it.Option&BINARY != BINARY {
+			dst, err = p.str.EncodeAppend(dst, it)
+		} else {
+			dst, err = p.b64.EncodeAppend(dst, it)
+		}
+		if err != nil {
+			return dst, err
+		}
+	}
+	return dst, nil
+}
+
+func (p *payloadV4) WriteTo(writer io.Writer, packets ...*Packet) error {
+	if len(packets) < 1 {
+		return ErrEmptyPayload
+	}
+	if !hasBody(packets) {
+		scratch := acquireScratch()
+		defer releaseScratch(scratch)
+		var err error
+		if *scratch, err = p.EncodeAppend((*scratch)[:0], packets...); err != nil {
+			return err
+		}
+		_, err = writer.Write(*scratch)
+		return err
+	}
+	for i, it := range packets {
+		if i > 0 {
+			if _, err := writer.Write([]byte{recordSeparator}); err != nil {
+				return err
+			}
+		}
+		var err error
+		if it.Option&BINARY != BINARY {
+			err = p.str.WriteTo(writer, it)
+		} else {
+			err = p.b64.WriteTo(writer, it)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *payloadV4) Decode(input []byte) ([]*Packet, error) {
+	packets := make([]*Packet, 0, bytes.Count(input, []byte{recordSeparator})+1)
+	err := p.DecodeFunc(input, func(packet *Packet) error {
+		packets = append(packets, packet)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return packets, nil
+}
+
+func (p *payloadV4) DecodeFunc(input []byte, fn func(*Packet) error) error {
+	if len(input) < 1 {
+		return ErrEmptyPayload
+	}
+	for offset := 0; offset <= len(input); {
+		end := bytes.IndexByte(input[offset:], recordSeparator)
+		if end < 0 {
+			end = len(input)
+		} else {
+			end += offset
+		}
+		it := input[offset:end]
+		var packet *Packet
+		var err error
+		if len(it) > 0 && it[0] == 'b' {
+			packet, err = p.b64.Decode(it)
+		} else {
+			packet, err = p.str.Decode(it)
+		}
+		start := offset
+		offset = end + 1
+		if err != nil {
+			if err = withOffset(err, start); p.options.skip(err) {
+				continue
+			}
+			return err
+		}
+		if err := fn(packet); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// b64CodecV4 is the base64 packet codec since protocol v4.
+// The packet type is omitted because only MESSAGE packets can carry binary data.
+type b64CodecV4 struct {
+	options CodecOptions
+}
+
+func (p *b64CodecV4) Decode(data []byte) (*Packet, error) {
+	if len(data) < 1 {
+		return nil, errEmptyPacket(0)
+	}
+	if err := p.options.checkSize(len(data), 0); err != nil {
+		return nil, err
+	}
+	if data[0] != 'b' {
+		return nil, newDecodeError(ErrInvalidFormat, 0, "'b'", fmt.Sprintf("%q", data[0]))
+	}
+	return p.options.newBase64Packet(MESSAGE, data[1:], 1)
+}
+
+func (p *b64CodecV4) WriteTo(writer io.Writer, packet *Packet) error {
+	if packet.Type != MESSAGE {
+		return fmt.Errorf("%w: binary packet %d is not supported by protocol v4", ErrInvalidType, packet.Type)
+	}
+	if _, err := writer.Write([]byte{'b'}); err != nil {
+		return err
+	}
+	return writeBase64Body(writer, packet, p.options.encoding())
+}
+
+func (p *b64CodecV4) Encode(packet *Packet) ([]byte, error) {
+	return p.EncodeAppend(make([]byte, 0, p.EncodedLen(packet)), packet)
+}
+
+func (p *b64CodecV4) EncodeAppend(dst []byte, packet *Packet) ([]byte, error) {
+	if packet.Type != MESSAGE {
+		return dst, fmt.Errorf("%w: binary packet %d is not supported by protocol v4", ErrInvalidType, packet.Type)
+	}
+	if err := packet.ReadBody(); err != nil {
+		return dst, err
+	}
+	return appendBase64(append(dst, 'b'), packet.Data, p.options.encoding()), nil
+}
+
+func (p *b64CodecV4) EncodedLen(packet *Packet) int {
+	return 1 + base64.StdEncoding.EncodedLen(dataLen(packet))
+}
@@ -0,0 +1,44 @@
+package parser
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEncodePayloadV4(t *testing.T) {
+	bs, err := ProtocolV4.Encode(NewPacket(MESSAGE, "hello"), NewPacket(MESSAGE, []byte{1, 2, 3, 4}), NewPacket(PING, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := "4hello\x1ebAQIDBA==\x1e2"
+	if string(bs) != exp {
+		t.Errorf("payload should be %q, got %q", exp, bs)
+	}
+	if _, err := ProtocolV4.Encode(NewPacket(PING, []byte{1})); err == nil {
+		t.Error("binary ping should be rejected")
+	}
+}
+
+func TestDecodePayloadV4(t *testing.T) {
+	packets, err := ProtocolV4.Decode([]byte("4你好，世界!\x1ebAQIDBA==\x1e3probe"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(packets) != 3 {
+		t.Fatal("should 3 packets")
+	}
+	if packets[0].Type != MESSAGE || string(packets[0].Data) != "你好，世界!" {
+		t.Error("illegal result")
+	}
+	if packets[1].Type != MESSAGE || packets[1].Option&BINARY != BINARY || !bytes.Equal(packets[1].Data, []byte{1, 2, 3, 4}) {
+		t.Error("illegal result")
+	}
+	if packets[2].Type != PONG || string(packets[2].Data) != "probe" {
+		t.Error("illegal result")
+	}
+	for _, input := range []string{"", "4a\x1e", "b!!!", "9"} {
+		if _, err := ProtocolV4.Decode([]byte(input)); err == nil {
+			t.Errorf("decode %q should fail", input)
+		}
+	}
+}
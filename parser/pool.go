@@ -0,0 +1,38 @@
+package parser
+
+import (
+	"sync"
+)
+
+var packetPool = sync.Pool{
+	New: func() interface{} {
+		return new(Packet)
+	},
+}
+
+// AcquirePacket returns an empty packet from the packet pool.
+// The packet should be put back with Release when it's no longer used.
+func AcquirePacket() *Packet {
+	return packetPool.Get().(*Packet)
+}
+
+// Release resets the packet and puts it back to the packet pool.
+// Neither the packet nor its data can be used after release, Clone it to retain a copy.
+func (p *Packet) Release() {
+	p.Type = 0
+	p.Data = nil
+	p.Option = 0
+	p.Options = PacketOptions{}
+	p.Body, p.BodyLen = nil, 0
+	p.pre = nil
+	packetPool.Put(p)
+}
+
+// ReleasePackets puts all of packets back to the packet pool.
+func ReleasePackets(packets []*Packet) {
+	for _, it := range packets {
+		if it != nil {
+			it.Release()
+		}
+	}
+}
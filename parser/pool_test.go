@@ -0,0 +1,57 @@
+package parser
+
+import (
+	"testing"
+)
+
+func TestPooledDecode(t *testing.T) {
+	codecs := []Codec{
+		NewStringCodec(CodecOptions{Pooled: true}),
+		NewBinaryCodec(CodecOptions{Pooled: true}),
+		NewBase64Codec(CodecOptions{Pooled: true}),
+	}
+	inputs := [][]byte{[]byte("4hello"), {byte(MESSAGE), 'h', 'e', 'l', 'l', 'o'}, []byte("b4aGVsbG8=")}
+	for i, codec := range codecs {
+		for j := 0; j < 3; j++ {
+			packet, err := codec.Decode(inputs[i])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if packet.Type != MESSAGE || string(packet.Data) != "hello" {
+				t.Error("illegal result:", string(packet.Data))
+			}
+			clone := packet.Clone()
+			packet.Release()
+			if packet.Data != nil || clone.buf != nil || string(clone.Data) != "hello" {
+				t.Error("illegal state after release")
+			}
+		}
+	}
+	if _, err := codecs[2].Decode([]byte("b4!!")); err == nil {
+		t.Error("should fail")
+	}
+}
+
+func TestPooledPayload(t *testing.T) {
+	packets, err := NewPayloadCodecV4(CodecOptions{Pooled: true}).Decode([]byte("4a\x1ebAQID"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(packets) != 2 || string(packets[0].Data) != "a" || len(packets[1].Data) != 3 {
+		t.Error("illegal result")
+	}
+	ReleasePackets(packets)
+}
+
+func BenchmarkDecodePooled(b *testing.B) {
+	codec := NewStringCodec(CodecOptions{Pooled: true})
+	input := []byte("4hello world")
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		packet, err := codec.Decode(input)
+		if err != nil {
+			b.Fatal(err)
+		}
+		packet.Release()
+	}
+}
@@ -0,0 +1,110 @@
+package parser
+
+import (
+	"sync"
+)
+
+// PreEncodedPacket caches the encoded forms of a packet, so a packet broadcasted to many sockets
+// over mixed transports is encoded at most once per form instead of once per socket.
+// Forms are encoded lazily on first use, it's safe for concurrent use.
+// The packet must not be modified after it's wrapped.
+type PreEncodedPacket struct {
+	packet *Packet
+	forms  [3]encodedForm
+	// codecs caches the forms of Encoded.
+	lock   sync.Mutex
+	codecs []codecForm
+}
+
+type codecForm struct {
+	codec Codec
+	form  *encodedForm
+}
+
+type encodedForm struct {
+	once sync.Once
+	data []byte
+	err  error
+}
+
+const (
+	formString = iota
+	formBinary
+	formBase64
+)
+
+// NewPreEncodedPacket wraps a packet to cache its encoded forms, the wrapper is returned by Packet.PreEncoded then.
+// So transports which are sent the packet can share its forms.
+func NewPreEncodedPacket(packet *Packet) *PreEncodedPacket {
+	ret := &PreEncodedPacket{packet: packet}
+	packet.pre = ret
+	return ret
+}
+
+// PreEncoded returns the wrapper of packet created by NewPreEncodedPacket, it's nil if the packet isn't wrapped.
+func (p *Packet) PreEncoded() *PreEncodedPacket {
+	return p.pre
+}
+
+// Packet returns the wrapped packet.
+func (p *PreEncodedPacket) Packet() *Packet {
+	return p.packet
+}
+
+// StringForm returns the packet encoded by the string codec, e.g. a websocket text frame.
+func (p *PreEncodedPacket) StringForm() ([]byte, error) {
+	return p.form(formString, stringEncoder)
+}
+
+// BinaryForm returns the packet encoded by the binary codec, e.g. a websocket binary frame.
+func (p *PreEncodedPacket) BinaryForm() ([]byte, error) {
+	return p.form(formBinary, binaryEncoder)
+}
+
+// Base64Form returns the packet encoded by the base64 codec, e.g. a binary packet in a string payload.
+func (p *PreEncodedPacket) Base64Form() ([]byte, error) {
+	return p.form(formBase64, base64Encoder)
+}
+
+// Form returns the form of the packet which Encode uses, according to its option.
+func (p *PreEncodedPacket) Form() ([]byte, error) {
+	switch packetCodecOf(p.packet) {
+	case binaryEncoder:
+		return p.BinaryForm()
+	case base64Encoder:
+		return p.Base64Form()
+	default:
+		return p.StringForm()
+	}
+}
+
+// Encoded returns the packet encoded by codec, it's encoded once per codec. A form is cached for every codec,
+// so it should be used with codecs shared by sessions, such as Protocol.PacketCodec, rather than per session ones.
+func (p *PreEncodedPacket) Encoded(codec Codec) ([]byte, error) {
+	p.lock.Lock()
+	var f *encodedForm
+	for _, it := range p.codecs {
+		if it.codec == codec {
+			f = it.form
+			break
+		}
+	}
+	if f == nil {
+		f = new(encodedForm)
+		p.codecs = append(p.codecs, codecForm{codec, f})
+	}
+	p.lock.Unlock()
+	f.once.Do(func() {
+		f.data, f.err = codec.Encode(p.packet)
+	})
+	return f.data, f.err
+}
+
+// form encodes the packet with codec once. The result is shared, callers must not modify it.
+func (p *PreEncodedPacket) form(i int, codec Codec) ([]byte, error) {
+	f := &p.forms[i]
+	f.once.Do(func() {
+		f.data, f.err = codec.Encode(p.packet)
+	})
+	return f.data, f.err
+}
@@ -0,0 +1,62 @@
+package parser
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestPreEncodedPacket(t *testing.T) {
+	packet := NewPacket(MESSAGE, []byte{0x01, 0x02})
+	pre := NewPreEncodedPacket(packet)
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if bs, err := pre.BinaryForm(); err != nil || string(bs) != "\x04\x01\x02" {
+				t.Error("bad binary form:", bs, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if bs, err := pre.Base64Form(); err != nil || string(bs) != "b4AQI=" {
+		t.Error("bad base64 form:", string(bs), err)
+	}
+	a, _ := pre.Form()
+	b, _ := pre.BinaryForm()
+	if &a[0] != &b[0] {
+		t.Error("form should be cached")
+	}
+	if pre.Packet() != packet {
+		t.Error("should return the wrapped packet")
+	}
+	text := NewPreEncodedPacket(NewPacket(MESSAGE, "hi"))
+	if bs, err := text.Form(); err != nil || string(bs) != "4hi" {
+		t.Error("bad string form:", string(bs), err)
+	}
+	if _, err := NewPreEncodedPacket(NewPacket(0x7F, "x")).StringForm(); err == nil {
+		t.Error("error of encoding should be cached and returned")
+	}
+}
+
+func TestPreEncodedCodecs(t *testing.T) {
+	packet := NewPacket(MESSAGE, []byte{0x01, 0x02})
+	if packet.PreEncoded() != nil {
+		t.Error("shouldn't be wrapped")
+	}
+	pre := NewPreEncodedPacket(packet)
+	if packet.PreEncoded() != pre || packet.Clone().PreEncoded() != nil {
+		t.Error("bad wrapper")
+	}
+	v3, v4 := V3.PacketCodec(true), V4.PacketCodec(true)
+	a, err := pre.Encoded(v3)
+	if err != nil || string(a) != "\x04\x01\x02" {
+		t.Error("bad v3 form:", a, err)
+	}
+	if b, err := pre.Encoded(v4); err != nil || string(b) != "\x01\x02" {
+		t.Error("bad v4 form:", b, err)
+	}
+	if c, _ := pre.Encoded(v3); &c[0] != &a[0] {
+		t.Error("form should be cached per codec")
+	}
+}
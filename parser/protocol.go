@@ -0,0 +1,209 @@
+package parser
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+)
+
+// Protocol is the revision of Engine.IO protocol, negotiated by the EIO query parameter.
+type Protocol uint8
+
+const (
+	// V3 is the protocol revision 3, used by engine.io 3.x.
+	V3 Protocol = 3
+	// V4 is the protocol revision 4, used by engine.io 4.x and later.
+	V4 Protocol = 4
+)
+
+const (
+	// ContentTypeText is the content type of string payloads.
+	ContentTypeText = "text/plain; charset=UTF-8"
+	// ContentTypeBinary is the content type of binary payloads.
+	ContentTypeBinary = "application/octet-stream"
+)
+
+var (
+	protocolCodecs = map[Protocol][2]Codec{
+		V3: {
+			NewMeteredCodec(&protocolCodec{str: stringEncoder, bin: base64Encoder}, mustMetrics(MetricsV3)),
+			NewMeteredCodec(&protocolCodec{str: stringEncoder, bin: binaryEncoder, binary: true}, mustMetrics(MetricsV3Binary)),
+		},
+		V4: {
+			NewMeteredCodec(&protocolCodec{str: stringEncoder, bin: new(b64CodecV4)}, mustMetrics(MetricsV4)),
+			NewMeteredCodec(&protocolCodec{str: stringEncoder, bin: new(rawCodecV4), binary: true}, mustMetrics(MetricsV4Binary)),
+		},
+	}
+)
+
+// ParseProtocol parses the value of EIO query parameter.
+func ParseProtocol(eio string) (Protocol, error) {
+	n, err := strconv.ParseUint(eio, 10, 8)
+	if err != nil || (Protocol(n) != V3 && Protocol(n) != V4) {
+		return 0, fmt.Errorf("parser: unsupported protocol version '%s'", eio)
+	}
+	return Protocol(n), nil
+}
+
+// String returns the value of EIO query parameter.
+func (p Protocol) String() string {
+	return strconv.Itoa(int(p))
+}
+
+// PayloadCodec returns the payload codec of polling transports.
+func (p Protocol) PayloadCodec() PayloadCodec {
+	if p == V4 {
+		return ProtocolV4
+	}
+	return ProtocolV3
+}
+
+// EncodePayload encodes packets with mixed text and binary bodies as one polling body,
+// returns the body and its content type.
+//
+// For v3 the body is a binary payload if binarySupported is true and any packet is binary,
+// or a string payload with base64 binary packets otherwise, as the JS implementation does.
+// For v4 the body is always text with 'b' prefixed base64 entries.
+func (p Protocol) EncodePayload(binarySupported bool, packets ...*Packet) ([]byte, string, error) {
+	codec, contentType := p.PayloadFormat(binarySupported, packets...)
+	body, err := codec.Encode(packets...)
+	if err != nil {
+		return nil, "", err
+	}
+	return body, contentType, nil
+}
+
+// PayloadFormat returns the payload codec and the content type EncodePayload uses for packets,
+// so a polling response can be streamed by codec.WriteTo after its Content-Type header is set.
+func (p Protocol) PayloadFormat(binarySupported bool, packets ...*Packet) (PayloadCodec, string) {
+	if p != V4 && binarySupported && hasBinary(packets) {
+		return ProtocolV3Binary, ContentTypeBinary
+	}
+	return p.PayloadCodec(), ContentTypeText
+}
+
+func hasBinary(packets []*Packet) bool {
+	for _, it := range packets {
+		if it.Option&BINARY == BINARY {
+			return true
+		}
+	}
+	return false
+}
+
+// Metrics returns the metrics of PacketCodec(binarySupported), transports count packets they decode with
+// other codecs into it.
+func (p Protocol) Metrics(binarySupported bool) *Metrics {
+	name := MetricsV3
+	if p == V4 {
+		name = MetricsV4
+	}
+	if binarySupported {
+		name += "-binary"
+	}
+	return mustMetrics(name)
+}
+
+// PacketCodec returns the codec of single packets, e.g. websocket frames.
+// String packets are encoded as text, binary packets are encoded in binary if binarySupported is true,
+// or as base64 text otherwise.
+// Decode treats input as binary if binarySupported is true, so text frames should be decoded by PacketCodec(false).
+func (p Protocol) PacketCodec(binarySupported bool) Codec {
+	codecs, ok := protocolCodecs[p]
+	if !ok {
+		codecs = protocolCodecs[V3]
+	}
+	if binarySupported {
+		return codecs[1]
+	}
+	return codecs[0]
+}
+
+// protocolCodec encodes a packet with str or bin according to its option.
+type protocolCodec struct {
+	str, bin Codec
+	binary   bool
+}
+
+func (p *protocolCodec) codecOf(packet *Packet) Codec {
+	if packet.Option&BINARY == BINARY {
+		return p.bin
+	}
+	return p.str
+}
+
+func (p *protocolCodec) Decode(data []byte) (*Packet, error) {
+	if p.binary {
+		return p.bin.Decode(data)
+	}
+	return readPacket(data, p.str, p.bin)
+}
+
+func (p *protocolCodec) WriteTo(writer io.Writer, packet *Packet) error {
+	return p.codecOf(packet).WriteTo(writer, packet)
+}
+
+func (p *protocolCodec) Encode(packet *Packet) ([]byte, error) {
+	return p.codecOf(packet).Encode(packet)
+}
+
+func (p *protocolCodec) EncodeAppend(dst []byte, packet *Packet) ([]byte, error) {
+	return p.codecOf(packet).EncodeAppend(dst, packet)
+}
+
+func (p *protocolCodec) EncodedLen(packet *Packet) int {
+	return p.codecOf(packet).EncodedLen(packet)
+}
+
+func (p *protocolCodec) EncodeBuffers(packet *Packet) (net.Buffers, error) {
+	return p.codecOf(packet).(VectorEncoder).EncodeBuffers(packet)
+}
+
+// rawCodecV4 is the binary packet codec since protocol v4.
+// A binary frame is the data of a MESSAGE packet, without the packet type.
+type rawCodecV4 struct {
+	options CodecOptions
+}
+
+func (p *rawCodecV4) Decode(data []byte) (*Packet, error) {
+	if err := p.options.checkSize(len(data), 0); err != nil {
+		return nil, err
+	}
+	return p.options.newPacket(MESSAGE, data, BINARY)
+}
+
+func (p *rawCodecV4) WriteTo(writer io.Writer, packet *Packet) error {
+	if packet.Type != MESSAGE {
+		return fmt.Errorf("%w: binary packet %d is not supported by protocol v4", ErrInvalidType, packet.Type)
+	}
+	return writeBody(writer, packet)
+}
+
+func (p *rawCodecV4) Encode(packet *Packet) ([]byte, error) {
+	return p.EncodeAppend(make([]byte, 0, p.EncodedLen(packet)), packet)
+}
+
+func (p *rawCodecV4) EncodeAppend(dst []byte, packet *Packet) ([]byte, error) {
+	if packet.Type != MESSAGE {
+		return dst, fmt.Errorf("%w: binary packet %d is not supported by protocol v4", ErrInvalidType, packet.Type)
+	}
+	if err := packet.ReadBody(); err != nil {
+		return dst, err
+	}
+	return append(dst, packet.Data...), nil
+}
+
+func (p *rawCodecV4) EncodedLen(packet *Packet) int {
+	return dataLen(packet)
+}
+
+func (p *rawCodecV4) EncodeBuffers(packet *Packet) (net.Buffers, error) {
+	if packet.Type != MESSAGE {
+		return nil, fmt.Errorf("%w: binary packet %d is not supported by protocol v4", ErrInvalidType, packet.Type)
+	}
+	if err := packet.ReadBody(); err != nil {
+		return nil, err
+	}
+	return appendBody(nil, packet.Data), nil
+}
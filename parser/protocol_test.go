@@ -0,0 +1,106 @@
+package parser
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestProtocol(t *testing.T) {
+	for _, it := range []string{"", "2", "5", "x", "300"} {
+		if _, err := ParseProtocol(it); err == nil {
+			t.Errorf("EIO=%s should be unsupported", it)
+		}
+	}
+	if v, err := ParseProtocol("4"); err != nil || v != V4 || v.String() != "4" {
+		t.Error("should parse v4:", v, err)
+	}
+	if V3.PayloadCodec() != ProtocolV3 || V4.PayloadCodec() != ProtocolV4 {
+		t.Error("bad payload codecs")
+	}
+	text, blob := NewPacket(MESSAGE, "hi"), NewPacket(MESSAGE, []byte{0x01})
+	cases := []struct {
+		codec      Codec
+		text, blob string
+	}{
+		{V3.PacketCodec(false), "4hi", "b4AQ=="},
+		{V3.PacketCodec(true), "4hi", "\x04\x01"},
+		{V4.PacketCodec(false), "4hi", "bAQ=="},
+		{V4.PacketCodec(true), "4hi", "\x01"},
+	}
+	for i, it := range cases {
+		for _, packet := range []*Packet{text, blob} {
+			exp := it.text
+			if packet == blob {
+				exp = it.blob
+			}
+			bs, err := it.codec.Encode(packet)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(bs) != exp {
+				t.Errorf("case %d: should be %q, got %q", i, exp, bs)
+			}
+			if packet == text && i%2 == 1 {
+				continue
+			}
+			decoded, err := it.codec.Decode(bs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if decoded.Type != MESSAGE || !bytes.Equal(decoded.Data, packet.Data) {
+				t.Errorf("case %d: should be %v, got %v", i, packet, decoded)
+			}
+		}
+	}
+	if _, err := V4.PacketCodec(true).Encode(NewPacket(PING, []byte{0x01})); err == nil {
+		t.Error("binary ping should be unsupported by v4")
+	}
+}
+
+func TestProtocolEncodePayload(t *testing.T) {
+	text, blob := NewPacket(MESSAGE, "hi"), NewPacket(MESSAGE, []byte{0x01})
+	cases := []struct {
+		protocol    Protocol
+		binary      bool
+		packets     []*Packet
+		body        string
+		contentType string
+	}{
+		{V3, true, []*Packet{text, blob}, "\x00\x03\xff4hi\x01\x02\xff\x04\x01", ContentTypeBinary},
+		{V3, true, []*Packet{text}, "3:4hi", ContentTypeText},
+		{V3, false, []*Packet{text, blob}, "3:4hi6:b4AQ==", ContentTypeText},
+		{V4, true, []*Packet{text, blob}, "4hi\x1ebAQ==", ContentTypeText},
+	}
+	for i, it := range cases {
+		body, contentType, err := it.protocol.EncodePayload(it.binary, it.packets...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(body) != it.body || contentType != it.contentType {
+			t.Errorf("case %d: should be %q %s, got %q %s", i, it.body, it.contentType, body, contentType)
+		}
+		decoded, err := it.protocol.PayloadCodec().Decode(body)
+		if it.protocol == V3 {
+			decoded, err = ProtocolV3Binary.Decode(body)
+		}
+		if err != nil || len(decoded) != len(it.packets) {
+			t.Errorf("case %d: decode failed: %v", i, err)
+		}
+	}
+	if _, _, err := V3.EncodePayload(true); err != ErrEmptyPayload {
+		t.Error("should be empty payload error:", err)
+	}
+}
+
+func TestProtocolPayloadFormat(t *testing.T) {
+	blob := NewPacket(MESSAGE, []byte{0x01})
+	if codec, contentType := V3.PayloadFormat(true, blob); codec != ProtocolV3Binary || contentType != ContentTypeBinary {
+		t.Errorf("should be binary payload, got %s", contentType)
+	}
+	if codec, contentType := V3.PayloadFormat(false, blob); codec != ProtocolV3 || contentType != ContentTypeText {
+		t.Errorf("should be string payload, got %s", contentType)
+	}
+	if codec, contentType := V4.PayloadFormat(true, blob); codec != ProtocolV4 || contentType != ContentTypeText {
+		t.Errorf("should be v4 payload, got %s", contentType)
+	}
+}
@@ -0,0 +1,66 @@
+package parser
+
+import (
+	"strconv"
+	"sync"
+)
+
+const (
+	scratchSize    = 1024
+	scratchMaxSize = 64 * 1024
+)
+
+// scratchPool holds buffers reused by encoders which write to an io.Writer, so no buffer is allocated per call.
+var scratchPool = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, 0, scratchSize)
+		return &b
+	},
+}
+
+func acquireScratch() *[]byte {
+	return scratchPool.Get().(*[]byte)
+}
+
+// releaseScratch puts b back to the pool, buffers grown too large are dropped to bound the memory held.
+func releaseScratch(b *[]byte) {
+	if cap(*b) > scratchMaxSize {
+		return
+	}
+	*b = (*b)[:0]
+	scratchPool.Put(b)
+}
+
+// insertHeader inserts header in dst at start, the bytes after start are moved without allocating
+// if dst has enough capacity.
+func insertHeader(dst []byte, start int, header []byte) []byte {
+	end := len(dst)
+	dst = append(dst, header...)
+	copy(dst[start+len(header):], dst[start:end])
+	copy(dst[start:], header)
+	return dst
+}
+
+// lengthHeader returns "<n>:" in b.
+func lengthHeader(b []byte, n int) []byte {
+	return append(strconv.AppendInt(b, int64(n), 10), ':')
+}
+
+// payloadSizeHint estimates the length of a payload of packets, encoders preallocate it.
+func payloadSizeHint(packets []*Packet) int {
+	var n int
+	for _, it := range packets {
+		n += dataLen(it)*4/3 + 16
+	}
+	return n
+}
+
+// hasBody reports whether any of packets is backed by a Body, which is streamed instead of buffered.
+func hasBody(packets []*Packet) bool {
+	for _, it := range packets {
+		if it.Body != nil {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,47 @@
+package parser
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPayloadCodec_EncodeAppend(t *testing.T) {
+	packets := benchPackets()
+	for name, codec := range map[string]PayloadCodec{
+		"v3":        ProtocolV3,
+		"v3-binary": ProtocolV3Binary,
+		"v4":        ProtocolV4,
+	} {
+		expect := new(bytes.Buffer)
+		if err := codec.WriteTo(expect, packets...); err != nil {
+			t.Fatal(err)
+		}
+		dst, err := codec.EncodeAppend([]byte("head"), packets...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(dst, append([]byte("head"), expect.Bytes()...)) {
+			t.Errorf("%s: EncodeAppend mismatch with WriteTo", name)
+		}
+		if _, err := codec.EncodeAppend(nil); err != ErrEmptyPayload {
+			t.Errorf("%s: bad error of empty payload: %v", name, err)
+		}
+	}
+}
+
+func TestAppendPayload(t *testing.T) {
+	bs, err := AppendPayload([]byte("x"), NewPacketByString(MESSAGE, "你好"), NewPacketCustom(PING, nil, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(bs) != "x3:4你好1:2" {
+		t.Errorf("bad payload: %s", bs)
+	}
+}
+
+func TestInsertHeader(t *testing.T) {
+	dst := insertHeader([]byte("ab1234"), 2, []byte("xyz"))
+	if string(dst) != "abxyz1234" {
+		t.Errorf("bad result: %s", dst)
+	}
+}
@@ -0,0 +1,134 @@
+package parser
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// sequenceTag is the leading byte of MESSAGE bodies stamped by Sequencer.
+const sequenceTag byte = '#'
+
+// Sequencer stamps outgoing MESSAGE packets with monotonically increasing sequence IDs,
+// so Deduper of the other peer can discard messages replayed after a reconnection.
+//
+// The body of every MESSAGE packet produced is tagged as '#<seq>:' + body, both of peers must use it.
+// It's safe for concurrent use.
+type Sequencer struct {
+	seq uint64
+}
+
+// NewSequencer returns a sequencer whose first ID is last+1, last should be zero for a new session
+// or the last ID sent before if the session is resumed.
+func NewSequencer(last uint64) *Sequencer {
+	return &Sequencer{seq: last}
+}
+
+// Stamp returns a copy of packet whose body is tagged with the next sequence ID, which is also saved
+// in Options.Seq. Packets other than MESSAGE are returned as is.
+func (p *Sequencer) Stamp(packet *Packet) (*Packet, error) {
+	if packet.Type != MESSAGE {
+		return packet, nil
+	}
+	if err := packet.ReadBody(); err != nil {
+		return nil, err
+	}
+	seq := atomic.AddUint64(&p.seq, 1)
+	data := make([]byte, 0, len(packet.Data)+22)
+	data = strconv.AppendUint(append(data, sequenceTag), seq, 10)
+	data = append(append(data, ':'), packet.Data...)
+	stamped := NewPacketCustom(MESSAGE, data, packet.Option)
+	stamped.Options = packet.Options
+	stamped.Options.Seq = seq
+	return stamped, nil
+}
+
+// Last returns the last sequence ID stamped.
+func (p *Sequencer) Last() uint64 {
+	return atomic.LoadUint64(&p.seq)
+}
+
+// Deduper accepts MESSAGE packets stamped by Sequencer and discards duplicates.
+// It remembers the IDs of a window of messages below the highest ID received, a message whose ID is
+// older than the window is treated as a duplicate. It's safe for concurrent use.
+type Deduper struct {
+	locker sync.Mutex
+	high   uint64
+	seen   []bool
+}
+
+// NewDeduper returns a deduper which remembers window sequence IDs.
+func NewDeduper(window int) *Deduper {
+	if window < 1 {
+		panic(fmt.Errorf("parser: illegal dedupe window %d", window))
+	}
+	return &Deduper{seen: make([]bool, window)}
+}
+
+// Accept consumes a received packet. It returns the packet with the tag removed from its body and the ID
+// saved in Options.Seq, or nil if it's a duplicate. Packets other than MESSAGE are returned as is.
+func (p *Deduper) Accept(packet *Packet) (*Packet, error) {
+	if packet.Type != MESSAGE {
+		return packet, nil
+	}
+	seq, body, err := readSequenceHeader(packet.Data)
+	if err != nil {
+		return nil, err
+	}
+	if !p.mark(seq) {
+		return nil, nil
+	}
+	packet.Data = body
+	packet.Options.Seq = seq
+	return packet, nil
+}
+
+// Last returns the highest sequence ID accepted.
+func (p *Deduper) Last() uint64 {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	return p.high
+}
+
+// mark records seq and returns false if it was seen before or is older than the window.
+func (p *Deduper) mark(seq uint64) bool {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	window := uint64(len(p.seen))
+	if seq > p.high {
+		// forget the IDs which move out of the window.
+		for i, n := p.high+1, uint64(0); i < seq && n < window; i, n = i+1, n+1 {
+			p.seen[i%window] = false
+		}
+		p.high = seq
+		p.seen[seq%window] = true
+		return true
+	}
+	if p.high-seq >= window || p.seen[seq%window] {
+		return false
+	}
+	p.seen[seq%window] = true
+	return true
+}
+
+// readSequenceHeader parses '#<seq>:' at the head of data.
+func readSequenceHeader(data []byte) (uint64, []byte, error) {
+	if len(data) < 1 || data[0] != sequenceTag {
+		got := "empty body"
+		if len(data) > 0 {
+			got = fmt.Sprintf("%q", data[0])
+		}
+		return 0, nil, newDecodeError(ErrInvalidFormat, 0, "sequence tag '#'", got)
+	}
+	end := bytes.IndexByte(data, ':')
+	if end < 0 {
+		return 0, nil, newDecodeError(ErrInvalidFormat, len(data), "':'", "end of body")
+	}
+	seq, err := strconv.ParseUint(string(data[1:end]), 10, 64)
+	if err != nil || seq == 0 {
+		return 0, nil, newDecodeError(ErrInvalidFormat, 1, "sequence ID", fmt.Sprintf("%q", data[1:end]))
+	}
+	return seq, data[end+1:], nil
+}
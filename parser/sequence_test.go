@@ -0,0 +1,66 @@
+package parser
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSequencer_Stamp(t *testing.T) {
+	sequencer := NewSequencer(0)
+	packet, err := sequencer.Stamp(NewPacketByString(MESSAGE, "hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(packet.Data) != "#1:hello" || packet.Options.Seq != 1 {
+		t.Errorf("bad stamped packet: %q seq=%d", packet.Data, packet.Options.Seq)
+	}
+	ping := NewPacket(PING, nil)
+	if p, _ := sequencer.Stamp(ping); p != ping {
+		t.Error("ping should not be stamped")
+	}
+	if sequencer.Last() != 1 {
+		t.Errorf("bad last: %d", sequencer.Last())
+	}
+}
+
+func TestDeduper_Accept(t *testing.T) {
+	sequencer := NewSequencer(0)
+	deduper := NewDeduper(4)
+	var stamped []*Packet
+	for i := 0; i < 8; i++ {
+		packet, _ := sequencer.Stamp(NewPacketByString(MESSAGE, "hello"))
+		stamped = append(stamped, packet)
+	}
+	accept := func(i int) bool {
+		packet, err := deduper.Accept(stamped[i].Clone())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if packet != nil && (string(packet.Data) != "hello" || packet.Options.Seq != uint64(i+1)) {
+			t.Errorf("bad accepted packet: %q seq=%d", packet.Data, packet.Options.Seq)
+		}
+		return packet != nil
+	}
+	for _, it := range []struct {
+		index  int
+		accept bool
+	}{
+		{0, true}, {2, true}, {0, false}, {1, true}, {2, false}, {7, true}, {5, true}, {3, false}, {5, false},
+	} {
+		if accept(it.index) != it.accept {
+			t.Errorf("packet %d: expected accept=%v", it.index, it.accept)
+		}
+	}
+	if deduper.Last() != 8 {
+		t.Errorf("bad last: %d", deduper.Last())
+	}
+}
+
+func TestDeduper_Malformed(t *testing.T) {
+	deduper := NewDeduper(4)
+	for _, it := range []string{"hello", "#hello", "#0:hello", "#x:hello", ""} {
+		if _, err := deduper.Accept(NewPacketByString(MESSAGE, it)); !errors.Is(err, ErrInvalidFormat) {
+			t.Errorf("%q: bad error %v", it, err)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package parser
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sync"
+)
+
+// streamChunk is the most bytes allocated for a frame before its data is read,
+// so a forged length can't make the reader allocate more than the peer sends.
+const streamChunk = 64 * 1024
+
+// StreamConn reads and writes packets over a byte stream, e.g. a TCP or Unix domain socket connection.
+// Every packet is framed by its encoded length as a 4-byte big-endian integer. String packets are encoded
+// as text and binary packets in binary, they are told apart by the type byte: '0'-'6' for text and 0-6 for binary.
+//
+// WritePacket is safe to be called concurrently, ReadPacket must be called by one goroutine.
+type StreamConn struct {
+	reader   *bufio.Reader
+	writer   io.Writer
+	options  CodecOptions
+	str, bin Codec
+	header   [4]byte
+	lock     sync.Mutex
+}
+
+// NewStreamConn returns a StreamConn over rw with options. Frames are read into new buffers,
+// so decoded packets always reference them as ZeroCopy does.
+func NewStreamConn(rw io.ReadWriter, options CodecOptions) *StreamConn {
+	decoding := options
+	decoding.ZeroCopy = true
+	return &StreamConn{
+		reader:  bufio.NewReader(rw),
+		writer:  rw,
+		options: options,
+		str:     NewStringCodec(decoding),
+		bin:     NewBinaryCodec(decoding),
+	}
+}
+
+// ReadPacket reads the next packet, it returns io.EOF if the stream ends between frames.
+func (p *StreamConn) ReadPacket() (*Packet, error) {
+	if _, err := io.ReadFull(p.reader, p.header[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, newDecodeError(ErrInvalidLength, 0, "4-byte length", "end of stream")
+		}
+		return nil, err
+	}
+	n := int64(binary.BigEndian.Uint32(p.header[:]))
+	if n < 1 {
+		return nil, errEmptyPacket(4)
+	}
+	if p.options.MaxPacketSize > 0 && n > int64(p.options.MaxPacketSize) {
+		return nil, newDecodeError(ErrPacketTooLarge, 0, fmt.Sprintf("at most %d bytes", p.options.MaxPacketSize), fmt.Sprintf("%d bytes", n))
+	}
+	frame, err := p.readFrame(n)
+	if err != nil {
+		return nil, err
+	}
+	if frame[0] < '0' {
+		return p.bin.Decode(frame)
+	}
+	return p.str.Decode(frame)
+}
+
+func (p *StreamConn) readFrame(n int64) ([]byte, error) {
+	if n <= streamChunk {
+		frame := make([]byte, n)
+		if _, err := io.ReadFull(p.reader, frame); err != nil {
+			return nil, newDecodeError(ErrInvalidLength, 4, fmt.Sprintf("%d bytes", n), "end of stream")
+		}
+		return frame, nil
+	}
+	bf := bytes.NewBuffer(make([]byte, 0, streamChunk))
+	if m, _ := bf.ReadFrom(io.LimitReader(p.reader, n)); m < n {
+		return nil, newDecodeError(ErrInvalidLength, 4, fmt.Sprintf("%d bytes", n), fmt.Sprintf("%d bytes", m))
+	}
+	return bf.Bytes(), nil
+}
+
+// WritePacket writes a packet as one frame.
+func (p *StreamConn) WritePacket(packet *Packet) error {
+	codec := p.str
+	if packet.Option&BINARY == BINARY {
+		codec = p.bin
+	}
+	scratch := acquireScratch()
+	defer releaseScratch(scratch)
+	var err error
+	if *scratch, err = codec.EncodeAppend(append((*scratch)[:0], 0, 0, 0, 0), packet); err != nil {
+		return err
+	}
+	n := len(*scratch) - 4
+	if uint64(n) > math.MaxUint32 {
+		return fmt.Errorf("%w: %d bytes can't be framed", ErrPacketTooLarge, n)
+	}
+	binary.BigEndian.PutUint32(*scratch, uint32(n))
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	_, err = p.writer.Write(*scratch)
+	return err
+}
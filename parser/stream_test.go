@@ -0,0 +1,61 @@
+package parser
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestStreamConn(t *testing.T) {
+	bf := new(bytes.Buffer)
+	conn := NewStreamConn(bf, CodecOptions{})
+	large := bytes.Repeat([]byte{0x07}, streamChunk+10)
+	packets := []*Packet{
+		NewPacket(MESSAGE, "hello"),
+		NewPacket(MESSAGE, []byte{0x01, 0x02}),
+		NewPacketCustom(PING, []byte("probe"), 0),
+		NewPacket(MESSAGE, large),
+	}
+	for _, it := range packets {
+		if err := conn.WritePacket(it); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !bytes.HasPrefix(bf.Bytes(), []byte("\x00\x00\x00\x064hello\x00\x00\x00\x03\x04\x01\x02")) {
+		t.Errorf("bad frames: %q", bf.Bytes()[:20])
+	}
+	for i, it := range packets {
+		got, err := conn.ReadPacket()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Type != it.Type || got.Option != it.Option || !bytes.Equal(got.Data, it.Data) {
+			t.Errorf("packet %d: should be %d %q, got %d %q", i, it.Type, it.Data, got.Type, got.Data)
+		}
+	}
+	if _, err := conn.ReadPacket(); err != io.EOF {
+		t.Error("should be EOF:", err)
+	}
+}
+
+func TestStreamConnMalformed(t *testing.T) {
+	cases := []struct {
+		input   string
+		options CodecOptions
+		err     error
+	}{
+		{"\x00\x00", CodecOptions{}, ErrInvalidLength},
+		{"\x00\x00\x00\x00", CodecOptions{}, ErrEmptyPacket},
+		{"\x00\x00\x00\x054hi", CodecOptions{}, ErrInvalidLength},
+		{"\xff\xff\xff\xff4hi", CodecOptions{}, ErrInvalidLength},
+		{"\x00\x00\x00\x104hello, world!!!", CodecOptions{MaxPacketSize: 8}, ErrPacketTooLarge},
+		{"\x00\x00\x00\x019", CodecOptions{}, ErrInvalidType},
+	}
+	for i, it := range cases {
+		conn := NewStreamConn(bytes.NewBufferString(it.input), it.options)
+		if _, err := conn.ReadPacket(); !errors.Is(err, it.err) {
+			t.Errorf("case %d: should be %v, got %v", i, it.err, err)
+		}
+	}
+}
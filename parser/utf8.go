@@ -0,0 +1,172 @@
+package parser
+
+import (
+	"bytes"
+	"fmt"
+	"unicode/utf8"
+)
+
+// UTF8Mode define how string packets deal with UTF-8 text.
+type UTF8Mode uint8
+
+const (
+	// UTF8Raw keeps the bytes of string packets as is, it's the default mode.
+	UTF8Raw UTF8Mode = iota
+	// UTF8Strict rejects string packets which are not valid UTF-8.
+	UTF8Strict
+	// UTF8Replace replaces invalid UTF-8 sequences of string packets with U+FFFD.
+	UTF8Replace
+	// UTF8JavaScript behaves as the reference JS implementation, which encodes text as WTF-8:
+	// lone surrogates are kept in their 3-byte encoding, encoded surrogate pairs are joined into
+	// 4-byte sequences on encode and decode, and lengths of v3 string payloads count UTF-16 code units.
+	UTF8JavaScript
+)
+
+// decodeText checks the text of a decoded string packet, offset is the position of text in input.
+func (p UTF8Mode) decodeText(text []byte, offset int) ([]byte, error) {
+	switch p {
+	default:
+		return text, nil
+	case UTF8Strict:
+		if i := invalidUTF8(text, false); i >= 0 {
+			return nil, newDecodeError(ErrInvalidUTF8, offset+i, "UTF-8 text", fmt.Sprintf("byte 0x%02X", text[i]))
+		}
+		return text, nil
+	case UTF8Replace:
+		if utf8.Valid(text) {
+			return text, nil
+		}
+		return bytes.ToValidUTF8(text, []byte(string(utf8.RuneError))), nil
+	case UTF8JavaScript:
+		if i := invalidUTF8(text, true); i >= 0 {
+			return nil, newDecodeError(ErrInvalidUTF8, offset+i, "WTF-8 text", fmt.Sprintf("byte 0x%02X", text[i]))
+		}
+		// encoded surrogate pairs are joined as encoding does, so text is the same after a round trip.
+		return toWTF8(text), nil
+	}
+}
+
+// encodeText converts the text of a string packet before encoding.
+func (p UTF8Mode) encodeText(text []byte) ([]byte, error) {
+	switch p {
+	default:
+		return text, nil
+	case UTF8Strict:
+		if i := invalidUTF8(text, false); i >= 0 {
+			return nil, fmt.Errorf("%w: illegal byte 0x%02X at %d", ErrInvalidUTF8, text[i], i)
+		}
+		return text, nil
+	case UTF8Replace:
+		if utf8.Valid(text) {
+			return text, nil
+		}
+		return bytes.ToValidUTF8(text, []byte(string(utf8.RuneError))), nil
+	case UTF8JavaScript:
+		return toWTF8(text), nil
+	}
+}
+
+// invalidUTF8 returns the index of the first invalid byte in text, or -1 if text is valid.
+// Encoded lone surrogates are legal if wtf8 is true.
+func invalidUTF8(text []byte, wtf8 bool) int {
+	for i := 0; i < len(text); {
+		if text[i] < utf8.RuneSelf {
+			i++
+			continue
+		}
+		r, width := utf8.DecodeRune(text[i:])
+		if r != utf8.RuneError || width != 1 {
+			i += width
+		} else if wtf8 && surrogateAt(text[i:]) != 0 {
+			i += 3
+		} else {
+			return i
+		}
+	}
+	return -1
+}
+
+// surrogateAt returns the surrogate encoded as 3 bytes at the head of b, or 0 if there's none.
+func surrogateAt(b []byte) rune {
+	if len(b) < 3 || b[0] != 0xED || b[1] < 0xA0 || b[1] > 0xBF || b[2] < 0x80 || b[2] > 0xBF {
+		return 0
+	}
+	return rune(b[0]&0x0F)<<12 | rune(b[1]&0x3F)<<6 | rune(b[2]&0x3F)
+}
+
+// toWTF8 joins encoded surrogate pairs into 4-byte sequences and replaces other invalid bytes with U+FFFD,
+// the result is what the JS implementation produces for the same text.
+func toWTF8(text []byte) []byte {
+	var out []byte
+	for i := 0; i < len(text); {
+		r, width := utf8.DecodeRune(text[i:])
+		if r != utf8.RuneError || width != 1 {
+			if out != nil {
+				out = append(out, text[i:i+width]...)
+			}
+			i += width
+			continue
+		}
+		if out == nil {
+			out = make([]byte, i, len(text)+3)
+			copy(out, text[:i])
+		}
+		high := surrogateAt(text[i:])
+		if high == 0 {
+			out = append(out, string(utf8.RuneError)...)
+			i++
+			continue
+		}
+		if low := surrogateAt(text[i+3:]); high < 0xDC00 && low >= 0xDC00 {
+			out = append(out, string((high-0xD800)<<10+(low-0xDC00)+0x10000)...)
+			i += 6
+			continue
+		}
+		out = append(out, text[i:i+3]...)
+		i += 3
+	}
+	if out == nil {
+		return text
+	}
+	return out
+}
+
+// utf16Len returns the length of WTF-8 text in UTF-16 code units, as the length of a JS string.
+func utf16Len(text []byte) int {
+	var n int
+	for i := 0; i < len(text); {
+		if text[i] < utf8.RuneSelf {
+			i++
+			n++
+			continue
+		}
+		r, width := utf8.DecodeRune(text[i:])
+		switch {
+		case r >= 0x10000:
+			n += 2
+		case r == utf8.RuneError && width == 1 && surrogateAt(text[i:]) != 0:
+			width = 3
+			n++
+		default:
+			n++
+		}
+		i += width
+	}
+	return n
+}
+
+// readUTF16 returns the prefix of input which is size UTF-16 code units long and the rest.
+func readUTF16(input []byte, size int) ([]byte, []byte) {
+	var i int
+	for i < len(input) && size > 0 {
+		r, width := utf8.DecodeRune(input[i:])
+		if r >= 0x10000 {
+			size--
+		} else if r == utf8.RuneError && width == 1 && surrogateAt(input[i:]) != 0 {
+			width = 3
+		}
+		size--
+		i += width
+	}
+	return input[:i], input[i:]
+}
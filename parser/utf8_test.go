@@ -0,0 +1,76 @@
+package parser
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+const (
+	loneHigh = "\xed\xa0\xbd" // U+D83D
+	loneLow  = "\xed\xb8\x80" // U+DE00
+)
+
+func TestUTF8Modes(t *testing.T) {
+	invalid := []byte("4ok\xffok")
+	if _, err := NewStringCodec(CodecOptions{UTF8: UTF8Strict}).Decode(invalid); !errors.Is(err, ErrInvalidUTF8) {
+		t.Error("strict mode should reject invalid UTF-8:", err)
+	}
+	packet, err := NewStringCodec(CodecOptions{UTF8: UTF8Replace}).Decode(invalid)
+	if err != nil || string(packet.Data) != "ok�ok" {
+		t.Error("replace mode should replace invalid UTF-8:", err)
+	}
+	js := NewStringCodec(CodecOptions{UTF8: UTF8JavaScript})
+	if _, err := js.Decode(invalid); !errors.Is(err, ErrInvalidUTF8) {
+		t.Error("javascript mode should reject invalid UTF-8:", err)
+	}
+	lone := []byte("4a" + loneHigh + "b")
+	if packet, err = js.Decode(lone); err != nil || !bytes.Equal(packet.Data, lone[1:]) {
+		t.Error("javascript mode should keep lone surrogates:", err)
+	}
+	if bs, err := js.Encode(packet); err != nil || !bytes.Equal(bs, lone) {
+		t.Error("lone surrogate should round-trip:", err)
+	}
+	// an encoded surrogate pair is joined as JS does.
+	bs, err := js.Encode(NewPacket(MESSAGE, loneHigh+loneLow+"\xff"))
+	if err != nil || string(bs) != "4😀�" {
+		t.Errorf("illegal result: %q %v", bs, err)
+	}
+	if n := js.EncodedLen(NewPacket(MESSAGE, loneHigh+loneLow)); n != 5 {
+		t.Error("encoded length should be 5, got", n)
+	}
+	if _, err := NewStringCodec(CodecOptions{UTF8: UTF8Strict}).Encode(NewPacket(MESSAGE, "\xff")); !errors.Is(err, ErrInvalidUTF8) {
+		t.Error("strict mode should reject invalid UTF-8:", err)
+	}
+}
+
+func TestUTF16Payload(t *testing.T) {
+	codec := NewPayloadCodecV3(false, CodecOptions{UTF8: UTF8JavaScript})
+	// "4😀" is 3 code units long in JS, a lone surrogate is 1.
+	input := "3:4😀3:4" + loneHigh + "x1:2"
+	bs, err := codec.Encode(NewPacket(MESSAGE, "😀"), NewPacket(MESSAGE, loneHigh+"x"), NewPacket(PING, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(bs) != input {
+		t.Errorf("payload should be %q, got %q", input, bs)
+	}
+	packets, err := codec.Decode([]byte(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(packets) != 3 || string(packets[0].Data) != "😀" || string(packets[1].Data) != loneHigh+"x" {
+		t.Error("illegal result")
+	}
+	decoder := NewDecoderWithOptions(strings.NewReader(input), CodecOptions{UTF8: UTF8JavaScript})
+	for _, exp := range []string{"😀", loneHigh + "x", ""} {
+		packet, err := decoder.Decode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(packet.Data) != exp {
+			t.Errorf("should be %q, got %q", exp, packet.Data)
+		}
+	}
+}
@@ -0,0 +1,256 @@
+package eio
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Presence tracks the users online, see EngineBuilder.SetPresence. A user is online while any of its sockets is open.
+type Presence interface {
+	// Online returns the users online on the local node sorted by user.
+	Online() []PresenceInfo
+	// Lookup returns the user of the local node, which is kept for the retention once it goes offline.
+	// ok is false if the user isn't seen in the retention.
+	Lookup(user string) (info PresenceInfo, ok bool)
+	// Cluster returns the users of all nodes sorted by user, the offline ones have no socket.
+	// It's the users of the local node unless the adapter is a PresenceAdapter.
+	Cluster() ([]PresenceInfo, error)
+	// OnJoin bind handler when a user comes online on the local node.
+	OnJoin(func(info PresenceInfo)) Presence
+	// OnLeave bind handler when a user goes offline on the local node.
+	OnLeave(func(info PresenceInfo)) Presence
+}
+
+// PresenceInfo is the presence of a user.
+type PresenceInfo struct {
+	User string `json:"user"`
+	// Sockets is the count of open sockets of user, it's 0 once user goes offline.
+	Sockets int `json:"sockets"`
+	// LastSeen is when user came online or went offline last.
+	LastSeen time.Time `json:"lastSeen"`
+}
+
+// PresenceAdapter is an Adapter sharing the users of nodes, so Presence.Cluster returns the users of all of them.
+type PresenceAdapter interface {
+	Adapter
+	// Track records the user of the local node once its sockets change.
+	Track(info PresenceInfo) error
+	// Presences returns the users of all nodes, the sockets of a user are summed and the latest LastSeen is kept.
+	Presences() ([]PresenceInfo, error)
+}
+
+// presenceImpl is the presence of an engine, users are the values of metadata key or sids if key is empty.
+type presenceImpl struct {
+	eng       *engineImpl
+	key       string
+	retention time.Duration
+	lock      sync.RWMutex
+	users     map[string]*PresenceInfo
+	// recordLock serializes the records of adapter.
+	recordLock sync.Mutex
+	// tracked is the user of sockets.
+	tracked  map[*socketImpl]string
+	pruned   time.Time
+	onJoins  []func(info PresenceInfo)
+	onLeaves []func(info PresenceInfo)
+}
+
+func newPresence(eng *engineImpl, key string, retention time.Duration) *presenceImpl {
+	return &presenceImpl{
+		eng:       eng,
+		key:       key,
+		retention: retention,
+		users:     make(map[string]*PresenceInfo),
+		tracked:   make(map[*socketImpl]string),
+		pruned:    time.Now(),
+	}
+}
+
+// presenceChange is a change of the sockets of user, which comes online or goes offline by it if joined or left is true.
+type presenceChange struct {
+	info         PresenceInfo
+	joined, left bool
+}
+
+// track sets the user of socket, it goes offline as the old user if any. A closed socket joins nothing.
+func (p *presenceImpl) track(socket *socketImpl, user string) {
+	p.lock.Lock()
+	old, tracked := p.tracked[socket]
+	if tracked && old == user || atomic.LoadInt64(&(socket.heartbeat)) == 0 {
+		p.lock.Unlock()
+		return
+	}
+	var changes []presenceChange
+	if tracked {
+		changes = append(changes, p.leaveLocked(socket, old))
+	}
+	p.tracked[socket] = user
+	info, ok := p.users[user]
+	if !ok {
+		info = &PresenceInfo{User: user}
+		p.users[user] = info
+	}
+	info.Sockets++
+	if info.Sockets == 1 {
+		info.LastSeen = time.Now()
+	}
+	changes = append(changes, presenceChange{info: *info, joined: info.Sockets == 1})
+	p.lock.Unlock()
+	p.changed(changes)
+}
+
+// untrack removes socket, its user goes offline if it's the last socket.
+func (p *presenceImpl) untrack(socket *socketImpl) {
+	p.lock.Lock()
+	user, ok := p.tracked[socket]
+	if !ok {
+		p.lock.Unlock()
+		return
+	}
+	change := p.leaveLocked(socket, user)
+	p.lock.Unlock()
+	p.changed([]presenceChange{change})
+}
+
+// leaveLocked removes socket from user while lock is held.
+func (p *presenceImpl) leaveLocked(socket *socketImpl, user string) presenceChange {
+	delete(p.tracked, socket)
+	info := p.users[user]
+	info.Sockets--
+	if info.Sockets < 1 {
+		info.LastSeen = time.Now()
+		p.prune()
+	}
+	return presenceChange{info: *info, left: info.Sockets < 1}
+}
+
+// prune forgets the users offline longer than retention while lock is held, it runs once a retention at most.
+func (p *presenceImpl) prune() {
+	if p.retention <= 0 || time.Since(p.pruned) < p.retention {
+		return
+	}
+	p.pruned = time.Now()
+	for user, info := range p.users {
+		if info.Sockets < 1 && p.pruned.Sub(info.LastSeen) > p.retention {
+			delete(p.users, user)
+		}
+	}
+}
+
+// changed records the users changed by the adapter, then runs the handlers of users which come online or go offline.
+func (p *presenceImpl) changed(changes []presenceChange) {
+	p.lock.RLock()
+	onJoins, onLeaves := p.onJoins, p.onLeaves
+	p.lock.RUnlock()
+	for _, it := range changes {
+		p.record(it.info.User)
+		var handlers []func(info PresenceInfo)
+		if it.joined {
+			handlers = onJoins
+		} else if it.left {
+			handlers = onLeaves
+		}
+		for _, fn := range handlers {
+			fn(it.info)
+		}
+	}
+}
+
+// record tracks the current presence of user by the adapter if it's a PresenceAdapter.
+// Records are serialized, so the adapter is left with the latest one of user.
+func (p *presenceImpl) record(user string) {
+	adapter, ok := p.eng.adapter.(PresenceAdapter)
+	if !ok {
+		return
+	}
+	p.recordLock.Lock()
+	defer p.recordLock.Unlock()
+	p.lock.RLock()
+	info := PresenceInfo{User: user, LastSeen: time.Now()}
+	if it, ok := p.users[user]; ok {
+		info = *it
+	}
+	p.lock.RUnlock()
+	if err := adapter.Track(info); err != nil {
+		p.eng.log(LogCluster, slog.LevelError, "track_failed", "track presence failed", "user", info.User, LogKeyError, err)
+	}
+}
+
+func (p *presenceImpl) Online() []PresenceInfo {
+	p.lock.RLock()
+	ret := make([]PresenceInfo, 0, len(p.users))
+	for _, it := range p.users {
+		if it.Sockets > 0 {
+			ret = append(ret, *it)
+		}
+	}
+	p.lock.RUnlock()
+	sortPresences(ret)
+	return ret
+}
+
+func (p *presenceImpl) Lookup(user string) (PresenceInfo, bool) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	info, ok := p.users[user]
+	if !ok || info.Sockets < 1 && p.retention > 0 && time.Since(info.LastSeen) > p.retention {
+		return PresenceInfo{}, false
+	}
+	return *info, true
+}
+
+func (p *presenceImpl) Cluster() ([]PresenceInfo, error) {
+	if adapter, ok := p.eng.adapter.(PresenceAdapter); ok {
+		ret, err := adapter.Presences()
+		if err != nil {
+			return nil, err
+		}
+		sortPresences(ret)
+		return ret, nil
+	}
+	p.lock.RLock()
+	ret := make([]PresenceInfo, 0, len(p.users))
+	for _, it := range p.users {
+		ret = append(ret, *it)
+	}
+	p.lock.RUnlock()
+	sortPresences(ret)
+	return ret, nil
+}
+
+func (p *presenceImpl) OnJoin(fn func(info PresenceInfo)) Presence {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.onJoins = append(p.onJoins, fn)
+	return p
+}
+
+func (p *presenceImpl) OnLeave(fn func(info PresenceInfo)) Presence {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.onLeaves = append(p.onLeaves, fn)
+	return p
+}
+
+func sortPresences(presences []PresenceInfo) {
+	sort.Slice(presences, func(i, j int) bool { return presences[i].User < presences[j].User })
+}
+
+// presenceUser returns the user of a metadata value.
+func presenceUser(value interface{}) string {
+	if s, ok := value.(string); ok {
+		return s
+	}
+	return fmt.Sprint(value)
+}
+
+func (p *engineImpl) Presence() Presence {
+	if p.presence == nil {
+		return nil
+	}
+	return p.presence
+}
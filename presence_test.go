@@ -0,0 +1,89 @@
+package eio
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPresence(t *testing.T) {
+	eng := NewEngineBuilder().SetPresence("user", time.Hour).Build()
+	defer eng.Close()
+	sockets := make(chan Socket, 3)
+	eng.OnConnect(func(socket Socket) { sockets <- socket })
+	joins, leaves := make(chan string, 3), make(chan string, 3)
+	eng.Presence().
+		OnJoin(func(info PresenceInfo) { joins <- info.User }).
+		OnLeave(func(info PresenceInfo) { leaves <- info.User })
+	for i := 0; i < 3; i++ {
+		client, err := eng.Loopback()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		client.Receive()
+	}
+	a, b, c := <-sockets, <-sockets, <-sockets
+	if n := len(eng.Presence().Online()); n != 0 {
+		t.Errorf("sockets without user shouldn't be online: %d", n)
+	}
+	a.Set("user", "alice")
+	b.Set("user", "alice")
+	c.Set("user", 42)
+	if user := <-joins; user != "alice" {
+		t.Errorf("bad join: %s", user)
+	}
+	if user := <-joins; user != "42" {
+		t.Errorf("bad join: %s", user)
+	}
+	online := eng.Presence().Online()
+	if len(online) != 2 || online[0].User != "42" || online[1].User != "alice" || online[1].Sockets != 2 {
+		t.Errorf("bad online users: %v", online)
+	}
+
+	a.Close()
+	c.Delete("user")
+	if user := <-leaves; user != "42" {
+		t.Errorf("alice has a socket yet: %s", user)
+	}
+	b.Set("user", "bob")
+	if user := <-leaves; user != "alice" {
+		t.Errorf("bad leave: %s", user)
+	}
+	if user := <-joins; user != "bob" {
+		t.Errorf("bad join: %s", user)
+	}
+	info, ok := eng.Presence().Lookup("alice")
+	if !ok || info.Sockets != 0 || info.LastSeen.IsZero() {
+		t.Errorf("offline user should be kept: %v %v", info, ok)
+	}
+	if _, ok := eng.Presence().Lookup("carol"); ok {
+		t.Error("carol is never seen")
+	}
+	if users, err := eng.Presence().Cluster(); err != nil || len(users) != 3 {
+		t.Errorf("cluster of no adapter should be the local users: %v %v", users, err)
+	}
+
+	if NewEngineBuilder().Build().Presence() != nil {
+		t.Error("presence should be disabled by default")
+	}
+}
+
+func TestPresenceOfSid(t *testing.T) {
+	eng := NewEngineBuilder().SetPresence("", 0).Build()
+	defer eng.Close()
+	client, err := eng.Loopback()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Receive()
+	online := eng.Presence().Online()
+	if len(online) != 1 || online[0].Sockets != 1 {
+		t.Fatalf("socket should be online by sid: %v", online)
+	}
+	client.Close()
+	for deadline := time.Now().Add(5 * time.Second); len(eng.Presence().Online()) > 0; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("closed socket should go offline")
+		}
+	}
+}
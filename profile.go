@@ -0,0 +1,43 @@
+package eio
+
+import (
+	"context"
+	"fmt"
+	"runtime/pprof"
+)
+
+// keys of the pprof labels of the goroutines of sessions, see EngineBuilder.SetProfileLabels.
+const (
+	ProfileLabelSid       = "eio.sid"
+	ProfileLabelTransport = "eio.transport"
+	ProfileLabelTenant    = "eio.tenant"
+)
+
+// transportType returns the type of the transport of socket as getTransport does, or POLLING if it has no transport
+// yet rather than panicking.
+func (p *socketImpl) transportType() TransportType {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	if p.transportPrimary != nil {
+		return p.transportPrimary.GetType()
+	} else if p.transportBackup != nil {
+		return p.transportBackup.GetType()
+	}
+	return POLLING
+}
+
+// profile runs fn with the pprof labels of socket on transport t if they're enabled, goroutines started by fn
+// inherit the labels.
+func (p *socketImpl) profile(t TransportType, fn func()) {
+	if p.engine == nil || !p.engine.profileLabels {
+		fn()
+		return
+	}
+	labels := []string{ProfileLabelSid, p.id, ProfileLabelTransport, t.String()}
+	if key := p.engine.profileTenantKey; len(key) > 0 {
+		if tenant, ok := p.Get(key); ok {
+			labels = append(labels, ProfileLabelTenant, fmt.Sprint(tenant))
+		}
+	}
+	pprof.Do(context.Background(), pprof.Labels(labels...), func(context.Context) { fn() })
+}
@@ -0,0 +1,42 @@
+package eio
+
+import (
+	"bytes"
+	"runtime/pprof"
+	"strings"
+	"testing"
+
+	"github.com/jjeffcaii/engine.io/parser"
+)
+
+func TestProfileLabels(t *testing.T) {
+	eng := NewEngineBuilder().SetProfileLabels("tenant").Build()
+	defer eng.Close()
+	sockets := make(chan Socket, 1)
+	entered, release := make(chan struct{}), make(chan struct{})
+	eng.OnConnect(func(socket Socket) {
+		socket.Set("tenant", "acme")
+		socket.OnMessage(func(data []byte) {
+			entered <- struct{}{}
+			<-release
+		})
+		sockets <- socket
+	})
+	client, err := eng.Loopback()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	client.Receive()
+	socket := <-sockets
+	client.Send(parser.NewPacketCustom(parser.MESSAGE, []byte("hello"), 0))
+	<-entered
+	var b bytes.Buffer
+	pprof.Lookup("goroutine").WriteTo(&b, 1)
+	close(release)
+	for _, it := range []string{`"eio.sid":"` + socket.ID() + `"`, `"eio.tenant":"acme"`, `"eio.transport":"loopback"`} {
+		if !strings.Contains(b.String(), it) {
+			t.Errorf("goroutine of dispatch should be labeled %s", it)
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package eio
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Headers of proxies resolving the address of client, see EngineBuilder.SetProxyHeader.
+const (
+	HeaderForwarded     = "Forwarded"
+	HeaderXForwardedFor = "X-Forwarded-For"
+	HeaderXRealIP       = "X-Real-IP"
+)
+
+// proxyHeaderTimeout is how long a connection of ProxyListener can take to send its PROXY protocol header.
+const proxyHeaderTimeout = 5 * time.Second
+
+var (
+	errProxyHeader = errors.New("proxy: invalid PROXY protocol header")
+	// proxySignature is the signature of PROXY protocol v2 headers.
+	proxySignature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+// trustedProxies is a list of networks of trusted proxies.
+type trustedProxies []*net.IPNet
+
+// parseTrustedProxies parses CIDRs or single IPs of proxies.
+func parseTrustedProxies(cidrs []string) (trustedProxies, error) {
+	ret := make(trustedProxies, 0, len(cidrs))
+	for _, it := range cidrs {
+		if !strings.Contains(it, "/") {
+			ip := net.ParseIP(it)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy: %q", it)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			ret = append(ret, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(it)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy: %q", it)
+		}
+		ret = append(ret, network)
+	}
+	return ret, nil
+}
+
+// contains returns true if ip is in a trusted network, an empty list trusts any ip.
+func (p trustedProxies) contains(ip net.IP) bool {
+	if len(p) < 1 {
+		return true
+	}
+	for _, it := range p {
+		if it.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteAddr returns the address of client of request. If the peer is a trusted proxy, the address is resolved
+// from the header, hops are walked from the right and the first one which isn't trusted is the client,
+// so addresses forged by the client are skipped. A resolved address has no port.
+func (p trustedProxies) remoteAddr(request *http.Request, header string) string {
+	peer := hostIP(request.RemoteAddr)
+	if peer == nil || !p.contains(peer) {
+		return request.RemoteAddr
+	}
+	var hops []string
+	switch header {
+	case HeaderXRealIP:
+		hops = []string{strings.TrimSpace(request.Header.Get(HeaderXRealIP))}
+	case HeaderForwarded:
+		hops = forwardedHops(request.Header.Values(HeaderForwarded))
+	default:
+		for _, line := range request.Header.Values(header) {
+			for _, it := range strings.Split(line, ",") {
+				hops = append(hops, strings.TrimSpace(it))
+			}
+		}
+	}
+	addr := request.RemoteAddr
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := hostIP(hops[i])
+		if ip == nil {
+			// obfuscated or unknown hops can't be walked through.
+			break
+		}
+		addr = ip.String()
+		if header == HeaderXRealIP || !p.contains(ip) {
+			break
+		}
+	}
+	return addr
+}
+
+// forwardedHops returns the for parameters of Forwarded headers (RFC 7239).
+func forwardedHops(lines []string) []string {
+	var ret []string
+	for _, line := range lines {
+		for _, element := range strings.Split(line, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if ok && strings.EqualFold(key, "for") {
+					ret = append(ret, strings.Trim(value, `"`))
+				}
+			}
+		}
+	}
+	return ret
+}
+
+// hostIP returns the ip of addr, which is an ip with or without port, IPv6 ones may be in brackets.
+func hostIP(addr string) net.IP {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	return net.ParseIP(strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]"))
+}
+
+// ProxyListener wraps listener whose connections begin with a PROXY protocol header (v1 or v2) sent by
+// load balancers, RemoteAddr of them returns the address of client in the header. So it can be served
+// by http.Server or Engine.Serve, and the remote address of socket is the real client.
+// Headers are read only from the peers in cidrs (CIDRs or single IPs), others are passed through as they are.
+// No cidrs trusts any peer, which is safe only if the listener can't be reached by clients directly.
+// It panics if a CIDR is invalid.
+func ProxyListener(listener net.Listener, cidrs ...string) net.Listener {
+	trusted, err := parseTrustedProxies(cidrs)
+	if err != nil {
+		panic(err)
+	}
+	return &proxyListener{Listener: listener, trusted: trusted}
+}
+
+type proxyListener struct {
+	net.Listener
+	trusted trustedProxies
+}
+
+// Accept returns the next connection, its header is read on its first Read or RemoteAddr,
+// so a slow peer doesn't block the others.
+func (p *proxyListener) Accept() (net.Conn, error) {
+	conn, err := p.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok && !p.trusted.contains(addr.IP) {
+		return conn, nil
+	}
+	return &proxyConn{Conn: conn, reader: bufio.NewReader(conn)}, nil
+}
+
+// proxyConn is a connection with a PROXY protocol header, it's closed if the header is invalid.
+type proxyConn struct {
+	net.Conn
+	reader *bufio.Reader
+	once   sync.Once
+	remote net.Addr
+	err    error
+}
+
+func (p *proxyConn) Read(b []byte) (int, error) {
+	p.once.Do(p.readHeader)
+	if p.err != nil {
+		return 0, p.err
+	}
+	return p.reader.Read(b)
+}
+
+func (p *proxyConn) RemoteAddr() net.Addr {
+	p.once.Do(p.readHeader)
+	if p.remote != nil {
+		return p.remote
+	}
+	return p.Conn.RemoteAddr()
+}
+
+func (p *proxyConn) readHeader() {
+	p.Conn.SetReadDeadline(time.Now().Add(proxyHeaderTimeout))
+	defer p.Conn.SetReadDeadline(time.Time{})
+	if p.remote, p.err = readProxyHeader(p.reader); p.err != nil {
+		p.Conn.Close()
+	}
+}
+
+// readProxyHeader reads a PROXY protocol header, the address is nil if the header carries no client,
+// such as health checks of load balancers.
+func readProxyHeader(reader *bufio.Reader) (net.Addr, error) {
+	sig, err := reader.Peek(len(proxySignature))
+	if err == nil && bytes.Equal(sig, proxySignature) {
+		return readProxyHeaderV2(reader)
+	}
+	// the line of v1 is 107 bytes at most.
+	line, err := reader.ReadSlice('\n')
+	if err != nil || len(line) > 107 || !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errProxyHeader
+	}
+	fields := strings.Fields(string(line))
+	if len(fields) < 2 || fields[0] != "PROXY" {
+		return nil, errProxyHeader
+	}
+	if fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || fields[1] != "TCP4" && fields[1] != "TCP6" {
+		return nil, errProxyHeader
+	}
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if ip == nil || err != nil {
+		return nil, errProxyHeader
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+func readProxyHeaderV2(reader *bufio.Reader) (net.Addr, error) {
+	head := make([]byte, 16)
+	if _, err := io.ReadFull(reader, head); err != nil {
+		return nil, errProxyHeader
+	}
+	if head[12]>>4 != 2 {
+		return nil, errProxyHeader
+	}
+	body := make([]byte, binary.BigEndian.Uint16(head[14:]))
+	if _, err := io.ReadFull(reader, body); err != nil {
+		return nil, errProxyHeader
+	}
+	// the LOCAL command and unspecified families carry no client.
+	if head[12]&0x0F == 0 {
+		return nil, nil
+	}
+	switch head[13] {
+	case 0x11:
+		if len(body) < 12 {
+			return nil, errProxyHeader
+		}
+		return &net.TCPAddr{IP: net.IP(body[:4]), Port: int(binary.BigEndian.Uint16(body[8:]))}, nil
+	case 0x21:
+		if len(body) < 36 {
+			return nil, errProxyHeader
+		}
+		return &net.TCPAddr{IP: net.IP(body[:16]), Port: int(binary.BigEndian.Uint16(body[32:]))}, nil
+	default:
+		return nil, nil
+	}
+}
@@ -0,0 +1,135 @@
+package eio
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTrustedProxies(t *testing.T) {
+	proxies, err := parseTrustedProxies([]string{"10.0.0.0/8", "192.168.1.1", "fd00::/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		peer, header, value, want string
+	}{
+		{"10.0.0.1:80", HeaderXForwardedFor, "203.0.113.7", "203.0.113.7"},
+		{"10.0.0.1:80", HeaderXForwardedFor, "6.6.6.6, 203.0.113.7, 10.1.1.1", "203.0.113.7"},
+		{"10.0.0.1:80", HeaderXForwardedFor, "10.2.2.2, 192.168.1.1", "10.2.2.2"},
+		{"10.0.0.1:80", HeaderXForwardedFor, "", "10.0.0.1:80"},
+		{"10.0.0.1:80", HeaderXForwardedFor, "unknown, 10.2.2.2", "10.2.2.2"},
+		{"203.0.113.9:80", HeaderXForwardedFor, "1.2.3.4", "203.0.113.9:80"},
+		{"[fd00::1]:80", HeaderForwarded, `for=192.0.2.60;proto=http, for="[2001:db8::1]:4711"`, "2001:db8::1"},
+		{"10.0.0.1:80", HeaderForwarded, "for=_hidden", "10.0.0.1:80"},
+		{"10.0.0.1:80", HeaderXRealIP, "203.0.113.7", "203.0.113.7"},
+		{"10.0.0.1:80", HeaderXRealIP, "10.2.2.2", "10.2.2.2"},
+	}
+	for _, it := range cases {
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.RemoteAddr = it.peer
+		if len(it.value) > 0 {
+			request.Header.Set(it.header, it.value)
+		}
+		if got := proxies.remoteAddr(request, it.header); got != it.want {
+			t.Errorf("%s %s=%q: got %s, want %s", it.peer, it.header, it.value, got, it.want)
+		}
+	}
+	if _, err := parseTrustedProxies([]string{"10.0.0.0/33"}); err == nil {
+		t.Error("should be invalid")
+	}
+}
+
+func TestTrustedProxiesServer(t *testing.T) {
+	srv := NewServer(WithTrustedProxies(HeaderXForwardedFor, "127.0.0.1", "::1"))
+	defer srv.Close()
+	sockets := make(chan Socket, 1)
+	srv.OnConnect(func(socket Socket) { sockets <- socket })
+	hs := httptest.NewServer(srv)
+	defer hs.Close()
+	poll(t, http.MethodGet, hs.URL+"/engine.io/?EIO=3&transport=polling", "", "")
+	if got := (<-sockets).RemoteAddr(); hostIP(got) == nil || !hostIP(got).IsLoopback() {
+		t.Errorf("should be the peer: %s", got)
+	}
+	request, _ := http.NewRequest(http.MethodGet, hs.URL+"/engine.io/?EIO=3&transport=polling", nil)
+	request.Header.Set("X-Forwarded-For", "203.0.113.7")
+	res, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if got := (<-sockets).RemoteAddr(); got != "203.0.113.7" {
+		t.Errorf("should be the client: %s", got)
+	}
+}
+
+func TestProxyHeader(t *testing.T) {
+	v2 := append([]byte(nil), proxySignature...)
+	v2 = append(v2, 0x21, 0x11, 0, 12, 203, 0, 113, 7, 10, 0, 0, 1)
+	v2 = binary.BigEndian.AppendUint16(v2, 4711)
+	v2 = binary.BigEndian.AppendUint16(v2, 80)
+	cases := []struct {
+		header string
+		want   string
+	}{
+		{"PROXY TCP4 203.0.113.7 10.0.0.1 4711 80\r\n", "203.0.113.7:4711"},
+		{"PROXY TCP6 2001:db8::1 fd00::1 4711 80\r\n", "[2001:db8::1]:4711"},
+		{"PROXY UNKNOWN\r\n", ""},
+		{string(v2), "203.0.113.7:4711"},
+		{"GET / HTTP/1.1\r\n", "error"},
+		{"PROXY TCP4 203.0.113.7 10.0.0.1 99999 80\r\n", "error"},
+	}
+	for _, it := range cases {
+		reader := bufio.NewReader(strings.NewReader(it.header + "payload"))
+		addr, err := readProxyHeader(reader)
+		switch {
+		case it.want == "error":
+			if err == nil {
+				t.Errorf("%q should be invalid", it.header)
+			}
+			continue
+		case err != nil:
+			t.Errorf("%q: %s", it.header, err)
+			continue
+		case it.want == "" && addr != nil, it.want != "" && (addr == nil || addr.String() != it.want):
+			t.Errorf("%q: bad address %v", it.header, addr)
+		}
+		if rest, _ := io.ReadAll(reader); string(rest) != "payload" {
+			t.Errorf("%q: bad payload %q", it.header, rest)
+		}
+	}
+}
+
+func TestProxyListener(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	srv := NewServer()
+	defer srv.Close()
+	sockets := make(chan Socket, 1)
+	srv.OnConnect(func(socket Socket) { sockets <- socket })
+	go http.Serve(ProxyListener(listener, "127.0.0.0/8"), srv)
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	io.WriteString(conn, "PROXY TCP4 203.0.113.7 127.0.0.1 4711 80\r\n")
+	io.WriteString(conn, "GET /engine.io/?EIO=3&transport=polling HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil || res.StatusCode != http.StatusOK {
+		t.Fatalf("bad response: %v %v", res, err)
+	}
+	res.Body.Close()
+	if got := (<-sockets).RemoteAddr(); got != "203.0.113.7:4711" {
+		t.Errorf("should be the client: %s", got)
+	}
+}
@@ -0,0 +1,260 @@
+package eio
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/jjeffcaii/engine.io/parser"
+)
+
+// maxRecoveryPackets is how many packets a suspended session buffers, it can't be recovered once it misses more.
+const maxRecoveryPackets = 1024
+
+// suspended is a session closed uncleanly, which is kept for the recovery window so its client can resume it.
+type suspended struct {
+	token   string
+	session *MigratedSession
+	timer   *time.Timer
+}
+
+// recovery keeps the suspended sessions of an engine, see EngineBuilder.SetRecovery.
+type recovery struct {
+	eng    *engineImpl
+	window time.Duration
+	lock   sync.Mutex
+	// tokens and sids index the same sessions.
+	tokens map[string]*suspended
+	sids   map[string]*suspended
+}
+
+func newRecovery(eng *engineImpl, window time.Duration) *recovery {
+	return &recovery{
+		eng:    eng,
+		window: window,
+		tokens: make(map[string]*suspended),
+		sids:   make(map[string]*suspended),
+	}
+}
+
+func newRecoveryToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// recoverable returns whether socket closed for reason can be recovered, only sockets lost by the network are.
+func (p *recovery) recoverable(socket *socketImpl) bool {
+	reason := socket.closeReason
+	return len(socket.recoveryToken) > 0 && (reason == ClosePingTimeout || reason == CloseTransportError)
+}
+
+// suspend keeps the state of socket before it leaves its rooms, the packets its transports didn't send come first.
+func (p *recovery) suspend(socket *socketImpl) {
+	session := &MigratedSession{
+		ID:       socket.id,
+		Protocol: uint8(socket.protocol),
+		Rooms:    socket.Rooms(),
+	}
+	socket.metaLock.RLock()
+	if len(socket.meta) > 0 {
+		session.Metadata = make(map[string]interface{}, len(socket.meta))
+		for k, v := range socket.meta {
+			session.Metadata[k] = v
+		}
+	}
+	socket.metaLock.RUnlock()
+	socket.lock.RLock()
+	transports := []Transport{socket.transportBackup, socket.transportPrimary}
+	socket.lock.RUnlock()
+	for _, it := range transports {
+		session.Packets = append(session.Packets, migratedPackets(undelivered(it))...)
+	}
+	it := &suspended{token: socket.recoveryToken, session: session}
+	p.lock.Lock()
+	p.tokens[it.token], p.sids[session.ID] = it, it
+	it.timer = time.AfterFunc(p.window, func() { p.expire(it) })
+	p.lock.Unlock()
+}
+
+// undelivered drains the packets a closed transport didn't send.
+func undelivered(t Transport) []*parser.Packet {
+	var queue *sendQueue
+	var tiny *tinyTransport
+	switch it := t.(type) {
+	case *xhrTransport:
+		queue, tiny = it.outbox, &it.tinyTransport
+	case *wsTransport:
+		queue, tiny = it.outbox, &it.tinyTransport
+	default:
+		return nil
+	}
+	var ret []*parser.Packet
+	for {
+		pk, ok := queue.pop()
+		if !ok {
+			break
+		}
+		if pk.Type == parser.MESSAGE {
+			ret = append(ret, pk)
+		}
+	}
+	for _, it := range tiny.takeHeld() {
+		if it.Type == parser.MESSAGE {
+			ret = append(ret, it)
+		}
+	}
+	return ret
+}
+
+// expire forgets it once the window ends, the session leaves the rooms of cluster then.
+func (p *recovery) expire(it *suspended) {
+	if !p.remove(it) {
+		return
+	}
+	p.eng.clusterLeave(it.session.ID, nil)
+	p.eng.log(LogCluster, slog.LevelInfo, "recovery_expired", "recovery expired", LogKeySid, it.session.ID)
+}
+
+// remove forgets it, it returns false if it's forgotten already.
+func (p *recovery) remove(it *suspended) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.tokens[it.token] != it {
+		return false
+	}
+	delete(p.tokens, it.token)
+	delete(p.sids, it.session.ID)
+	it.timer.Stop()
+	return true
+}
+
+// take returns the session of token and forgets it, it's nil if there's no such session.
+func (p *recovery) take(token string) *suspended {
+	if len(token) < 1 {
+		return nil
+	}
+	p.lock.Lock()
+	it, ok := p.tokens[token]
+	p.lock.Unlock()
+	if !ok || !p.remove(it) {
+		return nil
+	}
+	return it
+}
+
+// buffer keeps packet for the sessions in any of rooms, or all sessions if rooms are empty.
+func (p *recovery) buffer(rooms []string, packet MigratedPacket) {
+	p.lock.Lock()
+	if len(p.sids) < 1 {
+		p.lock.Unlock()
+		return
+	}
+	var overflows []*suspended
+	for _, it := range p.sids {
+		if len(rooms) > 0 && !intersects(it.session.Rooms, rooms) {
+			continue
+		}
+		if !p.appendLocked(it, packet) {
+			overflows = append(overflows, it)
+		}
+	}
+	p.lock.Unlock()
+	for _, it := range overflows {
+		p.expire(it)
+	}
+}
+
+// bufferTo keeps packet for the session of sid, it returns false if there's no such session.
+func (p *recovery) bufferTo(sid string, packet MigratedPacket) bool {
+	p.lock.Lock()
+	it, ok := p.sids[sid]
+	if ok && !p.appendLocked(it, packet) {
+		p.lock.Unlock()
+		p.expire(it)
+		return false
+	}
+	p.lock.Unlock()
+	return ok
+}
+
+// appendLocked appends packet to it while lock is held, it returns false if it buffers maxRecoveryPackets already.
+func (p *recovery) appendLocked(it *suspended, packet MigratedPacket) bool {
+	if len(it.session.Packets) >= maxRecoveryPackets {
+		return false
+	}
+	it.session.Packets = append(it.session.Packets, packet)
+	return true
+}
+
+// close forgets all sessions, they can't be recovered after the engine is closed.
+func (p *recovery) close() {
+	p.lock.Lock()
+	var all []*suspended
+	for _, it := range p.sids {
+		all = append(all, it)
+	}
+	p.lock.Unlock()
+	for _, it := range all {
+		p.expire(it)
+	}
+}
+
+func intersects(a, b []string) bool {
+	for _, x := range a {
+		for _, y := range b {
+			if x == y {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// buffer keeps a MESSAGE of data for the suspended sessions in any of rooms, or all of them if rooms are empty.
+func (p *engineImpl) buffer(rooms []string, data []byte, binary bool) {
+	if p.recovery != nil {
+		p.recovery.buffer(rooms, MigratedPacket{Type: parser.MESSAGE, Data: data, Binary: binary})
+	}
+}
+
+// bufferTo keeps a MESSAGE of data for the suspended session of sid, it returns false if there's no such session.
+func (p *engineImpl) bufferTo(sid string, data []byte, binary bool) bool {
+	return p.recovery != nil && p.recovery.bufferTo(sid, MigratedPacket{Type: parser.MESSAGE, Data: data, Binary: binary})
+}
+
+// reserveRecovered creates the socket of the session suspended with the recover query of request,
+// it's nil if the query is empty or the session isn't kept.
+func (p *engineImpl) reserveRecovered(ctx context.Context, remoteAddr string, request *http.Request) (*socketImpl, *MigratedSession) {
+	if p.recovery == nil || request == nil {
+		return nil, nil
+	}
+	it := p.recovery.take(request.URL.Query().Get("recover"))
+	if it == nil {
+		return nil, nil
+	}
+	socket := newSocket(ctx, it.session.ID, p, remoteAddr)
+	if !p.sockets.Put(socket) {
+		socket.cancel()
+		return nil, nil
+	}
+	socket.recovered = true
+	return socket, it.session
+}
+
+// resume gives socket the state of its suspended session, the missed packets are sent in order.
+func (p *engineImpl) resume(socket *socketImpl, session *MigratedSession) {
+	for k, v := range session.Metadata {
+		socket.Set(k, v)
+	}
+	socket.Join(session.Rooms...)
+	socket.queueMigrated(session.Packets)
+}
+
+func (p *socketImpl) Recovered() bool {
+	return p.recovered
+}
@@ -0,0 +1,73 @@
+package eio
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecovery(t *testing.T) {
+	eng := NewServer(WithRecovery(time.Minute))
+	defer eng.Close()
+	sockets := make(chan Socket, 1)
+	eng.OnConnect(func(socket Socket) { sockets <- socket })
+	server := httptest.NewServer(eng)
+	defer server.Close()
+	handshake := func(query string) messageOK {
+		_, body := poll(t, http.MethodGet, server.URL+"/engine.io/?EIO=4&transport=polling"+query, "", "")
+		var msg messageOK
+		if err := json.Unmarshal([]byte(body[1:]), &msg); err != nil {
+			t.Fatalf("bad handshake %q: %s", body, err)
+		}
+		return msg
+	}
+	open := handshake("")
+	if len(open.RecoveryToken) < 1 {
+		t.Fatal("handshake should have a recovery token")
+	}
+	socket := <-sockets
+	socket.Set("user", "alice")
+	socket.Join("news")
+	socket.Send("queued")
+	socket.(*socketImpl).closeWith(CloseTransportError, errors.New("lost"))
+
+	eng.To("news").Send([]byte("missed"), false)
+	eng.To("sports").Send([]byte("sports"), false)
+	if d, err := eng.SendTo(open.Sid, []byte("direct"), false); err != nil || d != DeliveryLocal {
+		t.Errorf("message to suspended session should be buffered: %s %v", d, err)
+	}
+	eng.Broadcast([]byte("all"), false, nil)
+
+	if other := handshake("&recover=bad"); other.Sid == open.Sid {
+		t.Error("bad token shouldn't recover")
+	}
+	<-sockets
+	resumed := handshake("&recover=" + open.RecoveryToken)
+	if resumed.Sid != open.Sid || resumed.RecoveryToken == open.RecoveryToken {
+		t.Errorf("session should be resumed with a new token: %v", resumed)
+	}
+	socket = <-sockets
+	if user, _ := socket.Get("user"); !socket.Recovered() || user != "alice" || !reflect.DeepEqual(socket.Rooms(), []string{"news"}) {
+		t.Errorf("bad recovered socket: %v %v %v", socket.Recovered(), user, socket.Rooms())
+	}
+	var messages []string
+	for deadline := time.Now().Add(5 * time.Second); len(messages) < 4 && time.Now().Before(deadline); {
+		_, body := poll(t, http.MethodGet, server.URL+"/engine.io/?EIO=4&transport=polling&sid="+open.Sid, "", "")
+		for _, it := range strings.Split(body, "\x1e") {
+			if strings.HasPrefix(it, "4") {
+				messages = append(messages, it[1:])
+			}
+		}
+	}
+	if !reflect.DeepEqual(messages, []string{"queued", "missed", "direct", "all"}) {
+		t.Errorf("missed messages should be sent in order: %v", messages)
+	}
+	if it := handshake("&recover=" + open.RecoveryToken); it.Sid == open.Sid {
+		t.Error("token shouldn't recover twice")
+	}
+}
@@ -0,0 +1,485 @@
+// Package redis is an adapter of engine.io clusters on Redis, see eio.Adapter.
+// Messages are broadcasted by pub/sub and rooms are kept in sets, SessionStore shares sessions of nodes without
+// sticky sessions. It speaks RESP without any client library.
+package redis
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	eio "github.com/jjeffcaii/engine.io"
+)
+
+const (
+	defaultAddr   = "localhost:6379"
+	defaultPrefix = "eio"
+	// defaultTimeout is the timeout of dials and commands.
+	defaultTimeout = 5 * time.Second
+	// retryInterval is how long the subscriber waits before it reconnects.
+	retryInterval      = time.Second
+	defaultSendTimeout = 200 * time.Millisecond
+	// defaultPingInterval is how often the subscriber pings, a dead connection is detected in twice of it.
+	defaultPingInterval = 30 * time.Second
+)
+
+var errAdapterClosed = errors.New("redis: adapter closed")
+
+// Options configures an Adapter.
+type Options struct {
+	// Addr is the host:port of Redis. (default is localhost:6379)
+	Addr string
+	// Password authenticates connections if it's not empty.
+	Password string
+	// DB is the database of room sets.
+	DB int
+	// Prefix is the prefix of the channel and keys, nodes of a cluster must have the same one. (default is eio)
+	Prefix string
+	// DialTimeout is the timeout of dials and commands. (default is 5 seconds)
+	DialTimeout time.Duration
+	// SendTimeout is how long SendTo waits for the node which owns the sid. (default is 200 milliseconds)
+	SendTimeout time.Duration
+	// PingInterval is how often the subscriber pings Redis, it reconnects and subscribes again once nothing is
+	// received in twice of it, so a half-open connection doesn't lose messages silently. (default is 30 seconds)
+	PingInterval time.Duration
+	// OnError receives errors of the subscriber, which reconnects after them.
+	OnError func(err error)
+}
+
+// Adapter is an eio.Adapter on Redis. A room is a set of sids at "<prefix>:room:<room>",
+// rooms of a socket are kept at "<prefix>:sid:<sid>" so they can be left once it's closed,
+// and sids of a node are kept at "<prefix>:node:<node>" so they can be expired once it's dead.
+// A message of SendTo is published to "<prefix>#sendto", the node which owns the sid acknowledges it
+// on "<prefix>#ack:<node>" of the sender.
+type Adapter struct {
+	options Options
+	channel string
+	node    eio.Node
+	// acks are the channels of SendTo waiting for acknowledgements by ID.
+	acks   sync.Map
+	ackSeq int64
+	// cmd is the connection of commands, it's dialed again once it fails. sub is the connection of subscriber.
+	cmd, sub         *conn
+	cmdLock, subLock sync.Mutex
+	done             chan struct{}
+	closed           sync.Once
+}
+
+// NewAdapter returns an adapter of options, it connects once it's started by eio.EngineBuilder.Build.
+func NewAdapter(options Options) *Adapter {
+	if len(options.Addr) < 1 {
+		options.Addr = defaultAddr
+	}
+	if len(options.Prefix) < 1 {
+		options.Prefix = defaultPrefix
+	}
+	if options.DialTimeout <= 0 {
+		options.DialTimeout = defaultTimeout
+	}
+	if options.SendTimeout <= 0 {
+		options.SendTimeout = defaultSendTimeout
+	}
+	if options.PingInterval <= 0 {
+		options.PingInterval = defaultPingInterval
+	}
+	return &Adapter{
+		options: options,
+		channel: options.Prefix + "#broadcast",
+		done:    make(chan struct{}),
+	}
+}
+
+// Start subscribes the channel of cluster in background, messages are delivered to node.
+func (p *Adapter) Start(node eio.Node) {
+	p.node = node
+	go p.subscribe()
+}
+
+// subscribe receives messages until the adapter is closed, it reconnects if the connection fails.
+func (p *Adapter) subscribe() {
+	for {
+		err := p.receive()
+		select {
+		case <-p.done:
+			return
+		default:
+		}
+		if p.options.OnError != nil {
+			p.options.OnError(err)
+		}
+		select {
+		case <-p.done:
+			return
+		case <-time.After(retryInterval):
+		}
+	}
+}
+
+func (p *Adapter) receive() error {
+	c, err := dial(&p.options)
+	if err != nil {
+		return err
+	}
+	p.subLock.Lock()
+	select {
+	case <-p.done:
+		p.subLock.Unlock()
+		c.close()
+		return errAdapterClosed
+	default:
+	}
+	p.sub = c
+	p.subLock.Unlock()
+	defer c.close()
+	node := p.node.ID()
+	if err := c.send("SUBSCRIBE", p.channel, p.sendToChannel(), p.ownerChannel(node), p.ackChannel(node)); err != nil {
+		return err
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	go p.ping(c, stop)
+	for {
+		// any reply, the pong included, extends the deadline, see ping.
+		c.c.SetReadDeadline(time.Now().Add(2 * p.options.PingInterval))
+		reply, err := c.receive()
+		if err != nil {
+			return err
+		}
+		// a message is ["message", channel, payload].
+		items, ok := reply.([]interface{})
+		if !ok || len(items) != 3 || items[0] != "message" {
+			continue
+		}
+		channel, _ := items[1].(string)
+		payload, _ := items[2].(string)
+		switch channel {
+		case p.channel:
+			var message eio.ClusterMessage
+			if err := json.Unmarshal([]byte(payload), &message); err != nil {
+				if p.options.OnError != nil {
+					p.options.OnError(fmt.Errorf("redis: bad message: %w", err))
+				}
+				continue
+			}
+			p.node.Deliver(&message)
+		case p.sendToChannel(), p.ownerChannel(node):
+			p.answerSendTo(payload)
+		default:
+			if ch, ok := p.acks.Load(payload); ok {
+				select {
+				case ch.(chan struct{}) <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}
+}
+
+// ping pings the subscriber connection c every PingInterval until stop is closed, the subscriber is the only
+// reader of c and ping is the only writer once it's subscribed.
+func (p *Adapter) ping(c *conn, stop chan struct{}) {
+	ticker := time.NewTicker(p.options.PingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		c.c.SetWriteDeadline(time.Now().Add(p.options.DialTimeout))
+		if err := c.send("PING"); err != nil {
+			// the subscriber fails by the read deadline.
+			return
+		}
+	}
+}
+
+func (p *Adapter) sendToChannel() string {
+	return p.options.Prefix + "#sendto"
+}
+
+// ownerChannel is the channel of SendTo of the sids owned by node, see eio.Node.Owner.
+func (p *Adapter) ownerChannel(node string) string {
+	return p.options.Prefix + "#sendto:" + node
+}
+
+func (p *Adapter) ackChannel(node string) string {
+	return p.options.Prefix + "#ack:" + node
+}
+
+// sendTo is a message of SendTo, ID is acknowledged by the node which delivers it.
+type sendTo struct {
+	ID      string              `json:"id"`
+	Message *eio.ClusterMessage `json:"message"`
+}
+
+// answerSendTo delivers a message of SendTo of another node and acknowledges it if the sid is local.
+func (p *Adapter) answerSendTo(payload string) {
+	var it sendTo
+	if err := json.Unmarshal([]byte(payload), &it); err != nil || it.Message == nil {
+		if p.options.OnError != nil {
+			p.options.OnError(fmt.Errorf("redis: bad message: %v", err))
+		}
+		return
+	}
+	if p.node.Deliver(it.Message) < 1 {
+		return
+	}
+	// the ack is published by the command connection, the subscriber can't publish.
+	go func() {
+		if _, err := p.do([]string{"PUBLISH", p.ackChannel(it.Message.Node), it.ID}); err != nil && p.options.OnError != nil {
+			p.options.OnError(err)
+		}
+	}()
+}
+
+// SendTo publishes message to the other nodes, it returns false if none acknowledges it in SendTimeout.
+// It's published to the owner of sid first if the node has a session router, then to all nodes if the owner
+// doesn't acknowledge it.
+func (p *Adapter) SendTo(sid string, message *eio.ClusterMessage) (bool, error) {
+	if owner := p.node.Owner(sid); len(owner) > 0 && owner != p.node.ID() {
+		if ok, err := p.sendTo(p.ownerChannel(owner), 1, message); ok || err != nil {
+			return ok, err
+		}
+	}
+	// the count of subscribers includes the local node.
+	return p.sendTo(p.sendToChannel(), 2, message)
+}
+
+// sendTo publishes message to channel and waits for the ack, it returns false if there are less than subscribers.
+func (p *Adapter) sendTo(channel string, subscribers int64, message *eio.ClusterMessage) (bool, error) {
+	id := strconv.FormatInt(atomic.AddInt64(&(p.ackSeq), 1), 36)
+	payload, err := json.Marshal(&sendTo{ID: id, Message: message})
+	if err != nil {
+		return false, err
+	}
+	ch := make(chan struct{}, 1)
+	p.acks.Store(id, ch)
+	defer p.acks.Delete(id)
+	replies, err := p.do([]string{"PUBLISH", channel, string(payload)})
+	if err != nil {
+		return false, err
+	}
+	if n, _ := replies[0].(int64); n < subscribers {
+		return false, nil
+	}
+	timer := time.NewTimer(p.options.SendTimeout)
+	defer timer.Stop()
+	select {
+	case <-ch:
+		return true, nil
+	case <-timer.C:
+		return false, nil
+	case <-p.done:
+		return false, errAdapterClosed
+	}
+}
+
+// do runs commands on the command connection in order, it stops at the first error.
+// The replies are returned in order.
+func (p *Adapter) do(commands ...[]string) ([]interface{}, error) {
+	p.cmdLock.Lock()
+	defer p.cmdLock.Unlock()
+	select {
+	case <-p.done:
+		return nil, errAdapterClosed
+	default:
+	}
+	if p.cmd == nil {
+		c, err := dial(&p.options)
+		if err != nil {
+			return nil, err
+		}
+		p.cmd = c
+	}
+	ret := make([]interface{}, 0, len(commands))
+	for _, it := range commands {
+		reply, err := p.cmd.do(p.options.DialTimeout, it...)
+		if err != nil {
+			if _, ok := err.(Error); !ok {
+				p.cmd.close()
+				p.cmd = nil
+			}
+			return nil, err
+		}
+		ret = append(ret, reply)
+	}
+	return ret, nil
+}
+
+func (p *Adapter) roomKey(room string) string {
+	return p.options.Prefix + ":room:" + room
+}
+
+func (p *Adapter) sidKey(sid string) string {
+	return p.options.Prefix + ":sid:" + sid
+}
+
+func (p *Adapter) nodeKey(node string) string {
+	return p.options.Prefix + ":node:" + node
+}
+
+// Publish publishes message to the channel of cluster.
+func (p *Adapter) Publish(message *eio.ClusterMessage) error {
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	_, err = p.do([]string{"PUBLISH", p.channel, string(payload)})
+	return err
+}
+
+func (p *Adapter) Join(sid string, rooms []string) error {
+	if len(rooms) < 1 {
+		return nil
+	}
+	commands := [][]string{
+		{"SADD", p.nodeKey(p.node.ID()), sid},
+		append([]string{"SADD", p.sidKey(sid)}, rooms...),
+	}
+	for _, it := range rooms {
+		commands = append(commands, []string{"SADD", p.roomKey(it), sid})
+	}
+	_, err := p.do(commands...)
+	return err
+}
+
+func (p *Adapter) Leave(sid string, rooms []string) error {
+	if rooms == nil {
+		replies, err := p.do([]string{"SMEMBERS", p.sidKey(sid)}, []string{"SREM", p.nodeKey(p.node.ID()), sid})
+		if err != nil {
+			return err
+		}
+		rooms = stringsOf(replies[0])
+	}
+	if len(rooms) < 1 {
+		return nil
+	}
+	commands := [][]string{append([]string{"SREM", p.sidKey(sid)}, rooms...)}
+	for _, it := range rooms {
+		commands = append(commands, []string{"SREM", p.roomKey(it), sid})
+	}
+	_, err := p.do(commands...)
+	return err
+}
+
+func (p *Adapter) Members(room string) ([]string, error) {
+	replies, err := p.do([]string{"SMEMBERS", p.roomKey(room)})
+	if err != nil {
+		return nil, err
+	}
+	return stringsOf(replies[0]), nil
+}
+
+// Close closes the connections, the subscriber stops.
+func (p *Adapter) Close() error {
+	p.closed.Do(func() {
+		p.subLock.Lock()
+		close(p.done)
+		if p.sub != nil {
+			p.sub.close()
+		}
+		p.subLock.Unlock()
+		p.cmdLock.Lock()
+		if p.cmd != nil {
+			p.cmd.close()
+			p.cmd = nil
+		}
+		p.cmdLock.Unlock()
+	})
+	return nil
+}
+
+func (p *Adapter) presenceKey(node string) string {
+	return p.options.Prefix + ":presence:" + node
+}
+
+// Track records the sockets of user of the local node in the hash "<prefix>:presence:<node>", nodes are kept in
+// the set "<prefix>:nodes" and the last seen of users are kept in the hash "<prefix>:seen" in unix nanoseconds.
+func (p *Adapter) Track(info eio.PresenceInfo) error {
+	node := p.node.ID()
+	commands := [][]string{
+		{"SADD", p.options.Prefix + ":nodes", node},
+		{"HSET", p.options.Prefix + ":seen", info.User, strconv.FormatInt(info.LastSeen.UnixNano(), 10)},
+	}
+	if info.Sockets > 0 {
+		commands = append(commands, []string{"HSET", p.presenceKey(node), info.User, strconv.Itoa(info.Sockets)})
+	} else {
+		commands = append(commands, []string{"HDEL", p.presenceKey(node), info.User})
+	}
+	_, err := p.do(commands...)
+	return err
+}
+
+// Presences returns the users of the hashes of all nodes which have tracked users.
+func (p *Adapter) Presences() ([]eio.PresenceInfo, error) {
+	replies, err := p.do([]string{"SMEMBERS", p.options.Prefix + ":nodes"}, []string{"HGETALL", p.options.Prefix + ":seen"})
+	if err != nil {
+		return nil, err
+	}
+	users := make(map[string]*eio.PresenceInfo)
+	seen := stringsOf(replies[1])
+	for i := 0; i+1 < len(seen); i += 2 {
+		n, _ := strconv.ParseInt(seen[i+1], 10, 64)
+		users[seen[i]] = &eio.PresenceInfo{User: seen[i], LastSeen: time.Unix(0, n)}
+	}
+	nodes := stringsOf(replies[0])
+	commands := make([][]string, 0, len(nodes))
+	for _, it := range nodes {
+		commands = append(commands, []string{"HGETALL", p.presenceKey(it)})
+	}
+	if replies, err = p.do(commands...); err != nil {
+		return nil, err
+	}
+	for _, reply := range replies {
+		counts := stringsOf(reply)
+		for i := 0; i+1 < len(counts); i += 2 {
+			n, _ := strconv.Atoi(counts[i+1])
+			info, ok := users[counts[i]]
+			if !ok {
+				info = &eio.PresenceInfo{User: counts[i]}
+				users[counts[i]] = info
+			}
+			info.Sockets += n
+		}
+	}
+	ret := make([]eio.PresenceInfo, 0, len(users))
+	for _, it := range users {
+		ret = append(ret, *it)
+	}
+	return ret, nil
+}
+
+// Expire removes the sids of node from their rooms, and the users of node.
+func (p *Adapter) Expire(node string) error {
+	replies, err := p.do([]string{"SMEMBERS", p.nodeKey(node)})
+	if err != nil {
+		return err
+	}
+	for _, sid := range stringsOf(replies[0]) {
+		if err := p.Leave(sid, nil); err != nil {
+			return err
+		}
+	}
+	_, err = p.do(
+		[]string{"DEL", p.nodeKey(node)},
+		[]string{"DEL", p.presenceKey(node)},
+		[]string{"SREM", p.options.Prefix + ":nodes", node},
+	)
+	return err
+}
+
+func stringsOf(reply interface{}) []string {
+	items, _ := reply.([]interface{})
+	ret := make([]string, 0, len(items))
+	for _, it := range items {
+		if s, ok := it.(string); ok {
+			ret = append(ret, s)
+		}
+	}
+	return ret
+}
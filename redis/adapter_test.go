@@ -0,0 +1,363 @@
+package redis
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	eio "github.com/jjeffcaii/engine.io"
+)
+
+// fakeRedis serves the commands used by Adapter and SessionStore in memory, keys never expire.
+type fakeRedis struct {
+	listener net.Listener
+	lock     sync.Mutex
+	sets     map[string]map[string]bool
+	strings  map[string]string
+	lists    map[string][]string
+	hashes   map[string]map[string]string
+	subs     map[string][]*conn
+	// published is the count of messages published to channels.
+	published map[string]int
+	// mute drops pings as a half-open connection does.
+	mute bool
+}
+
+func newFakeRedis(t *testing.T) *fakeRedis {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &fakeRedis{
+		listener:  listener,
+		sets:      make(map[string]map[string]bool),
+		strings:   make(map[string]string),
+		lists:     make(map[string][]string),
+		hashes:    make(map[string]map[string]string),
+		subs:      make(map[string][]*conn),
+		published: make(map[string]int),
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			c, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go p.serve(&conn{c: c, r: bufio.NewReader(c), w: bufio.NewWriter(c)})
+		}
+	}()
+	return p
+}
+
+func (p *fakeRedis) serve(c *conn) {
+	defer c.close()
+	for {
+		reply, err := c.readReply()
+		if err != nil {
+			return
+		}
+		items, _ := reply.([]interface{})
+		args := stringsOf(items)
+		if len(args) < 1 {
+			return
+		}
+		if strings.ToUpper(args[0]) == "BLPOP" {
+			p.blpop(c, args[1], args[2])
+			continue
+		}
+		p.lock.Lock()
+		switch strings.ToUpper(args[0]) {
+		case "SUBSCRIBE":
+			for i, it := range args[1:] {
+				p.subs[it] = append(p.subs[it], c)
+				fmt.Fprintf(c.w, "*3\r\n$9\r\nsubscribe\r\n$%d\r\n%s\r\n:%d\r\n", len(it), it, i+1)
+			}
+		case "PUBLISH":
+			p.published[args[1]]++
+			for _, it := range p.subs[args[1]] {
+				fmt.Fprintf(it.w, "*3\r\n$7\r\nmessage\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n", len(args[1]), args[1], len(args[2]), args[2])
+				it.w.Flush()
+			}
+			fmt.Fprintf(c.w, ":%d\r\n", len(p.subs[args[1]]))
+		case "SADD", "SREM":
+			set := p.sets[args[1]]
+			if set == nil {
+				set = make(map[string]bool)
+				p.sets[args[1]] = set
+			}
+			for _, it := range args[2:] {
+				if args[0] == "SADD" {
+					set[it] = true
+				} else {
+					delete(set, it)
+				}
+			}
+			fmt.Fprintf(c.w, ":%d\r\n", len(args)-2)
+		case "SMEMBERS":
+			fmt.Fprintf(c.w, "*%d\r\n", len(p.sets[args[1]]))
+			for it := range p.sets[args[1]] {
+				fmt.Fprintf(c.w, "$%d\r\n%s\r\n", len(it), it)
+			}
+		case "SET":
+			p.strings[args[1]] = args[2]
+			fmt.Fprint(c.w, "+OK\r\n")
+		case "GET":
+			if it, ok := p.strings[args[1]]; ok {
+				fmt.Fprintf(c.w, "$%d\r\n%s\r\n", len(it), it)
+			} else {
+				fmt.Fprint(c.w, "$-1\r\n")
+			}
+		case "DEL":
+			delete(p.strings, args[1])
+			delete(p.lists, args[1])
+			delete(p.sets, args[1])
+			delete(p.hashes, args[1])
+			fmt.Fprint(c.w, ":1\r\n")
+		case "RPUSH":
+			p.lists[args[1]] = append(p.lists[args[1]], args[2:]...)
+			fmt.Fprintf(c.w, ":%d\r\n", len(p.lists[args[1]]))
+		case "HSET":
+			hash := p.hashes[args[1]]
+			if hash == nil {
+				hash = make(map[string]string)
+				p.hashes[args[1]] = hash
+			}
+			hash[args[2]] = args[3]
+			fmt.Fprint(c.w, ":1\r\n")
+		case "HDEL":
+			delete(p.hashes[args[1]], args[2])
+			fmt.Fprint(c.w, ":1\r\n")
+		case "HGETALL":
+			fmt.Fprintf(c.w, "*%d\r\n", 2*len(p.hashes[args[1]]))
+			for k, v := range p.hashes[args[1]] {
+				fmt.Fprintf(c.w, "$%d\r\n%s\r\n$%d\r\n%s\r\n", len(k), k, len(v), v)
+			}
+		case "EXPIRE":
+			fmt.Fprint(c.w, ":1\r\n")
+		case "PING":
+			// only subscribers ping, which are replied by ["pong", ""].
+			if !p.mute {
+				fmt.Fprint(c.w, "*2\r\n$4\r\npong\r\n$0\r\n\r\n")
+			}
+		default:
+			fmt.Fprintf(c.w, "-ERR unknown command '%s'\r\n", args[0])
+		}
+		c.w.Flush()
+		p.lock.Unlock()
+	}
+}
+
+// blpop pops the head of list key, it polls the list until it times out after seconds.
+func (p *fakeRedis) blpop(c *conn, key, seconds string) {
+	n, _ := strconv.Atoi(seconds)
+	for deadline := time.Now().Add(time.Duration(n) * time.Second); ; time.Sleep(5 * time.Millisecond) {
+		p.lock.Lock()
+		if list := p.lists[key]; len(list) > 0 {
+			p.lists[key] = list[1:]
+			p.lock.Unlock()
+			fmt.Fprintf(c.w, "*2\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n", len(key), key, len(list[0]), list[0])
+			c.w.Flush()
+			return
+		}
+		p.lock.Unlock()
+		if n > 0 && time.Now().After(deadline) {
+			fmt.Fprint(c.w, "*-1\r\n")
+			c.w.Flush()
+			return
+		}
+	}
+}
+
+func (p *fakeRedis) subscribers(channel string) int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return len(p.subs[channel])
+}
+
+func TestAdapter(t *testing.T) {
+	redis := newFakeRedis(t)
+	sockets := make(chan eio.Socket, 2)
+	var engines []eio.Engine
+	var adapters []*Adapter
+	for i := 0; i < 2; i++ {
+		adapter := NewAdapter(Options{Addr: redis.listener.Addr().String()})
+		eng := eio.NewEngineBuilder().SetAdapter(adapter).SetPresence("", 0).Build()
+		defer eng.Close()
+		eng.OnConnect(func(socket eio.Socket) { sockets <- socket })
+		engines, adapters = append(engines, eng), append(adapters, adapter)
+	}
+	for deadline := time.Now().Add(5 * time.Second); redis.subscribers("eio#broadcast") < 2; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("adapters should subscribe")
+		}
+	}
+
+	client, err := engines[1].Loopback()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	client.Receive()
+	socket := <-sockets
+	socket.Join("news")
+
+	if n := engines[0].Broadcast([]byte("all"), false, nil); n != 0 {
+		t.Errorf("no local socket should be sent: %d", n)
+	}
+	if pack, err := client.Receive(); err != nil || string(pack.Data) != "all" {
+		t.Errorf("bad broadcast: %v %v", pack, err)
+	}
+	engines[0].To("sports").Send([]byte("sports"), false)
+	engines[0].To("news").Send([]byte{0x01}, true)
+	if pack, err := client.Receive(); err != nil || len(pack.Data) != 1 || pack.Data[0] != 0x01 {
+		t.Errorf("only the message of news should be sent: %v %v", pack, err)
+	}
+
+	if members, err := adapters[0].Members("news"); err != nil || len(members) != 1 || members[0] != socket.ID() {
+		t.Errorf("bad members: %v %v", members, err)
+	}
+
+	if d, err := engines[0].SendTo(socket.ID(), []byte("direct"), false); err != nil || d != eio.DeliveryForwarded {
+		t.Errorf("should be forwarded: %s %v", d, err)
+	}
+	if pack, err := client.Receive(); err != nil || string(pack.Data) != "direct" {
+		t.Errorf("bad direct message: %v %v", pack, err)
+	}
+	if users, err := engines[0].Presence().Cluster(); err != nil || len(users) != 1 || users[0].User != socket.ID() || users[0].Sockets != 1 {
+		t.Errorf("socket of b should be online: %v %v", users, err)
+	}
+	if d, err := engines[0].SendTo("nope", []byte("direct"), false); err != nil || d != eio.DeliveryUnknown {
+		t.Errorf("sid should be unknown: %s %v", d, err)
+	}
+	socket.Join("sports")
+	socket.Close()
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		a, _ := adapters[0].Members("news")
+		b, _ := adapters[0].Members("sports")
+		if len(a)+len(b) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("closed socket should leave: %v %v", a, b)
+		}
+	}
+	redis.lock.Lock()
+	defer redis.lock.Unlock()
+	for key, set := range redis.sets {
+		if len(set) > 0 && key != "eio:nodes" {
+			t.Errorf("%s should be empty: %v", key, set)
+		}
+	}
+}
+
+func TestAdapterOwner(t *testing.T) {
+	redis := newFakeRedis(t)
+	router := eio.NewSessionRouter(0).Add("a", "").Add("b", "")
+	sockets := make(chan eio.Socket, 1)
+	var engines []eio.Engine
+	for _, it := range []string{"a", "b"} {
+		eng := eio.NewEngineBuilder().SetNodeID(it).SetSessionRouter(router, false).
+			SetAdapter(NewAdapter(Options{Addr: redis.listener.Addr().String()})).Build()
+		defer eng.Close()
+		eng.OnConnect(func(socket eio.Socket) { sockets <- socket })
+		engines = append(engines, eng)
+	}
+	for deadline := time.Now().Add(5 * time.Second); redis.subscribers("eio#sendto:b") < 1; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("adapters should subscribe")
+		}
+	}
+	client, err := engines[1].Loopback()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	client.Receive()
+	socket := <-sockets
+	if d, err := engines[0].SendTo(socket.ID(), []byte("direct"), false); err != nil || d != eio.DeliveryForwarded {
+		t.Errorf("should be forwarded: %s %v", d, err)
+	}
+	if pack, err := client.Receive(); err != nil || string(pack.Data) != "direct" {
+		t.Errorf("bad direct message: %v %v", pack, err)
+	}
+	redis.lock.Lock()
+	defer redis.lock.Unlock()
+	if a, b := redis.published["eio#sendto"], redis.published["eio#sendto:b"]; a != 0 || b != 1 {
+		t.Errorf("message should be published to the owner only: %d %d", a, b)
+	}
+}
+
+func TestAdapterExpire(t *testing.T) {
+	redis := newFakeRedis(t)
+	dead, alive := NewAdapter(Options{Addr: redis.listener.Addr().String()}), NewAdapter(Options{Addr: redis.listener.Addr().String()})
+	eng := eio.NewEngineBuilder().SetAdapter(dead).Build()
+	defer eng.Close()
+	defer eio.NewEngineBuilder().SetAdapter(alive).Build().Close()
+	sockets := make(chan eio.Socket, 1)
+	eng.OnConnect(func(socket eio.Socket) { sockets <- socket })
+	client, err := eng.Loopback()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	client.Receive()
+	(<-sockets).Join("news", "sports")
+
+	if err := alive.Expire(eng.Nodes()[0]); err != nil {
+		t.Fatal(err)
+	}
+	for _, it := range []string{"news", "sports"} {
+		if members, err := alive.Members(it); err != nil || len(members) > 0 {
+			t.Errorf("sockets of dead node should be expired: %v %v", members, err)
+		}
+	}
+}
+
+func TestAdapterPing(t *testing.T) {
+	redis := newFakeRedis(t)
+	errs := make(chan error, 4)
+	eng := eio.NewEngineBuilder().SetAdapter(NewAdapter(Options{
+		Addr:         redis.listener.Addr().String(),
+		PingInterval: 20 * time.Millisecond,
+		OnError: func(err error) {
+			select {
+			case errs <- err:
+			default:
+			}
+		},
+	})).Build()
+	defer eng.Close()
+	for deadline := time.Now().Add(5 * time.Second); redis.subscribers("eio#broadcast") < 1; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("adapter should subscribe")
+		}
+	}
+	select {
+	case err := <-errs:
+		t.Fatal("pongs should keep the subscriber alive:", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	redis.lock.Lock()
+	redis.mute = true
+	redis.lock.Unlock()
+	select {
+	case err := <-errs:
+		if e, ok := err.(net.Error); !ok || !e.Timeout() {
+			t.Error("subscriber should time out:", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("half-open subscriber should be detected")
+	}
+	for deadline := time.Now().Add(5 * time.Second); redis.subscribers("eio#broadcast") < 2; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("adapter should subscribe again")
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package redis
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// Error is an error reply of Redis.
+type Error string
+
+func (e Error) Error() string {
+	return string(e)
+}
+
+var errBadReply = errors.New("redis: bad reply")
+
+// conn is a connection speaking RESP, it's not safe for concurrent use.
+type conn struct {
+	c net.Conn
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+// dial connects to the server of options and authenticates it.
+func dial(options *Options) (*conn, error) {
+	c, err := net.DialTimeout("tcp", options.Addr, options.DialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	ret := &conn{c: c, r: bufio.NewReader(c), w: bufio.NewWriter(c)}
+	if len(options.Password) > 0 {
+		if _, err := ret.do(options.DialTimeout, "AUTH", options.Password); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	if options.DB != 0 {
+		if _, err := ret.do(options.DialTimeout, "SELECT", strconv.Itoa(options.DB)); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return ret, nil
+}
+
+// do sends a command and returns its reply, it times out after timeout unless timeout is zero.
+func (p *conn) do(timeout time.Duration, args ...string) (interface{}, error) {
+	if timeout > 0 {
+		p.c.SetDeadline(time.Now().Add(timeout))
+		defer p.c.SetDeadline(time.Time{})
+	}
+	if err := p.send(args...); err != nil {
+		return nil, err
+	}
+	return p.receive()
+}
+
+func (p *conn) send(args ...string) error {
+	fmt.Fprintf(p.w, "*%d\r\n", len(args))
+	for _, it := range args {
+		fmt.Fprintf(p.w, "$%d\r\n%s\r\n", len(it), it)
+	}
+	return p.w.Flush()
+}
+
+// receive reads a reply, which is a string, an int64, nil, an Error or a []interface{} of them.
+// An Error reply is returned as the error too.
+func (p *conn) receive() (interface{}, error) {
+	reply, err := p.readReply()
+	if e, ok := reply.(Error); ok && err == nil {
+		return nil, e
+	}
+	return reply, err
+}
+
+func (p *conn) readReply() (interface{}, error) {
+	line, err := p.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, errBadReply
+	}
+	kind, body := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+':
+		return body, nil
+	case '-':
+		return Error(body), nil
+	case ':':
+		n, err := strconv.ParseInt(body, 10, 64)
+		if err != nil {
+			return nil, errBadReply
+		}
+		return n, nil
+	case '$':
+		n, err := strconv.Atoi(body)
+		if err != nil || n < -1 {
+			return nil, errBadReply
+		}
+		if n == -1 {
+			return nil, nil
+		}
+		return p.readBulk(n)
+	case '*':
+		n, err := strconv.Atoi(body)
+		if err != nil || n < -1 {
+			return nil, errBadReply
+		}
+		if n == -1 {
+			return nil, nil
+		}
+		ret := make([]interface{}, n)
+		for i := range ret {
+			if ret[i], err = p.readReply(); err != nil {
+				return nil, err
+			}
+		}
+		return ret, nil
+	}
+	return nil, errBadReply
+}
+
+func (p *conn) close() error {
+	return p.c.Close()
+}
+
+// readBulk reads a bulk string of n bytes.
+func (p *conn) readBulk(n int) (string, error) {
+	b := make([]byte, n+2)
+	if _, err := io.ReadFull(p.r, b); err != nil {
+		return "", err
+	}
+	return string(b[:n]), nil
+}
@@ -0,0 +1,301 @@
+package redis
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	eio "github.com/jjeffcaii/engine.io"
+)
+
+var errStoreClosed = errors.New("redis: session store closed")
+
+const (
+	// relayPoll is how long the owner of sessions blocks on its relay list before it checks whether it's closed.
+	relayPoll = time.Second
+	// replyTTL is how long a response waits for the relaying node, which may have given up.
+	replyTTL = time.Minute
+)
+
+// relayed is an item of the relay list of a node, the response is pushed to Reply.
+type relayed struct {
+	Reply   string              `json:"reply"`
+	Request *eio.RelayedRequest `json:"request"`
+}
+
+// SessionStore is an eio.SessionStore on Redis. A session is kept at "<prefix>:session:<sid>" and sids of a node are
+// kept at "<prefix>:sessions:<node>", so they can be expired once it's dead. Requests
+// are relayed by pushing them to the list "<prefix>:relay:<node>" the owner pops, the response is pushed
+// back to a list of the request which expires once no one waits for it.
+type SessionStore struct {
+	options Options
+	node    eio.SessionNode
+	// idle are the idle connections, a relay blocks a connection until its response comes.
+	idle   []*conn
+	lock   sync.Mutex
+	done   chan struct{}
+	closed sync.Once
+}
+
+// NewSessionStore returns a session store of options, it connects once it's started by eio.EngineBuilder.Build.
+func NewSessionStore(options Options) *SessionStore {
+	if len(options.Addr) < 1 {
+		options.Addr = defaultAddr
+	}
+	if len(options.Prefix) < 1 {
+		options.Prefix = defaultPrefix
+	}
+	if options.DialTimeout <= 0 {
+		options.DialTimeout = defaultTimeout
+	}
+	return &SessionStore{options: options, done: make(chan struct{})}
+}
+
+func (p *SessionStore) sessionKey(sid string) string {
+	return p.options.Prefix + ":session:" + sid
+}
+
+func (p *SessionStore) nodeKey(node string) string {
+	return p.options.Prefix + ":sessions:" + node
+}
+
+func (p *SessionStore) relayKey(node string) string {
+	return p.options.Prefix + ":relay:" + node
+}
+
+// get returns an idle connection or dials a new one.
+func (p *SessionStore) get() (*conn, error) {
+	p.lock.Lock()
+	select {
+	case <-p.done:
+		p.lock.Unlock()
+		return nil, errStoreClosed
+	default:
+	}
+	if n := len(p.idle); n > 0 {
+		c := p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		p.lock.Unlock()
+		return c, nil
+	}
+	p.lock.Unlock()
+	return dial(&p.options)
+}
+
+// put gives back c, it's closed if err isn't an Error reply, the connection may be broken by then.
+func (p *SessionStore) put(c *conn, err error) {
+	if _, ok := err.(Error); err != nil && !ok {
+		c.close()
+		return
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	select {
+	case <-p.done:
+		c.close()
+	default:
+		p.idle = append(p.idle, c)
+	}
+}
+
+// do runs a command on an idle connection.
+func (p *SessionStore) do(args ...string) (interface{}, error) {
+	c, err := p.get()
+	if err != nil {
+		return nil, err
+	}
+	reply, err := c.do(p.options.DialTimeout, args...)
+	p.put(c, err)
+	return reply, err
+}
+
+// Start serves the requests relayed to node in background.
+func (p *SessionStore) Start(node eio.SessionNode) {
+	p.node = node
+	go p.serve()
+}
+
+// serve pops the relay list of node until the store is closed, it reconnects if the connection fails.
+func (p *SessionStore) serve() {
+	key, wait := p.relayKey(p.node.ID()), strconv.Itoa(int(relayPoll/time.Second))
+	for {
+		c, err := p.get()
+		for err == nil {
+			select {
+			case <-p.done:
+				c.close()
+				return
+			default:
+			}
+			var reply interface{}
+			if reply, err = c.do(relayPoll+p.options.DialTimeout, "BLPOP", key, wait); err == nil {
+				p.serveItem(reply)
+			}
+		}
+		if c != nil {
+			c.close()
+		}
+		select {
+		case <-p.done:
+			return
+		default:
+		}
+		if p.options.OnError != nil {
+			p.options.OnError(err)
+		}
+		select {
+		case <-p.done:
+			return
+		case <-time.After(retryInterval):
+		}
+	}
+}
+
+// serveItem serves a reply of BLPOP, which is [key, item] or nil once it times out.
+func (p *SessionStore) serveItem(reply interface{}) {
+	items := stringsOf(reply)
+	if len(items) != 2 {
+		return
+	}
+	var item relayed
+	if err := json.Unmarshal([]byte(items[1]), &item); err != nil || item.Request == nil {
+		if p.options.OnError != nil {
+			p.options.OnError(fmt.Errorf("redis: bad relayed request: %v", err))
+		}
+		return
+	}
+	go func() {
+		payload, _ := json.Marshal(p.node.Serve(item.Request))
+		c, err := p.get()
+		if err == nil {
+			if _, err = c.do(p.options.DialTimeout, "RPUSH", item.Reply, string(payload)); err == nil {
+				_, err = c.do(p.options.DialTimeout, "EXPIRE", item.Reply, strconv.Itoa(int(replyTTL/time.Second)))
+			}
+			p.put(c, err)
+		}
+		if err != nil && p.options.OnError != nil {
+			p.options.OnError(err)
+		}
+	}()
+}
+
+// Save records the session as JSON.
+func (p *SessionStore) Save(info *eio.SessionInfo) error {
+	payload, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	if _, err := p.do("SET", p.sessionKey(info.ID), string(payload)); err != nil {
+		return err
+	}
+	_, err = p.do("SADD", p.nodeKey(info.Node), info.ID)
+	return err
+}
+
+func (p *SessionStore) Load(sid string) (*eio.SessionInfo, error) {
+	reply, err := p.do("GET", p.sessionKey(sid))
+	if err != nil || reply == nil {
+		return nil, err
+	}
+	payload, _ := reply.(string)
+	ret := new(eio.SessionInfo)
+	if err := json.Unmarshal([]byte(payload), ret); err != nil {
+		return nil, fmt.Errorf("redis: bad session: %w", err)
+	}
+	return ret, nil
+}
+
+func (p *SessionStore) Delete(sid string) error {
+	if _, err := p.do("DEL", p.sessionKey(sid)); err != nil {
+		return err
+	}
+	_, err := p.do("SREM", p.nodeKey(p.node.ID()), sid)
+	return err
+}
+
+// Expire deletes the sessions of node.
+func (p *SessionStore) Expire(node string) error {
+	reply, err := p.do("SMEMBERS", p.nodeKey(node))
+	if err != nil {
+		return err
+	}
+	for _, it := range stringsOf(reply) {
+		if _, err := p.do("DEL", p.sessionKey(it)); err != nil {
+			return err
+		}
+	}
+	_, err = p.do("DEL", p.nodeKey(node))
+	return err
+}
+
+// Relay pushes request to the relay list of node and blocks until the response comes or ctx is done.
+func (p *SessionStore) Relay(ctx context.Context, node string, request *eio.RelayedRequest) (*eio.RelayedResponse, error) {
+	id := make([]byte, 8)
+	rand.Read(id)
+	reply := p.options.Prefix + ":reply:" + hex.EncodeToString(id)
+	payload, err := json.Marshal(&relayed{Reply: reply, Request: request})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.do("RPUSH", p.relayKey(node), string(payload)); err != nil {
+		return nil, err
+	}
+	c, err := p.get()
+	if err != nil {
+		return nil, err
+	}
+	// BLPOP times out in seconds, the connection is broken once ctx is done before that.
+	wait := "0"
+	if deadline, ok := ctx.Deadline(); ok {
+		wait = strconv.Itoa(int(time.Until(deadline)/time.Second) + 1)
+	}
+	stop, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			c.c.SetDeadline(time.Now())
+		case <-stop:
+		}
+	}()
+	res, err := c.do(0, "BLPOP", reply, wait)
+	close(stop)
+	<-stopped
+	if ctx.Err() != nil {
+		c.close()
+		return nil, ctx.Err()
+	}
+	p.put(c, err)
+	if err != nil {
+		return nil, err
+	}
+	items := stringsOf(res)
+	if len(items) != 2 {
+		return nil, fmt.Errorf("redis: node %s didn't respond", node)
+	}
+	ret := new(eio.RelayedResponse)
+	if err := json.Unmarshal([]byte(items[1]), ret); err != nil {
+		return nil, fmt.Errorf("redis: bad relayed response: %w", err)
+	}
+	return ret, nil
+}
+
+// Close closes the idle connections, the owner stops serving relayed requests.
+func (p *SessionStore) Close() error {
+	p.closed.Do(func() {
+		p.lock.Lock()
+		defer p.lock.Unlock()
+		close(p.done)
+		for _, it := range p.idle {
+			it.close()
+		}
+		p.idle = nil
+	})
+	return nil
+}
@@ -0,0 +1,80 @@
+package redis
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	eio "github.com/jjeffcaii/engine.io"
+)
+
+func TestSessionStore(t *testing.T) {
+	redis := newFakeRedis(t)
+	var servers []*httptest.Server
+	sockets := make(chan eio.Socket, 1)
+	for i := 0; i < 2; i++ {
+		eng := eio.NewEngineBuilder().SetSessionStore(NewSessionStore(Options{Addr: redis.listener.Addr().String()})).Build()
+		defer eng.Close()
+		eng.OnConnect(func(socket eio.Socket) { sockets <- socket })
+		server := httptest.NewServer(http.HandlerFunc(eng.Router()))
+		defer server.Close()
+		servers = append(servers, server)
+	}
+	do := func(method, url, body string) (int, string) {
+		req, _ := http.NewRequest(method, url, strings.NewReader(body))
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		b, _ := io.ReadAll(res.Body)
+		return res.StatusCode, string(b)
+	}
+	do(http.MethodGet, servers[0].URL+"/engine.io/?EIO=3&transport=polling", "")
+	socket := <-sockets
+	messages := make(chan string, 1)
+	socket.OnMessage(func(data []byte) { messages <- string(data) })
+	query := "/engine.io/?EIO=3&transport=polling&sid=" + socket.ID()
+
+	if status, body := do(http.MethodPost, servers[1].URL+query, "6:4hello"); status != http.StatusOK || body != "ok" {
+		t.Errorf("post should be relayed: %d %q", status, body)
+	}
+	if msg := <-messages; msg != "hello" {
+		t.Errorf("bad message: %q", msg)
+	}
+	socket.Send("hi")
+	if status, body := do(http.MethodGet, servers[1].URL+query, ""); status != http.StatusOK || !strings.Contains(body, "4hi") {
+		t.Errorf("poll should be relayed: %d %q", status, body)
+	}
+
+	socket.Close()
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		redis.lock.Lock()
+		n := len(redis.strings)
+		redis.lock.Unlock()
+		if n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("session should be deleted")
+		}
+	}
+}
+
+func TestSessionStoreExpire(t *testing.T) {
+	redis := newFakeRedis(t)
+	store := NewSessionStore(Options{Addr: redis.listener.Addr().String()})
+	defer store.Close()
+	if err := store.Save(&eio.SessionInfo{ID: "a", Node: "dead"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Expire("dead"); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := store.Load("a"); err != nil || info != nil {
+		t.Errorf("session of dead node should be expired: %v %v", info, err)
+	}
+}
@@ -0,0 +1,166 @@
+package eio
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// Room is a group of sockets of an engine joined by Socket.Join, see Engine.To.
+type Room interface {
+	// Send sends data as a MESSAGE to the sockets of room as Engine.Broadcast does, it returns the count of local sockets sent.
+	Send(data []byte, binary bool) int
+	// Sockets returns the local sockets of room, see Adapter.Members for the sockets of a cluster.
+	Sockets() []Socket
+	// Len returns the count of local sockets of room.
+	Len() int
+}
+
+// roomMap is the membership of rooms, a room is removed once it has no socket.
+type roomMap struct {
+	lock    sync.RWMutex
+	members map[string]map[*socketImpl]struct{}
+	// joined is the rooms of sockets.
+	joined map[*socketImpl]map[string]struct{}
+}
+
+func newRoomMap() *roomMap {
+	return &roomMap{
+		members: make(map[string]map[*socketImpl]struct{}),
+		joined:  make(map[*socketImpl]map[string]struct{}),
+	}
+}
+
+// join adds socket to rooms, a closed socket joins nothing, so it isn't kept by rooms after it leaves all.
+// It returns false if socket is closed.
+func (p *roomMap) join(socket *socketImpl, rooms []string) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if atomic.LoadInt64(&(socket.heartbeat)) == 0 {
+		return false
+	}
+	joined, ok := p.joined[socket]
+	if !ok {
+		joined = make(map[string]struct{})
+		p.joined[socket] = joined
+	}
+	for _, room := range rooms {
+		members, ok := p.members[room]
+		if !ok {
+			members = make(map[*socketImpl]struct{})
+			p.members[room] = members
+		}
+		members[socket] = struct{}{}
+		joined[room] = struct{}{}
+	}
+	return true
+}
+
+// leave removes socket from rooms, or from all of its rooms if rooms is nil.
+func (p *roomMap) leave(socket *socketImpl, rooms []string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	joined, ok := p.joined[socket]
+	if !ok {
+		return
+	}
+	if rooms == nil {
+		for room := range joined {
+			rooms = append(rooms, room)
+		}
+	}
+	for _, room := range rooms {
+		delete(joined, room)
+		if members, ok := p.members[room]; ok {
+			delete(members, socket)
+			if len(members) < 1 {
+				delete(p.members, room)
+			}
+		}
+	}
+	if len(joined) < 1 {
+		delete(p.joined, socket)
+	}
+}
+
+// roomsOf returns the sorted rooms of socket.
+func (p *roomMap) roomsOf(socket *socketImpl) []string {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	ret := make([]string, 0, len(p.joined[socket]))
+	for room := range p.joined[socket] {
+		ret = append(ret, room)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// names returns the sorted rooms which have sockets.
+func (p *roomMap) names() []string {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	ret := make([]string, 0, len(p.members))
+	for room := range p.members {
+		ret = append(ret, room)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// sockets returns the sockets of any of rooms, a socket is returned once even if it's in many of them.
+func (p *roomMap) sockets(rooms []string) []*socketImpl {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	if len(rooms) == 1 {
+		ret := make([]*socketImpl, 0, len(p.members[rooms[0]]))
+		for it := range p.members[rooms[0]] {
+			ret = append(ret, it)
+		}
+		return ret
+	}
+	seen := make(map[*socketImpl]struct{})
+	var ret []*socketImpl
+	for _, room := range rooms {
+		for it := range p.members[room] {
+			if _, ok := seen[it]; !ok {
+				seen[it] = struct{}{}
+				ret = append(ret, it)
+			}
+		}
+	}
+	return ret
+}
+
+// roomImpl is the union of rooms.
+type roomImpl struct {
+	eng   *engineImpl
+	rooms []string
+}
+
+// Send publishes data to the rooms of other nodes too if there's an adapter, see EngineBuilder.SetAdapter.
+func (p *roomImpl) Send(data []byte, binary bool) int {
+	p.eng.publish(p.rooms, data, binary)
+	p.eng.buffer(p.rooms, data, binary)
+	return p.eng.broadcast(p.eng.rooms.sockets(p.rooms), data, binary, nil)
+}
+
+func (p *roomImpl) Sockets() []Socket {
+	sockets := p.eng.rooms.sockets(p.rooms)
+	ret := make([]Socket, len(sockets))
+	for i, it := range sockets {
+		ret[i] = it
+	}
+	return ret
+}
+
+func (p *roomImpl) Len() int {
+	return len(p.eng.rooms.sockets(p.rooms))
+}
+
+func (p *engineImpl) To(rooms ...string) Room {
+	return &roomImpl{eng: p, rooms: append([]string(nil), rooms...)}
+}
+
+func (p *engineImpl) Rooms() []string {
+	return p.rooms.names()
+}
@@ -0,0 +1,65 @@
+package eio
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRooms(t *testing.T) {
+	eng := NewEngineBuilder().Build()
+	defer eng.Close()
+	sockets := make(chan Socket, 3)
+	closed := make(chan struct{}, 3)
+	eng.OnConnect(func(socket Socket) { sockets <- socket })
+	eng.OnDisconnect(func(socket Socket, reason CloseReason) { closed <- struct{}{} })
+	var clients []LoopbackClient
+	for i := 0; i < 3; i++ {
+		client, err := eng.Loopback()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		client.Receive()
+		clients = append(clients, client)
+	}
+	a, b, c := <-sockets, <-sockets, <-sockets
+	a.Join("news", "sports")
+	b.Join("news")
+	c.Join("weather")
+	if got := a.Rooms(); !reflect.DeepEqual(got, []string{"news", "sports"}) {
+		t.Errorf("bad rooms of socket: %v", got)
+	}
+	if got := eng.Rooms(); !reflect.DeepEqual(got, []string{"news", "sports", "weather"}) {
+		t.Errorf("bad rooms: %v", got)
+	}
+	if n := eng.To("news").Len(); n != 2 {
+		t.Errorf("bad count of news: %d", n)
+	}
+	if n := eng.To("sports", "news").Send([]byte("hi"), false); n != 2 {
+		t.Errorf("sockets in both rooms should be sent once: %d", n)
+	}
+	for _, it := range clients[:2] {
+		if pack, err := it.Receive(); err != nil || string(pack.Data) != "hi" {
+			t.Errorf("bad packet: %v %v", pack, err)
+		}
+	}
+
+	a.Leave("sports")
+	if got := eng.Rooms(); !reflect.DeepEqual(got, []string{"news", "weather"}) {
+		t.Errorf("empty room should be removed: %v", got)
+	}
+	b.Close()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("socket should be closed")
+	}
+	if got := eng.To("news").Sockets(); len(got) != 1 || got[0] != a {
+		t.Errorf("closed socket should leave: %v", got)
+	}
+	b.Join("news")
+	if len(b.Rooms()) != 0 || eng.To("news").Len() != 1 {
+		t.Error("closed socket shouldn't join")
+	}
+}
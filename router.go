@@ -0,0 +1,174 @@
+package eio
+
+import (
+	"hash/crc32"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	defaultRouterReplicas = 128
+	// maxRoutedIDAttempts is how many IDs owned by other nodes are generated for a handshake before one is taken.
+	maxRoutedIDAttempts = 256
+)
+
+// SessionRouter maps sids to the nodes owning them by consistent hashing, see EngineBuilder.SetSessionRouter.
+// A node is placed on a ring at some points, so adding or removing it moves the sids of its neighbours only.
+// The nodes are the IDs of engines (see EngineBuilder.SetNodeID), a node may have an URL polling requests are
+// redirected to. It's safe for concurrent use.
+type SessionRouter struct {
+	replicas int
+	lock     sync.RWMutex
+	// points are the sorted hashes of ring, owners are the nodes of them.
+	points []uint32
+	owners map[uint32]string
+	urls   map[string]string
+}
+
+// NewSessionRouter returns an empty router which places a node at replicas points. (default is 128)
+func NewSessionRouter(replicas int) *SessionRouter {
+	if replicas <= 0 {
+		replicas = defaultRouterReplicas
+	}
+	return &SessionRouter{
+		replicas: replicas,
+		owners:   make(map[uint32]string),
+		urls:     make(map[string]string),
+	}
+}
+
+// Add puts node on the ring, url is the base URL of node such as "http://10.0.0.2:8080", it may be empty if the
+// node isn't redirected to. The URL of a node added already is replaced.
+func (p *SessionRouter) Add(node, url string) *SessionRouter {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if _, ok := p.urls[node]; !ok {
+		for i := 0; i < p.replicas; i++ {
+			point := routerHash(node + "#" + strconv.Itoa(i))
+			// a collision is owned by the least node, so the ring doesn't depend on the order of adds.
+			if owner, ok := p.owners[point]; ok && owner < node {
+				continue
+			}
+			p.owners[point] = node
+		}
+		p.reindex()
+	}
+	p.urls[node] = url
+	return p
+}
+
+// Remove takes node off the ring, its sids are owned by the next nodes of ring.
+func (p *SessionRouter) Remove(node string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if _, ok := p.urls[node]; !ok {
+		return
+	}
+	delete(p.urls, node)
+	for point, owner := range p.owners {
+		if owner == node {
+			delete(p.owners, point)
+		}
+	}
+	// the points taken by other nodes are placed again.
+	for other := range p.urls {
+		for i := 0; i < p.replicas; i++ {
+			point := routerHash(other + "#" + strconv.Itoa(i))
+			if owner, ok := p.owners[point]; !ok || other < owner {
+				p.owners[point] = other
+			}
+		}
+	}
+	p.reindex()
+}
+
+// reindex sorts the points while lock is held.
+func (p *SessionRouter) reindex() {
+	p.points = p.points[:0]
+	for it := range p.owners {
+		p.points = append(p.points, it)
+	}
+	sort.Slice(p.points, func(i, j int) bool { return p.points[i] < p.points[j] })
+}
+
+// Owner returns the node owning sid, which is the first one on the ring from the hash of sid.
+// It's empty if there's no node.
+func (p *SessionRouter) Owner(sid string) string {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	if len(p.points) < 1 {
+		return ""
+	}
+	h := routerHash(sid)
+	i := sort.Search(len(p.points), func(i int) bool { return p.points[i] >= h })
+	if i == len(p.points) {
+		i = 0
+	}
+	return p.owners[p.points[i]]
+}
+
+// URL returns the base URL of node, it's empty if node isn't redirected to.
+func (p *SessionRouter) URL(node string) string {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	return p.urls[node]
+}
+
+// Nodes returns the sorted nodes of ring.
+func (p *SessionRouter) Nodes() []string {
+	p.lock.RLock()
+	ret := make([]string, 0, len(p.urls))
+	for it := range p.urls {
+		ret = append(ret, it)
+	}
+	p.lock.RUnlock()
+	sort.Strings(ret)
+	return ret
+}
+
+func (p *SessionRouter) has(node string) bool {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	_, ok := p.urls[node]
+	return ok
+}
+
+func routerHash(key string) uint32 {
+	return crc32.ChecksumIEEE([]byte(key))
+}
+
+// owns returns whether sid is owned by the local node, every sid is if there's no router or the node isn't on it.
+func (p *engineImpl) owns(sid string) bool {
+	if p.router == nil || !p.router.has(p.node.id) {
+		return true
+	}
+	return p.router.Owner(sid) == p.node.id
+}
+
+// redirect sends a polling request of session sid to the node owning it by 307 if redirects are enabled,
+// it returns false if the local node owns it or the owner has no URL.
+func (p *engineImpl) redirect(writer http.ResponseWriter, request *http.Request, sid string, ttype TransportType) bool {
+	if !p.routerRedirect || ttype != POLLING {
+		return false
+	}
+	owner := p.router.Owner(sid)
+	if len(owner) < 1 || owner == p.node.id {
+		return false
+	}
+	base := p.router.URL(owner)
+	if len(base) < 1 {
+		return false
+	}
+	http.Redirect(writer, request, strings.TrimSuffix(base, "/")+request.URL.RequestURI(), http.StatusTemporaryRedirect)
+	return true
+}
+
+func (p *clusterNode) Owner(sid string) string {
+	if p.eng.router == nil {
+		return ""
+	}
+	return p.eng.router.Owner(sid)
+}
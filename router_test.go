@@ -0,0 +1,84 @@
+package eio
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestSessionRouter(t *testing.T) {
+	router := NewSessionRouter(0)
+	if owner := router.Owner("a"); owner != "" {
+		t.Errorf("empty router shouldn't have owner: %s", owner)
+	}
+	router.Add("x", "").Add("y", "").Add("z", "http://z")
+	if nodes := router.Nodes(); strings.Join(nodes, ",") != "x,y,z" {
+		t.Errorf("bad nodes: %v", nodes)
+	}
+	if url := router.URL("z"); url != "http://z" {
+		t.Errorf("bad url: %s", url)
+	}
+	owners := make(map[string]string)
+	counts := make(map[string]int)
+	for i := 0; i < 3000; i++ {
+		sid := strconv.Itoa(i)
+		owners[sid] = router.Owner(sid)
+		counts[owners[sid]]++
+	}
+	for _, it := range []string{"x", "y", "z"} {
+		if counts[it] < 500 {
+			t.Errorf("sids should be spread: %v", counts)
+		}
+	}
+	// the ring doesn't depend on the order of adds.
+	other := NewSessionRouter(0).Add("z", "").Add("x", "").Add("y", "")
+	router.Remove("y")
+	for sid, owner := range owners {
+		if other.Owner(sid) != owner {
+			t.Fatalf("sid %s should be owned by %s: %s", sid, owner, other.Owner(sid))
+		}
+		// only the sids of the removed node move.
+		if now := router.Owner(sid); owner != "y" && now != owner || now == "y" {
+			t.Fatalf("sid %s of %s shouldn't move to %s", sid, owner, now)
+		}
+	}
+}
+
+func TestSessionRouterRedirect(t *testing.T) {
+	router := NewSessionRouter(0)
+	var servers []*httptest.Server
+	for _, it := range []string{"a", "b"} {
+		eng := NewEngineBuilder().SetNodeID(it).SetSessionRouter(router, true).Build()
+		defer eng.Close()
+		server := httptest.NewServer(http.HandlerFunc(eng.Router()))
+		defer server.Close()
+		router.Add(it, server.URL)
+		servers = append(servers, server)
+	}
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	for i := 0; i < 10; i++ {
+		res, err := client.Get(servers[0].URL + "/engine.io/?EIO=3&transport=polling")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		begin := strings.Index(string(body), `"sid":"`) + 7
+		sid := string(body[begin : begin+strings.IndexByte(string(body[begin:]), '"')])
+		if owner := router.Owner(sid); owner != "a" {
+			t.Fatalf("sid %s of handshake should be owned by a: %s", sid, owner)
+		}
+		query := "/engine.io/?EIO=3&transport=polling&sid=" + sid
+		res, err = client.Get(servers[1].URL + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if location := res.Header.Get("Location"); res.StatusCode != http.StatusTemporaryRedirect || location != servers[0].URL+query {
+			t.Errorf("poll should be redirected to owner: %d %s", res.StatusCode, location)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package eio
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jjeffcaii/engine.io/parser"
+)
+
+var (
+	// ErrSendQueueFull is the close reason of a socket whose send queue overflows with OverflowDisconnect.
+	ErrSendQueueFull = errors.New("transport: send queue is full")
+	// ErrPacketDropped is returned by sending a packet which is dropped by OverflowDropNewest.
+	ErrPacketDropped   = errors.New("transport: packet dropped")
+	errSendQueueClosed = errors.New("transport: send queue closed")
+)
+
+// OverflowPolicy decides what happens to a packet sent while the send queue of its socket is full, see EngineBuilder.SetSendQueue.
+type OverflowPolicy int8
+
+const (
+	// OverflowBlock blocks the sender until the client receives, or fails with ErrWriteStalled in the write timeout.
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDropOldest drops the oldest packets queued until the packet fits.
+	OverflowDropOldest
+	// OverflowDropNewest drops the packet, sending it returns ErrPacketDropped.
+	OverflowDropNewest
+	// OverflowDisconnect closes the socket with ErrSendQueueFull.
+	OverflowDisconnect
+)
+
+// sendQueue is the bounded outbox of a transport. Packets are received from ch, which is closed by close,
+// and every packet received must be passed to took.
+type sendQueue struct {
+	ch       chan *parser.Packet
+	maxBytes int64
+	policy   OverflowPolicy
+	// bytes counts the data of packets in ch.
+	bytes int64
+	// lock serializes senders, so a packet can be dropped to make room for another.
+	lock   sync.Mutex
+	space  chan struct{}
+	done   chan struct{}
+	closed bool
+}
+
+func newSendQueue(options *engineOptions) *sendQueue {
+	return &sendQueue{
+		ch:       make(chan *parser.Packet, options.sendQueuePackets),
+		maxBytes: options.sendQueueBytes,
+		policy:   options.overflowPolicy,
+		space:    make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+}
+
+// full returns true if a packet of size doesn't fit, a packet fits an empty queue whatever its size is.
+func (q *sendQueue) full(size int64) bool {
+	n := len(q.ch)
+	return n >= cap(q.ch) || q.maxBytes > 0 && n > 0 && atomic.LoadInt64(&(q.bytes))+size > q.maxBytes
+}
+
+// push queues packet as the policy decides if the queue is full, stalling gives the timeout of OverflowBlock.
+func (q *sendQueue) push(packet *parser.Packet, stalling func() (<-chan time.Time, func() bool)) error {
+	size := int64(len(packet.Data))
+	var timeout <-chan time.Time
+	for {
+		q.lock.Lock()
+		if q.closed {
+			q.lock.Unlock()
+			return errSendQueueClosed
+		}
+		if q.full(size) {
+			switch q.policy {
+			case OverflowDropOldest:
+				for q.full(size) {
+					select {
+					case old := <-q.ch:
+						q.took(old)
+					default:
+					}
+				}
+			case OverflowDropNewest:
+				q.lock.Unlock()
+				return ErrPacketDropped
+			case OverflowDisconnect:
+				q.lock.Unlock()
+				return ErrSendQueueFull
+			}
+		}
+		if !q.full(size) {
+			atomic.AddInt64(&(q.bytes), size)
+			q.ch <- packet
+			q.lock.Unlock()
+			return nil
+		}
+		q.lock.Unlock()
+		if timeout == nil {
+			var stop func() bool
+			timeout, stop = stalling()
+			defer stop()
+		}
+		select {
+		case <-q.space:
+		case <-q.done:
+			return errSendQueueClosed
+		case <-timeout:
+			return ErrWriteStalled
+		}
+	}
+}
+
+// size returns the bytes of the data of packets queued.
+func (q *sendQueue) size() int64 {
+	return atomic.LoadInt64(&(q.bytes))
+}
+
+// pop returns a packet queued if any.
+func (q *sendQueue) pop() (*parser.Packet, bool) {
+	select {
+	case packet, ok := <-q.ch:
+		if ok {
+			q.took(packet)
+		}
+		return packet, ok
+	default:
+		return nil, false
+	}
+}
+
+// took releases the room of a packet received from ch.
+func (q *sendQueue) took(packet *parser.Packet) {
+	if packet == nil {
+		return
+	}
+	atomic.AddInt64(&(q.bytes), -int64(len(packet.Data)))
+	select {
+	case q.space <- struct{}{}:
+	default:
+	}
+}
+
+// close closes ch, packets queued can be received still. Senders fail then.
+func (q *sendQueue) close() {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.closed {
+		return
+	}
+	q.closed = true
+	close(q.done)
+	close(q.ch)
+}
+
+// enqueue pushes packet into q, closing the socket if the write stalls or the queue overflows with OverflowDisconnect.
+func (p *tinyTransport) enqueue(q *sendQueue, packet *parser.Packet) error {
+	switch err := q.push(packet, p.stalling); err {
+	case ErrWriteStalled:
+		return p.stall()
+	case ErrSendQueueFull:
+		if socket := p.socket; socket != nil {
+			go socket.closeWith(CloseTransportError, ErrSendQueueFull)
+		}
+		return err
+	default:
+		return err
+	}
+}
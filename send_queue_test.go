@@ -0,0 +1,111 @@
+package eio
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jjeffcaii/engine.io/parser"
+)
+
+func newTestQueue(maxPackets int, maxBytes int64, policy OverflowPolicy) *sendQueue {
+	return newSendQueue(&engineOptions{sendQueuePackets: maxPackets, sendQueueBytes: maxBytes, overflowPolicy: policy})
+}
+
+func noStalling() (<-chan time.Time, func() bool) {
+	return nil, func() bool { return false }
+}
+
+func drain(q *sendQueue) []string {
+	var ret []string
+	for {
+		pk, ok := q.pop()
+		if !ok {
+			return ret
+		}
+		ret = append(ret, string(pk.Data))
+	}
+}
+
+func TestSendQueueDrop(t *testing.T) {
+	q := newTestQueue(2, 0, OverflowDropOldest)
+	for _, it := range []string{"1", "2", "3"} {
+		if err := q.push(parser.NewPacket(parser.MESSAGE, it), noStalling); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := drain(q); len(got) != 2 || got[0] != "2" || got[1] != "3" {
+		t.Errorf("oldest should be dropped: %v", got)
+	}
+
+	q = newTestQueue(8, 4, OverflowDropNewest)
+	q.push(parser.NewPacket(parser.MESSAGE, "abc"), noStalling)
+	if err := q.push(parser.NewPacket(parser.MESSAGE, "de"), noStalling); err != ErrPacketDropped {
+		t.Error("newest should be dropped:", err)
+	}
+	if got := drain(q); len(got) != 1 || got[0] != "abc" {
+		t.Errorf("bad packets: %v", got)
+	}
+	// a packet larger than max bytes fits an empty queue.
+	if err := q.push(parser.NewPacket(parser.MESSAGE, "large"), noStalling); err != nil {
+		t.Error("should fit an empty queue:", err)
+	}
+}
+
+func TestSendQueueBlock(t *testing.T) {
+	q := newTestQueue(1, 0, OverflowBlock)
+	q.push(parser.NewPacket(parser.MESSAGE, "1"), noStalling)
+	pushed := make(chan error, 1)
+	go func() { pushed <- q.push(parser.NewPacket(parser.MESSAGE, "2"), noStalling) }()
+	select {
+	case <-pushed:
+		t.Fatal("should block while full")
+	case <-time.After(50 * time.Millisecond):
+	}
+	drain(q)
+	if err := <-pushed; err != nil {
+		t.Error("should be queued once there's room:", err)
+	}
+	stalling := func() (<-chan time.Time, func() bool) {
+		timer := time.NewTimer(20 * time.Millisecond)
+		return timer.C, timer.Stop
+	}
+	if err := q.push(parser.NewPacket(parser.MESSAGE, "3"), stalling); err != ErrWriteStalled {
+		t.Error("should stall:", err)
+	}
+	go func() { pushed <- q.push(parser.NewPacket(parser.MESSAGE, "4"), noStalling) }()
+	time.Sleep(20 * time.Millisecond)
+	q.close()
+	if err := <-pushed; err != errSendQueueClosed {
+		t.Error("blocked sender should fail once closed:", err)
+	}
+}
+
+func TestSendQueueDisconnect(t *testing.T) {
+	eng := NewEngineBuilder().SetSendQueue(2, 0, OverflowDisconnect).Build()
+	defer eng.Close()
+	sockets := make(chan Socket, 1)
+	reasons := make(chan string, 1)
+	eng.OnConnect(func(socket Socket) {
+		socket.OnClose(func(reason string) { reasons <- reason })
+		sockets <- socket
+	})
+	client, err := eng.Loopback()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	socket := <-sockets
+	// the OPEN packet is queued already.
+	socket.Send("1")
+	if err := socket.Send("2"); err != ErrSendQueueFull {
+		t.Error("should overflow:", err)
+	}
+	select {
+	case reason := <-reasons:
+		if reason != ErrSendQueueFull.Error() {
+			t.Errorf("bad close reason: %q", reason)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("slow client should be disconnected")
+	}
+}
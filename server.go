@@ -0,0 +1,277 @@
+package eio
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Server is an Engine serving http requests of engine.io sessions, it's created by NewServer.
+// It can be mounted on any path, requests are served regardless of their path.
+type Server struct {
+	Engine
+	handler func(http.ResponseWriter, *http.Request)
+}
+
+// Option configures the engine of a Server, see NewServer.
+type Option func(builder *EngineBuilder)
+
+// NewServer returns a Server whose engine is built with options, they are applied to the builder in order.
+// It panics if the settings are invalid, see ValidateOptions.
+func NewServer(options ...Option) *Server {
+	builder := NewEngineBuilder()
+	for _, it := range options {
+		it(builder)
+	}
+	eng := builder.Build()
+	return &Server{
+		Engine:  eng,
+		handler: eng.Router(),
+	}
+}
+
+// ServeHTTP serves a handshake or a request of an existing session with its transport.
+func (p *Server) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	p.handler(writer, request)
+}
+
+// ValidateOptions returns the error of EngineBuilder.Validate for a builder applied with options.
+func ValidateOptions(options ...Option) error {
+	builder := NewEngineBuilder()
+	for _, it := range options {
+		it(builder)
+	}
+	return builder.Validate()
+}
+
+// WithTransports is the option of EngineBuilder.SetTransports.
+func WithTransports(transports ...TransportType) Option {
+	return func(builder *EngineBuilder) { builder.SetTransports(transports...) }
+}
+
+// WithAllowUpgrades is the option of EngineBuilder.SetAllowUpgrades.
+func WithAllowUpgrades(enable bool) Option {
+	return func(builder *EngineBuilder) { builder.SetAllowUpgrades(enable) }
+}
+
+// WithPingInterval is the option of EngineBuilder.SetPingInterval.
+func WithPingInterval(interval time.Duration) Option {
+	return func(builder *EngineBuilder) { builder.SetPingInterval(interval) }
+}
+
+// WithPingTimeout is the option of EngineBuilder.SetPingTimeout.
+func WithPingTimeout(timeout time.Duration) Option {
+	return func(builder *EngineBuilder) { builder.SetPingTimeout(timeout) }
+}
+
+// WithUpgradeTimeout is the option of EngineBuilder.SetUpgradeTimeout.
+func WithUpgradeTimeout(timeout time.Duration) Option {
+	return func(builder *EngineBuilder) { builder.SetUpgradeTimeout(timeout) }
+}
+
+// WithWriteTimeout is the option of EngineBuilder.SetWriteTimeout.
+func WithWriteTimeout(timeout time.Duration) Option {
+	return func(builder *EngineBuilder) { builder.SetWriteTimeout(timeout) }
+}
+
+// WithMaxPayload is the option of EngineBuilder.SetMaxPayload.
+func WithMaxPayload(max int64) Option {
+	return func(builder *EngineBuilder) { builder.SetMaxPayload(max) }
+}
+
+// WithSendQueue is the option of EngineBuilder.SetSendQueue.
+func WithSendQueue(maxPackets int, maxBytes int64, policy OverflowPolicy) Option {
+	return func(builder *EngineBuilder) { builder.SetSendQueue(maxPackets, maxBytes, policy) }
+}
+
+// WithBroadcastWorkers is the option of EngineBuilder.SetBroadcastWorkers.
+func WithBroadcastWorkers(workers int) Option {
+	return func(builder *EngineBuilder) { builder.SetBroadcastWorkers(workers) }
+}
+
+// WithCompression is the option of EngineBuilder.SetCompression.
+func WithCompression(enable bool) Option {
+	return func(builder *EngineBuilder) { builder.SetCompression(enable) }
+}
+
+// WithHTTPCompression is the option of EngineBuilder.SetHTTPCompression.
+func WithHTTPCompression(enable bool) Option {
+	return func(builder *EngineBuilder) { builder.SetHTTPCompression(enable) }
+}
+
+// WithWebsocketReadLimit is the option of EngineBuilder.SetWebsocketReadLimit.
+func WithWebsocketReadLimit(limit int64) Option {
+	return func(builder *EngineBuilder) { builder.SetWebsocketReadLimit(limit) }
+}
+
+// WithWebsocketKeepalive is the option of EngineBuilder.SetWebsocketKeepalive.
+func WithWebsocketKeepalive(interval time.Duration) Option {
+	return func(builder *EngineBuilder) { builder.SetWebsocketKeepalive(interval) }
+}
+
+// WithCheckProtocol is the option of EngineBuilder.ForceCheckProtocol, the EIO query is checked if check is true.
+func WithCheckProtocol(check bool) Option {
+	return func(builder *EngineBuilder) { builder.checkProtocol = check }
+}
+
+// WithCookie enables the cookie of handshake responses with name, see EngineBuilder.SetCookie.
+func WithCookie(name string) Option {
+	return func(builder *EngineBuilder) { builder.SetCookie(true).SetCookieName(name) }
+}
+
+// WithLogger is the option of the logger setters of EngineBuilder, nil loggers are kept off.
+func WithLogger(info, warn, err func(format string, v ...interface{})) Option {
+	return func(builder *EngineBuilder) { builder.SetLoggerInfo(info).SetLoggerWarn(warn).SetLoggerError(err) }
+}
+
+// WithStructuredLogger is the option of EngineBuilder.SetLogger.
+func WithStructuredLogger(logger Logger) Option {
+	return func(builder *EngineBuilder) { builder.SetLogger(logger) }
+}
+
+// WithLogLevel is the option of EngineBuilder.SetLogLevel.
+func WithLogLevel(component LogComponent, level slog.Level) Option {
+	return func(builder *EngineBuilder) { builder.SetLogLevel(component, level) }
+}
+
+// WithAllowRequest is the option of EngineBuilder.SetAllowRequest.
+func WithAllowRequest(validator func(*http.Request) error) Option {
+	return func(builder *EngineBuilder) { builder.SetAllowRequest(validator) }
+}
+
+// WithMiddleware is the option of EngineBuilder.Use.
+func WithMiddleware(middlewares ...Middleware) Option {
+	return func(builder *EngineBuilder) { builder.Use(middlewares...) }
+}
+
+// WithAdapter is the option of EngineBuilder.SetAdapter.
+func WithAdapter(adapter Adapter) Option {
+	return func(builder *EngineBuilder) { builder.SetAdapter(adapter) }
+}
+
+// WithMembership is the option of EngineBuilder.SetMembership.
+func WithMembership(conn net.PacketConn, discovery Discovery, interval time.Duration) Option {
+	return func(builder *EngineBuilder) { builder.SetMembership(conn, discovery, interval) }
+}
+
+// WithMembershipKey is the option of EngineBuilder.SetMembershipKey.
+func WithMembershipKey(key []byte) Option {
+	return func(builder *EngineBuilder) { builder.SetMembershipKey(key) }
+}
+
+// WithNodeID is the option of EngineBuilder.SetNodeID.
+func WithNodeID(id string) Option {
+	return func(builder *EngineBuilder) { builder.SetNodeID(id) }
+}
+
+// WithSessionRouter is the option of EngineBuilder.SetSessionRouter.
+func WithSessionRouter(router *SessionRouter, redirect bool) Option {
+	return func(builder *EngineBuilder) { builder.SetSessionRouter(router, redirect) }
+}
+
+// WithRecovery is the option of EngineBuilder.SetRecovery.
+func WithRecovery(window time.Duration) Option {
+	return func(builder *EngineBuilder) { builder.SetRecovery(window) }
+}
+
+// WithTracer is the option of EngineBuilder.SetTracer.
+func WithTracer(tracer Tracer) Option {
+	return func(builder *EngineBuilder) { builder.SetTracer(tracer) }
+}
+
+// WithPacketTracer is the option of EngineBuilder.SetPacketTracer.
+func WithPacketTracer(tracer PacketTracer, limit int) Option {
+	return func(builder *EngineBuilder) { builder.SetPacketTracer(tracer, limit) }
+}
+
+// WithStatsEndpoint is the option of EngineBuilder.SetStatsEndpoint.
+func WithStatsEndpoint(path string, auth func(*http.Request) error) Option {
+	return func(builder *EngineBuilder) { builder.SetStatsEndpoint(path, auth) }
+}
+
+// WithProfileLabels is the option of EngineBuilder.SetProfileLabels.
+func WithProfileLabels(tenantKey string) Option {
+	return func(builder *EngineBuilder) { builder.SetProfileLabels(tenantKey) }
+}
+
+// WithSlowThresholds is the option of EngineBuilder.SetSlowThresholds.
+func WithSlowThresholds(handler, write time.Duration) Option {
+	return func(builder *EngineBuilder) { builder.SetSlowThresholds(handler, write) }
+}
+
+// WithCapture is the option of EngineBuilder.SetCapture.
+func WithCapture(capture *Capture) Option {
+	return func(builder *EngineBuilder) { builder.SetCapture(capture) }
+}
+
+// WithPresence is the option of EngineBuilder.SetPresence.
+func WithPresence(key string, retention time.Duration) Option {
+	return func(builder *EngineBuilder) { builder.SetPresence(key, retention) }
+}
+
+// WithSessionStore is the option of EngineBuilder.SetSessionStore.
+func WithSessionStore(store SessionStore) Option {
+	return func(builder *EngineBuilder) { builder.SetSessionStore(store) }
+}
+
+// WithAllowHandshake is the option of EngineBuilder.SetAllowHandshake.
+func WithAllowHandshake(fn func(*http.Request) (context.Context, error)) Option {
+	return func(builder *EngineBuilder) { builder.SetAllowHandshake(fn) }
+}
+
+// WithAuthenticator is the option of EngineBuilder.SetAuthenticator.
+func WithAuthenticator(authenticator Authenticator) Option {
+	return func(builder *EngineBuilder) { builder.SetAuthenticator(authenticator) }
+}
+
+// WithMaxConnections is the option of EngineBuilder.SetMaxConnections and EngineBuilder.SetOverloadPolicy.
+func WithMaxConnections(max int, policy OverloadPolicy) Option {
+	return func(builder *EngineBuilder) { builder.SetMaxConnections(max).SetOverloadPolicy(policy) }
+}
+
+// WithAdmission is the option of EngineBuilder.SetAdmission.
+func WithAdmission(fn func(request *http.Request, connections int) error) Option {
+	return func(builder *EngineBuilder) { builder.SetAdmission(fn) }
+}
+
+// WithDetachedContext is the option of EngineBuilder.SetDetachedContext.
+func WithDetachedContext(detached bool) Option {
+	return func(builder *EngineBuilder) { builder.SetDetachedContext(detached) }
+}
+
+// WithHandshakeFields is the option of EngineBuilder.SetHandshakeFields.
+func WithHandshakeFields(fn func(socket Socket) map[string]interface{}) Option {
+	return func(builder *EngineBuilder) { builder.SetHandshakeFields(fn) }
+}
+
+// WithShutdownMessage is the option of EngineBuilder.SetShutdownMessage.
+func WithShutdownMessage(message interface{}) Option {
+	return func(builder *EngineBuilder) { builder.SetShutdownMessage(message) }
+}
+
+// WithSessionIDGenerator is the option of EngineBuilder.SetSessionIDGenerator.
+func WithSessionIDGenerator(gen SessionIDGenerator) Option {
+	return func(builder *EngineBuilder) { builder.SetSessionIDGenerator(gen) }
+}
+
+// WithTrustedProxies is the option of EngineBuilder.SetProxyHeader and EngineBuilder.SetTrustedProxies.
+func WithTrustedProxies(header string, cidrs ...string) Option {
+	return func(builder *EngineBuilder) { builder.SetProxyHeader(header).SetTrustedProxies(cidrs...) }
+}
+
+// WithAllowedOrigins is the option of EngineBuilder.SetAllowedOrigins.
+func WithAllowedOrigins(origins ...string) Option {
+	return func(builder *EngineBuilder) { builder.SetAllowedOrigins(origins...) }
+}
+
+// WithCheckOrigin is the option of EngineBuilder.SetCheckOrigin.
+func WithCheckOrigin(fn func(*http.Request) bool) Option {
+	return func(builder *EngineBuilder) { builder.SetCheckOrigin(fn) }
+}
+
+// WithCORS is the option of EngineBuilder.SetCORS.
+func WithCORS(options CORSOptions) Option {
+	return func(builder *EngineBuilder) { builder.SetCORS(options) }
+}
@@ -0,0 +1,244 @@
+package eio
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jjeffcaii/engine.io/parser"
+)
+
+func TestServer(t *testing.T) {
+	srv := NewServer(WithPingInterval(10*time.Second), WithPingTimeout(20*time.Second), WithMaxPayload(64))
+	defer srv.Close()
+	sockets := make(chan Socket, 1)
+	srv.OnConnect(func(socket Socket) { sockets <- socket })
+	hs := httptest.NewServer(srv)
+	defer hs.Close()
+
+	_, body := poll(t, http.MethodGet, hs.URL+"/engine.io/?EIO=3&transport=polling", "", "")
+	var msg messageOK
+	if i := strings.IndexByte(body, '{'); i < 0 || json.Unmarshal([]byte(body[i:]), &msg) != nil {
+		t.Fatalf("bad handshake: %q", body)
+	}
+	if msg.PingInterval != 10000 || msg.PingTimeout != 20000 || msg.MaxPayload != 64 || len(msg.Upgrades) != 1 {
+		t.Errorf("bad handshake: %+v", msg)
+	}
+	socket := <-sockets
+	url := hs.URL + "/engine.io/?EIO=3&transport=polling&sid=" + socket.ID()
+	large, _ := parser.EncodePayload(parser.NewPacket(parser.MESSAGE, strings.Repeat("x", 100)))
+	if res, _ := poll(t, http.MethodPost, url, "", string(large)); res.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("should be too large: %d", res.StatusCode)
+	}
+	if res, _ := poll(t, http.MethodPost, url, "", "6:4hello"); res.StatusCode != http.StatusOK {
+		t.Errorf("should be ok: %d", res.StatusCode)
+	}
+}
+
+type userKey struct{}
+
+func TestAllowHandshake(t *testing.T) {
+	srv := NewServer(WithAllowHandshake(func(request *http.Request) (context.Context, error) {
+		user := request.URL.Query().Get("user")
+		if len(user) < 1 {
+			return nil, &RequestError{Status: http.StatusUnauthorized, Code: 4, Message: "who are you"}
+		}
+		return context.WithValue(context.Background(), userKey{}, user), nil
+	}))
+	defer srv.Close()
+	sockets := make(chan Socket, 1)
+	srv.OnConnect(func(socket Socket) { sockets <- socket })
+	hs := httptest.NewServer(srv)
+	defer hs.Close()
+	url := hs.URL + "/engine.io/?EIO=3&transport=polling"
+
+	res, body := poll(t, http.MethodGet, url, "", "")
+	if res.StatusCode != http.StatusUnauthorized || !strings.Contains(body, `"code":4`) {
+		t.Errorf("should be rejected: %d %s", res.StatusCode, body)
+	}
+	if res, _ := poll(t, http.MethodGet, url+"&user=alice", "", ""); res.StatusCode != http.StatusOK {
+		t.Fatalf("should be allowed: %d", res.StatusCode)
+	}
+	socket := <-sockets
+	ctx := socket.Context()
+	if got := ctx.Value(userKey{}); got != "alice" {
+		t.Errorf("bad context value: %v", got)
+	}
+	socket.Close()
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Error("context should be canceled")
+	}
+}
+
+func TestRequestErrorDefaults(t *testing.T) {
+	srv := NewServer(WithAllowHandshake(func(request *http.Request) (context.Context, error) {
+		return nil, &RequestError{Code: 5, Message: "no status"}
+	}))
+	defer srv.Close()
+	hs := httptest.NewServer(srv)
+	defer hs.Close()
+	res, body := poll(t, http.MethodGet, hs.URL+"/engine.io/?EIO=3&transport=polling", "", "")
+	if res.StatusCode != http.StatusForbidden || !strings.Contains(body, `"code":5`) {
+		t.Errorf("zero status should fall back to 403: %d %s", res.StatusCode, body)
+	}
+	recorder := httptest.NewRecorder()
+	sendError(recorder, &RequestError{Status: http.StatusTeapot, Message: "no code"}, http.StatusForbidden, 4)
+	if recorder.Code != http.StatusTeapot || !strings.Contains(recorder.Body.String(), `"code":4`) {
+		t.Errorf("zero code should fall back to 4: %d %s", recorder.Code, recorder.Body)
+	}
+}
+
+func TestHandshakeFields(t *testing.T) {
+	srv := NewServer(WithHandshakeFields(func(socket Socket) map[string]interface{} {
+		return map[string]interface{}{"region": "eu-west", "sid": "forged", "remote": socket.RemoteAddr() != ""}
+	}))
+	defer srv.Close()
+	hs := httptest.NewServer(srv)
+	defer hs.Close()
+
+	_, body := poll(t, http.MethodGet, hs.URL+"/engine.io/?EIO=3&transport=polling", "", "")
+	var fields map[string]interface{}
+	if i := strings.IndexByte(body, '{'); i < 0 || json.Unmarshal([]byte(body[i:]), &fields) != nil {
+		t.Fatalf("bad handshake: %q", body)
+	}
+	if fields["region"] != "eu-west" || fields["remote"] != true {
+		t.Errorf("extra fields should be set: %v", fields)
+	}
+	if sid, _ := fields["sid"].(string); sid == "forged" || len(sid) < 1 {
+		t.Errorf("sid shouldn't be replaced: %v", fields)
+	}
+	if _, ok := fields["pingInterval"]; !ok {
+		t.Errorf("protocol fields should be kept: %v", fields)
+	}
+}
+
+func TestServerShutdown(t *testing.T) {
+	srv := NewServer(WithShutdownMessage("reconnect elsewhere"))
+	sockets := make(chan Socket, 1)
+	reasons := make(chan CloseReason, 1)
+	srv.OnConnect(func(socket Socket) { sockets <- socket })
+	srv.OnDisconnect(func(socket Socket, reason CloseReason) { reasons <- reason })
+	hs := httptest.NewServer(srv)
+	defer hs.Close()
+	url := hs.URL + "/engine.io/?EIO=3&transport=polling"
+	poll(t, http.MethodGet, url, "", "")
+	socket := <-sockets
+
+	polled := make(chan string, 1)
+	go func() {
+		_, body := poll(t, http.MethodGet, url+"&sid="+socket.ID(), "", "")
+		polled <- body
+	}()
+	time.Sleep(100 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		t.Fatal("shutdown failed:", err)
+	}
+	// the message may end the pending poll before the CLOSE is sent.
+	packets, err := parser.DecodePayloadString(<-polled)
+	if err != nil || len(packets) < 1 || string(packets[0].Data) != "reconnect elsewhere" || len(packets) > 1 && packets[1].Type != parser.CLOSE {
+		t.Errorf("pending poll should be drained: %v %v", packets, err)
+	}
+	if reason := <-reasons; reason != CloseServerShutdown {
+		t.Errorf("bad close reason: %s", reason)
+	}
+	if res, _ := poll(t, http.MethodGet, url, "", ""); res.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("handshake should be rejected: %d", res.StatusCode)
+	}
+}
+
+func TestServerShutdownTimeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := NewServer(WithAllowRequest(func(request *http.Request) error {
+		<-release
+		return nil
+	}))
+	hs := httptest.NewServer(srv)
+	defer hs.Close()
+	defer close(release)
+	go http.Get(hs.URL + "/engine.io/?EIO=3&transport=polling")
+	time.Sleep(100 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Error("shutdown should time out:", err)
+	}
+}
+
+type requestKey struct{}
+
+func TestSocketContext(t *testing.T) {
+	for _, detached := range []bool{false, true} {
+		srv := NewServer(WithDetachedContext(detached))
+		sockets := make(chan Socket, 1)
+		srv.OnConnect(func(socket Socket) { sockets <- socket })
+		hs := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			srv.ServeHTTP(writer, request.WithContext(context.WithValue(request.Context(), requestKey{}, "trace")))
+		}))
+		poll(t, http.MethodGet, hs.URL+"/engine.io/?EIO=3&transport=polling", "", "")
+		ctx := (<-sockets).Context()
+		if got := ctx.Value(requestKey{}); detached && got != nil || !detached && got != "trace" {
+			t.Errorf("bad value of detached=%v: %v", detached, got)
+		}
+		if ctx.Err() != nil {
+			t.Error("context shouldn't end with the handshake request")
+		}
+		srv.Close()
+		select {
+		case <-ctx.Done():
+		case <-time.After(5 * time.Second):
+			t.Error("context should be canceled")
+		}
+		hs.Close()
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	var trace []string
+	traced := func(name string) Middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+				trace = append(trace, name)
+				next.ServeHTTP(writer, request)
+			})
+		}
+	}
+	auth := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			if request.URL.Query().Get("token") != "secret" {
+				writer.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(writer, request)
+		})
+	}
+	srv := NewServer(WithMiddleware(traced("log"), auth), WithMiddleware(traced("tenant")))
+	defer srv.Close()
+	sockets := make(chan Socket, 1)
+	srv.OnConnect(func(socket Socket) { sockets <- socket })
+	hs := httptest.NewServer(srv)
+	defer hs.Close()
+	url := hs.URL + "/engine.io/?EIO=3&transport=polling"
+
+	if res, _ := poll(t, http.MethodGet, url, "", ""); res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("should be rejected: %d", res.StatusCode)
+	}
+	url += "&token=secret"
+	if res, _ := poll(t, http.MethodGet, url, "", ""); res.StatusCode != http.StatusOK {
+		t.Fatalf("should be allowed: %d", res.StatusCode)
+	}
+	socket := <-sockets
+	if res, _ := poll(t, http.MethodPost, url+"&sid="+socket.ID(), "", "6:4hello"); res.StatusCode != http.StatusOK {
+		t.Errorf("should be ok: %d", res.StatusCode)
+	}
+	if got := strings.Join(trace, ","); got != "log,log,tenant,log,tenant" {
+		t.Errorf("bad order: %s", got)
+	}
+}
@@ -0,0 +1,205 @@
+package eio
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// errRelayWebsocket rejects websocket requests of sessions owned by other nodes, an upgraded connection can't be relayed.
+var errRelayWebsocket = errors.New("transport: cannot relay websocket of another node")
+
+// SessionStore shares sessions between the nodes of a cluster, so requests of a session can land on any node
+// without sticky sessions, see EngineBuilder.SetSessionStore. A session lives on the node which handshakes it,
+// the store records its owner and relays requests landing on other nodes to the owner. Websocket connections
+// can't be relayed, clients of them must reach the owner. Implementations must be safe for concurrent use.
+type SessionStore interface {
+	// Start begins serving the requests relayed to node, it's called once by EngineBuilder.Build.
+	Start(node SessionNode)
+	// Save records the session, it's called once a session is opened by http.
+	Save(info *SessionInfo) error
+	// Load returns the session of sid, it's nil if there's no such session.
+	Load(sid string) (*SessionInfo, error)
+	// Delete removes the session of sid, it's called once the session is closed.
+	Delete(sid string) error
+	// Relay sends request to node and returns its response, it returns the error of ctx once ctx is done.
+	Relay(ctx context.Context, node string, request *RelayedRequest) (*RelayedResponse, error)
+	// Close stops the store, it's called by Engine.Close.
+	Close() error
+}
+
+// SessionNode is the local engine of a session store.
+type SessionNode interface {
+	// ID returns the unique ID of node.
+	ID() string
+	// Serve serves a request relayed from another node.
+	Serve(request *RelayedRequest) *RelayedResponse
+}
+
+// SessionInfo is the state of a session shared by a SessionStore.
+type SessionInfo struct {
+	ID string `json:"id"`
+	// Node is the ID of node which owns the session.
+	Node     string `json:"node"`
+	Protocol uint8  `json:"protocol"`
+}
+
+// RelayedRequest is an http request relayed to the node which owns its session.
+type RelayedRequest struct {
+	Method     string      `json:"method"`
+	URL        string      `json:"url"`
+	Header     http.Header `json:"header,omitempty"`
+	RemoteAddr string      `json:"remoteAddr"`
+	Body       []byte      `json:"body,omitempty"`
+	// Session is a session migrated to the node instead of an http request, see Engine.Migrate.
+	Session *MigratedSession `json:"session,omitempty"`
+}
+
+// RelayedResponse is the response of a RelayedRequest.
+type RelayedResponse struct {
+	Status int         `json:"status"`
+	Header http.Header `json:"header,omitempty"`
+	Body   []byte      `json:"body,omitempty"`
+}
+
+// relayTimeout is how long a relayed request can take, a poll is answered in the ping interval.
+func (p *engineImpl) relayTimeout() time.Duration {
+	return p.options.pingInterval + p.options.pingTimeout
+}
+
+// relay serves request of session sid which isn't local, it returns false if no other node owns the session.
+func (p *engineImpl) relay(writer http.ResponseWriter, request *http.Request, sid string, ttype TransportType) bool {
+	if p.store == nil {
+		return false
+	}
+	info, err := p.store.Load(sid)
+	if err != nil {
+		p.log(LogCluster, slog.LevelError, "load_failed", "load session failed", LogKeySid, sid, LogKeyError, err)
+		return false
+	}
+	if info == nil || info.Node == p.node.id {
+		return false
+	}
+	if ttype == WEBSOCKET {
+		sendError(writer, errRelayWebsocket, http.StatusBadRequest)
+		return true
+	}
+	var reader io.Reader = request.Body
+	if p.options.maxPayload > 0 {
+		reader = io.LimitReader(reader, p.options.maxPayload+1)
+	}
+	body, err := io.ReadAll(reader)
+	if err != nil {
+		sendError(writer, err, http.StatusBadRequest)
+		return true
+	}
+	if p.options.maxPayload > 0 && int64(len(body)) > p.options.maxPayload {
+		sendError(writer, errors.New("payload too large"), http.StatusRequestEntityTooLarge)
+		return true
+	}
+	ctx, cancel := context.WithTimeout(request.Context(), p.relayTimeout())
+	defer cancel()
+	res, err := p.store.Relay(ctx, info.Node, &RelayedRequest{
+		Method:     request.Method,
+		URL:        request.URL.RequestURI(),
+		Header:     request.Header,
+		RemoteAddr: request.RemoteAddr,
+		Body:       body,
+	})
+	if err != nil {
+		sendError(writer, fmt.Errorf("%s:socket#%s relay failed: %w", request.Method, sid, err), http.StatusBadGateway)
+		return true
+	}
+	for k, v := range res.Header {
+		writer.Header()[k] = v
+	}
+	writer.WriteHeader(res.Status)
+	writer.Write(res.Body)
+	return true
+}
+
+// Serve serves a relayed request as a local one.
+func (p *clusterNode) Serve(request *RelayedRequest) *RelayedResponse {
+	if request.Session != nil {
+		if err := p.eng.adopt(request.Session); err != nil {
+			return &RelayedResponse{Status: http.StatusConflict, Body: []byte(err.Error())}
+		}
+		return &RelayedResponse{Status: http.StatusOK}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), p.eng.relayTimeout())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, request.Method, request.URL, bytes.NewReader(request.Body))
+	if err != nil {
+		return &RelayedResponse{Status: http.StatusBadRequest, Body: []byte(err.Error())}
+	}
+	req.Header = request.Header
+	if req.Header == nil {
+		req.Header = make(http.Header)
+	}
+	req.RemoteAddr = request.RemoteAddr
+	writer := &relayWriter{header: make(http.Header), ctx: ctx}
+	p.eng.route(writer, req)
+	if writer.status == 0 {
+		writer.status = http.StatusOK
+	}
+	return &RelayedResponse{Status: writer.status, Header: writer.header, Body: writer.body.Bytes()}
+}
+
+// relayWriter buffers the response of a relayed request, it's closed once the relay times out.
+type relayWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+	ctx    context.Context
+}
+
+// CloseNotify implements http.CloseNotifier which polls wait on.
+func (p *relayWriter) CloseNotify() <-chan bool {
+	ret := make(chan bool, 1)
+	go func() {
+		<-p.ctx.Done()
+		ret <- true
+	}()
+	return ret
+}
+
+func (p *relayWriter) Header() http.Header {
+	return p.header
+}
+
+func (p *relayWriter) Write(b []byte) (int, error) {
+	if p.status == 0 {
+		p.status = http.StatusOK
+	}
+	return p.body.Write(b)
+}
+
+func (p *relayWriter) WriteHeader(status int) {
+	if p.status == 0 {
+		p.status = status
+	}
+}
+
+// saveSession records socket opened by http in the store.
+func (p *engineImpl) saveSession(socket *socketImpl) {
+	if p.store == nil {
+		return
+	}
+	if err := p.store.Save(&SessionInfo{ID: socket.id, Node: p.node.id, Protocol: uint8(socket.protocol)}); err != nil {
+		p.log(LogCluster, slog.LevelError, "save_failed", "save session failed", LogKeySid, socket.id, LogKeyError, err)
+	}
+}
+
+func (p *engineImpl) deleteSession(socket *socketImpl) {
+	if p.store == nil {
+		return
+	}
+	if err := p.store.Delete(socket.id); err != nil {
+		p.log(LogCluster, slog.LevelError, "delete_failed", "delete session failed", LogKeySid, socket.id, LogKeyError, err)
+	}
+}
@@ -0,0 +1,111 @@
+package eio
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// memoryStore is a SessionStore of nodes in the same process.
+type memoryStore struct {
+	lock     sync.Mutex
+	sessions map[string]*SessionInfo
+	nodes    map[string]SessionNode
+}
+
+func (p *memoryStore) Start(node SessionNode) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.nodes[node.ID()] = node
+}
+
+func (p *memoryStore) Save(info *SessionInfo) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.sessions[info.ID] = info
+	return nil
+}
+
+func (p *memoryStore) Load(sid string) (*SessionInfo, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.sessions[sid], nil
+}
+
+func (p *memoryStore) Delete(sid string) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	delete(p.sessions, sid)
+	return nil
+}
+
+func (p *memoryStore) Relay(ctx context.Context, node string, request *RelayedRequest) (*RelayedResponse, error) {
+	p.lock.Lock()
+	owner := p.nodes[node]
+	p.lock.Unlock()
+	done := make(chan *RelayedResponse, 1)
+	go func() { done <- owner.Serve(request) }()
+	select {
+	case res := <-done:
+		return res, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (p *memoryStore) Close() error {
+	return nil
+}
+
+func TestSessionStore(t *testing.T) {
+	store := &memoryStore{sessions: make(map[string]*SessionInfo), nodes: make(map[string]SessionNode)}
+	a, b := NewServer(WithSessionStore(store)), NewServer(WithSessionStore(store))
+	defer a.Close()
+	defer b.Close()
+	sockets := make(chan Socket, 1)
+	messages := make(chan string, 1)
+	a.OnConnect(func(socket Socket) {
+		socket.OnMessage(func(data []byte) { messages <- string(data) })
+		sockets <- socket
+	})
+	ha, hb := httptest.NewServer(a), httptest.NewServer(b)
+	defer ha.Close()
+	defer hb.Close()
+	poll(t, http.MethodGet, ha.URL+"/engine.io/?EIO=3&transport=polling", "", "")
+	socket := <-sockets
+	query := "/engine.io/?EIO=3&transport=polling&sid=" + socket.ID()
+
+	if res, body := poll(t, http.MethodPost, hb.URL+query, "", "6:4hello"); res.StatusCode != http.StatusOK || body != "ok" {
+		t.Errorf("post should be relayed: %d %q", res.StatusCode, body)
+	}
+	if msg := <-messages; msg != "hello" {
+		t.Errorf("bad message: %q", msg)
+	}
+	socket.Send("hi")
+	if res, body := poll(t, http.MethodGet, hb.URL+query, "", ""); res.StatusCode != http.StatusOK || !strings.Contains(body, "4hi") {
+		t.Errorf("poll should be relayed: %d %q", res.StatusCode, body)
+	}
+	url := "ws" + strings.TrimPrefix(hb.URL, "http") + "/engine.io/?EIO=3&transport=websocket&sid=" + socket.ID()
+	if _, res, err := websocket.DefaultDialer.Dial(url, nil); err == nil || res.StatusCode != http.StatusBadRequest {
+		t.Error("websocket shouldn't be relayed:", err)
+	}
+
+	socket.Close()
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if info, _ := store.Load(socket.ID()); info == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("session should be deleted")
+		}
+	}
+	if res, _ := poll(t, http.MethodGet, hb.URL+query, "", ""); res.StatusCode != http.StatusInternalServerError {
+		t.Errorf("session should be gone: %d", res.StatusCode)
+	}
+}
@@ -0,0 +1,118 @@
+package eio
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// maxSessionIDAttempts is how many IDs are generated for a handshake before it fails, if they collide with open sessions.
+const maxSessionIDAttempts = 3
+
+// crockford is the alphabet of ULID.
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// SessionIDGenerator generates the session IDs of new sockets, see EngineBuilder.SetSessionIDGenerator.
+// An ID colliding with an open session is generated again, and the handshake fails with an error of Generate.
+type SessionIDGenerator interface {
+	// Generate returns a URL safe ID for the handshake request, which is nil for sessions out of http.
+	Generate(request *http.Request) (string, error)
+}
+
+// SessionIDGeneratorFunc is a function as a SessionIDGenerator.
+type SessionIDGeneratorFunc func(request *http.Request) (string, error)
+
+// Generate calls fn(request).
+func (fn SessionIDGeneratorFunc) Generate(request *http.Request) (string, error) {
+	return fn(request)
+}
+
+// sequenceIDGenerator generates IDs by a function of a sequence, see EngineBuilder.SetGenerateID.
+type sequenceIDGenerator struct {
+	gen      func(seq uint32) string
+	sequence uint32
+}
+
+func (p *sequenceIDGenerator) Generate(request *http.Request) (string, error) {
+	if atomic.CompareAndSwapUint32(&(p.sequence), 0xFFFF, 0) {
+		return p.gen(0), nil
+	}
+	return p.gen(atomic.AddUint32(&(p.sequence), 1)), nil
+}
+
+// NewBase64IDGenerator returns the default generator, whose IDs are 20 chars of URL safe base64
+// as the JS implementation generates: 12 random bytes and 3 bytes of a sequence.
+func NewBase64IDGenerator() SessionIDGenerator {
+	return &sequenceIDGenerator{gen: randomSessionID, sequence: randomSequence()}
+}
+
+// randomSequence returns a random start of sequences, so IDs of restarted servers differ in their sequence.
+func randomSequence() uint32 {
+	var b [4]byte
+	rand.Read(b[:])
+	return binary.BigEndian.Uint32(b[:]) & 0xFFFF
+}
+
+// NewUUIDGenerator returns a generator of random UUIDs. (version 4)
+func NewUUIDGenerator() SessionIDGenerator {
+	return SessionIDGeneratorFunc(func(*http.Request) (string, error) {
+		var b [16]byte
+		if _, err := rand.Read(b[:]); err != nil {
+			return "", err
+		}
+		b[6] = b[6]&0x0F | 0x40
+		b[8] = b[8]&0x3F | 0x80
+		var s [36]byte
+		hex.Encode(s[0:8], b[0:4])
+		hex.Encode(s[9:13], b[4:6])
+		hex.Encode(s[14:18], b[6:8])
+		hex.Encode(s[19:23], b[8:10])
+		hex.Encode(s[24:], b[10:])
+		s[8], s[13], s[18], s[23] = '-', '-', '-', '-'
+		return string(s[:]), nil
+	})
+}
+
+// NewULIDGenerator returns a generator of ULIDs, which are sorted by the time they're generated in milliseconds.
+func NewULIDGenerator() SessionIDGenerator {
+	return SessionIDGeneratorFunc(func(*http.Request) (string, error) {
+		var b [16]byte
+		binary.BigEndian.PutUint64(b[:8], uint64(time.Now().UnixNano()/int64(time.Millisecond))<<16)
+		if _, err := rand.Read(b[6:]); err != nil {
+			return "", err
+		}
+		// 26 chars of 5 bits encode the 128 bits with 2 leading zero bits.
+		hi, lo := binary.BigEndian.Uint64(b[:8]), binary.BigEndian.Uint64(b[8:])
+		var s [26]byte
+		for i := len(s) - 1; i >= 0; i-- {
+			s[i] = crockford[lo&0x1F]
+			lo = lo>>5 | hi<<59
+			hi >>= 5
+		}
+		return string(s[:]), nil
+	})
+}
+
+// NewPrefixIDGenerator returns a generator of IDs of gen prefixed with the prefix of request, such as a shard hint
+// which lets load balancers route requests of a session. prefix can be nil for no prefix.
+func NewPrefixIDGenerator(prefix func(request *http.Request) string, gen SessionIDGenerator) SessionIDGenerator {
+	return SessionIDGeneratorFunc(func(request *http.Request) (string, error) {
+		id, err := gen.Generate(request)
+		if err != nil || prefix == nil {
+			return id, err
+		}
+		return prefix(request) + id, nil
+	})
+}
+
+// randomSessionID returns 12 random bytes and the lower 3 bytes of seed in URL safe base64.
+func randomSessionID(seed uint32) string {
+	var b [15]byte
+	rand.Read(b[:12])
+	b[12], b[13], b[14] = byte(seed>>16), byte(seed>>8), byte(seed)
+	return base64.URLEncoding.EncodeToString(b[:])
+}
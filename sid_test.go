@@ -0,0 +1,70 @@
+package eio
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSessionIDGenerators(t *testing.T) {
+	for name, it := range map[string]struct {
+		gen     SessionIDGenerator
+		pattern string
+	}{
+		"base64": {NewBase64IDGenerator(), `^[A-Za-z0-9_-]{20}$`},
+		"uuid":   {NewUUIDGenerator(), `^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`},
+		"ulid":   {NewULIDGenerator(), `^[0-7][0-9A-HJKMNP-TV-Z]{25}$`},
+		"prefix": {NewPrefixIDGenerator(func(*http.Request) string { return "eu1." }, NewUUIDGenerator()), `^eu1\.[0-9a-f-]{36}$`},
+	} {
+		seen := make(map[string]bool)
+		for i := 0; i < 100; i++ {
+			id, err := it.gen.Generate(nil)
+			if err != nil || !regexp.MustCompile(it.pattern).MatchString(id) {
+				t.Fatalf("bad %s id: %q %v", name, id, err)
+			}
+			if seen[id] {
+				t.Fatalf("%s id repeats: %s", name, id)
+			}
+			seen[id] = true
+		}
+	}
+	gen := NewULIDGenerator()
+	first, _ := gen.Generate(nil)
+	time.Sleep(2 * time.Millisecond)
+	if second, _ := gen.Generate(nil); second <= first {
+		t.Errorf("ulid should be sorted by time: %s %s", first, second)
+	}
+}
+
+func TestSessionIDCollision(t *testing.T) {
+	ids := make(chan string, 8)
+	eng := NewEngineBuilder().SetSessionIDGenerator(SessionIDGeneratorFunc(func(*http.Request) (string, error) {
+		return <-ids, nil
+	})).Build()
+	defer eng.Close()
+	srv := httptest.NewServer(http.HandlerFunc(eng.Router()))
+	defer srv.Close()
+	url := srv.URL + "/engine.io/?EIO=3&transport=polling"
+
+	ids <- "dup"
+	if _, body := poll(t, http.MethodGet, url, "", ""); !strings.Contains(body, `"sid":"dup"`) {
+		t.Fatalf("bad handshake: %q", body)
+	}
+	ids <- "dup"
+	ids <- "unique"
+	if _, body := poll(t, http.MethodGet, url, "", ""); !strings.Contains(body, `"sid":"unique"`) {
+		t.Errorf("collided id should be generated again: %q", body)
+	}
+	for i := 0; i < maxSessionIDAttempts; i++ {
+		ids <- "dup"
+	}
+	if res, _ := poll(t, http.MethodGet, url, "", ""); res.StatusCode != http.StatusInternalServerError {
+		t.Errorf("handshake should fail: %d", res.StatusCode)
+	}
+	if n := eng.CountClients(); n != 2 {
+		t.Errorf("bad count of clients: %d", n)
+	}
+}
@@ -0,0 +1,102 @@
+package eio
+
+import (
+	"bytes"
+	"log/slog"
+	"runtime"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// slowStackLimit bounds the stack sample logged of a slow operation.
+	slowStackLimit = 8 << 10
+	// slowDumpLimit bounds the dump of all goroutines searched for the one running a slow operation.
+	slowDumpLimit = 16 << 20
+)
+
+// slowWatch is a handler dispatch or a transport write being watched, see EngineBuilder.SetSlowThresholds.
+type slowWatch struct {
+	timer *time.Timer
+}
+
+// done stops watching, an operation done in time is never reported.
+func (p *slowWatch) done() {
+	if p != nil {
+		p.timer.Stop()
+	}
+}
+
+// watchHandler watches the dispatch of a message to the handlers of socket, which runs in the calling goroutine.
+func (p *engineImpl) watchHandler(socket *socketImpl) *slowWatch {
+	if p.options.slowHandler <= 0 {
+		return nil
+	}
+	return p.watchSlow(p.options.slowHandler, func(elapsed time.Duration, stack []byte) {
+		atomic.AddUint64(&(p.stats.slowHandlers), 1)
+		p.logSocket(socket, slog.LevelWarn, "slow_handler", "handle socket message event is slow",
+			LogKeyTransport, socket.transportType().String(), LogKeyElapsed, elapsed, LogKeyStack, string(stack))
+	})
+}
+
+// watchWrite watches a write of transport t to its client, which runs in the calling goroutine.
+func (p *engineImpl) watchWrite(t Transport) *slowWatch {
+	if p.options.slowWrite <= 0 {
+		return nil
+	}
+	return p.watchSlow(p.options.slowWrite, func(elapsed time.Duration, stack []byte) {
+		atomic.AddUint64(&(p.stats.slowWrites), 1)
+		p.logTransport(t, slog.LevelWarn, "slow_write", "write to client is slow",
+			LogKeyElapsed, elapsed, LogKeyStack, string(stack))
+	})
+}
+
+// watchSlow calls report with the stack of the calling goroutine once it runs beyond threshold without calling done,
+// so an operation stalled forever is reported still.
+func (p *engineImpl) watchSlow(threshold time.Duration, report func(elapsed time.Duration, stack []byte)) *slowWatch {
+	id, start := goroutineID(), time.Now()
+	return &slowWatch{timer: time.AfterFunc(threshold, func() {
+		report(time.Since(start), goroutineStack(id))
+	})}
+}
+
+// goroutineID returns the id of the calling goroutine as its stack is headed, e.g. "goroutine 18 [running]:".
+func goroutineID() []byte {
+	var b [64]byte
+	header := b[:runtime.Stack(b[:], false)]
+	header = bytes.TrimPrefix(header, []byte("goroutine "))
+	if i := bytes.IndexByte(header, ' '); i > 0 {
+		if _, err := strconv.ParseUint(string(header[:i]), 10, 64); err == nil {
+			return append([]byte(nil), header[:i]...)
+		}
+	}
+	return nil
+}
+
+// goroutineStack returns the stack of goroutine id sampled from the dump of all goroutines, it's nil if the goroutine
+// is gone.
+func goroutineStack(id []byte) []byte {
+	if id == nil {
+		return nil
+	}
+	b := make([]byte, 64<<10)
+	for {
+		n := runtime.Stack(b, true)
+		if n < len(b) || len(b) >= slowDumpLimit {
+			b = b[:n]
+			break
+		}
+		b = make([]byte, len(b)*2)
+	}
+	header := append(append([]byte("goroutine "), id...), " ["...)
+	for _, it := range bytes.Split(b, []byte("\n\n")) {
+		if bytes.HasPrefix(it, header) {
+			if len(it) > slowStackLimit {
+				it = it[:slowStackLimit]
+			}
+			return append([]byte(nil), it...)
+		}
+	}
+	return nil
+}
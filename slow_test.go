@@ -0,0 +1,62 @@
+package eio
+
+import (
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jjeffcaii/engine.io/parser"
+)
+
+func slowHandler(release chan struct{}) {
+	<-release
+}
+
+func TestSlowHandler(t *testing.T) {
+	logger := &recordingLogger{records: make(chan *logRecord, 16)}
+	eng := NewEngineBuilder().SetLogger(logger).SetSlowThresholds(20*time.Millisecond, 0).Build()
+	defer eng.Close()
+	sockets := make(chan Socket, 1)
+	release := make(chan struct{})
+	eng.OnConnect(func(socket Socket) {
+		socket.OnMessage(func(data []byte) {
+			if string(data) == "slow" {
+				slowHandler(release)
+			}
+		})
+		sockets <- socket
+	})
+	client, err := eng.Loopback()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	client.Receive()
+	socket := <-sockets
+	client.Send(parser.NewPacketCustom(parser.MESSAGE, []byte("fast"), 0))
+	client.Send(parser.NewPacketCustom(parser.MESSAGE, []byte("slow"), 0))
+	timeout := time.After(3 * time.Second)
+	for {
+		select {
+		case <-timeout:
+			t.Fatal("slow handler should be reported")
+		case it := <-logger.records:
+			if it.attrs[LogKeyEvent] != "slow_handler" {
+				continue
+			}
+			close(release)
+			if it.level != slog.LevelWarn || it.attrs[LogKeySid] != socket.ID() || it.attrs[LogKeyTransport] != "loopback" ||
+				it.attrs[LogKeyElapsed].(time.Duration) < 20*time.Millisecond {
+				t.Errorf("bad log: %v %s %v", it.level, it.msg, it.attrs)
+			}
+			if stack := it.attrs[LogKeyStack].(string); !strings.Contains(stack, "eio.slowHandler") {
+				t.Errorf("stack should be sampled from the stalled handler: %s", stack)
+			}
+			if n := eng.Stats().SlowHandlers; n != 1 {
+				t.Errorf("slow handlers should be counted once: %d", n)
+			}
+			return
+		}
+	}
+}
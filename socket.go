@@ -1,8 +1,12 @@
 package eio
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -10,19 +14,47 @@ import (
 )
 
 type socketImpl struct {
-	id        string
+	id         string
+	remoteAddr string
+	// heartbeat is the unix nano time of the last heartbeat, it's 0 once the socket is closed.
 	heartbeat int64
 	engine    *engineImpl
+	// protocol is negotiated by the EIO query of handshake, server pings the client in V4.
+	protocol  parser.Protocol
+	pingTimer *time.Timer
+	// pingSent is when the PING unanswered yet is sent in unix nanoseconds, the PONG of it measures the round trip.
+	pingSent int64
+	// ctx is canceled once the socket is closed.
+	ctx    context.Context
+	cancel context.CancelFunc
 
 	msgHanders      []func([]byte)
 	upgradeHandlers []func()
 	errorHandlers   []func(err error)
 	closeHandlers   []func(reason string)
 
+	// transportBackup is the transport in use, transportPrimary is the one being upgraded to.
+	// They are switched while lock is held exclusively, so no packet is written during the switch.
 	transportBackup, transportPrimary Transport
+	lock                              sync.RWMutex
+	upgradeTimer                      *time.Timer
+	// closeReason is set before close handlers run.
+	closeReason CloseReason
+	// meta is the data of application, see Set.
+	meta     map[string]interface{}
+	metaLock sync.RWMutex
+	// recoveryToken resumes the session once it's closed uncleanly, recovered is true if it's resumed.
+	// suspended is set before close handlers run if the session is kept for recovery.
+	recoveryToken string
+	recovered     bool
+	suspended     bool
+	// upgradeSpan is the span of upgrade to transportPrimary, it's guarded by lock.
+	upgradeSpan Span
 }
 
 func (p *socketImpl) Transport() Transport {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
 	if p.transportPrimary != nil {
 		return p.transportPrimary
 	}
@@ -37,21 +69,96 @@ func (p *socketImpl) Server() Engine {
 	return p.engine
 }
 
+func (p *socketImpl) Context() context.Context {
+	return p.ctx
+}
+
+func (p *socketImpl) RemoteAddr() string {
+	return p.remoteAddr
+}
+
+func (p *socketImpl) Protocol() uint8 {
+	return uint8(p.protocol)
+}
+
+func (p *socketImpl) Set(key string, value interface{}) {
+	p.metaLock.Lock()
+	if p.meta == nil {
+		p.meta = make(map[string]interface{})
+	}
+	p.meta[key] = value
+	p.metaLock.Unlock()
+	if presence := p.presenceOf(key); presence != nil {
+		presence.track(p, presenceUser(value))
+	}
+}
+
+func (p *socketImpl) Get(key string) (interface{}, bool) {
+	p.metaLock.RLock()
+	defer p.metaLock.RUnlock()
+	value, ok := p.meta[key]
+	return value, ok
+}
+
+func (p *socketImpl) Delete(key string) {
+	p.metaLock.Lock()
+	delete(p.meta, key)
+	p.metaLock.Unlock()
+	if presence := p.presenceOf(key); presence != nil {
+		presence.untrack(p)
+	}
+}
+
+// presenceOf returns the presence of engine if key is the metadata key of its users.
+func (p *socketImpl) presenceOf(key string) *presenceImpl {
+	if p.engine == nil || p.engine.presence == nil || len(key) < 1 || key != p.engine.presence.key {
+		return nil
+	}
+	return p.engine.presence
+}
+
+func (p *socketImpl) Join(rooms ...string) {
+	if p.engine.rooms.join(p, rooms) {
+		p.engine.clusterJoin(p.id, rooms)
+	}
+}
+
+func (p *socketImpl) Leave(rooms ...string) {
+	if len(rooms) > 0 {
+		p.engine.rooms.leave(p, rooms)
+		p.engine.clusterLeave(p.id, rooms)
+	}
+}
+
+func (p *socketImpl) Rooms() []string {
+	return p.engine.rooms.roomsOf(p)
+}
+
+func (p *socketImpl) State() SocketState {
+	if atomic.LoadInt64(&(p.heartbeat)) == 0 {
+		return SocketClosed
+	}
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	if p.transportPrimary != nil && p.transportBackup != nil {
+		return SocketUpgrading
+	}
+	return SocketOpen
+}
+
 func (p *socketImpl) OnClose(handler func(string)) Socket {
 	if handler == nil {
 		return p
 	}
 	p.closeHandlers = append(p.closeHandlers, func(reason string) {
-		go func() {
+		go p.profile(p.transportType(), func() {
 			defer func() {
 				if e := recover(); e != nil {
-					if p.engine.logErr != nil {
-						p.engine.logErr("handle socket close event failed: %s\n", e)
-					}
+					p.engine.logSocket(p, slog.LevelError, "handler_panic", "handle socket close event failed", LogKeyError, e)
 				}
 			}()
 			handler(reason)
-		}()
+		})
 	})
 	return p
 }
@@ -75,9 +182,10 @@ func (p *socketImpl) OnMessage(handler func([]byte)) Socket {
 					fn(err)
 				}
 			}
-			if p.engine.logErr != nil {
-				p.engine.logErr("handle socket message event failed: %s\n", e)
+			if p.engine != nil {
+				p.engine.events.publish(EventError, p, func(event *Event) { event.Err = err })
 			}
+			p.engine.logSocket(p, slog.LevelError, "handler_panic", "handle socket message event failed", LogKeyError, e)
 		}()
 		handler(data)
 	})
@@ -91,9 +199,7 @@ func (p *socketImpl) OnError(handler func(error)) Socket {
 	p.errorHandlers = append(p.errorHandlers, func(err error) {
 		defer func() {
 			if e := recover(); e != nil {
-				if p.engine.logErr != nil {
-					p.engine.logErr("handle socket error event failed: %s\n", e)
-				}
+				p.engine.logSocket(p, slog.LevelError, "handler_panic", "handle socket error event failed", LogKeyError, e)
 			}
 		}()
 		handler(err)
@@ -108,9 +214,7 @@ func (p *socketImpl) OnUpgrade(handler func()) Socket {
 	p.upgradeHandlers = append(p.upgradeHandlers, func() {
 		defer func() {
 			if e := recover(); e != nil {
-				if p.engine.logErr != nil {
-					p.engine.logErr("handle socket upgrade event failed: %s\n", e)
-				}
+				p.engine.logSocket(p, slog.LevelError, "handler_panic", "handle socket upgrade event failed", LogKeyError, e)
 			}
 		}()
 		handler()
@@ -122,7 +226,38 @@ func (p *socketImpl) Send(message interface{}) error {
 	if atomic.LoadInt64(&(p.heartbeat)) == 0 {
 		return fmt.Errorf("socket#%s is closed", p.id)
 	}
-	packet := parser.NewPacket(parser.MESSAGE, message)
+	packet, ok := message.(*parser.Packet)
+	if !ok {
+		packet = parser.NewPacket(parser.MESSAGE, message)
+	}
+	return p.write(packet)
+}
+
+// SendContext sends data in a goroutine, so it returns the error of ctx once ctx is done even if the write blocks.
+// The packet may be sent after that.
+func (p *socketImpl) SendContext(ctx context.Context, data []byte, binary bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	var option parser.PacketOption
+	if binary {
+		option = parser.BINARY
+	}
+	packet := parser.NewPacketCustom(parser.MESSAGE, data, option)
+	done := make(chan error, 1)
+	go func() { done <- p.Send(packet) }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// write sends a packet on the transport in use, which holds it while paused for upgrading.
+func (p *socketImpl) write(packet *parser.Packet) error {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
 	if p.transportBackup != nil {
 		return p.transportBackup.write(packet)
 	}
@@ -130,42 +265,177 @@ func (p *socketImpl) Send(message interface{}) error {
 }
 
 func (p *socketImpl) Close() {
-	if atomic.LoadInt64(&(p.heartbeat)) == 0 {
+	p.closeWith(CloseForced, nil)
+}
+
+func (p *socketImpl) CloseWithReason(reason string) {
+	p.closeWith(CloseForced, errors.New(reason))
+}
+
+// closeWith closes the socket for reason, cause leads the reason passed to close handlers if it's not nil.
+func (p *socketImpl) closeWith(reason CloseReason, cause error) {
+	if atomic.SwapInt64(&(p.heartbeat), 0) == 0 {
 		return
 	}
-	atomic.StoreInt64(&(p.heartbeat), 0)
-	var reason string
-	if p.transportPrimary != nil {
-		if err := p.transportPrimary.close(); err != nil {
-			reason += err.Error()
+	p.closeReason = reason
+	p.cancel()
+	if reason == CloseTransportError && cause != nil && p.engine != nil {
+		p.engine.events.publish(EventError, p, func(event *Event) { event.Err = cause })
+	}
+	p.lock.RLock()
+	primary, backup := p.transportPrimary, p.transportBackup
+	if p.upgradeTimer != nil {
+		p.upgradeTimer.Stop()
+	}
+	if p.pingTimer != nil {
+		p.pingTimer.Stop()
+	}
+	p.lock.RUnlock()
+	message := reason.String()
+	if cause != nil {
+		message = cause.Error()
+	}
+	if primary != nil {
+		if err := primary.close(); err != nil {
+			message += ", " + err.Error()
 		}
 	}
-	if p.transportBackup != nil {
-		if err := p.transportBackup.close(); err != nil {
-			if len(reason) > 0 {
-				reason += ", "
-			}
-			reason += err.Error()
+	if backup != nil {
+		if err := backup.close(); err != nil {
+			message += ", " + err.Error()
 		}
 	}
+	// the session is suspended before sends to it fail, as close handlers run in background.
+	if p.engine != nil && p.engine.recovery != nil && p.engine.recovery.recoverable(p) {
+		p.engine.recovery.suspend(p)
+		p.suspended = true
+	}
 	for _, fn := range p.closeHandlers {
-		fn(reason)
+		fn(message)
 	}
 }
 
+// setTransport sets the transport of a new socket, or the transport to upgrade to, which is dropped
+// if the upgrade isn't done in upgrade timeout.
 func (p *socketImpl) setTransport(t Transport) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
 	if p.transportPrimary != nil {
 		return errors.New("transports is full")
 	}
 	if p.transportBackup == nil {
 		p.transportBackup = t
-	} else {
-		p.transportPrimary = t
+		return nil
 	}
+	p.transportPrimary = t
+	p.upgradeTimer = time.AfterFunc(p.engine.options.upgradeTimeout, func() {
+		if p.abortUpgrade(t) {
+			p.engine.logSocket(p, slog.LevelWarn, "upgrade_timeout", "upgrade timeout", LogKeyTransport, t.GetType().String())
+		}
+	})
 	return nil
 }
 
+// traceUpgrade keeps span of the upgrade to t, it's ended at once if the upgrade is done or aborted already.
+func (p *socketImpl) traceUpgrade(t Transport, span Span) {
+	if span == nil {
+		return
+	}
+	p.lock.Lock()
+	if p.transportPrimary == t && p.transportBackup != nil {
+		p.upgradeSpan = span
+		span = nil
+	}
+	p.lock.Unlock()
+	endSpan(span, nil)
+}
+
+// probe answers the probe PING of the transport being upgraded to, then the pending poll of the transport
+// in use is ended by a NOOP and it's paused.
+func (p *socketImpl) probe(pong *parser.Packet) error {
+	p.lock.RLock()
+	old, dest := p.transportBackup, p.transportPrimary
+	p.lock.RUnlock()
+	if old == nil || dest == nil {
+		return p.write(pong)
+	}
+	if err := dest.write(pong); err != nil {
+		return err
+	}
+	if err := old.upgradeStart(dest); err != nil {
+		return err
+	}
+	old.Pause()
+	return nil
+}
+
+// upgrade switches to the transport being upgraded to, packets left on the old transport
+// and the ones it holds are sent on the new transport first, in the order they were written.
+func (p *socketImpl) upgrade() error {
+	p.lock.Lock()
+	old, dest := p.transportBackup, p.transportPrimary
+	if old == nil || dest == nil {
+		p.lock.Unlock()
+		return nil
+	}
+	p.upgradeTimer.Stop()
+	err := old.upgradeEnd(dest)
+	p.transportBackup = nil
+	span := p.upgradeSpan
+	p.upgradeSpan = nil
+	p.lock.Unlock()
+	p.engine.stats.upgraded(err)
+	endSpan(span, err)
+	if err != nil {
+		return err
+	}
+	return old.close()
+}
+
+// abortUpgrade drops transport t if the socket is being upgraded to it, the old transport is resumed then. It returns false if t isn't the transport being upgraded to.
+func (p *socketImpl) abortUpgrade(t Transport) bool {
+	p.lock.Lock()
+	if p.transportPrimary != t || p.transportBackup == nil {
+		p.lock.Unlock()
+		return false
+	}
+	p.upgradeTimer.Stop()
+	if err := p.transportBackup.Resume(); err != nil {
+		p.engine.logSocket(p, slog.LevelError, "resume_failed", "resume transport failed",
+			LogKeyTransport, p.transportBackup.GetType().String(), LogKeyError, err)
+	}
+	p.transportPrimary = nil
+	span := p.upgradeSpan
+	p.upgradeSpan = nil
+	p.lock.Unlock()
+	p.engine.stats.upgraded(errUpgradeAborted)
+	endSpan(span, errUpgradeAborted)
+	t.close()
+	return true
+}
+
+// transportClosed is called when transport t ends because of err, it aborts the upgrade to t,
+// or closes the socket if t is in use. A nil err or io.EOF means the client closes t.
+func (p *socketImpl) transportClosed(t Transport, err error) {
+	if p.abortUpgrade(t) {
+		return
+	}
+	p.lock.RLock()
+	attached := p.transportPrimary == t || p.transportBackup == t
+	p.lock.RUnlock()
+	if !attached {
+		return
+	}
+	if err == nil || err == io.EOF {
+		p.closeWith(CloseClientClose, nil)
+	} else {
+		p.closeWith(CloseTransportError, err)
+	}
+}
+
 func (p *socketImpl) getTransport() Transport {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
 	if p.transportPrimary != nil {
 		return p.transportPrimary
 	} else if p.transportBackup != nil {
@@ -175,9 +445,12 @@ func (p *socketImpl) getTransport() Transport {
 	}
 }
 
+// getTransportOld returns the transport in use while upgrading, or nil.
 func (p *socketImpl) getTransportOld() Transport {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
 	if p.transportPrimary == nil || p.transportBackup == nil {
-		panic("old transport unavailable")
+		return nil
 	}
 	return p.transportBackup
 }
@@ -187,50 +460,101 @@ func (p *socketImpl) accept(packet *parser.Packet) error {
 	default:
 		return fmt.Errorf("unsupport packet: %d", packet.Type)
 	case parser.CLOSE:
-		p.Close()
+		p.closeWith(CloseClientClose, nil)
 		break
 	case parser.UPGRADE:
-		if p.transportPrimary != nil && p.transportBackup != nil {
-			old := p.transportBackup
-			old.upgradeEnd(p.transportPrimary)
-			p.transportBackup = nil
-			if err := old.close(); err != nil {
-				return err
-			}
+		if err := p.upgrade(); err != nil {
+			return err
 		}
 		for _, fn := range p.upgradeHandlers {
 			fn()
 		}
+		p.engine.events.publish(EventUpgrade, p, nil)
 		break
 	case parser.PING:
 		go func() {
 			// refresh heartbeat then pong it.
-			if atomic.LoadInt64(&(p.heartbeat)) != 0 {
-				atomic.StoreInt64(&(p.heartbeat), time.Now().Unix())
-			}
+			p.beat()
 			pong := parser.NewPacketCustom(parser.PONG, packet.Data, 0)
-			p.getTransport().write(pong)
+			if string(packet.Data) == "probe" {
+				p.probe(pong)
+			} else {
+				p.write(pong)
+			}
 		}()
 		break
+	case parser.PONG:
+		// V4 clients answer the PING of server.
+		p.beat()
+		if sent := atomic.SwapInt64(&(p.pingSent), 0); sent > 0 && p.engine != nil {
+			p.engine.stats.heartbeat(p.transportType(), time.Duration(time.Now().UnixNano()-sent))
+		}
+		break
 	case parser.MESSAGE:
+		var span Span
+		var watch *slowWatch
+		if p.engine != nil {
+			_, span = p.engine.startSpan(p.ctx, SpanMessage, Attribute{AttrSid, p.id}, Attribute{AttrBytes, len(packet.Data)})
+			watch = p.engine.watchHandler(p)
+		}
 		for _, fn := range p.msgHanders {
 			fn(packet.Data)
 		}
+		watch.done()
+		endSpan(span, nil)
 		break
 	}
 	return nil
 }
 
+// isLost returns true if no heartbeat comes in ping interval and ping timeout, as the JS implementation waits.
 func (p *socketImpl) isLost() bool {
-	d := time.Now().Unix() - atomic.LoadInt64(&(p.heartbeat))
-	return d > int64(p.engine.options.pingTimeout.Seconds())
+	last := atomic.LoadInt64(&(p.heartbeat))
+	if last == 0 {
+		return false
+	}
+	return time.Duration(time.Now().UnixNano()-last) > p.engine.options.pingInterval+p.engine.options.pingTimeout
+}
+
+// beat refreshes the heartbeat unless the socket is closed.
+func (p *socketImpl) beat() {
+	for {
+		last := atomic.LoadInt64(&(p.heartbeat))
+		if last == 0 || atomic.CompareAndSwapInt64(&(p.heartbeat), last, time.Now().UnixNano()) {
+			return
+		}
+	}
+}
+
+// startPing sends a PING every ping interval until the socket is closed, it's used by V4 sessions.
+func (p *socketImpl) startPing() {
+	interval := p.engine.options.pingInterval
+	var ping func()
+	ping = func() {
+		if atomic.LoadInt64(&(p.heartbeat)) == 0 {
+			return
+		}
+		atomic.StoreInt64(&(p.pingSent), time.Now().UnixNano())
+		p.write(parser.NewPacketCustom(parser.PING, nil, 0))
+		p.lock.Lock()
+		p.pingTimer = time.AfterFunc(interval, ping)
+		p.lock.Unlock()
+	}
+	p.lock.Lock()
+	p.pingTimer = time.AfterFunc(interval, ping)
+	p.lock.Unlock()
 }
 
-func newSocket(id string, eng *engineImpl) *socketImpl {
+func newSocket(ctx context.Context, id string, eng *engineImpl, remoteAddr string) *socketImpl {
+	ctx, cancel := context.WithCancel(ctx)
 	socket := &socketImpl{
+		ctx:             ctx,
+		cancel:          cancel,
 		id:              id,
+		remoteAddr:      remoteAddr,
 		engine:          eng,
-		heartbeat:       time.Now().Unix(),
+		heartbeat:       time.Now().UnixNano(),
+		protocol:        protocolVersion,
 		upgradeHandlers: make([]func(), 0),
 		msgHanders:      make([]func([]byte), 0),
 		errorHandlers:   make([]func(error), 0),
@@ -0,0 +1,131 @@
+package eio
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// socketShards is the count of shards of socketMap, it's a power of 2.
+const socketShards = 64
+
+// socketMap is the registry of open sockets by session ID. Sockets are sharded by their ID, so handshakes and
+// closes lock only the shard of their socket. Iteration reads a snapshot, which is built again after a change.
+type socketMap struct {
+	shards   [socketShards]socketShard
+	snapshot atomic.Value
+}
+
+type socketShard struct {
+	lock    sync.RWMutex
+	sockets map[string]*socketImpl
+	// version is increased by every change of the shard, a snapshot of older versions is stale.
+	version uint64
+	count   int64
+	// shards are padded to their own cache lines, so changes of a shard don't slow down the others.
+	_ [64]byte
+}
+
+type socketSnapshot struct {
+	versions [socketShards]uint64
+	sockets  []*socketImpl
+}
+
+func newSocketMap() *socketMap {
+	p := new(socketMap)
+	for i := range p.shards {
+		p.shards[i].sockets = make(map[string]*socketImpl)
+	}
+	return p
+}
+
+// shard returns the shard of id by its FNV-1a hash.
+func (p *socketMap) shard(id string) *socketShard {
+	h := uint32(2166136261)
+	for i := 0; i < len(id); i++ {
+		h ^= uint32(id[i])
+		h *= 16777619
+	}
+	return &p.shards[h&(socketShards-1)]
+}
+
+func (p *socketMap) Get(id string) (*socketImpl, bool) {
+	shard := p.shard(id)
+	shard.lock.RLock()
+	socket, ok := shard.sockets[id]
+	shard.lock.RUnlock()
+	return socket, ok
+}
+
+// Put returns false if a socket of the same ID exists already.
+func (p *socketMap) Put(socket *socketImpl) bool {
+	shard := p.shard(socket.id)
+	shard.lock.Lock()
+	defer shard.lock.Unlock()
+	if _, ok := shard.sockets[socket.id]; ok {
+		return false
+	}
+	shard.sockets[socket.id] = socket
+	atomic.AddInt64(&(shard.count), 1)
+	atomic.AddUint64(&(shard.version), 1)
+	return true
+}
+
+// Remove removes socket, another socket of the same ID is kept.
+func (p *socketMap) Remove(socket *socketImpl) {
+	shard := p.shard(socket.id)
+	shard.lock.Lock()
+	defer shard.lock.Unlock()
+	if shard.sockets[socket.id] != socket {
+		return
+	}
+	delete(shard.sockets, socket.id)
+	atomic.AddInt64(&(shard.count), -1)
+	atomic.AddUint64(&(shard.version), 1)
+}
+
+func (p *socketMap) Count() int {
+	var n int64
+	for i := range p.shards {
+		n += atomic.LoadInt64(&(p.shards[i].count))
+	}
+	return int(n)
+}
+
+// List returns the sockets accepted by filter, or the snapshot of all sockets if filter is nil,
+// which is shared so it mustn't be modified.
+func (p *socketMap) List(filter func(impl *socketImpl) bool) []*socketImpl {
+	all := p.all()
+	if filter == nil {
+		return all
+	}
+	ret := make([]*socketImpl, 0)
+	for _, it := range all {
+		if filter(it) {
+			ret = append(ret, it)
+		}
+	}
+	return ret
+}
+
+// all returns the snapshot of sockets, it's built again if a socket is put or removed since the last one.
+// A snapshot built along with changes is labeled by the versions before them, so the next call builds it again.
+func (p *socketMap) all() []*socketImpl {
+	var versions [socketShards]uint64
+	for i := range p.shards {
+		versions[i] = atomic.LoadUint64(&(p.shards[i].version))
+	}
+	if snapshot, ok := p.snapshot.Load().(*socketSnapshot); ok && snapshot.versions == versions {
+		return snapshot.sockets
+	}
+	sockets := make([]*socketImpl, 0, p.Count())
+	for i := range p.shards {
+		shard := &p.shards[i]
+		shard.lock.RLock()
+		for _, it := range shard.sockets {
+			sockets = append(sockets, it)
+		}
+		shard.lock.RUnlock()
+	}
+	p.snapshot.Store(&socketSnapshot{versions: versions, sockets: sockets})
+	return sockets
+}
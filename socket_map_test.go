@@ -0,0 +1,64 @@
+package eio
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestSocketMap(t *testing.T) {
+	sockets := newSocketMap()
+	eng := NewEngineBuilder().Build().(*engineImpl)
+	defer eng.Close()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				socket := newSocket(context.Background(), strconv.Itoa(i*1000+j), eng, "")
+				if !sockets.Put(socket) {
+					t.Errorf("put %s failed", socket.id)
+				}
+				sockets.List(nil)
+				if j%2 == 0 {
+					sockets.Remove(socket)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	if n := sockets.Count(); n != 400 {
+		t.Errorf("bad count: %d", n)
+	}
+	if n := len(sockets.List(nil)); n != 400 {
+		t.Errorf("bad snapshot: %d", n)
+	}
+	one, _ := sockets.Get("1")
+	if one == nil || sockets.Put(newSocket(context.Background(), "1", eng, "")) {
+		t.Error("id should be taken")
+	}
+	sockets.Remove(newSocket(context.Background(), "1", eng, ""))
+	if _, ok := sockets.Get("1"); !ok {
+		t.Error("another socket of the id shouldn't be removed")
+	}
+	if odd := sockets.List(func(it *socketImpl) bool { return it.id == "1" }); len(odd) != 1 {
+		t.Errorf("bad filtered list: %d", len(odd))
+	}
+}
+
+func BenchmarkSocketMapChurn(b *testing.B) {
+	sockets := newSocketMap()
+	var seq int64
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			id := strconv.FormatInt(atomic.AddInt64(&seq, 1), 36)
+			socket := &socketImpl{id: id}
+			sockets.Put(socket)
+			sockets.Get(id)
+			sockets.Remove(socket)
+		}
+	})
+}
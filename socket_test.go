@@ -0,0 +1,192 @@
+package eio
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/jjeffcaii/engine.io/parser"
+)
+
+// openPolling creates a session by polling, returns its url with sid and the socket.
+func openPolling(t *testing.T, srv *httptest.Server, sockets chan Socket) (string, Socket) {
+	url := srv.URL + "/engine.io/?EIO=3&transport=polling"
+	if _, body := poll(t, http.MethodGet, url, "", ""); len(body) < 1 {
+		t.Fatal("no handshake")
+	}
+	socket := <-sockets
+	return url + "&sid=" + socket.ID(), socket
+}
+
+func TestSocketUpgrade(t *testing.T) {
+	eng := NewEngineBuilder().Build()
+	defer eng.Close()
+	sockets := make(chan Socket, 1)
+	upgraded := make(chan struct{})
+	eng.OnConnect(func(socket Socket) {
+		socket.OnUpgrade(func() { close(upgraded) })
+		sockets <- socket
+	})
+	srv := httptest.NewServer(http.HandlerFunc(eng.Router()))
+	defer srv.Close()
+	url, socket := openPolling(t, srv, sockets)
+
+	conn := dialWebsocket(t, srv, "&sid="+socket.ID())
+	// no poll is pending, so the packet is left on polling.
+	socket.Send("a")
+	conn.WriteMessage(websocket.TextMessage, []byte("2probe"))
+	if _, msg := readFrame(t, conn); msg != "3probe" {
+		t.Fatalf("bad probe: %q", msg)
+	}
+	// packets are buffered until the upgrade is done.
+	socket.Send("b")
+	socket.Send("c")
+	if socket.Transport().GetType() != WEBSOCKET || socket.State() != SocketUpgrading {
+		t.Errorf("should be upgrading to websocket")
+	}
+	conn.WriteMessage(websocket.TextMessage, []byte("5"))
+	for _, expect := range []string{"4a", "4b", "4c"} {
+		if _, msg := readFrame(t, conn); msg != expect {
+			t.Errorf("should be %q, got %q", expect, msg)
+		}
+	}
+	select {
+	case <-upgraded:
+	case <-time.After(5 * time.Second):
+		t.Fatal("not upgraded")
+	}
+	if state := socket.State(); state != SocketOpen {
+		t.Errorf("should be open: %s", state)
+	}
+	socket.Send("d")
+	if _, msg := readFrame(t, conn); msg != "4d" {
+		t.Errorf("bad message after upgrade: %q", msg)
+	}
+	if res, _ := poll(t, http.MethodGet, url, "", ""); res.StatusCode != http.StatusBadRequest {
+		t.Errorf("polling should be rejected after upgrade: %d", res.StatusCode)
+	}
+}
+
+func TestSocketUpgradeNoop(t *testing.T) {
+	eng := NewEngineBuilder().Build()
+	defer eng.Close()
+	sockets := make(chan Socket, 1)
+	eng.OnConnect(func(socket Socket) { sockets <- socket })
+	srv := httptest.NewServer(http.HandlerFunc(eng.Router()))
+	defer srv.Close()
+	url, socket := openPolling(t, srv, sockets)
+
+	conn := dialWebsocket(t, srv, "&sid="+socket.ID())
+	conn.WriteMessage(websocket.TextMessage, []byte("2probe"))
+	readFrame(t, conn)
+	// the pending poll is ended by a NOOP.
+	if _, body := poll(t, http.MethodGet, url, "", ""); body != "1:6" {
+		t.Errorf("should be NOOP, got %q", body)
+	}
+	socket.Close()
+}
+
+func TestSocketUpgradeTimeout(t *testing.T) {
+	eng := NewEngineBuilder().SetUpgradeTimeout(100 * time.Millisecond).Build()
+	defer eng.Close()
+	sockets := make(chan Socket, 1)
+	eng.OnConnect(func(socket Socket) { sockets <- socket })
+	srv := httptest.NewServer(http.HandlerFunc(eng.Router()))
+	defer srv.Close()
+	url, socket := openPolling(t, srv, sockets)
+
+	conn := dialWebsocket(t, srv, "&sid="+socket.ID())
+	conn.WriteMessage(websocket.TextMessage, []byte("2probe"))
+	readFrame(t, conn)
+	socket.Send("a")
+	if _, _, err := conn.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+		t.Errorf("websocket should be closed, got %v", err)
+	}
+	// the session stays on polling with the buffered packet.
+	_, body := poll(t, http.MethodGet, url, "", "")
+	packets, err := parser.DecodePayloadString(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last := packets[len(packets)-1]; last.Type != parser.MESSAGE || string(last.Data) != "a" {
+		t.Errorf("bad payload after upgrade timeout: %q", body)
+	}
+	if socket.Transport().GetType() != POLLING {
+		t.Errorf("should be polling")
+	}
+	socket.Close()
+}
+
+func TestSocketLifecycle(t *testing.T) {
+	eng := NewEngineBuilder().Build()
+	defer eng.Close()
+	sockets := make(chan Socket, 1)
+	reasons := make(chan string, 1)
+	eng.OnConnect(func(socket Socket) {
+		socket.OnClose(func(reason string) { reasons <- reason })
+		sockets <- socket
+	})
+	client, err := eng.Loopback()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Receive()
+	socket := <-sockets
+	if socket.State() != SocketOpen || socket.RemoteAddr() != loopbackAddr {
+		t.Errorf("bad socket: %s %q", socket.State(), socket.RemoteAddr())
+	}
+
+	if err := socket.SendContext(context.Background(), []byte{0x01}, true); err != nil {
+		t.Fatal(err)
+	}
+	if pack, _ := client.Receive(); pack.Option&parser.BINARY != parser.BINARY || pack.Data[0] != 0x01 {
+		t.Errorf("should be binary: %v", pack)
+	}
+	socket.SendContext(context.Background(), []byte("hi"), false)
+	if pack, _ := client.Receive(); pack.Option&parser.BINARY == parser.BINARY || string(pack.Data) != "hi" {
+		t.Errorf("should be text: %v", pack)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := socket.SendContext(ctx, []byte("late"), false); err != context.Canceled {
+		t.Error("should be canceled:", err)
+	}
+
+	socket.CloseWithReason("bye")
+	if reason := <-reasons; reason != "bye" {
+		t.Errorf("bad close reason: %q", reason)
+	}
+	if socket.State() != SocketClosed {
+		t.Error("should be closed")
+	}
+}
+
+func TestSocketMetadata(t *testing.T) {
+	socket := newSocket(context.Background(), "meta", nil, loopbackAddr)
+	if _, ok := socket.Get("user"); ok {
+		t.Error("should be empty")
+	}
+	socket.Delete("user")
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			socket.Set("tenant", i)
+		}
+	}()
+	socket.Set("user", "alice")
+	<-done
+	if user, ok := socket.Get("user"); !ok || user != "alice" {
+		t.Errorf("bad user: %v", user)
+	}
+	if tenant, _ := socket.Get("tenant"); tenant != 99 {
+		t.Errorf("bad tenant: %v", tenant)
+	}
+	socket.Delete("user")
+	if _, ok := socket.Get("user"); ok {
+		t.Error("should be deleted")
+	}
+}
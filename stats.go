@@ -0,0 +1,214 @@
+package eio
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jjeffcaii/engine.io/parser"
+)
+
+// Stats is a snapshot of the counters of an engine, see Engine.Stats.
+type Stats struct {
+	// Connections is the count of open sockets.
+	Connections int
+	// Handshakes is the count of sockets opened, including the recovered ones.
+	Handshakes uint64
+	// Upgrades is the count of upgrades done, UpgradeFailures is the count of upgrades aborted,
+	// e.g. by the upgrade timeout or the close of transport being upgraded to.
+	Upgrades, UpgradeFailures uint64
+	// Transports are the packets of transports by type.
+	Transports map[TransportType]TransportStats
+	// HeartbeatTimeouts is the count of sockets closed by the ping timeout.
+	HeartbeatTimeouts uint64
+	// Closes is the count of sockets closed by reason.
+	Closes map[CloseReason]uint64
+	// SlowHandlers is the count of messages whose handlers ran beyond the threshold, SlowWrites is the count of
+	// writes to clients beyond the threshold, see EngineBuilder.SetSlowThresholds.
+	SlowHandlers, SlowWrites uint64
+}
+
+// TransportStats is the count of packets received and sent by transports of a type.
+// Bytes are the length of packet data, the framing of codecs isn't counted (see parser.CodecStats).
+type TransportStats struct {
+	PacketsIn, PacketsOut uint64
+	BytesIn, BytesOut     uint64
+	// SizesIn and SizesOut are the distributions of the data length of messages in bytes.
+	SizesIn, SizesOut Histogram
+	// HeartbeatRTT is the distribution of the seconds from a PING of server to the PONG of client, only V4 sessions
+	// are measured as the server pings them.
+	HeartbeatRTT Histogram
+}
+
+// Histogram is a snapshot of a distribution. Counts[i] is the count of observations in (Bounds[i-1], Bounds[i]],
+// the last of Counts is the count of those beyond all bounds, so len(Counts) is len(Bounds)+1.
+type Histogram struct {
+	Bounds []float64
+	Counts []uint64
+	Count  uint64
+	Sum    float64
+}
+
+var (
+	// sizeBounds are the bounds of message sizes in bytes.
+	sizeBounds = []float64{64, 256, 1 << 10, 4 << 10, 16 << 10, 64 << 10, 256 << 10, 1 << 20}
+	// rttBounds are the bounds of heartbeat round trips in seconds.
+	rttBounds = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+)
+
+// histogram is a distribution of fixed bounds, it's safe for concurrent use.
+type histogram struct {
+	bounds []float64
+	counts []uint64
+	// sum holds the bits of a float64.
+	sum uint64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]uint64, len(bounds)+1)}
+}
+
+func (p *histogram) observe(v float64) {
+	atomic.AddUint64(&(p.counts[sort.SearchFloat64s(p.bounds, v)]), 1)
+	for {
+		old := atomic.LoadUint64(&(p.sum))
+		if atomic.CompareAndSwapUint64(&(p.sum), old, math.Float64bits(math.Float64frombits(old)+v)) {
+			return
+		}
+	}
+}
+
+func (p *histogram) snapshot() Histogram {
+	it := Histogram{Bounds: p.bounds, Counts: make([]uint64, len(p.counts))}
+	for i := range p.counts {
+		it.Counts[i] = atomic.LoadUint64(&(p.counts[i]))
+		it.Count += it.Counts[i]
+	}
+	it.Sum = math.Float64frombits(atomic.LoadUint64(&(p.sum)))
+	return it
+}
+
+// errUpgradeAborted counts an upgrade aborted as a failure.
+var errUpgradeAborted = errors.New("transport: upgrade aborted")
+
+// engineStats are the counters of an engine, it's safe for concurrent use.
+type engineStats struct {
+	handshakes, upgrades, upgradeFailures uint64
+	// transports are the *transportCounters of transport types.
+	transports               sync.Map
+	closes                   [CloseMigrated + 1]uint64
+	slowHandlers, slowWrites uint64
+}
+
+type transportCounters struct {
+	packetsIn, packetsOut, bytesIn, bytesOut uint64
+	sizesIn, sizesOut, rtt                   *histogram
+}
+
+func newTransportCounters() *transportCounters {
+	return &transportCounters{
+		sizesIn:  newHistogram(sizeBounds),
+		sizesOut: newHistogram(sizeBounds),
+		rtt:      newHistogram(rttBounds),
+	}
+}
+
+func (p *engineStats) transport(t TransportType) *transportCounters {
+	if it, ok := p.transports.Load(t); ok {
+		return it.(*transportCounters)
+	}
+	it, _ := p.transports.LoadOrStore(t, newTransportCounters())
+	return it.(*transportCounters)
+}
+
+// received counts packet received by a transport of t.
+func (p *engineStats) received(t TransportType, packet *parser.Packet) {
+	it := p.transport(t)
+	atomic.AddUint64(&(it.packetsIn), 1)
+	atomic.AddUint64(&(it.bytesIn), uint64(len(packet.Data)))
+	observeSize(it.sizesIn, packet)
+}
+
+// observeSize observes the data length of packet in h if it's a message, a streamed body of unknown length isn't.
+func observeSize(h *histogram, packet *parser.Packet) {
+	if packet.Type != parser.MESSAGE {
+		return
+	}
+	size := int64(len(packet.Data))
+	if packet.Body != nil {
+		if packet.BodyLen < 0 {
+			return
+		}
+		size = packet.BodyLen
+	}
+	h.observe(float64(size))
+}
+
+// counting returns send which counts the packets sent by a transport of t.
+func (p *engineStats) counting(t TransportType, send func(packet *parser.Packet) error) func(packet *parser.Packet) error {
+	it := p.transport(t)
+	return func(packet *parser.Packet) error {
+		if err := send(packet); err != nil {
+			return err
+		}
+		atomic.AddUint64(&(it.packetsOut), 1)
+		atomic.AddUint64(&(it.bytesOut), uint64(len(packet.Data)))
+		observeSize(it.sizesOut, packet)
+		return nil
+	}
+}
+
+// heartbeat observes rtt of a PING answered by a transport of t.
+func (p *engineStats) heartbeat(t TransportType, rtt time.Duration) {
+	p.transport(t).rtt.observe(rtt.Seconds())
+}
+
+func (p *engineStats) upgraded(err error) {
+	if err != nil {
+		atomic.AddUint64(&(p.upgradeFailures), 1)
+	} else {
+		atomic.AddUint64(&(p.upgrades), 1)
+	}
+}
+
+func (p *engineStats) closed(reason CloseReason) {
+	if reason >= 0 && int(reason) < len(p.closes) {
+		atomic.AddUint64(&(p.closes[reason]), 1)
+	}
+}
+
+func (p *engineImpl) Stats() Stats {
+	stats := Stats{
+		Connections:     p.CountClients(),
+		Handshakes:      atomic.LoadUint64(&(p.stats.handshakes)),
+		Upgrades:        atomic.LoadUint64(&(p.stats.upgrades)),
+		UpgradeFailures: atomic.LoadUint64(&(p.stats.upgradeFailures)),
+		Transports:      make(map[TransportType]TransportStats),
+		Closes:          make(map[CloseReason]uint64),
+		SlowHandlers:    atomic.LoadUint64(&(p.stats.slowHandlers)),
+		SlowWrites:      atomic.LoadUint64(&(p.stats.slowWrites)),
+	}
+	p.stats.transports.Range(func(k, v interface{}) bool {
+		it := v.(*transportCounters)
+		stats.Transports[k.(TransportType)] = TransportStats{
+			PacketsIn:    atomic.LoadUint64(&(it.packetsIn)),
+			PacketsOut:   atomic.LoadUint64(&(it.packetsOut)),
+			BytesIn:      atomic.LoadUint64(&(it.bytesIn)),
+			BytesOut:     atomic.LoadUint64(&(it.bytesOut)),
+			SizesIn:      it.sizesIn.snapshot(),
+			SizesOut:     it.sizesOut.snapshot(),
+			HeartbeatRTT: it.rtt.snapshot(),
+		}
+		return true
+	})
+	for i := range p.stats.closes {
+		if n := atomic.LoadUint64(&(p.stats.closes[i])); n > 0 {
+			stats.Closes[CloseReason(i)] = n
+		}
+	}
+	stats.HeartbeatTimeouts = stats.Closes[ClosePingTimeout]
+	return stats
+}
@@ -0,0 +1,175 @@
+package eio
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// LiveStats is a snapshot of the open sessions of an engine, see Engine.LiveStats. It can be published by expvar:
+//
+//	expvar.Publish("eio", expvar.Func(func() interface{} { return eng.LiveStats() }))
+type LiveStats struct {
+	// Started is when the engine is built.
+	Started time.Time
+	Uptime  time.Duration
+	// Connections is the count of open sockets.
+	Connections int
+	// Transports counts the sockets by transport, a socket being upgraded counts in the transport it upgrades to.
+	Transports map[TransportType]int
+	// Protocols counts the sockets by protocol version, see Socket.Protocol.
+	Protocols map[uint8]int
+	// Upgrading is the count of sockets being upgraded.
+	Upgrading int
+	// QueuedPackets and QueuedBytes are the packets waiting in the send queues of transports, including the
+	// packets held while transports are paused. MaxQueuedPackets is the most packets waiting for a socket.
+	QueuedPackets    int
+	QueuedBytes      int64
+	MaxQueuedPackets int
+}
+
+// queuedTransport is a transport which queues the packets being sent.
+type queuedTransport interface {
+	queued() (packets int, bytes int64)
+}
+
+// queued returns the packets held while paused.
+func (p *tinyTransport) queued() (packets int, bytes int64) {
+	p.holdLock.Lock()
+	defer p.holdLock.Unlock()
+	for _, it := range p.held {
+		bytes += int64(len(it.Data))
+	}
+	return len(p.held), bytes
+}
+
+func (p *xhrTransport) queued() (int, int64) {
+	packets, bytes := p.tinyTransport.queued()
+	return packets + len(p.outbox.ch), bytes + p.outbox.size()
+}
+
+func (p *wsTransport) queued() (int, int64) {
+	packets, bytes := p.tinyTransport.queued()
+	return packets + len(p.outbox.ch), bytes + p.outbox.size()
+}
+
+func (p *loopbackTransport) queued() (int, int64) {
+	packets, bytes := p.tinyTransport.queued()
+	return packets + len(p.outbox.ch), bytes + p.outbox.size()
+}
+
+func (p *engineImpl) LiveStats() LiveStats {
+	stats := LiveStats{
+		Started:    p.started,
+		Uptime:     time.Since(p.started),
+		Transports: make(map[TransportType]int),
+		Protocols:  make(map[uint8]int),
+	}
+	for _, socket := range p.sockets.all() {
+		socket.lock.RLock()
+		primary, backup := socket.transportPrimary, socket.transportBackup
+		socket.lock.RUnlock()
+		stats.Connections++
+		stats.Protocols[uint8(socket.protocol)]++
+		var packets int
+		for _, it := range []Transport{primary, backup} {
+			if it == nil {
+				continue
+			}
+			if q, ok := it.(queuedTransport); ok {
+				n, bytes := q.queued()
+				packets += n
+				stats.QueuedBytes += bytes
+			}
+		}
+		stats.QueuedPackets += packets
+		if packets > stats.MaxQueuedPackets {
+			stats.MaxQueuedPackets = packets
+		}
+		if primary != nil && backup != nil {
+			stats.Upgrading++
+		}
+		if primary == nil {
+			primary = backup
+		}
+		if primary != nil {
+			stats.Transports[primary.GetType()]++
+		}
+	}
+	return stats
+}
+
+// statsJSON is the response of the stats endpoint, see EngineBuilder.SetStatsEndpoint.
+type statsJSON struct {
+	Started       time.Time                     `json:"started"`
+	UptimeSeconds float64                       `json:"uptimeSeconds"`
+	Connections   int                           `json:"connections"`
+	Upgrading     int                           `json:"upgrading"`
+	Transports    map[string]int                `json:"transports"`
+	Protocols     map[string]int                `json:"protocols"`
+	Queues        statsQueuesJSON               `json:"queues"`
+	Handshakes    uint64                        `json:"handshakes"`
+	Upgrades      uint64                        `json:"upgrades"`
+	UpgradeFails  uint64                        `json:"upgradeFailures"`
+	Timeouts      uint64                        `json:"heartbeatTimeouts"`
+	Packets       map[string]transportStatsJSON `json:"packets"`
+	Closes        map[string]uint64             `json:"closes"`
+}
+
+type statsQueuesJSON struct {
+	Packets    int   `json:"packets"`
+	Bytes      int64 `json:"bytes"`
+	MaxPackets int   `json:"maxPackets"`
+}
+
+// transportStatsJSON is TransportStats in the response of the stats endpoint.
+type transportStatsJSON struct {
+	PacketsIn  uint64 `json:"packetsIn"`
+	PacketsOut uint64 `json:"packetsOut"`
+	BytesIn    uint64 `json:"bytesIn"`
+	BytesOut   uint64 `json:"bytesOut"`
+}
+
+// serveStats serves the live stats and the counters of engine as JSON, if the stats auth allows request.
+func (p *engineImpl) serveStats(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet {
+		writer.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if err := p.statsAuth(request); err != nil {
+		sendError(writer, err, http.StatusForbidden)
+		return
+	}
+	live, counters := p.LiveStats(), p.Stats()
+	out := statsJSON{
+		Started:       live.Started,
+		UptimeSeconds: live.Uptime.Seconds(),
+		Connections:   live.Connections,
+		Upgrading:     live.Upgrading,
+		Transports:    make(map[string]int, len(live.Transports)),
+		Protocols:     make(map[string]int, len(live.Protocols)),
+		Queues:        statsQueuesJSON{live.QueuedPackets, live.QueuedBytes, live.MaxQueuedPackets},
+		Handshakes:    counters.Handshakes,
+		Upgrades:      counters.Upgrades,
+		UpgradeFails:  counters.UpgradeFailures,
+		Timeouts:      counters.HeartbeatTimeouts,
+		Packets:       make(map[string]transportStatsJSON, len(counters.Transports)),
+		Closes:        make(map[string]uint64, len(counters.Closes)),
+	}
+	for k, v := range live.Transports {
+		out.Transports[k.String()] = v
+	}
+	for k, v := range live.Protocols {
+		out.Protocols[strconv.Itoa(int(k))] = v
+	}
+	for k, v := range counters.Transports {
+		out.Packets[k.String()] = transportStatsJSON{v.PacketsIn, v.PacketsOut, v.BytesIn, v.BytesOut}
+	}
+	for k, v := range counters.Closes {
+		out.Closes[k.String()] = v
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(writer).Encode(&out)
+}
@@ -0,0 +1,49 @@
+package eio
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStatsEndpoint(t *testing.T) {
+	eng := NewEngineBuilder().SetStatsEndpoint("", func(request *http.Request) error {
+		if request.Header.Get("Authorization") != "Bearer secret" {
+			return errors.New("bad token")
+		}
+		return nil
+	}).Build()
+	defer eng.Close()
+	sockets := make(chan Socket, 1)
+	eng.OnConnect(func(socket Socket) { sockets <- socket })
+	srv := httptest.NewServer(http.HandlerFunc(eng.Router()))
+	defer srv.Close()
+	poll(t, http.MethodGet, srv.URL+"/engine.io/?EIO=3&transport=polling", "", "")
+	socket := <-sockets
+	// no poll is pending, so the message waits in the send queue.
+	socket.Send("hello")
+
+	if res, _ := poll(t, http.MethodGet, srv.URL+"/engine.io/stats", "", ""); res.StatusCode != http.StatusForbidden {
+		t.Errorf("stats should be denied: %d", res.StatusCode)
+	}
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/engine.io/stats", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	var stats statsJSON
+	if err := json.NewDecoder(res.Body).Decode(&stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.Connections != 1 || stats.Transports["polling"] != 1 || stats.Protocols["3"] != 1 || stats.Handshakes != 1 ||
+		stats.Queues.Packets != 1 || stats.Queues.Bytes != 5 || stats.Queues.MaxPackets != 1 || stats.UptimeSeconds <= 0 {
+		t.Errorf("bad stats: %+v", stats)
+	}
+	if live := eng.LiveStats(); live.Connections != 1 || live.Transports[POLLING] != 1 || live.Started.IsZero() {
+		t.Errorf("bad live stats: %+v", live)
+	}
+}
@@ -0,0 +1,56 @@
+package eio
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jjeffcaii/engine.io/parser"
+)
+
+func TestHistograms(t *testing.T) {
+	eng := NewEngineBuilder().SetPingInterval(50 * time.Millisecond).Build()
+	defer eng.Close()
+	sockets := make(chan Socket, 1)
+	eng.OnConnect(func(socket Socket) { sockets <- socket })
+	srv := httptest.NewServer(http.HandlerFunc(eng.Router()))
+	defer srv.Close()
+	url := srv.URL + "/engine.io/?EIO=4&transport=polling"
+	poll(t, http.MethodGet, url, "", "")
+	url += "&sid=" + (<-sockets).ID()
+	if _, body := poll(t, http.MethodGet, url, "", ""); body != "2" {
+		t.Fatalf("server should ping: %q", body)
+	}
+	poll(t, http.MethodPost, url, parser.ContentTypeText, "3\x1e4hello")
+
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		stats := eng.Stats().Transports[POLLING]
+		if stats.HeartbeatRTT.Count < 1 || stats.SizesIn.Count < 1 {
+			if time.Now().After(deadline) {
+				t.Fatalf("pong and message should be observed: %+v", stats)
+			}
+			continue
+		}
+		if rtt := stats.HeartbeatRTT; rtt.Count != 1 || rtt.Sum <= 0 || len(rtt.Counts) != len(rtt.Bounds)+1 {
+			t.Errorf("bad heartbeat rtt: %+v", rtt)
+		}
+		if sizes := stats.SizesIn; sizes.Count != 1 || sizes.Sum != 5 || sizes.Counts[0] != 1 {
+			t.Errorf("bad message sizes: %+v", sizes)
+		}
+		if stats.SizesOut.Count != 0 {
+			t.Errorf("only messages should be observed: %+v", stats.SizesOut)
+		}
+		return
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	h := newHistogram([]float64{1, 10})
+	for _, it := range []float64{0.5, 1, 5, 100} {
+		h.observe(it)
+	}
+	if it := h.snapshot(); it.Count != 4 || it.Sum != 106.5 || it.Counts[0] != 2 || it.Counts[1] != 1 || it.Counts[2] != 1 {
+		t.Errorf("bad histogram: %+v", it)
+	}
+}
@@ -0,0 +1,151 @@
+package eio
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// names of the spans of engines, see Tracer.
+const (
+	// SpanHandshake is the handshake of a socket, the context of socket carries it.
+	SpanHandshake = "eio.handshake"
+	// SpanUpgrade lasts from the request of the transport being upgraded to until the upgrade is done or aborted.
+	SpanUpgrade = "eio.upgrade"
+	// SpanPoll is a polling request of a socket.
+	SpanPoll = "eio.poll"
+	// SpanMessage is the dispatch of a MESSAGE to the handlers of Socket.OnMessage.
+	SpanMessage = "eio.message"
+)
+
+// keys of the attributes of spans.
+const (
+	AttrSid        = "eio.sid"
+	AttrTransport  = "eio.transport"
+	AttrRemoteAddr = "eio.remote_addr"
+	AttrMethod     = "http.method"
+	AttrBytes      = "eio.message.bytes"
+)
+
+// errTraceParent is returned by ParseTraceParent.
+var errTraceParent = errors.New("invalid traceparent")
+
+// Tracer traces the handshakes, upgrades, polling requests and messages of an engine, see EngineBuilder.SetTracer.
+// It bridges a tracing system, e.g. an OpenTelemetry bridge extracts by its propagator and starts the spans of
+// its tracer. Implementations must be safe for concurrent use.
+type Tracer interface {
+	// Extract returns ctx carrying the trace context of the headers of an incoming request, such as the W3C
+	// traceparent (see ExtractTraceParent). The context of a socket carries the trace context of its handshake.
+	Extract(ctx context.Context, header http.Header) context.Context
+	// Start begins a span of name as a child of the span of ctx, the returned context carries the new span.
+	Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span)
+}
+
+// Span is a span begun by Tracer.Start.
+type Span interface {
+	// SetAttributes adds attrs to the span.
+	SetAttributes(attrs ...Attribute)
+	// End finishes the span, err records its failure if it's not nil.
+	End(err error)
+}
+
+// Attribute is a key value of a span.
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+// TraceParent is the W3C trace context of a request, see https://www.w3.org/TR/trace-context/.
+type TraceParent struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Flags   byte
+	// State is the vendor specific tracestate header.
+	State string
+}
+
+// Sampled returns whether the caller may have recorded the trace.
+func (p TraceParent) Sampled() bool {
+	return p.Flags&0x01 == 0x01
+}
+
+// String returns the traceparent header of version 00.
+func (p TraceParent) String() string {
+	return "00-" + hex.EncodeToString(p.TraceID[:]) + "-" + hex.EncodeToString(p.SpanID[:]) + "-" + hex.EncodeToString([]byte{p.Flags})
+}
+
+// ParseTraceParent parses a traceparent header, the IDs of a valid one aren't all zeros.
+func ParseTraceParent(s string) (TraceParent, error) {
+	var ret TraceParent
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	// a future version may append fields.
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || parts[0] == "00" && len(parts) != 4 {
+		return ret, errTraceParent
+	}
+	var flags [1]byte
+	for _, it := range []struct {
+		s   string
+		dst []byte
+	}{{parts[1], ret.TraceID[:]}, {parts[2], ret.SpanID[:]}, {parts[3], flags[:]}} {
+		if len(it.s) != 2*len(it.dst) || strings.ToLower(it.s) != it.s {
+			return ret, errTraceParent
+		}
+		if _, err := hex.Decode(it.dst, []byte(it.s)); err != nil {
+			return ret, errTraceParent
+		}
+	}
+	if ret.TraceID == [16]byte{} || ret.SpanID == [8]byte{} {
+		return ret, errTraceParent
+	}
+	ret.Flags = flags[0]
+	return ret, nil
+}
+
+type traceParentKey struct{}
+
+// ContextWithTraceParent returns ctx carrying tp.
+func ContextWithTraceParent(ctx context.Context, tp TraceParent) context.Context {
+	return context.WithValue(ctx, traceParentKey{}, tp)
+}
+
+// TraceParentFromContext returns the trace context carried by ctx, ok is false if there's none.
+func TraceParentFromContext(ctx context.Context) (tp TraceParent, ok bool) {
+	tp, ok = ctx.Value(traceParentKey{}).(TraceParent)
+	return
+}
+
+// ExtractTraceParent returns ctx carrying the traceparent and tracestate of header, it's ctx as is if the
+// traceparent is missing or invalid. It's the Extract of tracers which speak W3C trace context.
+func ExtractTraceParent(ctx context.Context, header http.Header) context.Context {
+	tp, err := ParseTraceParent(header.Get("traceparent"))
+	if err != nil {
+		return ctx
+	}
+	tp.State = strings.Join(header.Values("tracestate"), ",")
+	return ContextWithTraceParent(ctx, tp)
+}
+
+// startSpan begins a span by the tracer of engine, the span is nil if there's no tracer.
+func (p *engineImpl) startSpan(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span) {
+	if p.tracer == nil {
+		return ctx, nil
+	}
+	return p.tracer.Start(ctx, name, attrs...)
+}
+
+// extract returns ctx carrying the trace context of request by the tracer of engine.
+func (p *engineImpl) extract(ctx context.Context, request *http.Request) context.Context {
+	if p.tracer == nil {
+		return ctx
+	}
+	return p.tracer.Extract(ctx, request.Header)
+}
+
+// endSpan finishes span if it's not nil.
+func endSpan(span Span, err error) {
+	if span != nil {
+		span.End(err)
+	}
+}
@@ -0,0 +1,140 @@
+package eio
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+type recordedSpan struct {
+	name   string
+	parent TraceParent
+	attrs  map[string]interface{}
+	err    error
+}
+
+// recordingTracer records the spans ended, the trace context is W3C.
+type recordingTracer struct {
+	lock  sync.Mutex
+	spans chan *recordedSpan
+}
+
+func (p *recordingTracer) Extract(ctx context.Context, header http.Header) context.Context {
+	return ExtractTraceParent(ctx, header)
+}
+
+func (p *recordingTracer) Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span) {
+	span := &recordingSpan{tracer: p, span: &recordedSpan{name: name, attrs: make(map[string]interface{})}}
+	span.span.parent, _ = TraceParentFromContext(ctx)
+	span.SetAttributes(attrs...)
+	return ctx, span
+}
+
+type recordingSpan struct {
+	tracer *recordingTracer
+	span   *recordedSpan
+}
+
+func (p *recordingSpan) SetAttributes(attrs ...Attribute) {
+	p.tracer.lock.Lock()
+	defer p.tracer.lock.Unlock()
+	for _, it := range attrs {
+		p.span.attrs[it.Key] = it.Value
+	}
+}
+
+func (p *recordingSpan) End(err error) {
+	p.span.err = err
+	p.tracer.spans <- p.span
+}
+
+func TestTracer(t *testing.T) {
+	tracer := &recordingTracer{spans: make(chan *recordedSpan, 16)}
+	eng := NewServer(WithTracer(tracer))
+	defer eng.Close()
+	sockets := make(chan Socket, 1)
+	messages := make(chan string, 1)
+	eng.OnConnect(func(socket Socket) {
+		socket.OnMessage(func(data []byte) { messages <- string(data) })
+		sockets <- socket
+	})
+	srv := httptest.NewServer(eng)
+	defer srv.Close()
+	// spans of other names are kept, as spans of a request and of its messages end in any order.
+	var ended []*recordedSpan
+	next := func(name string) *recordedSpan {
+		for {
+			for i, it := range ended {
+				if it.name == name {
+					ended = append(ended[:i], ended[i+1:]...)
+					return it
+				}
+			}
+			select {
+			case it := <-tracer.spans:
+				ended = append(ended, it)
+			case <-time.After(5 * time.Second):
+				t.Fatalf("no span %s", name)
+			}
+		}
+	}
+
+	const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/engine.io/?EIO=3&transport=polling", nil)
+	req.Header.Set("traceparent", traceparent)
+	req.Header.Set("tracestate", "a=1")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	socket := <-sockets
+	if tp, ok := TraceParentFromContext(socket.Context()); !ok || tp.String() != traceparent || tp.State != "a=1" || !tp.Sampled() {
+		t.Errorf("socket should carry the trace context of handshake: %v %v", tp, ok)
+	}
+	if it := next(SpanHandshake); it.attrs[AttrSid] != socket.ID() || it.attrs[AttrTransport] != "polling" || it.parent.String() != traceparent {
+		t.Errorf("bad handshake span: %v", it)
+	}
+
+	url := srv.URL + "/engine.io/?EIO=3&transport=polling&sid=" + socket.ID()
+	poll(t, http.MethodPost, url, "", "6:4hello")
+	<-messages
+	if it := next(SpanMessage); it.attrs[AttrSid] != socket.ID() || it.attrs[AttrBytes] != 5 || it.parent.String() != traceparent {
+		t.Errorf("bad message span: %v", it)
+	}
+	if it := next(SpanPoll); it.attrs[AttrMethod] != http.MethodPost {
+		t.Errorf("bad poll span: %v", it)
+	}
+
+	conn := dialWebsocket(t, srv, "&sid="+socket.ID())
+	conn.WriteMessage(websocket.TextMessage, []byte("2probe"))
+	readFrame(t, conn)
+	conn.WriteMessage(websocket.TextMessage, []byte("5"))
+	if it := next(SpanUpgrade); it.err != nil || it.attrs[AttrTransport] != "websocket" {
+		t.Errorf("bad upgrade span: %v", it)
+	}
+}
+
+func TestParseTraceParent(t *testing.T) {
+	for _, it := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-x",
+	} {
+		if _, err := ParseTraceParent(it); err == nil {
+			t.Errorf("%q should be invalid", it)
+		}
+	}
+	if tp, err := ParseTraceParent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00-x"); err != nil || tp.Sampled() {
+		t.Errorf("future version should be parsed: %v %v", tp, err)
+	}
+}
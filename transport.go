@@ -1,15 +1,28 @@
 package eio
 
 import (
+	"errors"
 	"fmt"
+	"log/slog"
+	"net"
 	"sync"
+	"time"
+
+	"github.com/jjeffcaii/engine.io/parser"
 )
 
+// ErrWriteStalled is the close reason of a socket whose peer stops reading, so a write can't finish
+// in the write timeout. See EngineBuilder.SetWriteTimeout.
+var ErrWriteStalled = errors.New("transport: write stalled")
+
 type messageOK struct {
 	Sid          string   `json:"sid"`
 	Upgrades     []string `json:"upgrades"`
 	PingInterval int64    `json:"pingInterval"`
 	PingTimeout  int64    `json:"pingTimeout"`
+	MaxPayload   int64    `json:"maxPayload,omitempty"`
+	// RecoveryToken resumes the session in the recover query of a handshake, see EngineBuilder.SetRecovery.
+	RecoveryToken string `json:"recoveryToken,omitempty"`
 }
 
 type tinyTransport struct {
@@ -18,6 +31,97 @@ type tinyTransport struct {
 	locker       *sync.RWMutex
 	handlerWrite func()
 	handlerFlush func()
+	// handlerSend sends a packet on the concrete transport, packets written while paused are held instead.
+	handlerSend func(packet *parser.Packet) error
+	// pauseLock is held exclusively to pause or resume, so no packet is being sent meanwhile.
+	pauseLock sync.RWMutex
+	paused    bool
+	held      []*parser.Packet
+	holdLock  sync.Mutex
+}
+
+func (p *tinyTransport) write(packet *parser.Packet) error {
+	p.pauseLock.RLock()
+	defer p.pauseLock.RUnlock()
+	if p.paused {
+		p.holdLock.Lock()
+		p.held = append(p.held, packet)
+		p.holdLock.Unlock()
+		return nil
+	}
+	return p.handlerSend(packet)
+}
+
+func (p *tinyTransport) Pause() {
+	p.pauseLock.Lock()
+	p.paused = true
+	p.pauseLock.Unlock()
+}
+
+func (p *tinyTransport) Resume() error {
+	p.pauseLock.Lock()
+	defer p.pauseLock.Unlock()
+	if !p.paused {
+		return nil
+	}
+	p.paused = false
+	for _, it := range p.takeHeld() {
+		if err := p.handlerSend(it); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *tinyTransport) Paused() bool {
+	p.pauseLock.RLock()
+	defer p.pauseLock.RUnlock()
+	return p.paused
+}
+
+// writeDeadline returns the deadline of a write starting now, it's zero if writes never time out.
+func (p *tinyTransport) writeDeadline() time.Time {
+	if p.eng.options.writeTimeout <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(p.eng.options.writeTimeout)
+}
+
+// stalled calls stall if err is a timeout, or returns err otherwise.
+func (p *tinyTransport) stalled(err error) error {
+	var ne net.Error
+	if !errors.As(err, &ne) || !ne.Timeout() {
+		return err
+	}
+	return p.stall()
+}
+
+// stalling returns a channel which fires when a write starting now times out, it's nil if writes never time out.
+// The returned stop function releases the timer.
+func (p *tinyTransport) stalling() (<-chan time.Time, func() bool) {
+	if p.eng.options.writeTimeout <= 0 {
+		return nil, func() bool { return false }
+	}
+	timer := time.NewTimer(p.eng.options.writeTimeout)
+	return timer.C, timer.Stop
+}
+
+// stall closes the socket with ErrWriteStalled and returns it.
+func (p *tinyTransport) stall() error {
+	// the writer may hold locks of the socket, so it's closed by another goroutine.
+	if socket := p.socket; socket != nil {
+		go socket.closeWith(CloseTransportError, ErrWriteStalled)
+	}
+	return ErrWriteStalled
+}
+
+// takeHeld returns the packets held while paused and forgets them.
+func (p *tinyTransport) takeHeld() []*parser.Packet {
+	p.holdLock.Lock()
+	defer p.holdLock.Unlock()
+	held := p.held
+	p.held = nil
+	return held
 }
 
 func (p *tinyTransport) onWrite(fn func(), async bool) {
@@ -27,9 +131,7 @@ func (p *tinyTransport) onWrite(fn func(), async bool) {
 	fn2 := func() {
 		defer func() {
 			if e := recover(); e != nil {
-				if p.eng.logErr != nil {
-					p.eng.logErr("handle write failed: %s\n", e)
-				}
+				p.eng.log(LogTransport, slog.LevelError, "handler_panic", "handle write failed", append(p.socketAttrs(), LogKeyError, e)...)
 			}
 		}()
 		fn()
@@ -48,9 +150,7 @@ func (p *tinyTransport) onFlush(fn func(), async bool) {
 	fn2 := func() {
 		defer func() {
 			if e := recover(); e != nil {
-				if p.eng.logErr != nil {
-					p.eng.logErr("handle flush failed: %s\n", e)
-				}
+				p.eng.log(LogTransport, slog.LevelError, "handler_panic", "handle flush failed", append(p.socketAttrs(), LogKeyError, e)...)
 			}
 		}()
 		fn()
@@ -62,6 +162,24 @@ func (p *tinyTransport) onFlush(fn func(), async bool) {
 	}
 }
 
+// socketAttrs returns the log attributes of the socket of transport, it's nil if there's no socket.
+func (p *tinyTransport) socketAttrs() []interface{} {
+	if socket := p.socket; socket != nil {
+		return []interface{}{LogKeySid, socket.id, LogKeyRemoteAddr, socket.remoteAddr}
+	}
+	return nil
+}
+
+// protocol returns the protocol revision of socket, which the transport speaks.
+func (p *tinyTransport) protocol() parser.Protocol {
+	p.locker.RLock()
+	defer p.locker.RUnlock()
+	if p.socket == nil {
+		return protocolVersion
+	}
+	return p.socket.protocol
+}
+
 func (p *tinyTransport) setSocket(socket Socket) {
 	p.locker.Lock()
 	p.socket = socket.(*socketImpl)
@@ -75,10 +193,120 @@ func (p *tinyTransport) clearSocket() {
 func newTransport(engine *engineImpl, transport TransportType) Transport {
 	switch transport {
 	default:
-		panic(fmt.Errorf("invalid transport '%d'", transport))
+		entry, ok := transportEntryOf(transport)
+		if !ok || entry.factory == nil {
+			panic(fmt.Errorf("invalid transport '%d'", transport))
+		}
+		return newCustomTransport(engine, transport, entry.factory())
 	case WEBSOCKET:
 		return newWebsocketTransport(engine)
 	case POLLING:
 		return newXhrTransport(engine)
 	}
 }
+
+type transportEntry struct {
+	name        string
+	upgradeable bool
+	// direct transports serve sessions out of http, they are never requested nor upgraded.
+	direct  bool
+	factory func() CustomTransport
+}
+
+var (
+	transportLock sync.RWMutex
+	// transportEntries is indexed by transport type, built-in transports are registered already.
+	transportEntries = []transportEntry{
+		POLLING:   {name: "polling"},
+		WEBSOCKET: {name: "websocket", upgradeable: true},
+		STREAM:    {name: "stream", direct: true},
+		LOOPBACK:  {name: "loopback", direct: true},
+	}
+)
+
+// RegisterTransport registers a custom transport and returns its type, which can be allowed by EngineBuilder.SetTransports.
+// The factory is called once for every session using the transport, and once on registration to read the name
+// and whether sessions can be upgraded to it. It panics if the name is registered already.
+func RegisterTransport(factory func() CustomTransport) TransportType {
+	sample := factory()
+	name, upgradeable := sample.Name(), sample.Upgradeable()
+	sample.Close()
+	transportLock.Lock()
+	defer transportLock.Unlock()
+	for _, it := range transportEntries {
+		if it.name == name {
+			panic(fmt.Errorf("transport '%s' is registered already", name))
+		}
+	}
+	transportEntries = append(transportEntries, transportEntry{name: name, upgradeable: upgradeable, factory: factory})
+	return TransportType(len(transportEntries) - 1)
+}
+
+// String returns the name of transport, which is the value of transport query.
+func (t TransportType) String() string {
+	if entry, ok := transportEntryOf(t); ok {
+		return entry.name
+	}
+	return fmt.Sprintf("TransportType(%d)", t)
+}
+
+func transportEntryOf(t TransportType) (transportEntry, bool) {
+	transportLock.RLock()
+	defer transportLock.RUnlock()
+	if t < 0 || int(t) >= len(transportEntries) {
+		return transportEntry{}, false
+	}
+	return transportEntries[t], true
+}
+
+func lookupTransport(name string) (TransportType, bool) {
+	transportLock.RLock()
+	defer transportLock.RUnlock()
+	for i, it := range transportEntries {
+		if it.name == name {
+			return TransportType(i), true
+		}
+	}
+	return -1, false
+}
+
+// handshake returns the OPEN packet of socket created on transport current.
+// Upgradeable transports are offered in upgrades if current isn't one of them, as polling is, except for direct ones.
+func (p *engineImpl) handshake(socket *socketImpl, current TransportType) *parser.Packet {
+	msg := messageOK{
+		Sid:           socket.id,
+		Upgrades:      emptyStringArray,
+		PingInterval:  int64(1000 * p.options.pingInterval.Seconds()),
+		PingTimeout:   int64(1000 * p.options.pingTimeout.Seconds()),
+		MaxPayload:    p.options.maxPayload,
+		RecoveryToken: socket.recoveryToken,
+	}
+	if entry, ok := transportEntryOf(current); ok && !entry.upgradeable && !entry.direct && p.options.allowUpgrades {
+		msg.Upgrades = make([]string, 0)
+		for _, it := range p.allowTransports {
+			if dest, ok := transportEntryOf(it); ok && dest.upgradeable {
+				msg.Upgrades = append(msg.Upgrades, dest.name)
+			}
+		}
+	}
+	if p.handshakeFields == nil {
+		return parser.NewPacketByJSON(parser.OPEN, &msg)
+	}
+	fields := p.handshakeFields(socket)
+	if len(fields) < 1 {
+		return parser.NewPacketByJSON(parser.OPEN, &msg)
+	}
+	body := make(map[string]interface{}, len(fields)+5)
+	for k, v := range fields {
+		body[k] = v
+	}
+	body["sid"], body["upgrades"] = msg.Sid, msg.Upgrades
+	body["pingInterval"], body["pingTimeout"] = msg.PingInterval, msg.PingTimeout
+	if msg.MaxPayload > 0 {
+		body["maxPayload"] = msg.MaxPayload
+	}
+	if len(msg.RecoveryToken) > 0 {
+		body["recoveryToken"] = msg.RecoveryToken
+	}
+	return parser.NewPacketByJSON(parser.OPEN, body)
+}
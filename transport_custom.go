@@ -0,0 +1,109 @@
+package eio
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"github.com/jjeffcaii/engine.io/parser"
+)
+
+// customTransport adapts a CustomTransport to sockets, packets it receives are accepted by the socket.
+type customTransport struct {
+	tinyTransport
+	ttype     TransportType
+	conn      CustomTransport
+	req       *http.Request
+	receiving sync.Once
+	// closed is set to 1 once the transport is closed.
+	closed int32
+}
+
+func (p *customTransport) GetRequest() *http.Request {
+	return p.req
+}
+
+func (p *customTransport) GetType() TransportType {
+	return p.ttype
+}
+
+func (p *customTransport) GetEngine() Engine {
+	return p.eng
+}
+
+func (p *customTransport) GetSocket() Socket {
+	return p.socket
+}
+
+func (p *customTransport) ready(writer http.ResponseWriter, request *http.Request) error {
+	return p.write(p.eng.handshake(p.socket, p.ttype))
+}
+
+func (p *customTransport) doReq(writer http.ResponseWriter, request *http.Request) {
+	p.req = request
+	p.receiving.Do(func() {
+		go p.receive(p.socket)
+	})
+	if err := p.conn.HandleRequest(writer, request); err != nil {
+		p.eng.logTransport(p, slog.LevelError, "request_failed", "handle request failed", LogKeyError, err)
+	}
+}
+
+func (p *customTransport) receive(socket *socketImpl) {
+	var err error
+	defer func() { socket.transportClosed(p, err) }()
+	for {
+		var pack *parser.Packet
+		pack, err = p.conn.Receive()
+		if err != nil {
+			if err != io.EOF {
+				p.eng.logTransport(p, slog.LevelError, "read_failed", "receive packet failed", LogKeyError, err)
+			}
+			return
+		}
+		p.eng.received(p, pack)
+		if err = socket.accept(pack); err != nil {
+			p.eng.logTransport(p, slog.LevelWarn, "accept_failed", "accept packet failed", LogKeyError, err)
+			return
+		}
+	}
+}
+
+func (p *customTransport) upgradeStart(dest Transport) error {
+	return fmt.Errorf("transport: cannot upgrade %s transport", p.ttype)
+}
+
+func (p *customTransport) upgradeEnd(dest Transport) error {
+	return fmt.Errorf("transport: cannot upgrade %s transport", p.ttype)
+}
+
+func (p *customTransport) send(packet *parser.Packet) error {
+	return p.conn.Send(packet)
+}
+
+func (p *customTransport) flush() error {
+	return nil
+}
+
+func (p *customTransport) close() error {
+	if !atomic.CompareAndSwapInt32(&(p.closed), 0, 1) {
+		return nil
+	}
+	return p.conn.Close()
+}
+
+func newCustomTransport(eng *engineImpl, ttype TransportType, conn CustomTransport) Transport {
+	trans := &customTransport{
+		tinyTransport: tinyTransport{
+			eng:    eng,
+			locker: new(sync.RWMutex),
+		},
+		ttype: ttype,
+		conn:  conn,
+	}
+	trans.handlerSend = eng.counting(trans, trans.send)
+	return trans
+}
@@ -0,0 +1,151 @@
+package eio
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jjeffcaii/engine.io/parser"
+)
+
+// fakeSent receives the packets sent by every fake transport.
+var fakeSent = make(chan *parser.Packet, 16)
+
+var fakeTransportType = RegisterTransport(func() CustomTransport {
+	return &fakeTransport{
+		inbox:  make(chan *parser.Packet, 16),
+		closed: make(chan struct{}),
+	}
+})
+
+// fakeTransport decodes POST bodies as payloads and sends packets to fakeSent.
+type fakeTransport struct {
+	inbox  chan *parser.Packet
+	closed chan struct{}
+	once   sync.Once
+}
+
+func (p *fakeTransport) Name() string {
+	return "fake"
+}
+
+func (p *fakeTransport) Upgradeable() bool {
+	return true
+}
+
+func (p *fakeTransport) HandleRequest(writer http.ResponseWriter, request *http.Request) error {
+	if request.Method == http.MethodPost {
+		body, err := ioutil.ReadAll(request.Body)
+		if err != nil {
+			return err
+		}
+		packets, err := parser.DecodePayload(body)
+		if err != nil {
+			return err
+		}
+		for _, it := range packets {
+			p.inbox <- it
+		}
+	}
+	_, err := writer.Write([]byte("ok"))
+	return err
+}
+
+func (p *fakeTransport) Send(packet *parser.Packet) error {
+	fakeSent <- packet
+	return nil
+}
+
+func (p *fakeTransport) Receive() (*parser.Packet, error) {
+	select {
+	case pack := <-p.inbox:
+		return pack, nil
+	case <-p.closed:
+		return nil, io.EOF
+	}
+}
+
+func (p *fakeTransport) Close() error {
+	p.once.Do(func() { close(p.closed) })
+	return nil
+}
+
+func receiveSent(t *testing.T) *parser.Packet {
+	select {
+	case pack := <-fakeSent:
+		return pack
+	case <-time.After(5 * time.Second):
+		t.Fatal("no packet is sent")
+		return nil
+	}
+}
+
+func TestCustomTransport(t *testing.T) {
+	if fakeTransportType.String() != "fake" || POLLING.String() != "polling" || WEBSOCKET.String() != "websocket" {
+		t.Errorf("bad transport names: %s %s %s", fakeTransportType, POLLING, WEBSOCKET)
+	}
+	eng := NewEngineBuilder().SetTransports(POLLING, WEBSOCKET, fakeTransportType).Build()
+	defer eng.Close()
+	messages := make(chan string, 1)
+	sockets := make(chan Socket, 1)
+	eng.OnConnect(func(socket Socket) {
+		socket.OnMessage(func(data []byte) {
+			messages <- string(data)
+		})
+		sockets <- socket
+	})
+	srv := httptest.NewServer(http.HandlerFunc(eng.Router()))
+	defer srv.Close()
+
+	// the fake transport is offered as an upgrade of polling.
+	_, body := poll(t, http.MethodGet, srv.URL+"/engine.io/?EIO=3&transport=polling", "", "")
+	packets, err := parser.DecodePayloadString(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var msg messageOK
+	if err := json.Unmarshal(packets[0].Data, &msg); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(msg.Upgrades, []string{"websocket", "fake"}) {
+		t.Errorf("bad upgrades: %v", msg.Upgrades)
+	}
+	(<-sockets).Close()
+
+	url := srv.URL + "/engine.io/?EIO=3&transport=fake"
+	poll(t, http.MethodGet, url, "", "")
+	if pack := receiveSent(t); pack.Type != parser.OPEN {
+		t.Fatalf("should send OPEN first, got %d", pack.Type)
+	} else if err := json.Unmarshal(pack.Data, &msg); err != nil || len(msg.Upgrades) != 0 {
+		t.Errorf("bad handshake: %q", pack.Data)
+	}
+	socket := <-sockets
+	if socket.Transport().GetType() != fakeTransportType {
+		t.Errorf("bad transport type: %s", socket.Transport().GetType())
+	}
+
+	poll(t, http.MethodPost, url+"&sid="+socket.ID(), "", "3:4hi")
+	if msg := <-messages; msg != "hi" {
+		t.Errorf("bad message: %q", msg)
+	}
+	socket.Send("yo")
+	if pack := receiveSent(t); pack.Type != parser.MESSAGE || string(pack.Data) != "yo" {
+		t.Errorf("bad packet sent: %d %q", pack.Type, pack.Data)
+	}
+	socket.Close()
+}
+
+func TestRegisterTransportTwice(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("should panic")
+		}
+	}()
+	RegisterTransport(func() CustomTransport { return new(fakeTransport) })
+}
@@ -0,0 +1,148 @@
+package eio
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+
+	"github.com/jjeffcaii/engine.io/parser"
+)
+
+// loopbackAddr is the remote address of loopback sessions.
+const loopbackAddr = "loopback"
+
+var (
+	errUpgradeLoopbackTransport = errors.New("transport: cannot upgrade loopback transport")
+	errLoopbackClosed           = errors.New("transport: loopback closed")
+)
+
+// loopbackTransport runs a session in process, see Engine.Loopback.
+type loopbackTransport struct {
+	tinyTransport
+	// inbox carries packets from client, outbox carries packets to client.
+	inbox     chan *parser.Packet
+	outbox    *sendQueue
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func (p *loopbackTransport) GetRequest() *http.Request {
+	return nil
+}
+
+func (p *loopbackTransport) GetType() TransportType {
+	return LOOPBACK
+}
+
+func (p *loopbackTransport) GetEngine() Engine {
+	return p.eng
+}
+
+func (p *loopbackTransport) GetSocket() Socket {
+	return p.socket
+}
+
+func (p *loopbackTransport) ready(writer http.ResponseWriter, request *http.Request) error {
+	return p.write(p.eng.handshake(p.socket, LOOPBACK))
+}
+
+// doReq is never called, a loopback session isn't served by http.
+func (p *loopbackTransport) doReq(writer http.ResponseWriter, request *http.Request) {
+}
+
+// serve accepts packets of client until the transport is closed.
+func (p *loopbackTransport) serve() {
+	socket := p.socket
+	var err error
+	defer func() { socket.transportClosed(p, err) }()
+	for {
+		select {
+		case <-p.done:
+			return
+		case pack := <-p.inbox:
+			p.eng.received(p, pack)
+			if err = socket.accept(pack); err != nil {
+				p.eng.logTransport(p, slog.LevelWarn, "accept_failed", "accept packet failed", LogKeyError, err)
+				return
+			}
+		}
+	}
+}
+
+func (p *loopbackTransport) upgradeStart(dest Transport) error {
+	return errUpgradeLoopbackTransport
+}
+
+func (p *loopbackTransport) upgradeEnd(dest Transport) error {
+	return errUpgradeLoopbackTransport
+}
+
+// send blocks while outbox is full, until client receives, the transport is closed or the write times out,
+// unless the send queue drops packets.
+func (p *loopbackTransport) send(packet *parser.Packet) error {
+	if err := p.enqueue(p.outbox, packet); err != errSendQueueClosed {
+		return err
+	}
+	return errLoopbackClosed
+}
+
+func (p *loopbackTransport) flush() error {
+	return nil
+}
+
+func (p *loopbackTransport) close() error {
+	p.closeOnce.Do(func() {
+		close(p.done)
+		p.outbox.close()
+	})
+	return nil
+}
+
+func newLoopbackTransport(eng *engineImpl) *loopbackTransport {
+	trans := &loopbackTransport{
+		tinyTransport: tinyTransport{
+			eng:    eng,
+			locker: new(sync.RWMutex),
+		},
+		inbox:  make(chan *parser.Packet),
+		outbox: newSendQueue(eng.options),
+		done:   make(chan struct{}),
+	}
+	trans.handlerSend = eng.counting(trans, trans.send)
+	return trans
+}
+
+// loopbackClient is the client side of a loopback transport.
+type loopbackClient struct {
+	tp *loopbackTransport
+}
+
+func (p *loopbackClient) Send(packet *parser.Packet) error {
+	select {
+	case <-p.tp.done:
+		return io.ErrClosedPipe
+	default:
+	}
+	select {
+	case p.tp.inbox <- packet:
+		return nil
+	case <-p.tp.done:
+		return io.ErrClosedPipe
+	}
+}
+
+// Receive returns packets sent before the transport is closed, then io.EOF.
+func (p *loopbackClient) Receive() (*parser.Packet, error) {
+	pack, ok := <-p.tp.outbox.ch
+	if !ok {
+		return nil, io.EOF
+	}
+	p.tp.outbox.took(pack)
+	return pack, nil
+}
+
+func (p *loopbackClient) Close() error {
+	return p.tp.close()
+}
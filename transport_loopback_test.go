@@ -0,0 +1,109 @@
+package eio
+
+import (
+	"encoding/json"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/jjeffcaii/engine.io/parser"
+)
+
+func TestLoopbackTransport(t *testing.T) {
+	eng := NewEngineBuilder().Build()
+	defer eng.Close()
+	messages := make(chan string, 1)
+	closed := make(chan struct{})
+	eng.OnConnect(func(socket Socket) {
+		if socket.Transport().GetType() != LOOPBACK {
+			t.Error("should be loopback")
+		}
+		socket.OnMessage(func(data []byte) {
+			messages <- string(data)
+			socket.Send(data)
+		})
+		socket.OnClose(func(reason string) { close(closed) })
+	})
+	client, err := eng.Loopback()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	open, err := client.Receive()
+	if err != nil || open.Type != parser.OPEN {
+		t.Fatalf("bad handshake: %v %v", open, err)
+	}
+	var msg messageOK
+	if err := json.Unmarshal(open.Data, &msg); err != nil || len(msg.Upgrades) != 0 || len(msg.Sid) < 1 {
+		t.Errorf("bad handshake: %q", open.Data)
+	}
+
+	client.Send(parser.NewPacketCustom(parser.PING, []byte("probe"), 0))
+	if pong, err := client.Receive(); err != nil || pong.Type != parser.PONG || string(pong.Data) != "probe" {
+		t.Errorf("should be PONG: %v %v", pong, err)
+	}
+	client.Send(parser.NewPacket(parser.MESSAGE, "hello"))
+	if got := <-messages; got != "hello" {
+		t.Errorf("bad message: %q", got)
+	}
+	if echo, err := client.Receive(); err != nil || string(echo.Data) != "hello" {
+		t.Errorf("bad echo: %v %v", echo, err)
+	}
+
+	client.Close()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("socket should be closed")
+	}
+	if _, err := client.Receive(); err != io.EOF {
+		t.Error("should be EOF:", err)
+	}
+	if err := client.Send(parser.NewPacket(parser.MESSAGE, "bye")); err != io.ErrClosedPipe {
+		t.Error("should be closed:", err)
+	}
+}
+
+func TestLoopbackNotRequestable(t *testing.T) {
+	eng := NewEngineBuilder().Build().(*engineImpl)
+	defer eng.Close()
+	for _, it := range []string{"stream", "loopback"} {
+		if _, err := eng.checkTransport(it); err == nil {
+			t.Errorf("%s shouldn't be requested by http", it)
+		}
+	}
+}
+
+func TestWriteStalled(t *testing.T) {
+	eng := NewEngineBuilder().SetWriteTimeout(50 * time.Millisecond).Build()
+	defer eng.Close()
+	sockets := make(chan Socket, 1)
+	reasons := make(chan string, 1)
+	eng.OnConnect(func(socket Socket) {
+		socket.OnClose(func(reason string) { reasons <- reason })
+		sockets <- socket
+	})
+	client, err := eng.Loopback()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	socket := <-sockets
+	// the client never receives, so outbox is full at last.
+	for i := 0; i <= outboxThreshold; i++ {
+		if err = socket.Send("hello"); err != nil {
+			break
+		}
+	}
+	if err != ErrWriteStalled {
+		t.Error("should be stalled:", err)
+	}
+	select {
+	case reason := <-reasons:
+		if reason != ErrWriteStalled.Error() {
+			t.Errorf("bad close reason: %q", reason)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("socket should be closed")
+	}
+}
@@ -0,0 +1,109 @@
+package eio
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"github.com/jjeffcaii/engine.io/parser"
+)
+
+var errUpgradeStreamTransport = errors.New("transport: cannot upgrade stream transport")
+
+// streamTransport runs a session on a stream connection, see Engine.Serve.
+type streamTransport struct {
+	tinyTransport
+	conn   net.Conn
+	stream *parser.StreamConn
+	// closed is set to 1 once the connection is closed by server.
+	closed int32
+}
+
+func (p *streamTransport) GetRequest() *http.Request {
+	return nil
+}
+
+func (p *streamTransport) GetType() TransportType {
+	return STREAM
+}
+
+func (p *streamTransport) GetEngine() Engine {
+	return p.eng
+}
+
+func (p *streamTransport) GetSocket() Socket {
+	return p.socket
+}
+
+func (p *streamTransport) ready(writer http.ResponseWriter, request *http.Request) error {
+	return p.write(p.eng.handshake(p.socket, STREAM))
+}
+
+// doReq is never called, a stream session isn't served by http.
+func (p *streamTransport) doReq(writer http.ResponseWriter, request *http.Request) {
+}
+
+// serve reads packets of connection until it's closed.
+func (p *streamTransport) serve() {
+	socket := p.socket
+	var err error
+	defer func() { socket.transportClosed(p, err) }()
+	for {
+		var pack *parser.Packet
+		pack, err = p.stream.ReadPacket()
+		if err != nil {
+			if err != io.EOF && atomic.LoadInt32(&(p.closed)) == 0 {
+				p.eng.logTransport(p, slog.LevelError, "read_failed", "read stream packet failed", LogKeyError, err)
+			}
+			return
+		}
+		p.eng.received(p, pack)
+		if err = socket.accept(pack); err != nil {
+			p.eng.logTransport(p, slog.LevelWarn, "accept_failed", "accept packet failed", LogKeyError, err)
+			return
+		}
+	}
+}
+
+func (p *streamTransport) upgradeStart(dest Transport) error {
+	return errUpgradeStreamTransport
+}
+
+func (p *streamTransport) upgradeEnd(dest Transport) error {
+	return errUpgradeStreamTransport
+}
+
+func (p *streamTransport) send(packet *parser.Packet) error {
+	p.conn.SetWriteDeadline(p.writeDeadline())
+	defer p.eng.watchWrite(p).done()
+	return p.stalled(p.stream.WritePacket(packet))
+}
+
+func (p *streamTransport) flush() error {
+	return nil
+}
+
+func (p *streamTransport) close() error {
+	if !atomic.CompareAndSwapInt32(&(p.closed), 0, 1) {
+		return nil
+	}
+	return p.conn.Close()
+}
+
+func newStreamTransport(eng *engineImpl, conn net.Conn) *streamTransport {
+	trans := &streamTransport{
+		tinyTransport: tinyTransport{
+			eng:    eng,
+			locker: new(sync.RWMutex),
+		},
+		conn: conn,
+		// a frame is read as a whole, so the length announced by the peer is bounded by the max payload.
+		stream: parser.NewStreamConn(conn, parser.CodecOptions{MaxPacketSize: int(eng.options.maxPayload)}),
+	}
+	trans.handlerSend = eng.counting(trans, trans.send)
+	return trans
+}
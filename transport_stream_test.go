@@ -0,0 +1,93 @@
+package eio
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jjeffcaii/engine.io/parser"
+)
+
+func TestStreamTransport(t *testing.T) {
+	eng := NewEngineBuilder().Build()
+	defer eng.Close()
+	messages := make(chan string, 1)
+	closed := make(chan struct{})
+	eng.OnConnect(func(socket Socket) {
+		socket.OnMessage(func(data []byte) {
+			messages <- string(data)
+			socket.Send(data)
+		})
+		socket.OnClose(func(reason string) { close(closed) })
+	})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go eng.Serve(listener)
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	client := parser.NewStreamConn(conn, parser.CodecOptions{})
+
+	open, err := client.ReadPacket()
+	if err != nil || open.Type != parser.OPEN {
+		t.Fatalf("bad handshake: %v %v", open, err)
+	}
+	var msg messageOK
+	if err := json.Unmarshal(open.Data, &msg); err != nil || len(msg.Upgrades) != 0 || len(msg.Sid) < 1 {
+		t.Errorf("bad handshake: %q", open.Data)
+	}
+
+	client.WritePacket(parser.NewPacketCustom(parser.PING, nil, 0))
+	if pong, err := client.ReadPacket(); err != nil || pong.Type != parser.PONG {
+		t.Errorf("should be PONG: %v %v", pong, err)
+	}
+	client.WritePacket(parser.NewPacket(parser.MESSAGE, "hello"))
+	if got := <-messages; got != "hello" {
+		t.Errorf("bad message: %q", got)
+	}
+	if echo, err := client.ReadPacket(); err != nil || string(echo.Data) != "hello" || echo.Option&parser.BINARY != parser.BINARY {
+		t.Errorf("bad echo: %v %v", echo, err)
+	}
+
+	conn.Close()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("socket should be closed")
+	}
+}
+
+func TestStreamTransportMaxPayload(t *testing.T) {
+	eng := NewEngineBuilder().SetMaxPayload(100).Build()
+	defer eng.Close()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go eng.Serve(listener)
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if open, err := parser.NewStreamConn(conn, parser.CodecOptions{}).ReadPacket(); err != nil || open.Type != parser.OPEN {
+		t.Fatalf("bad handshake: %v %v", open, err)
+	}
+	// a frame of about 4 GB is announced.
+	conn.Write([]byte{0xff, 0xff, 0xff, 0xf0, '4'})
+	if _, err := io.ReadAll(conn); err != nil {
+		t.Errorf("connection should be closed: %v", err)
+	}
+}
@@ -2,33 +2,97 @@ package eio
 
 import (
 	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/jjeffcaii/engine.io/parser"
 )
 
+// wsCloseTimeout is how long a close frame can take to be sent before the connection is dropped.
+const wsCloseTimeout = time.Second
+
 var (
-	libWebsocket          *websocket.Upgrader
 	errUpgradeWsTransport error
+	errUnencryptedMessage error
+	// websocket messages are read into fresh buffers, so packets can reference them directly.
+	// they are counted into the metrics of protocol codecs, which encode the frames sent.
+	wsStringCodec = parser.NewMeteredCodec(parser.NewStringCodec(parser.CodecOptions{ZeroCopy: true}), protocolVersion.Metrics(false))
+	wsBinaryCodec = parser.NewMeteredCodec(parser.NewBinaryCodec(parser.CodecOptions{ZeroCopy: true}), protocolVersion.Metrics(true))
 )
 
 func init() {
-	libWebsocket = &websocket.Upgrader{
+	errUpgradeWsTransport = errors.New("transport: cannot upgrade websocket transport")
+	errUnencryptedMessage = errors.New("transport: unencrypted message in encrypted session")
+}
+
+// newWebsocketUpgrader returns the upgrader of an engine, permessage-deflate is negotiated if compression is enabled.
+// Only the no context takeover mode is supported by the websocket library.
+func newWebsocketUpgrader(options *engineOptions) *websocket.Upgrader {
+	return &websocket.Upgrader{
 		CheckOrigin:       func(r *http.Request) bool { return true },
-		ReadBufferSize:    1024,
-		WriteBufferSize:   1024,
-		EnableCompression: true,
+		ReadBufferSize:    options.wsReadBufferSize,
+		WriteBufferSize:   options.wsWriteBufferSize,
+		EnableCompression: options.compression,
+		Subprotocols:      options.wsSubprotocols,
 	}
-	errUpgradeWsTransport = errors.New("transport: cannot upgrade websocket transport")
+}
+
+// negotiatedSubprotocol returns the first of protocols requested by the client, as the websocket library chooses it.
+func negotiatedSubprotocol(protocols []string, request *http.Request) string {
+	requested := websocket.Subprotocols(request)
+	for _, it := range protocols {
+		for _, want := range requested {
+			if it == want {
+				return it
+			}
+		}
+	}
+	return ""
+}
+
+// negotiatedExtensions returns the names of extensions accepted for request, permessage-deflate is the only one
+// supported by the websocket library.
+func negotiatedExtensions(compression bool, request *http.Request) []string {
+	if !compression {
+		return nil
+	}
+	for _, header := range request.Header["Sec-Websocket-Extensions"] {
+		for _, it := range strings.Split(header, ",") {
+			if i := strings.IndexByte(it, ';'); i >= 0 {
+				it = it[:i]
+			}
+			if strings.TrimSpace(it) == "permessage-deflate" {
+				return []string{"permessage-deflate"}
+			}
+		}
+	}
+	return nil
 }
 
 type wsTransport struct {
 	tinyTransport
 	req     *http.Request
 	connect *websocket.Conn
-	outbox  *queue
+	outbox  *sendQueue
+	// codec is selected by the 'codec' query of handshake, it encodes every packet into binary frames.
+	codec parser.Codec
+	// encoder and binDecoder are resolved from codec, the session key and the 'checksum' query of handshake.
+	encoder, binDecoder parser.Codec
+	// encrypted is true if MESSAGE packets are encrypted, they are sent in binary frames then.
+	encrypted bool
+	// flushing serializes flushes, so packets are sent in the order they are written.
+	flushing sync.Mutex
+	// closed is set to 1 once the close frame is sent.
+	closed int32
+	// subprotocol and extensions are negotiated by the upgrade.
+	subprotocol string
+	extensions  []string
 }
 
 func (p *wsTransport) GetRequest() *http.Request {
@@ -47,46 +111,137 @@ func (p *wsTransport) GetSocket() Socket {
 	return p.socket
 }
 
+func (p *wsTransport) Subprotocol() string {
+	return p.subprotocol
+}
+
+func (p *wsTransport) Extensions() []string {
+	return p.extensions
+}
+
+// wsCodec returns the codec selected by the 'codec' query of request, it's nil if the query is empty. Only the codecs
+// of EngineBuilder.SetWebsocketCodecs can be selected.
+func (p *engineImpl) wsCodec(request *http.Request) (parser.Codec, error) {
+	name := request.URL.Query().Get("codec")
+	if len(name) < 1 {
+		return nil, nil
+	}
+	for _, it := range p.options.wsCodecs {
+		if it == name {
+			if codec, ok := parser.LookupCodec(name); ok {
+				return codec, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("transport: unsupported codec '%s'", name)
+}
+
 func (p *wsTransport) ensureWebsocket(writer http.ResponseWriter, request *http.Request) error {
 	if p.connect != nil {
 		return nil
 	}
-	// upgrade to websocket.
-	conn, err := libWebsocket.Upgrade(writer, request, nil)
+	codec, err := p.eng.wsCodec(request)
 	if err != nil {
-		if p.eng.logErr != nil {
-			p.eng.logErr("websocket upgrade failed: %s\n", err)
+		return err
+	}
+	p.codec = codec
+	// binary frames of v4 carry the data of a MESSAGE only.
+	p.encoder, p.binDecoder = protocolVersion.PacketCodec(true), wsBinaryCodec
+	if protocol := p.protocol(); protocol != protocolVersion {
+		p.encoder, p.binDecoder = protocol.PacketCodec(true), protocol.PacketCodec(true)
+	}
+	if p.codec != nil {
+		p.encoder, p.binDecoder = p.codec, p.codec
+	}
+	if p.eng.sessionKey != nil {
+		key, err := p.eng.sessionKey(request)
+		if err != nil {
+			return err
+		}
+		if key != nil {
+			if p.encoder, err = parser.NewAESGCMCodec(p.encoder, key); err != nil {
+				return err
+			}
+			if p.binDecoder, err = parser.NewAESGCMCodec(p.binDecoder, key); err != nil {
+				return err
+			}
+			p.encrypted = true
 		}
+	}
+	if name := request.URL.Query().Get("checksum"); len(name) > 0 {
+		algorithm, err := parser.ParseChecksum(name)
+		if err != nil {
+			return err
+		}
+		p.encoder = parser.NewChecksumCodec(p.encoder, algorithm)
+		p.binDecoder = parser.NewChecksumCodec(p.binDecoder, algorithm)
+	}
+	// upgrade to websocket, the upgrader writes its own response so cookies set before are passed on.
+	var header http.Header
+	if cookies, ok := writer.Header()["Set-Cookie"]; ok {
+		header = http.Header{"Set-Cookie": cookies}
+	}
+	conn, err := p.eng.upgrader.Upgrade(writer, request, header)
+	if err != nil {
+		p.eng.logTransport(p, slog.LevelError, "upgrade_failed", "websocket upgrade failed", LogKeyError, err)
 		return err
 	}
+	// it's a noop if the client doesn't negotiate permessage-deflate.
+	conn.SetCompressionLevel(p.eng.options.compressionLevel)
+	// the websocket library answers a larger message with a close frame of 1009 and fails the read.
+	if p.eng.options.wsReadLimit > 0 {
+		conn.SetReadLimit(p.eng.options.wsReadLimit)
+	}
 	p.connect = conn
 	p.req = request
-	p.onWrite(func() { p.flush() }, false)
+	p.subprotocol = conn.Subprotocol()
+	p.extensions = negotiatedExtensions(p.eng.options.compression, request)
+	if interval := p.eng.options.wsKeepalive; interval > 0 {
+		go p.keepalive(interval)
+	}
+	p.onWrite(func() {
+		if err := p.flush(); err != nil {
+			p.eng.logTransport(p, slog.LevelWarn, "write_failed", "write packets failed", LogKeyError, err)
+		}
+	}, false)
 	return nil
 }
 
+// keepalive sends ping frames every interval until the connection is closed.
+func (p *wsTransport) keepalive(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if atomic.LoadInt32(&(p.closed)) == 1 {
+			return
+		}
+		// control frames can be written along with messages being flushed.
+		if err := p.connect.WriteControl(websocket.PingMessage, nil, time.Now().Add(interval)); err != nil {
+			p.eng.logTransport(p, slog.LevelDebug, "keepalive_failed", "send ping frame failed", LogKeyError, err)
+			return
+		}
+	}
+}
+
 func (p *wsTransport) ready(writer http.ResponseWriter, request *http.Request) error {
 	if err := p.ensureWebsocket(writer, request); err != nil {
 		return err
 	}
-	msgOpen := parser.NewPacketByJSON(parser.OPEN, &messageOK{
-		Sid:          p.socket.id,
-		Upgrades:     emptyStringArray,
-		PingInterval: int64(1000 * p.eng.options.pingInterval.Seconds()),
-		PingTimeout:  int64(1000 * p.eng.options.pingTimeout.Seconds()),
-	})
-	return p.write(msgOpen)
+	return p.write(p.eng.handshake(p.socket, WEBSOCKET))
 }
 
-func (p *wsTransport) doAccept(msg []byte, opt parser.PacketOption) {
-	pack, err := parser.Decode(msg, opt)
+func (p *wsTransport) doAccept(msg []byte, codec parser.Codec) {
+	pack, err := codec.Decode(msg)
 	if err != nil {
-		if p.eng.logErr != nil {
-			p.eng.logErr("decode packet failed: %s\n", err)
-		}
+		p.eng.logTransport(p, slog.LevelWarn, "decode_failed", "decode packet failed", LogKeyError, err)
 		panic(err)
 	}
+	// encrypted messages are binary, so a message of text frame would bypass the encryption.
+	if p.encrypted && codec == wsStringCodec && pack.Type == parser.MESSAGE {
+		panic(errUnencryptedMessage)
+	}
 
+	p.eng.received(p, pack)
 	err = p.socket.accept(pack)
 	if err != nil {
 		panic(err)
@@ -96,17 +251,26 @@ func (p *wsTransport) doAccept(msg []byte, opt parser.PacketOption) {
 func (p *wsTransport) doReq(writer http.ResponseWriter, request *http.Request) {
 	defer func() {
 		p.req = nil
-		p.GetSocket().Close()
 		e := recover()
+		err, ok := e.(error)
+		if !ok && e != nil {
+			err = fmt.Errorf("%v", e)
+		}
+		if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway, websocket.CloseNoStatusReceived) {
+			err = nil
+		}
+		p.socket.transportClosed(p, err)
 		if e == nil {
 			return
 		}
 		if _, ok := e.(*websocket.CloseError); ok {
 			return
 		}
-		if p.eng.logErr != nil {
-			p.eng.logErr("do request failed: %s\n", e)
+		// reading fails on purpose after the connection is closed by server.
+		if atomic.LoadInt32(&(p.closed)) == 1 {
+			return
 		}
+		p.eng.logTransport(p, slog.LevelError, "request_failed", "do request failed", LogKeyError, e)
 	}()
 
 	if err := p.ensureWebsocket(writer, request); err != nil {
@@ -123,10 +287,10 @@ func (p *wsTransport) doReq(writer http.ResponseWriter, request *http.Request) {
 		default:
 			break
 		case websocket.TextMessage:
-			p.doAccept(message, 0)
+			p.doAccept(message, wsStringCodec)
 			break
 		case websocket.BinaryMessage:
-			p.doAccept(message, parser.BINARY)
+			p.doAccept(message, p.binDecoder)
 			break
 		}
 	}
@@ -139,8 +303,10 @@ func (p *wsTransport) upgradeEnd(dest Transport) error {
 	return errUpgradeWsTransport
 }
 
-func (p *wsTransport) write(packet *parser.Packet) error {
-	p.outbox.append(packet)
+func (p *wsTransport) send(packet *parser.Packet) error {
+	if err := p.enqueue(p.outbox, packet); err != nil {
+		return err
+	}
 	if p.handlerWrite != nil {
 		p.handlerWrite()
 	}
@@ -148,30 +314,32 @@ func (p *wsTransport) write(packet *parser.Packet) error {
 }
 
 func (p *wsTransport) flush() error {
+	p.flushing.Lock()
+	defer p.flushing.Unlock()
 	for {
-		item, ok := p.outbox.pop()
+		out, ok := p.outbox.pop()
 		if !ok {
 			break
 		}
-		out := item.(*parser.Packet)
-		var msgType int
-		if out.Option&parser.BINARY == parser.BINARY {
+		msgType := websocket.TextMessage
+		if p.codec != nil || out.Option&parser.BINARY == parser.BINARY || p.encrypted && out.Type == parser.MESSAGE {
 			msgType = websocket.BinaryMessage
-		} else {
-			msgType = websocket.TextMessage
 		}
-		bs, err := parser.Encode(out)
-		if err != nil {
-			return err
+		codec := p.encoder
+		if codec == nil {
+			codec = p.protocol().PacketCodec(true)
 		}
-		p.locker.Lock()
-		err = p.connect.WriteMessage(msgType, bs)
-		p.locker.Unlock()
-		if err != nil {
-			return err
+		p.connect.SetWriteDeadline(p.writeDeadline())
+		watch := p.eng.watchWrite(p)
+		var err error
+		if out.Body != nil {
+			err = p.writeStream(msgType, codec, out)
+		} else {
+			err = p.writeMessage(msgType, codec, out)
 		}
-		if out.Type == parser.PONG && out.Data != nil && len(out.Data) == 5 && string(out.Data[:5]) == "probe" {
-			p.socket.getTransportOld().upgradeStart(p)
+		watch.done()
+		if err != nil {
+			return p.stalled(err)
 		}
 	}
 	if p.handlerFlush != nil {
@@ -180,19 +348,63 @@ func (p *wsTransport) flush() error {
 	return nil
 }
 
+// writeMessage writes packet in one frame, a pre-encoded packet (see Engine.Broadcast) shares the frame of
+// the protocol codec with other sessions.
+func (p *wsTransport) writeMessage(msgType int, codec parser.Codec, out *parser.Packet) error {
+	var bs []byte
+	var err error
+	if pre := out.PreEncoded(); pre != nil && codec == p.protocol().PacketCodec(true) {
+		bs, err = pre.Encoded(codec)
+	} else {
+		bs, err = codec.Encode(out)
+	}
+	if err != nil {
+		return err
+	}
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	p.connect.EnableWriteCompression(out.Options.Compressible(len(bs), p.eng.options.compressionThreshold))
+	return p.connect.WriteMessage(msgType, bs)
+}
+
+// writeStream copies the body of packet into one frame, so it's never held in memory as a whole.
+func (p *wsTransport) writeStream(msgType int, codec parser.Codec, out *parser.Packet) error {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	p.connect.EnableWriteCompression(out.Options.Compressible(codec.EncodedLen(out), p.eng.options.compressionThreshold))
+	writer, err := p.connect.NextWriter(msgType)
+	if err != nil {
+		return err
+	}
+	if err := codec.WriteTo(writer, out); err != nil {
+		writer.Close()
+		return err
+	}
+	return writer.Close()
+}
+
+// close sends a normal close frame before closing the connection, so clients don't take it for a lost connection.
 func (p *wsTransport) close() error {
-	if p.connect == nil {
+	p.outbox.close()
+	if p.connect == nil || !atomic.CompareAndSwapInt32(&(p.closed), 0, 1) {
 		return nil
 	}
+	// it's best effort, the frame can't be sent if the peer is gone or has sent its close frame already.
+	p.locker.Lock()
+	msg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+	p.connect.WriteControl(websocket.CloseMessage, msg, time.Now().Add(wsCloseTimeout))
+	p.locker.Unlock()
 	return p.connect.Close()
 }
 
 func newWebsocketTransport(eng *engineImpl) Transport {
-	return &wsTransport{
+	trans := &wsTransport{
 		tinyTransport: tinyTransport{
 			eng:    eng,
 			locker: new(sync.RWMutex),
 		},
-		outbox: newQueue(),
+		outbox: newSendQueue(eng.options),
 	}
+	trans.handlerSend = eng.counting(trans, trans.send)
+	return trans
 }
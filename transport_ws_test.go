@@ -0,0 +1,250 @@
+package eio
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/jjeffcaii/engine.io/parser"
+)
+
+// dialWebsocket connects to engine.io of srv by websocket, query is appended to the url.
+func dialWebsocket(t *testing.T, srv *httptest.Server, query string) *websocket.Conn {
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/engine.io/?EIO=3&transport=websocket" + query
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	return conn
+}
+
+func readFrame(t *testing.T, conn *websocket.Conn) (int, string) {
+	msgType, msg, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msgType, string(msg)
+}
+
+func TestWebsocketTransport(t *testing.T) {
+	eng := NewEngineBuilder().Build()
+	defer eng.Close()
+	messages := make(chan string, 1)
+	sockets := make(chan Socket, 1)
+	eng.OnConnect(func(socket Socket) {
+		socket.OnMessage(func(data []byte) {
+			messages <- string(data)
+		})
+		sockets <- socket
+	})
+	srv := httptest.NewServer(http.HandlerFunc(eng.Router()))
+	defer srv.Close()
+	conn := dialWebsocket(t, srv, "")
+
+	if msgType, msg := readFrame(t, conn); msgType != websocket.TextMessage || !strings.HasPrefix(msg, `0{"sid":`) {
+		t.Fatalf("bad open frame: %d %q", msgType, msg)
+	}
+	socket := <-sockets
+
+	conn.WriteMessage(websocket.TextMessage, []byte("4hello"))
+	if msg := <-messages; msg != "hello" {
+		t.Errorf("bad message: %q", msg)
+	}
+	conn.WriteMessage(websocket.BinaryMessage, []byte{4, 1, 2})
+	if msg := <-messages; msg != "\x01\x02" {
+		t.Errorf("bad binary message: %q", msg)
+	}
+
+	conn.WriteMessage(websocket.TextMessage, []byte("2probe"))
+	if msgType, msg := readFrame(t, conn); msgType != websocket.TextMessage || msg != "3probe" {
+		t.Errorf("bad pong frame: %d %q", msgType, msg)
+	}
+
+	socket.Send("world")
+	if msgType, msg := readFrame(t, conn); msgType != websocket.TextMessage || msg != "4world" {
+		t.Errorf("bad text frame: %d %q", msgType, msg)
+	}
+	socket.Send([]byte{1, 2})
+	if msgType, msg := readFrame(t, conn); msgType != websocket.BinaryMessage || msg != "\x04\x01\x02" {
+		t.Errorf("bad binary frame: %d %q", msgType, msg)
+	}
+
+	socket.Close()
+	if _, _, err := conn.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+		t.Errorf("expect normal close, got %v", err)
+	}
+}
+
+func TestWebsocketCompression(t *testing.T) {
+	for _, enable := range []bool{true, false} {
+		eng := NewEngineBuilder().SetCompression(enable).SetCompressionLevel(9).SetCompressionThreshold(16).Build()
+		sockets := make(chan Socket, 1)
+		eng.OnConnect(func(socket Socket) { sockets <- socket })
+		srv := httptest.NewServer(http.HandlerFunc(eng.Router()))
+		dialer := websocket.Dialer{EnableCompression: true}
+		url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/engine.io/?EIO=3&transport=websocket"
+		conn, res, err := dialer.Dial(url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if negotiated := strings.Contains(res.Header.Get("Sec-Websocket-Extensions"), "permessage-deflate"); negotiated != enable {
+			t.Errorf("permessage-deflate should be negotiated: %v", enable)
+		}
+		readFrame(t, conn)
+		socket := <-sockets
+		large := strings.Repeat("hello", 100)
+		socket.Send(large)
+		if _, msg := readFrame(t, conn); msg != "4"+large {
+			t.Errorf("bad compressed message: %d bytes", len(msg))
+		}
+		socket.Close()
+		conn.Close()
+		srv.Close()
+		eng.Close()
+	}
+}
+
+func TestWebsocketReadLimit(t *testing.T) {
+	eng := NewEngineBuilder().SetMaxPayload(64).SetWebsocketReadLimit(64).SetWebsocketBufferSize(256, 16).Build()
+	defer eng.Close()
+	sockets := make(chan Socket, 1)
+	eng.OnConnect(func(socket Socket) { sockets <- socket })
+	srv := httptest.NewServer(http.HandlerFunc(eng.Router()))
+	defer srv.Close()
+	conn := dialWebsocket(t, srv, "")
+	readFrame(t, conn)
+	socket := <-sockets
+
+	// messages larger than the write buffer are fragmented.
+	large := strings.Repeat("hello", 20)
+	socket.Send(large)
+	if _, msg := readFrame(t, conn); msg != "4"+large {
+		t.Errorf("bad fragmented message: %q", msg)
+	}
+	conn.WriteMessage(websocket.TextMessage, []byte("4"+large))
+	_, _, err := conn.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseMessageTooBig) {
+		t.Error("should be closed by 1009:", err)
+	}
+}
+
+func TestWebsocketBufferSize(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("should panic")
+		}
+	}()
+	NewEngineBuilder().SetWebsocketBufferSize(0, 1024)
+}
+
+func TestWebsocketNegotiation(t *testing.T) {
+	negotiated := make(chan []string, 1)
+	eng := NewEngineBuilder().
+		SetWebsocketSubprotocols("v2.chat", "v1.chat").
+		SetWebsocketNegotiation(func(request *http.Request, subprotocol string, extensions []string) error {
+			if subprotocol == "" {
+				return errors.New("no subprotocol")
+			}
+			negotiated <- append([]string{subprotocol}, extensions...)
+			return nil
+		}).
+		Build()
+	defer eng.Close()
+	sockets := make(chan Socket, 1)
+	eng.OnConnect(func(socket Socket) { sockets <- socket })
+	srv := httptest.NewServer(http.HandlerFunc(eng.Router()))
+	defer srv.Close()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/engine.io/?EIO=3&transport=websocket"
+
+	dialer := websocket.Dialer{Subprotocols: []string{"v1.chat", "v2.chat"}, EnableCompression: true}
+	conn, _, err := dialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if got := conn.Subprotocol(); got != "v2.chat" {
+		t.Errorf("should choose v2.chat, got %q", got)
+	}
+	if got := <-negotiated; len(got) != 2 || got[0] != "v2.chat" || got[1] != "permessage-deflate" {
+		t.Errorf("bad negotiation: %v", got)
+	}
+	trans := (<-sockets).Transport().(WebsocketTransport)
+	if trans.Subprotocol() != "v2.chat" || len(trans.Extensions()) != 1 {
+		t.Errorf("bad transport: %q %v", trans.Subprotocol(), trans.Extensions())
+	}
+
+	dialer = websocket.Dialer{Subprotocols: []string{"v3.chat"}}
+	if _, res, err := dialer.Dial(url, nil); err == nil || res == nil || res.StatusCode != http.StatusForbidden {
+		t.Error("should be rejected:", err)
+	}
+}
+
+func TestWebsocketKeepalive(t *testing.T) {
+	eng := NewEngineBuilder().SetWebsocketKeepalive(20 * time.Millisecond).Build()
+	defer eng.Close()
+	srv := httptest.NewServer(http.HandlerFunc(eng.Router()))
+	defer srv.Close()
+	conn := dialWebsocket(t, srv, "")
+	pings := make(chan struct{}, 8)
+	conn.SetPingHandler(func(string) error {
+		select {
+		case pings <- struct{}{}:
+		default:
+		}
+		return nil
+	})
+	// ping frames are handled while reading.
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	for i := 0; i < 2; i++ {
+		select {
+		case <-pings:
+		case <-time.After(5 * time.Second):
+			t.Fatal("should be pinged")
+		}
+	}
+}
+
+func TestWebsocketCodecs(t *testing.T) {
+	eng := NewEngineBuilder().SetWebsocketCodecs(parser.CodecMsgpack).Build()
+	defer eng.Close()
+	srv := httptest.NewServer(http.HandlerFunc(eng.Router()))
+	defer srv.Close()
+	msgType, msg := readFrame(t, dialWebsocket(t, srv, "&codec="+parser.CodecMsgpack))
+	codec, _ := parser.LookupCodec(parser.CodecMsgpack)
+	if pack, err := codec.Decode([]byte(msg)); msgType != websocket.BinaryMessage || err != nil || pack.Type != parser.OPEN {
+		t.Errorf("handshake should be encoded by msgpack: %d %q %v", msgType, msg, err)
+	}
+
+	plain := NewEngineBuilder().Build()
+	defer plain.Close()
+	other := httptest.NewServer(http.HandlerFunc(plain.Router()))
+	defer other.Close()
+	for _, it := range []struct {
+		srv   *httptest.Server
+		codec string
+	}{{srv, parser.CodecCBOR}, {srv, "nope"}, {other, parser.CodecMsgpack}} {
+		url := "ws" + strings.TrimPrefix(it.srv.URL, "http") + "/engine.io/?EIO=3&transport=websocket&codec=" + it.codec
+		if _, res, err := websocket.DefaultDialer.Dial(url, nil); err == nil || res == nil || res.StatusCode != http.StatusBadRequest {
+			t.Errorf("codec %s should be rejected: %v", it.codec, err)
+		}
+	}
+	defer func() {
+		if e := recover(); e == nil {
+			t.Error("unregistered codec should panic")
+		}
+	}()
+	NewEngineBuilder().SetWebsocketCodecs("nope")
+}
@@ -1,9 +1,12 @@
 package eio
 
 import (
+	"bytes"
 	"errors"
-	"fmt"
+	"io"
 	"io/ioutil"
+	"log/slog"
+	"mime"
 	"net/http"
 	"sync"
 	"time"
@@ -12,20 +15,26 @@ import (
 )
 
 const (
-	noopDelay       = 1 * time.Second
-	outboxThreshold = 128 // The smaller this value, the more GET will be requested.
+	// outboxThreshold is the default size of send queues. The smaller this value, the more GET will be requested.
+	outboxThreshold = 128
+	// contentTypeJSONP is the content type of JSONP polling responses.
+	contentTypeJSONP = "text/javascript; charset=UTF-8"
 )
 
 var (
-	errHTTPMethod      = errors.New("transport: illegal http method")
-	errPollingEOF      = errors.New("transport: polling EOF")
+	errHTTPMethod = errors.New("transport: illegal http method")
+	errPollingEOF = errors.New("transport: polling EOF")
+	// errPollingClosed means the CLOSE packet is written along with the packets left already.
+	errPollingClosed   = errors.New("transport: polling closed")
+	errBinaryPayloadV4 = errors.New("transport: binary payload is not allowed by v4")
 	defaultPacketClose = parser.NewPacketCustom(parser.CLOSE, nil, 0)
-	jsonpEnd           = []byte("\");")
+	// streamPadding is the first chunk of streaming polls, clients ignore NOOP packets.
+	streamPadding = parser.NewPacketCustom(parser.NOOP, bytes.Repeat([]byte{' '}, 2048), 0)
 )
 
 type xhrTransport struct {
 	tinyTransport
-	outbox chan *parser.Packet
+	outbox *sendQueue
 	req    *http.Request
 	res    http.ResponseWriter
 }
@@ -58,40 +67,11 @@ func (p *xhrTransport) ready(writer http.ResponseWriter, request *http.Request)
 	if request.Method != http.MethodGet {
 		return errHTTPMethod
 	}
-	okMsg := messageOK{
-		Sid:          p.socket.id,
-		Upgrades:     make([]string, 0),
-		PingInterval: int64(1000 * p.eng.options.pingInterval.Seconds()),
-		PingTimeout:  int64(1000 * p.eng.options.pingTimeout.Seconds()),
-	}
-	if p.eng.options.allowUpgrades {
-		for _, it := range p.eng.allowTransports {
-			if it == WEBSOCKET {
-				okMsg.Upgrades = append(okMsg.Upgrades, "websocket")
-				break
-			}
-		}
-	}
-	return p.write(parser.NewPacketByJSON(parser.OPEN, &okMsg))
+	return p.write(p.eng.handshake(p.socket, POLLING))
 }
 
 func (p *xhrTransport) doReq(writer http.ResponseWriter, request *http.Request) {
-	if p.eng.options.cookie {
-		var cookie string
-		if p.eng.options.cookieHTTPOnly {
-			cookie = fmt.Sprintf("io=%s; Path=%s; HttpOnly", p.socket.id, p.eng.options.cookiePath)
-		} else {
-			cookie = fmt.Sprintf("io=%s; Path=%s;", p.socket.id, p.eng.options.cookiePath)
-		}
-		writer.Header().Set("Set-Cookie", cookie)
-	}
-	origin := request.Header.Get("Origin")
-	if len(origin) > 0 {
-		writer.Header().Set("Access-Control-Allow-Credentials", "true")
-		writer.Header().Set("Access-Control-Allow-Origin", origin)
-	} else {
-		writer.Header().Set("Access-Control-Allow-Origin", "*")
-	}
+	p.eng.cors.setHeaders(writer, request)
 	switch request.Method {
 	default:
 		break
@@ -111,35 +91,31 @@ func (p *xhrTransport) doReqGet(writer http.ResponseWriter, request *http.Reques
 		p.req = nil
 		p.res = nil
 	}()
-	j, jsonp := p.tryJSONP()
-	if jsonp {
-		if _, err := p.res.Write([]byte(fmt.Sprintf("___eio[%s](\"", *j))); err != nil {
-			if p.eng.logErr != nil {
-				p.eng.logErr("write jsonp prefix failed: %s\n", err)
-			}
-			return
-		}
+	// the url of a poll can be requested again, so responses must never be served from a cache.
+	writer.Header().Set("Cache-Control", "no-store")
+	if p.eng.options.writeTimeout > 0 {
+		// the deadline would be left on a kept alive connection.
+		defer http.NewResponseController(writer).SetWriteDeadline(time.Time{})
 	}
 	var kill bool
-	if err := p.flush(); err == errPollingEOF {
+	if _, jsonp := p.tryJSONP(); !jsonp && len(request.URL.Query().Get("stream")) > 0 {
+		// the CLOSE packet is a chunk of the stream already.
+		err := p.stream()
+		kill = err == errPollingEOF
+		p.writeFailed(err)
+	} else if err := p.flush(); err == errPollingEOF {
 		kill = true
-		if err := parser.WritePayloadTo(p.res, false, defaultPacketClose); err != nil {
-			if p.eng.logErr != nil {
-				p.eng.logErr("write close packet failed: %s\n", err)
-			}
-			return
-		}
-	}
-	if jsonp {
-		if _, err := p.res.Write(jsonpEnd); err != nil {
-			if p.eng.logErr != nil {
-				p.eng.logErr("write jsonp suffix failed: %s\n", err)
-			}
+		if err := p.writePayload(defaultPacketClose); err != nil {
+			p.eng.logTransport(p, slog.LevelWarn, "write_failed", "write close packet failed", LogKeyError, err)
 			return
 		}
+	} else if err == errPollingClosed {
+		kill = true
+	} else {
+		p.writeFailed(err)
 	}
 	if kill {
-		p.socket.Close()
+		p.socket.transportClosed(p, nil)
 		p.socket = nil
 	}
 }
@@ -147,93 +123,111 @@ func (p *xhrTransport) doReqPost(writer http.ResponseWriter, request *http.Reque
 	var err error
 	defer func() {
 		request.Body.Close()
+		var tooLarge *http.MaxBytesError
 		if err == nil {
 			writer.Header().Set("Content-Type", "text/html; charset=UTF-8")
 			writer.Write([]byte("ok"))
+		} else if errors.As(err, &tooLarge) {
+			sendError(writer, err, http.StatusRequestEntityTooLarge)
 		} else {
 			sendError(writer, err, http.StatusInternalServerError)
 		}
 	}()
-	// read body
-	var body []byte
-	switch request.Header.Get("Content-Type") {
-	default:
-		body, err = ioutil.ReadAll(request.Body)
-		if err != nil {
-			if p.eng.logErr != nil {
-				p.eng.logErr("read request body failed: %s\n", err)
-			}
-			return
-		}
-		break
-	case "application/x-www-form-urlencoded":
-		if err := request.ParseForm(); err != nil {
-			if p.eng.logErr != nil {
-				p.eng.logErr("parse post form failed: %s\n", err)
-			}
-			return
-		}
-		body = []byte(request.PostFormValue("d"))
-		break
+	if max := p.eng.options.maxPayload; max > 0 {
+		request.Body = http.MaxBytesReader(writer, request.Body, max)
 	}
-	// extract packets
 	var packets []*parser.Packet
-	packets, err = parser.DecodePayload(body)
-	if err != nil {
+	if packets, err = p.readPayload(request); err != nil {
+		p.eng.logTransport(p, slog.LevelWarn, "decode_failed", "decode payload failed", LogKeyError, err)
 		return
 	}
-	// notify socket
+	// notify socket, the response is sent already so errors of accepting stop it only.
+	socket := p.socket
 	go func() {
 		for _, pack := range packets {
-			err = p.socket.accept(pack)
-			if err != nil {
+			p.eng.received(p, pack)
+			if err := socket.accept(pack); err != nil {
+				p.eng.logTransport(p, slog.LevelWarn, "accept_failed", "accept packet failed", LogKeyError, err)
 				return
 			}
 		}
 	}()
 }
 
+// readPayload decodes the packets of a POST request, the payload is binary if it's sent as application/octet-stream.
+func (p *xhrTransport) readPayload(request *http.Request) ([]*parser.Packet, error) {
+	packets := make([]*parser.Packet, 0)
+	var body io.Reader
+	mediaType, _, _ := mime.ParseMediaType(request.Header.Get("Content-Type"))
+	switch mediaType {
+	default:
+		body = request.Body
+		break
+	case "application/x-www-form-urlencoded":
+		if err := request.ParseForm(); err != nil {
+			return nil, err
+		}
+		// JSONP clients post the payload in field 'd' with newlines escaped.
+		body = bytes.NewReader(parser.DecodeJSONP([]byte(request.PostFormValue("d"))))
+		break
+	case parser.ContentTypeBinary:
+		if p.protocol() == parser.V4 {
+			return nil, errBinaryPayloadV4
+		}
+		input, err := ioutil.ReadAll(request.Body)
+		if err != nil {
+			return nil, err
+		}
+		return parser.ProtocolV3Binary.Decode(input)
+	}
+	if p.protocol() == parser.V4 {
+		// packets of v4 payloads are separated by a record separator rather than prefixed by length.
+		input, err := ioutil.ReadAll(body)
+		if err != nil {
+			return nil, err
+		}
+		return parser.ProtocolV4.Decode(input)
+	}
+	decoder := parser.NewDecoder(body)
+	for decoder.More() {
+		pack, err := decoder.Decode()
+		if err != nil {
+			return nil, err
+		}
+		packets = append(packets, pack)
+	}
+	return packets, nil
+}
+
 func (p *xhrTransport) upgradeStart(dest Transport) error {
-	p.write(parser.NewPacketCustom(parser.NOOP, make([]byte, 0), 0))
+	// the NOOP is sent even if paused, the pending poll must end.
+	p.send(parser.NewPacketCustom(parser.NOOP, make([]byte, 0), 0))
 	return nil
 }
 
 func (p *xhrTransport) upgradeEnd(dest Transport) error {
-	end := false
 	for {
-		select {
-		case pk := <-p.outbox:
-			if pk != nil {
-				dest.write(pk)
-			}
-			break
-		default:
-			end = true
+		pk, ok := p.outbox.pop()
+		if !ok {
 			break
 		}
-		if end {
-			break
+		// a NOOP left means no poll was pending when upgrade started, it's useless now.
+		if pk.Type != parser.NOOP {
+			dest.write(pk)
 		}
 	}
+	// packets held since the transport is paused follow the queued ones.
+	for _, it := range p.takeHeld() {
+		dest.write(it)
+	}
 	return nil
 }
 
-func (p *xhrTransport) write(packet *parser.Packet) (err error) {
-	defer func() {
-		e := recover()
-		if e == nil {
-			return
-		}
-		switch e.(type) {
-		case error:
-			err = e.(error)
-			break
-		case string:
-			err = errors.New(e.(string))
-			break
-		}
-	}()
-	p.outbox <- packet
+// send blocks while outbox is full until the client polls, unless the send queue drops packets.
+func (p *xhrTransport) send(packet *parser.Packet) error {
+	if err := p.enqueue(p.outbox, packet); err != nil {
+		return err
+	}
 	if p.handlerWrite != nil {
 		p.handlerWrite()
 	}
@@ -247,13 +241,18 @@ func (p *xhrTransport) flush() error {
 	end := false
 	for {
 		select {
-		case pk, ok := <-p.outbox:
-			if !ok {
+		case pk, ok := <-p.outbox.ch:
+			p.outbox.took(pk)
+			if !ok && len(queue) < 1 {
 				return errPollingEOF
 			}
-			/*if pk == nil {
-				return errPollingEOF
-			}*/
+			if !ok {
+				// packets sent before the transport is closed go along with the CLOSE.
+				if err := p.writePayload(append(queue, defaultPacketClose)...); err != nil {
+					return err
+				}
+				return errPollingClosed
+			}
 			queue = append(queue, pk)
 			if pk.Type == parser.OPEN {
 				end = true
@@ -271,56 +270,178 @@ func (p *xhrTransport) flush() error {
 	if len(queue) < 1 {
 		select {
 		case <-closeNotifier.CloseNotify():
-			if p.eng.logWarn != nil {
-				p.eng.logWarn("client close connect\n")
-			}
+			p.eng.logTransport(p, slog.LevelDebug, "poll_aborted", "client closed the poll")
 			return errPollingEOF
-		case pk := <-p.outbox:
+		case pk := <-p.outbox.ch:
 			if pk == nil {
 				return errPollingEOF
 			}
+			p.outbox.took(pk)
 			queue = append(queue, pk)
 			break
-		case <-time.After(p.eng.options.pingTimeout):
+		case <-time.After(p.pollTimeout()):
 			return errPollingEOF
 			//queue = append(queue, parser.NewPacketCustom(parser.CLOSE, make([]byte, 0), 0))
 		}
 	}
-	_, jsonp := p.tryJSONP()
-	if len(queue) == 1 {
-		if queue[0].Type == parser.NOOP {
-			time.Sleep(noopDelay)
-		}
-		return parser.WritePayloadTo(p.res, jsonp, queue[0])
+	// a NOOP of upgrade ends the pending poll as any other packet, the client ignores it.
+	return p.writePayload(queue...)
+}
+
+// pollTimeout returns how long an idle poll waits for packets. V3 clients ping within the ping timeout, while V4 ones
+// wait for the PING of server sent every ping interval, so their polls are ended by the PING.
+func (p *xhrTransport) pollTimeout() time.Duration {
+	if p.protocol() == parser.V4 {
+		return p.eng.options.pingInterval + p.eng.options.pingTimeout
+	}
+	return p.eng.options.pingTimeout
+}
+
+// stream serves a streaming poll, which is requested by the stream query. The response stays open and the packets
+// are written as payload chunks, which make one payload together. It starts with a NOOP padding, so proxies buffering
+// the first KB of responses pass the chunks at once. The stream ends if the client goes away, or after a NOOP since
+// the session is being upgraded. It returns errPollingEOF after the CLOSE chunk as flush does.
+// Chunks of v4 payloads can't be joined without separators, so v4 clients are answered as a normal poll.
+func (p *xhrTransport) stream() error {
+	flusher, ok := p.res.(http.Flusher)
+	if !ok || p.protocol() != protocolVersion {
+		return p.flush()
+	}
+	codec, contentType := protocolVersion.PayloadCodec(), parser.ContentTypeText
+	if len(p.req.URL.Query().Get("b64")) < 1 {
+		codec, contentType = parser.ProtocolV3Binary, parser.ContentTypeBinary
+	}
+	p.res.Header().Set("Content-Type", contentType)
+	p.res.Header().Set("X-Accel-Buffering", "no")
+	p.setWriteDeadline()
+	if err := codec.WriteTo(p.res, streamPadding); err != nil {
+		return err
 	}
-	nooped := false
-	for _, v := range queue {
-		if v.Type == parser.NOOP && !nooped {
-			nooped = true
-			p.write(v)
-			continue
+	flusher.Flush()
+	closed := p.res.(http.CloseNotifier).CloseNotify()
+	idle := time.NewTimer(p.eng.options.pingTimeout)
+	defer idle.Stop()
+	for {
+		var queue []*parser.Packet
+		select {
+		case <-closed:
+			return nil
+		case <-idle.C:
+			return p.endStream(codec, flusher)
+		case pk, ok := <-p.outbox.ch:
+			if !ok {
+				return p.endStream(codec, flusher)
+			}
+			p.outbox.took(pk)
+			queue = append(queue, pk)
+		}
+		// packets queued meanwhile are written in the same chunk.
+		eof := false
+		for more := true; more; {
+			select {
+			case pk, ok := <-p.outbox.ch:
+				if ok {
+					p.outbox.took(pk)
+					queue = append(queue, pk)
+				} else {
+					eof, more = true, false
+				}
+			default:
+				more = false
+			}
 		}
-		if err := parser.WritePayloadTo(p.res, jsonp, v); err != nil {
+		p.setWriteDeadline()
+		if err := codec.WriteTo(p.res, queue...); err != nil {
 			return err
 		}
+		flusher.Flush()
+		if eof {
+			return p.endStream(codec, flusher)
+		}
+		for _, it := range queue {
+			if it.Type == parser.NOOP {
+				return nil
+			}
+		}
+		if !idle.Stop() {
+			<-idle.C
+		}
+		idle.Reset(p.eng.options.pingTimeout)
 	}
-	return nil
 }
 
-func (p *xhrTransport) close() (err error) {
-	defer func() {
-		e := recover()
-		if e == nil {
-			return
+func (p *xhrTransport) endStream(codec parser.PayloadCodec, flusher http.Flusher) error {
+	p.setWriteDeadline()
+	if err := codec.WriteTo(p.res, defaultPacketClose); err != nil {
+		return err
+	}
+	flusher.Flush()
+	return errPollingEOF
+}
+
+// writePayload writes packets as one payload. Binary packets are sent in a binary payload,
+// unless the client asks for base64 by the b64 query or polls with JSONP.
+// Payloads of at least the http compression threshold are compressed as the client accepts.
+func (p *xhrTransport) writePayload(packets ...*parser.Packet) error {
+	p.setWriteDeadline()
+	defer p.eng.watchWrite(p).done()
+	var encoding string
+	if p.eng.options.httpCompression {
+		encoding = acceptEncoding(p.req)
+	}
+	var body []byte
+	if j, jsonp := p.tryJSONP(); jsonp {
+		// a JSONP response is a script calling the callback of index j with the string payload of protocol.
+		payload, err := p.protocol().PayloadCodec().Encode(packets...)
+		if err != nil {
+			return err
 		}
-		if ex, ok := e.(error); ok {
-			err = ex
-		} else {
-			err = fmt.Errorf("%s", ex)
+		p.res.Header().Set("Content-Type", contentTypeJSONP)
+		body = parser.EncodeJSONP(*j, payload)
+	} else {
+		codec, contentType := p.protocol().PayloadFormat(len(p.req.URL.Query().Get("b64")) < 1, packets...)
+		p.res.Header().Set("Content-Type", contentType)
+		// streamed bodies are written as is, so they're never held in memory.
+		if len(encoding) < 1 || hasStreamedBody(packets) {
+			return codec.WriteTo(p.res, packets...)
 		}
-	}()
-	close(p.outbox)
-	return err
+		var err error
+		if body, err = codec.Encode(packets...); err != nil {
+			return err
+		}
+	}
+	if len(body) < p.eng.options.httpCompressionThreshold {
+		encoding = ""
+	}
+	return writeCompressed(p.res, encoding, body)
+}
+
+// writeFailed logs err of writing a poll, a stalled write closes the socket.
+func (p *xhrTransport) writeFailed(err error) {
+	if err = p.stalled(err); err != nil && err != errPollingEOF && err != errPollingClosed {
+		p.eng.logTransport(p, slog.LevelWarn, "write_failed", "write poll failed", LogKeyError, err)
+	}
+}
+
+// setWriteDeadline sets the write deadline of the response if writes time out.
+func (p *xhrTransport) setWriteDeadline() {
+	if p.eng.options.writeTimeout > 0 {
+		http.NewResponseController(p.res).SetWriteDeadline(p.writeDeadline())
+	}
+}
+
+func hasStreamedBody(packets []*parser.Packet) bool {
+	for _, it := range packets {
+		if it.Body != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *xhrTransport) close() error {
+	p.outbox.close()
+	return nil
 }
 
 func newXhrTransport(server *engineImpl) Transport {
@@ -329,7 +450,8 @@ func newXhrTransport(server *engineImpl) Transport {
 			eng:    server,
 			locker: &sync.RWMutex{},
 		},
-		outbox: make(chan *parser.Packet, outboxThreshold),
+		outbox: newSendQueue(server.options),
 	}
+	trans.handlerSend = server.counting(&trans, trans.send)
 	return &trans
 }
@@ -0,0 +1,220 @@
+package eio
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jjeffcaii/engine.io/parser"
+)
+
+func poll(t *testing.T, method, url, contentType, body string) (*http.Response, string) {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(contentType) > 0 {
+		req.Header.Set("Content-Type", contentType)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	bs, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res, string(bs)
+}
+
+func TestPollingTransport(t *testing.T) {
+	eng := NewEngineBuilder().Build()
+	defer eng.Close()
+	messages := make(chan string, 1)
+	sockets := make(chan Socket, 1)
+	eng.OnConnect(func(socket Socket) {
+		socket.OnMessage(func(data []byte) {
+			messages <- string(data)
+		})
+		sockets <- socket
+	})
+	srv := httptest.NewServer(http.HandlerFunc(eng.Router()))
+	defer srv.Close()
+	url := srv.URL + "/engine.io/?EIO=3&transport=polling"
+
+	res, body := poll(t, http.MethodGet, url, "", "")
+	if res.Header.Get("Content-Type") != parser.ContentTypeText || res.Header.Get("Cache-Control") != "no-store" {
+		t.Errorf("bad handshake headers: %v", res.Header)
+	}
+	packets, err := parser.DecodePayloadString(body)
+	if err != nil || len(packets) != 1 || packets[0].Type != parser.OPEN {
+		t.Fatalf("bad handshake: %q %v", body, err)
+	}
+	socket := <-sockets
+	url += "&sid=" + socket.ID()
+
+	if _, body = poll(t, http.MethodPost, url, parser.ContentTypeText, "3:4hi"); body != "ok" {
+		t.Errorf("bad post response: %q", body)
+	}
+	if msg := <-messages; msg != "hi" {
+		t.Errorf("bad message: %q", msg)
+	}
+	if _, body = poll(t, http.MethodPost, url, parser.ContentTypeBinary, "\x01\x03\xff\x04\x01\x02"); body != "ok" {
+		t.Errorf("bad post response: %q", body)
+	}
+	if msg := <-messages; msg != "\x01\x02" {
+		t.Errorf("bad binary message: %q", msg)
+	}
+
+	socket.Send([]byte{1, 2})
+	res, body = poll(t, http.MethodGet, url, "", "")
+	if res.Header.Get("Content-Type") != parser.ContentTypeBinary || body != "\x01\x03\xff\x04\x01\x02" {
+		t.Errorf("bad binary payload: %s %q", res.Header.Get("Content-Type"), body)
+	}
+	socket.Send([]byte{1, 2})
+	res, body = poll(t, http.MethodGet, url+"&b64=1", "", "")
+	if res.Header.Get("Content-Type") != parser.ContentTypeText || body != "6:b4AQI=" {
+		t.Errorf("bad base64 payload: %s %q", res.Header.Get("Content-Type"), body)
+	}
+}
+
+func TestPollingJSONP(t *testing.T) {
+	eng := NewEngineBuilder().Build()
+	defer eng.Close()
+	messages := make(chan string, 1)
+	sockets := make(chan Socket, 1)
+	eng.OnConnect(func(socket Socket) {
+		socket.OnMessage(func(data []byte) {
+			messages <- string(data)
+		})
+		sockets <- socket
+	})
+	srv := httptest.NewServer(http.HandlerFunc(eng.Router()))
+	defer srv.Close()
+	// non-digits of the index are dropped, so it can't inject script.
+	url := srv.URL + "/engine.io/?EIO=3&transport=polling&j=1%29%3Balert%28"
+
+	res, body := poll(t, http.MethodGet, url, "", "")
+	if res.Header.Get("Content-Type") != contentTypeJSONP || !strings.HasPrefix(body, `___eio[1]("`) || !strings.HasSuffix(body, `");`) {
+		t.Fatalf("bad jsonp handshake: %s %q", res.Header.Get("Content-Type"), body)
+	}
+	socket := <-sockets
+	url += "&sid=" + socket.ID()
+
+	if _, body = poll(t, http.MethodPost, url, "application/x-www-form-urlencoded", `d=4:4a%5Cnb`); body != "ok" {
+		t.Errorf("bad post response: %q", body)
+	}
+	if msg := <-messages; msg != "a\nb" {
+		t.Errorf("bad message: %q", msg)
+	}
+	socket.Send([]byte{1, 2})
+	if _, body = poll(t, http.MethodGet, url, "", ""); body != `___eio[1]("6:b4AQI=");` {
+		t.Errorf("bad jsonp payload: %q", body)
+	}
+	socket.Close()
+
+	// v4 payloads have no length prefix in JSONP as in XHR.
+	url = srv.URL + "/engine.io/?EIO=4&transport=polling&j=2"
+	if _, body = poll(t, http.MethodGet, url, "", ""); !strings.HasPrefix(body, `___eio[2]("0{`) {
+		t.Fatalf("bad v4 jsonp handshake: %q", body)
+	}
+	socket = <-sockets
+	socket.Send([]byte{1, 2})
+	if _, body = poll(t, http.MethodGet, url+"&sid="+socket.ID(), "", ""); body != `___eio[2]("bAQI=");` {
+		t.Errorf("bad v4 jsonp payload: %q", body)
+	}
+	socket.Close()
+}
+
+func TestPollingPause(t *testing.T) {
+	eng := NewEngineBuilder().Build()
+	defer eng.Close()
+	trans := newXhrTransport(eng.(*engineImpl)).(*xhrTransport)
+	trans.write(parser.NewPacket(parser.MESSAGE, "1"))
+	trans.Pause()
+	if !trans.Paused() {
+		t.Error("should be paused")
+	}
+	trans.write(parser.NewPacket(parser.MESSAGE, "2"))
+	trans.write(parser.NewPacket(parser.MESSAGE, "3"))
+	if n := len(trans.outbox.ch); n != 1 {
+		t.Errorf("packets should be held while paused, %d queued", n)
+	}
+	if err := trans.Resume(); err != nil {
+		t.Fatal(err)
+	}
+	trans.write(parser.NewPacket(parser.MESSAGE, "4"))
+	for _, expect := range []string{"1", "2", "3", "4"} {
+		if got := string((<-trans.outbox.ch).Data); got != expect {
+			t.Errorf("should be %q, got %q", expect, got)
+		}
+	}
+}
+
+func TestPollingStream(t *testing.T) {
+	eng := NewEngineBuilder().Build()
+	defer eng.Close()
+	sockets := make(chan Socket, 1)
+	eng.OnConnect(func(socket Socket) { sockets <- socket })
+	srv := httptest.NewServer(http.HandlerFunc(eng.Router()))
+	defer srv.Close()
+	url, socket := openPolling(t, srv, sockets)
+
+	res, err := http.Get(url + "&stream=1&b64=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if got := res.Header.Get("Content-Type"); got != parser.ContentTypeText {
+		t.Errorf("bad content type: %q", got)
+	}
+	decoder := parser.NewDecoder(res.Body)
+	next := func() *parser.Packet {
+		pack, err := decoder.Decode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pack
+	}
+	if padding := next(); padding.Type != parser.NOOP || len(padding.Data) != 2048 {
+		t.Errorf("should be padding: %d", padding.Type)
+	}
+	for _, it := range []string{"a", "b"} {
+		socket.Send(it)
+		if pack := next(); pack.Type != parser.MESSAGE || string(pack.Data) != it {
+			t.Errorf("should be %q, got %q", it, pack.Data)
+		}
+	}
+	socket.Close()
+	if pack := next(); pack.Type != parser.CLOSE {
+		t.Errorf("should be CLOSE: %d", pack.Type)
+	}
+}
+
+func TestPollingIdleV4(t *testing.T) {
+	eng := NewEngineBuilder().SetPingInterval(300 * time.Millisecond).SetPingTimeout(200 * time.Millisecond).
+		SetUpgradeTimeout(200 * time.Millisecond).Build()
+	defer eng.Close()
+	sockets := make(chan Socket, 1)
+	eng.OnConnect(func(socket Socket) { sockets <- socket })
+	srv := httptest.NewServer(http.HandlerFunc(eng.Router()))
+	defer srv.Close()
+	url := srv.URL + "/engine.io/?EIO=4&transport=polling"
+	poll(t, http.MethodGet, url, "", "")
+	socket := <-sockets
+	// the idle poll outlives the ping timeout till the PING of server.
+	start := time.Now()
+	if _, body := poll(t, http.MethodGet, url+"&sid="+socket.ID(), "", ""); body != "2" {
+		t.Fatalf("idle poll should get the PING: %q", body)
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("poll should wait for the PING: %s", elapsed)
+	}
+	if socket.State() != SocketOpen {
+		t.Errorf("socket should be open: %v", socket.State())
+	}
+}
@@ -1,26 +1,30 @@
 package eio
 
 import (
-	"bytes"
-	"encoding/base64"
-	"encoding/binary"
 	"encoding/json"
-	"math/rand"
+	"errors"
 	"net/http"
-	"strings"
-	"sync"
-	"time"
 )
 
 var (
 	emptyStringArray = make([]string, 0)
-	b64Rep           = strings.NewReplacer("/", "_", "+", "-")
 )
 
-func init() {
-	rand.Seed(time.Now().UnixNano())
+// RequestError rejects a request with an http status and an engine.io error code, such as 4 (forbidden).
+// It can be returned by the functions of EngineBuilder.SetAllowRequest and EngineBuilder.SetAllowHandshake.
+// A zero Status or Code leaves the one of the rejection, e.g. 403 and 4 of SetAllowHandshake.
+type RequestError struct {
+	Status  int
+	Code    int
+	Message string
 }
 
+func (e *RequestError) Error() string {
+	return e.Message
+}
+
+// sendError writes e as an error response with the http status and error code of codes, which are
+// overridden by the ones set of a *RequestError.
 func sendError(writer http.ResponseWriter, e error, codes ...int) {
 	httpCode, bizCode := http.StatusInternalServerError, 0
 	if len(codes) > 0 {
@@ -29,6 +33,15 @@ func sendError(writer http.ResponseWriter, e error, codes ...int) {
 	if len(codes) > 1 {
 		bizCode = codes[1]
 	}
+	var re *RequestError
+	if errors.As(e, &re) {
+		if re.Status >= 100 {
+			httpCode = re.Status
+		}
+		if re.Code != 0 {
+			bizCode = re.Code
+		}
+	}
 	writer.Header().Set("Content-Type", "application/json; charset=UTF8")
 	writer.WriteHeader(httpCode)
 	foo := struct {
@@ -39,64 +52,3 @@ func sendError(writer http.ResponseWriter, e error, codes ...int) {
 		panic(err)
 	}
 }
-
-func randomSessionID(seed uint32) string {
-	bf := new(bytes.Buffer)
-	for i := 0; i < 12; i++ {
-		bf.WriteByte(byte(rand.Int31n(256)))
-	}
-	b := make([]byte, 4)
-	binary.BigEndian.PutUint32(b, seed)
-	for i := 1; i < 4; i++ {
-		bf.WriteByte(b[i])
-	}
-	bs := bf.Bytes()
-	s := base64.StdEncoding.EncodeToString(bs)
-
-	s = b64Rep.Replace(s)
-	return s
-}
-
-type queue struct {
-	lock *sync.RWMutex
-	q    []interface{}
-}
-
-func newQueue() *queue {
-	foo := queue{
-		lock: &sync.RWMutex{},
-		q:    make([]interface{}, 0),
-	}
-	return &foo
-}
-
-func (p *queue) size() int {
-	p.lock.RLock()
-	defer p.lock.RUnlock()
-	return len(p.q)
-}
-
-func (p *queue) append(item interface{}) {
-	p.lock.Lock()
-	defer p.lock.Unlock()
-	p.q = append(p.q, item)
-}
-
-func (p *queue) pop() (interface{}, bool) {
-	p.lock.Lock()
-	defer p.lock.Unlock()
-	if len(p.q) < 1 {
-		return nil, false
-	}
-	var foo interface{}
-	foo, p.q = p.q[0], p.q[1:]
-	return foo, true
-}
-
-func (p *queue) reset() []interface{} {
-	p.lock.Lock()
-	defer p.lock.Unlock()
-	var ret []interface{}
-	ret, p.q = p.q[:], p.q[:0]
-	return ret
-}